@@ -0,0 +1,43 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"forum/config"
+	"forum/gc"
+	"forum/repository"
+	"forum/storage"
+)
+
+// runGC implements the "gc" subcommand: one sweep over the upload store,
+// printing the report. Defaults come from the IMAGE_GC_* environment.
+func runGC(db *sql.DB, store storage.Storage, args []string) int {
+	cfg := config.LoadGCConfig()
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", cfg.DryRun, "only report orphaned files, do not remove them")
+	grace := fs.Duration("grace", cfg.Grace, "keep orphaned files younger than this")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	fs.Parse(args)
+
+	collector := gc.NewCollector(repository.NewImageRepository(db), repository.NewJobRepository(db), store, *grace, *dryRun)
+	report, err := collector.Run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gc: %v\n", err)
+		return 1
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		fmt.Println(report)
+	}
+	if len(report.Errors) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -4,9 +4,14 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 
+	"forum/config"
+	"forum/gc"
 	"forum/models"
+	"forum/repository"
 	"forum/routes"
+	"forum/storage"
 	"forum/utils"
 )
 
@@ -23,8 +28,25 @@ func main() {
 	}
 	defer db.Close()
 
+	// Initialize upload storage
+	store, err := storage.New(config.LoadStorageConfig())
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+
+	// "api gc [-dry-run] [-grace 24h] [-json]" runs one sweep and exits
+	if len(os.Args) > 1 && os.Args[1] == "gc" {
+		code := runGC(db, store, os.Args[2:])
+		db.Close()
+		os.Exit(code)
+	}
+
+	// Sweep orphaned uploads in the background
+	gcConfig := config.LoadGCConfig()
+	gc.NewCollector(repository.NewImageRepository(db), repository.NewJobRepository(db), store, gcConfig.Grace, gcConfig.DryRun).Start(gcConfig.Interval)
+
 	// Setup routes
-	handler := routes.SetupRoutes(db)
+	handler := routes.SetupRoutes(db, store)
 
 	// Start server
 	port := 8080
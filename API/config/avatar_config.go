@@ -0,0 +1,29 @@
+package config
+
+import (
+	"os"
+	"sort"
+	"strings"
+)
+
+// AvatarConfig controls user profile pictures served under /avatars/.
+type AvatarConfig struct {
+	// BaseURL is the public URL of the /avatars/ route, with a trailing slash.
+	BaseURL string
+	// Sizes lists the edges, in pixels, of the square copies stored for every
+	// uploaded avatar, smallest first.
+	Sizes []int
+}
+
+// LoadAvatarConfig reads the avatar settings from the environment.
+func LoadAvatarConfig() AvatarConfig {
+	cfg := AvatarConfig{
+		BaseURL: getEnv("AVATAR_BASE_URL", "http://localhost:8080/avatars/"),
+		Sizes:   parseInts(os.Getenv("AVATAR_SIZES"), []int{32, 64, 128, 256}),
+	}
+	if !strings.HasSuffix(cfg.BaseURL, "/") {
+		cfg.BaseURL += "/"
+	}
+	sort.Ints(cfg.Sizes)
+	return cfg
+}
@@ -1,14 +1,27 @@
 package config
 
 const IdxPostsUserID = `CREATE INDEX IF NOT EXISTS idx_posts_user_id ON posts(user_id);`
+const IdxPostsCreatedAt = `CREATE INDEX IF NOT EXISTS idx_posts_created_at ON posts(created_at, post_id);`
+const IdxPostsUserCreatedAt = `CREATE INDEX IF NOT EXISTS idx_posts_user_created_at ON posts(user_id, created_at, post_id);`
 const IdxPostCategoriesPostID = `CREATE INDEX IF NOT EXISTS idx_post_categories_post_id ON post_categories(post_id);`
 const IdxPostCategoriesCategoryID = `CREATE INDEX IF NOT EXISTS idx_post_categories_category_id ON post_categories(category_id);`
 const IdxCommentsPostID = `CREATE INDEX IF NOT EXISTS idx_comments_post_id ON comments(post_id);`
 const IdxCommentsUserID = `CREATE INDEX IF NOT EXISTS idx_comments_user_id ON comments(user_id);`
+const IdxCommentsParentID = `CREATE INDEX IF NOT EXISTS idx_comments_parent_id ON comments(parent_id);`
 const IdxReactionsUserID = `CREATE INDEX IF NOT EXISTS idx_reactions_user_id ON reactions(user_id);`
 const IdxReactionsPostID = `CREATE INDEX IF NOT EXISTS idx_reactions_post_id ON reactions(post_id);`
 const IdxReactionsCommentID = `CREATE INDEX IF NOT EXISTS idx_reactions_comment_id ON reactions(comment_id);`
 const IdxImagesPostID = `CREATE INDEX IF NOT EXISTS idx_images_post_id ON images(post_id);`
+const IdxImagesPostPosition = `CREATE INDEX IF NOT EXISTS idx_images_post_position ON images(post_id, position);`
+const IdxImagesContentHash = `CREATE INDEX IF NOT EXISTS idx_images_content_hash ON images(content_hash);`
+const IdxImageBlobsRefCount = `CREATE INDEX IF NOT EXISTS idx_image_blobs_ref_count ON image_blobs(ref_count);`
+const IdxJobsStatusRunAt = `CREATE INDEX IF NOT EXISTS idx_jobs_status_run_at ON jobs(status, run_at);`
+const IdxJobsImageID = `CREATE INDEX IF NOT EXISTS idx_jobs_image_id ON jobs(image_id);`
+const IdxUploadSessionsExpiresAt = `CREATE INDEX IF NOT EXISTS idx_upload_sessions_expires_at ON upload_sessions(expires_at);`
+const IdxImagesStatus = `CREATE INDEX IF NOT EXISTS idx_images_status ON images(status);`
+const IdxImageScanVerdictsImageID = `CREATE INDEX IF NOT EXISTS idx_image_scan_verdicts_image_id ON image_scan_verdicts(image_id);`
+const IdxImageScanVerdictsContentHash = `CREATE INDEX IF NOT EXISTS idx_image_scan_verdicts_content_hash ON image_scan_verdicts(content_hash);`
+const IdxImageRenditionsImageID = `CREATE INDEX IF NOT EXISTS idx_image_renditions_image_id ON image_renditions(image_id);`
 
 const IdxNotificationsUserID = `CREATE INDEX IF NOT EXISTS idx_notifications_user_id ON notifications(user_id);`
 const IdxNotificationsActorID = `CREATE INDEX IF NOT EXISTS idx_notifications_actor_id ON notifications(actor_id);`
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"time"
+)
+
+// GCConfig controls the background sweeper that removes uploaded files no
+// longer referenced by the database.
+type GCConfig struct {
+	// Interval between sweeps; zero disables the background sweeper.
+	Interval time.Duration
+	// Grace keeps unreferenced files younger than this, so uploads whose
+	// database row is still being written are never removed.
+	Grace time.Duration
+	// DryRun only reports what would be removed.
+	DryRun bool
+}
+
+// LoadGCConfig reads the sweeper settings from the environment.
+func LoadGCConfig() GCConfig {
+	return GCConfig{
+		Interval: getDuration("IMAGE_GC_INTERVAL", 6*time.Hour),
+		Grace:    getDuration("IMAGE_GC_GRACE", 24*time.Hour),
+		DryRun:   getEnv("IMAGE_GC_DRY_RUN", "false") == "true",
+	}
+}
+
+// getDuration parses a Go duration such as "30m"; "0" or "off" yields zero.
+// Invalid values fall back to the default.
+func getDuration(key string, fallback time.Duration) time.Duration {
+	v := getEnv(key, "")
+	switch v {
+	case "":
+		return fallback
+	case "0", "off":
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return fallback
+	}
+	return d
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RenditionSpec describes one resized copy generated for every upload.
+// Renditions keep the aspect ratio of the original and are never upscaled.
+type RenditionSpec struct {
+	Name     string
+	MaxWidth int
+}
+
+// DefaultRenditions are used when IMAGE_RENDITIONS is not set.
+var DefaultRenditions = []RenditionSpec{
+	{Name: "small", MaxWidth: 320},
+	{Name: "medium", MaxWidth: 800},
+	{Name: "large", MaxWidth: 1600},
+}
+
+// ImageConfig holds the settings of the image upload pipeline.
+type ImageConfig struct {
+	Renditions []RenditionSpec
+	// ExifKeep lists the EXIF tag names (e.g. "Copyright", "Artist") copied
+	// into stored originals. Everything else is stripped.
+	ExifKeep []string
+	// Variants lists the modern formats ("webp", "avif") generated next to
+	// every stored JPEG and PNG. There is no built-in AVIF encoder, so
+	// "avif" produces nothing unless AVIFEncoder is set.
+	Variants []string
+	// WebPEncoder and AVIFEncoder are optional external encoder command
+	// lines with {in} and {out} placeholders, e.g. "cwebp -q 80 {in} -o {out}".
+	// Without a WebP command PNGs get a built-in lossless WebP variant; AVIF
+	// variants require a command.
+	WebPEncoder string
+	AVIFEncoder string
+	// MaxImagesPerPost caps the gallery size of a post; 0 means no limit.
+	MaxImagesPerPost int
+	// UserQuotaBytes caps the stored bytes attributed to one user's images
+	// (originals and renditions); 0 means no limit.
+	UserQuotaBytes int64
+
+	// Decoding limits, checked from the image header before any pixels are
+	// decoded. 0 disables a limit.
+	MaxWidth  int
+	MaxHeight int
+	MaxPixels int
+	// MaxFrames caps the number of frames of an animated GIF.
+	MaxFrames int
+	// DecodeBudgetBytes caps the estimated memory needed to decode and
+	// process one upload.
+	DecodeBudgetBytes int64
+
+	// GIFThumbMaxFrames and GIFThumbMaxDuration cut animated thumbnails
+	// short. 0 disables a limit.
+	GIFThumbMaxFrames   int
+	GIFThumbMaxDuration time.Duration
+
+	// HashDistance is the largest Hamming distance, in bits out of 64, at
+	// which two perceptual hashes count as the same picture: uploads this
+	// close to a banned image are rejected.
+	HashDistance int
+
+	// RequireAltText rejects uploads without alt text and stops authors
+	// from clearing it later.
+	RequireAltText bool
+
+	// Scan selects the scanner uploads go through before they are stored.
+	Scan ScanConfig
+}
+
+// LoadImageConfig reads the image pipeline settings from the environment.
+func LoadImageConfig() ImageConfig {
+	return ImageConfig{
+		Renditions:  parseRenditions(os.Getenv("IMAGE_RENDITIONS")),
+		ExifKeep:    parseList(os.Getenv("IMAGE_EXIF_KEEP")),
+		Variants:    parseVariants(os.Getenv("IMAGE_VARIANTS")),
+		WebPEncoder: os.Getenv("IMAGE_WEBP_ENCODER"),
+		AVIFEncoder: os.Getenv("IMAGE_AVIF_ENCODER"),
+
+		MaxImagesPerPost: parseLimit(os.Getenv("IMAGE_MAX_PER_POST"), 10),
+		UserQuotaBytes:   int64(parseLimit(os.Getenv("IMAGE_USER_QUOTA_MB"), 500)) << 20,
+
+		MaxWidth:          parseLimit(os.Getenv("IMAGE_MAX_WIDTH"), 10000),
+		MaxHeight:         parseLimit(os.Getenv("IMAGE_MAX_HEIGHT"), 10000),
+		MaxPixels:         parseLimit(os.Getenv("IMAGE_MAX_PIXELS"), 40000000),
+		MaxFrames:         parseLimit(os.Getenv("IMAGE_MAX_FRAMES"), 500),
+		DecodeBudgetBytes: int64(parseLimit(os.Getenv("IMAGE_DECODE_BUDGET_MB"), 512)) << 20,
+
+		GIFThumbMaxFrames:   parseLimit(os.Getenv("IMAGE_GIF_THUMB_MAX_FRAMES"), 50),
+		GIFThumbMaxDuration: getDuration("IMAGE_GIF_THUMB_MAX_DURATION", 5*time.Second),
+
+		HashDistance: parseLimit(os.Getenv("IMAGE_HASH_DISTANCE"), 10),
+
+		RequireAltText: getEnv("IMAGE_REQUIRE_ALT_TEXT", "false") == "true",
+
+		Scan: LoadScanConfig(),
+	}
+}
+
+// parseLimit parses a non-negative integer, returning fallback when unset or
+// invalid. "0" disables the limit.
+func parseLimit(raw string, fallback int) int {
+	n, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil || n < 0 {
+		return fallback
+	}
+	return n
+}
+
+// parseRenditions parses a list such as "small:320,medium:800,large:1600".
+// Invalid entries are skipped; an empty or fully invalid list falls back to
+// DefaultRenditions.
+func parseRenditions(raw string) []RenditionSpec {
+	var specs []RenditionSpec
+	for _, part := range strings.Split(raw, ",") {
+		name, width, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			continue
+		}
+		w, err := strconv.Atoi(strings.TrimSpace(width))
+		name = strings.ToLower(strings.TrimSpace(name))
+		if err != nil || w <= 0 || name == "" || name == "original" {
+			continue
+		}
+		specs = append(specs, RenditionSpec{Name: name, MaxWidth: w})
+	}
+	if len(specs) == 0 {
+		return DefaultRenditions
+	}
+	return specs
+}
+
+// parseList splits a comma-separated list, dropping empty entries.
+func parseList(raw string) []string {
+	var out []string
+	for _, part := range strings.Split(raw, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// parseVariants parses IMAGE_VARIANTS, keeping the known formats. Unset
+// means WebP only; "none" disables variants.
+func parseVariants(raw string) []string {
+	if strings.TrimSpace(raw) == "" {
+		return []string{"webp"}
+	}
+	var out []string
+	for _, v := range parseList(strings.ToLower(raw)) {
+		if v == "webp" || v == "avif" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
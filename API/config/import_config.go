@@ -0,0 +1,37 @@
+package config
+
+import (
+	"os"
+	"time"
+)
+
+// ImportConfig controls importing images from remote URLs.
+type ImportConfig struct {
+	// Timeout bounds a whole download, from connecting to the last byte.
+	Timeout time.Duration
+	// MaxBytes caps the size of a downloaded image.
+	MaxBytes int64
+	// MaxRedirects is how many redirects are followed; each target is
+	// checked like the original URL.
+	MaxRedirects int
+	// AllowPrivate lifts the ban on loopback and private addresses, for
+	// local development only.
+	AllowPrivate bool
+}
+
+// LoadImportConfig reads the URL import settings from the environment.
+func LoadImportConfig() ImportConfig {
+	cfg := ImportConfig{
+		Timeout:      getDuration("IMAGE_IMPORT_TIMEOUT", 10*time.Second),
+		MaxBytes:     int64(parseLimit(os.Getenv("IMAGE_IMPORT_MAX_MB"), 20)) << 20,
+		MaxRedirects: parseLimit(os.Getenv("IMAGE_IMPORT_MAX_REDIRECTS"), 3),
+		AllowPrivate: getEnv("IMAGE_IMPORT_ALLOW_PRIVATE", "false") == "true",
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = 20 << 20
+	}
+	return cfg
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"os"
+	"time"
+)
+
+// JobConfig controls the background job workers.
+type JobConfig struct {
+	// Workers is the number of jobs processed concurrently.
+	Workers int
+	// PollInterval is how often idle workers look for due jobs; new jobs
+	// wake a worker immediately.
+	PollInterval time.Duration
+	// MaxAttempts is how often a job runs before it is marked failed.
+	MaxAttempts int
+	// RetryBackoff is the delay before the first retry; it doubles with
+	// every further attempt up to MaxBackoff.
+	RetryBackoff time.Duration
+	MaxBackoff   time.Duration
+}
+
+// LoadJobConfig reads the worker settings from the environment.
+func LoadJobConfig() JobConfig {
+	cfg := JobConfig{
+		Workers:      parseLimit(os.Getenv("JOB_WORKERS"), 2),
+		PollInterval: getDuration("JOB_POLL_INTERVAL", 2*time.Second),
+		MaxAttempts:  parseLimit(os.Getenv("JOB_MAX_ATTEMPTS"), 5),
+		RetryBackoff: getDuration("JOB_RETRY_BACKOFF", 10*time.Second),
+		MaxBackoff:   getDuration("JOB_MAX_BACKOFF", 30*time.Minute),
+	}
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = 2 * time.Second
+	}
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+	return cfg
+}
@@ -0,0 +1,19 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// ModerationConfig controls who may moderate the forum.
+type ModerationConfig struct {
+	// Moderators lists the usernames and email addresses, lower-cased, of
+	// the users allowed to use the moderation endpoints.
+	Moderators []string
+}
+
+// LoadModerationConfig reads FORUM_MODERATORS, a comma-separated list of
+// usernames or email addresses. Without it nobody can moderate.
+func LoadModerationConfig() ModerationConfig {
+	return ModerationConfig{Moderators: parseList(strings.ToLower(os.Getenv("FORUM_MODERATORS")))}
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ScanConfig selects the scanner uploads go through before they are stored.
+type ScanConfig struct {
+	// Scanner is "none" (the default), "heuristic" or "http".
+	Scanner string
+	// URL and Token configure the http scanner.
+	URL     string
+	Token   string
+	Timeout time.Duration
+	// SkinThreshold is the share of skin-coloured pixels at which the
+	// heuristic scanner flags an image.
+	SkinThreshold float64
+	// QuarantineOnError quarantines uploads the scanner failed on instead of
+	// publishing them unscanned.
+	QuarantineOnError bool
+}
+
+// LoadScanConfig reads the image scanning settings from the environment.
+func LoadScanConfig() ScanConfig {
+	cfg := ScanConfig{
+		Scanner:           strings.ToLower(strings.TrimSpace(os.Getenv("IMAGE_SCANNER"))),
+		URL:               os.Getenv("IMAGE_SCANNER_URL"),
+		Token:             os.Getenv("IMAGE_SCANNER_TOKEN"),
+		Timeout:           getDuration("IMAGE_SCANNER_TIMEOUT", 10*time.Second),
+		SkinThreshold:     0.45,
+		QuarantineOnError: getEnv("IMAGE_SCANNER_ON_ERROR", "quarantine") != "allow",
+	}
+	if t, err := strconv.ParseFloat(os.Getenv("IMAGE_SCANNER_SKIN_THRESHOLD"), 64); err == nil && t > 0 && t <= 1 {
+		cfg.SkinThreshold = t
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	return cfg
+}
@@ -57,6 +57,73 @@ const CreateCommentsTable = `CREATE TABLE IF NOT EXISTS comments (
             FOREIGN KEY (user_id) REFERENCES user(user_id) ON DELETE CASCADE
         );`
 
+// AddCommentsParentID makes a comment a reply to another comment on the
+// same post; top-level comments have none
+const AddCommentsParentID = `ALTER TABLE comments ADD COLUMN parent_id TEXT REFERENCES comments(comment_id) ON DELETE CASCADE;`
+
+// CreatePostsSearchTable indexes post titles and contents for full-text
+// search. Rows are found by post_id, not rowid, since a VACUUM may renumber
+// the rowids of posts.
+const CreatePostsSearchTable = `CREATE VIRTUAL TABLE IF NOT EXISTS posts_search USING fts5(
+    post_id UNINDEXED, title, content,
+    tokenize = 'unicode61 remove_diacritics 2'
+);`
+
+// CreateCommentsSearchTable indexes comment contents like posts_search
+const CreateCommentsSearchTable = `CREATE VIRTUAL TABLE IF NOT EXISTS comments_search USING fts5(
+    comment_id UNINDEXED, content,
+    tokenize = 'unicode61 remove_diacritics 2'
+);`
+
+// FillPostsSearch indexes the existing posts
+const FillPostsSearch = `INSERT INTO posts_search(post_id, title, content) SELECT post_id, title, content FROM posts;`
+
+// FillCommentsSearch indexes the existing comments
+const FillCommentsSearch = `INSERT INTO comments_search(comment_id, content) SELECT comment_id, content FROM comments;`
+
+// CreatePostsSearchInsertTrigger indexes a new post
+const CreatePostsSearchInsertTrigger = `CREATE TRIGGER IF NOT EXISTS trg_posts_search_insert
+AFTER INSERT ON posts
+BEGIN
+    INSERT INTO posts_search(post_id, title, content) VALUES (NEW.post_id, NEW.title, NEW.content);
+END;`
+
+// CreatePostsSearchDeleteTrigger drops a deleted post from the index
+const CreatePostsSearchDeleteTrigger = `CREATE TRIGGER IF NOT EXISTS trg_posts_search_delete
+AFTER DELETE ON posts
+BEGIN
+    DELETE FROM posts_search WHERE post_id = OLD.post_id;
+END;`
+
+// CreatePostsSearchUpdateTrigger reindexes an edited post
+const CreatePostsSearchUpdateTrigger = `CREATE TRIGGER IF NOT EXISTS trg_posts_search_update
+AFTER UPDATE OF title, content ON posts
+BEGIN
+    UPDATE posts_search SET title = NEW.title, content = NEW.content WHERE post_id = OLD.post_id;
+END;`
+
+// CreateCommentsSearchInsertTrigger indexes a new comment
+const CreateCommentsSearchInsertTrigger = `CREATE TRIGGER IF NOT EXISTS trg_comments_search_insert
+AFTER INSERT ON comments
+BEGIN
+    INSERT INTO comments_search(comment_id, content) VALUES (NEW.comment_id, NEW.content);
+END;`
+
+// CreateCommentsSearchDeleteTrigger drops a deleted comment from the index,
+// including the comments removed with their post
+const CreateCommentsSearchDeleteTrigger = `CREATE TRIGGER IF NOT EXISTS trg_comments_search_delete
+AFTER DELETE ON comments
+BEGIN
+    DELETE FROM comments_search WHERE comment_id = OLD.comment_id;
+END;`
+
+// CreateCommentsSearchUpdateTrigger reindexes an edited comment
+const CreateCommentsSearchUpdateTrigger = `CREATE TRIGGER IF NOT EXISTS trg_comments_search_update
+AFTER UPDATE OF content ON comments
+BEGIN
+    UPDATE comments_search SET content = NEW.content WHERE comment_id = OLD.comment_id;
+END;`
+
 const CreateReactionsTable = `CREATE TABLE IF NOT EXISTS reactions (
             user_id TEXT NOT NULL,
             reaction_type INTEGER NOT NULL CHECK (reaction_type IN (1, 2, 3)),
@@ -85,6 +152,186 @@ const CreateImagesTable = `CREATE TABLE IF NOT EXISTS images (
     FOREIGN KEY (user_id) REFERENCES user(user_id) ON DELETE CASCADE
 );`
 
+// AddImagesStorageBackend records which storage backend holds each image
+const AddImagesStorageBackend = `ALTER TABLE images ADD COLUMN storage_backend TEXT NOT NULL DEFAULT 'local';`
+
+// AddImagesOrientation records the EXIF orientation applied on upload
+const AddImagesOrientation = `ALTER TABLE images ADD COLUMN orientation INTEGER NOT NULL DEFAULT 1;`
+
+// AddImagesStrippedMetadata records the metadata fields removed on upload as a JSON array
+const AddImagesStrippedMetadata = `ALTER TABLE images ADD COLUMN stripped_metadata TEXT NOT NULL DEFAULT '[]';`
+
+// CreateImageRenditionsTable stores the resized copies generated for each image
+const CreateImageRenditionsTable = `CREATE TABLE IF NOT EXISTS image_renditions (
+    rendition_id TEXT PRIMARY KEY,
+    image_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    file_path TEXT NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    content_type TEXT NOT NULL,
+    size_bytes INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(image_id, name),
+    FOREIGN KEY (image_id) REFERENCES images(image_id) ON DELETE CASCADE
+);`
+
+// CreateImageBlobsTable stores processed uploads by SHA-256 of their bytes.
+// ref_count is maintained by triggers on images so cascading post deletes
+// release blobs too.
+const CreateImageBlobsTable = `CREATE TABLE IF NOT EXISTS image_blobs (
+    content_hash TEXT PRIMARY KEY,
+    file_path TEXT NOT NULL,
+    thumbnail_path TEXT NOT NULL,
+    storage_backend TEXT NOT NULL DEFAULT 'local',
+    ref_count INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);`
+
+// AddImagesContentHash links images to their blob; NULL for uploads made
+// before deduplication
+const AddImagesContentHash = `ALTER TABLE images ADD COLUMN content_hash TEXT REFERENCES image_blobs(content_hash);`
+
+// AddImagesPosition orders the images of a post; 0 is shown first
+const AddImagesPosition = `ALTER TABLE images ADD COLUMN position INTEGER NOT NULL DEFAULT 0;`
+
+// AddImagesAltText describes an image for screen readers
+const AddImagesAltText = `ALTER TABLE images ADD COLUMN alt_text TEXT NOT NULL DEFAULT '';`
+
+// AddImagesCaption is shown below an image in a gallery
+const AddImagesCaption = `ALTER TABLE images ADD COLUMN caption TEXT NOT NULL DEFAULT '';`
+
+// BackfillImagesPosition numbers the existing images of each post in upload order
+const BackfillImagesPosition = `UPDATE images SET position = (
+    SELECT COUNT(*) FROM images i2
+    WHERE i2.post_id = images.post_id
+      AND (i2.created_at < images.created_at OR (i2.created_at = images.created_at AND i2.image_id < images.image_id))
+);`
+
+// AddImagesBlurHash stores a BlurHash placeholder shown while an image loads
+const AddImagesBlurHash = `ALTER TABLE images ADD COLUMN blurhash TEXT NOT NULL DEFAULT '';`
+
+// AddImagesDominantColor stores the most common colour of an image as #rrggbb
+const AddImagesDominantColor = `ALTER TABLE images ADD COLUMN dominant_color TEXT NOT NULL DEFAULT '';`
+
+// AddImagesPHash stores the 64-bit perceptual hash of an image as 16 hex digits
+const AddImagesPHash = `ALTER TABLE images ADD COLUMN phash TEXT NOT NULL DEFAULT '';`
+
+// AddImagesDHash stores the 64-bit difference hash of an image as 16 hex digits
+const AddImagesDHash = `ALTER TABLE images ADD COLUMN dhash TEXT NOT NULL DEFAULT '';`
+
+// AddImagesStatus tracks background processing: 'processing' until the
+// files are written, then 'ready', or 'failed' after the last retry
+const AddImagesStatus = `ALTER TABLE images ADD COLUMN status TEXT NOT NULL DEFAULT 'ready';`
+
+// AddImagesSourceBytes records the size of the upload, which counts towards
+// the storage quota while the image is processing and has no renditions yet
+const AddImagesSourceBytes = `ALTER TABLE images ADD COLUMN source_bytes INTEGER NOT NULL DEFAULT 0;`
+
+// CreateImageBlobRefTrigger counts a new reference to a blob
+const CreateImageBlobRefTrigger = `CREATE TRIGGER IF NOT EXISTS trg_images_blob_ref
+AFTER INSERT ON images WHEN NEW.content_hash IS NOT NULL
+BEGIN
+    UPDATE image_blobs SET ref_count = ref_count + 1 WHERE content_hash = NEW.content_hash;
+END;`
+
+// CreateImageBlobUnrefTrigger releases a reference when an image row is deleted
+const CreateImageBlobUnrefTrigger = `CREATE TRIGGER IF NOT EXISTS trg_images_blob_unref
+AFTER DELETE ON images WHEN OLD.content_hash IS NOT NULL
+BEGIN
+    UPDATE image_blobs SET ref_count = ref_count - 1 WHERE content_hash = OLD.content_hash;
+END;`
+
+// CreateImageBlobRehashTrigger moves a reference when processing sets the
+// content hash of an existing image
+const CreateImageBlobRehashTrigger = `CREATE TRIGGER IF NOT EXISTS trg_images_blob_rehash
+AFTER UPDATE OF content_hash ON images
+WHEN OLD.content_hash IS NOT NEW.content_hash
+BEGIN
+    UPDATE image_blobs SET ref_count = ref_count - 1 WHERE content_hash = OLD.content_hash;
+    UPDATE image_blobs SET ref_count = ref_count + 1 WHERE content_hash = NEW.content_hash;
+END;`
+
+// CreateJobsTable is the persistent background job queue. image_id has no
+// foreign key so a job outlives its image and can still clean up its input.
+const CreateJobsTable = `CREATE TABLE IF NOT EXISTS jobs (
+    job_id TEXT PRIMARY KEY,
+    kind TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    image_id TEXT,
+    payload TEXT NOT NULL DEFAULT '{}',
+    status TEXT NOT NULL DEFAULT 'queued',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL DEFAULT 5,
+    last_error TEXT NOT NULL DEFAULT '',
+    run_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES user(user_id) ON DELETE CASCADE
+);`
+
+// CreateUploadSessionsTable tracks resumable uploads; the received bytes
+// live in a temporary file named after upload_id
+const CreateUploadSessionsTable = `CREATE TABLE IF NOT EXISTS upload_sessions (
+    upload_id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    post_id TEXT NOT NULL,
+    filename TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    received INTEGER NOT NULL DEFAULT 0,
+    checksum TEXT NOT NULL DEFAULT '',
+    alt_text TEXT NOT NULL DEFAULT '',
+    caption TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES user(user_id) ON DELETE CASCADE,
+    FOREIGN KEY (post_id) REFERENCES posts(post_id) ON DELETE CASCADE
+);`
+
+// CreateBannedImagesTable lists the perceptual hashes of images banned by
+// moderators; uploads that look like one of them are rejected. image_id
+// has no foreign key since the banned image is usually deleted afterwards
+const CreateBannedImagesTable = `CREATE TABLE IF NOT EXISTS banned_images (
+    ban_id TEXT PRIMARY KEY,
+    phash TEXT NOT NULL,
+    dhash TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    image_id TEXT,
+    created_by TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (created_by) REFERENCES user(user_id) ON DELETE SET NULL
+);`
+
+// CreateUserAvatarsTable records the profile picture uploaded by a user: a
+// square copy of each listed size is stored under dir
+const CreateUserAvatarsTable = `CREATE TABLE IF NOT EXISTS user_avatars (
+    user_id TEXT PRIMARY KEY,
+    dir TEXT NOT NULL,
+    ext TEXT NOT NULL,
+    sizes TEXT NOT NULL DEFAULT '[]',
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES user(user_id) ON DELETE CASCADE
+);`
+
+// CreateImageScanVerdictsTable records what the upload scanner said about
+// each image and, for quarantined ones, the moderator's decision
+const CreateImageScanVerdictsTable = `CREATE TABLE IF NOT EXISTS image_scan_verdicts (
+    verdict_id TEXT PRIMARY KEY,
+    image_id TEXT,
+    content_hash TEXT NOT NULL,
+    scanner TEXT NOT NULL,
+    flagged INTEGER NOT NULL DEFAULT 0,
+    score REAL NOT NULL DEFAULT 0,
+    labels TEXT NOT NULL DEFAULT '[]',
+    error TEXT NOT NULL DEFAULT '',
+    decision TEXT NOT NULL DEFAULT '',
+    reviewed_by TEXT,
+    reviewed_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (image_id) REFERENCES images(image_id) ON DELETE SET NULL,
+    FOREIGN KEY (reviewed_by) REFERENCES user(user_id) ON DELETE SET NULL
+);`
+
 // CreateNotificationsTable stores user notifications for reactions and comments
 const CreateNotificationsTable = `CREATE TABLE IF NOT EXISTS notifications (
     notification_id TEXT PRIMARY KEY,
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// StorageConfig selects and configures the backend that holds uploaded files.
+type StorageConfig struct {
+	Driver string // "local" or "s3"
+
+	// Local driver
+	LocalRoot string
+
+	// S3-compatible driver (AWS S3, MinIO, ...)
+	S3Endpoint  string
+	S3Region    string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
+	S3PathStyle bool
+}
+
+// LoadStorageConfig reads the storage settings from the environment.
+// Without any configuration uploads are kept on the local disk under ./uploads.
+func LoadStorageConfig() StorageConfig {
+	cfg := StorageConfig{
+		Driver:      strings.ToLower(getEnv("STORAGE_DRIVER", "local")),
+		LocalRoot:   getEnv("STORAGE_LOCAL_ROOT", "uploads"),
+		S3Endpoint:  os.Getenv("S3_ENDPOINT"),
+		S3Region:    getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:    os.Getenv("S3_BUCKET"),
+		S3AccessKey: os.Getenv("S3_ACCESS_KEY"),
+		S3SecretKey: os.Getenv("S3_SECRET_KEY"),
+		S3PathStyle: getEnv("S3_PATH_STYLE", "true") == "true",
+	}
+	return cfg
+}
+
+func getEnv(key, fallback string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return fallback
+}
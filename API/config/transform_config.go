@@ -0,0 +1,60 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// TransformConfig restricts and caches the on-the-fly image transformations
+// served under /img/.
+type TransformConfig struct {
+	// BaseURL is the public URL of the /img/ route, with a trailing slash.
+	BaseURL string
+	// Sizes lists the widths and heights that may be requested.
+	Sizes []int
+	// Qualities lists the JPEG qualities that may be requested.
+	Qualities      []int
+	DefaultQuality int
+	// CacheDir holds transformed images, evicted least recently used once
+	// they exceed CacheBytes.
+	CacheDir   string
+	CacheBytes int64
+}
+
+// LoadTransformConfig reads the transformation settings from the environment.
+func LoadTransformConfig() TransformConfig {
+	cfg := TransformConfig{
+		BaseURL:        getEnv("IMAGE_TRANSFORM_BASE_URL", "http://localhost:8080/img/"),
+		Sizes:          parseInts(os.Getenv("IMAGE_TRANSFORM_SIZES"), []int{64, 128, 256, 300, 320, 400, 600, 640, 800, 1024, 1280, 1600}),
+		Qualities:      parseInts(os.Getenv("IMAGE_TRANSFORM_QUALITIES"), []int{60, 75, 80, 90}),
+		DefaultQuality: parseLimit(os.Getenv("IMAGE_TRANSFORM_QUALITY"), 80),
+		CacheDir:       getEnv("IMAGE_TRANSFORM_CACHE_DIR", filepath.Join(os.TempDir(), "forum-transforms")),
+		CacheBytes:     int64(parseLimit(os.Getenv("IMAGE_TRANSFORM_CACHE_MB"), 256)) << 20,
+	}
+	if !strings.HasSuffix(cfg.BaseURL, "/") {
+		cfg.BaseURL += "/"
+	}
+	if cfg.DefaultQuality < 1 || cfg.DefaultQuality > 100 {
+		cfg.DefaultQuality = 80
+	}
+	return cfg
+}
+
+// parseInts parses a list such as "320,640,1280" of positive integers up to
+// 4096, returning fallback when nothing valid is listed.
+func parseInts(raw string, fallback []int) []int {
+	var out []int
+	for _, part := range parseList(raw) {
+		n, err := strconv.Atoi(part)
+		if err != nil || n <= 0 || n > 4096 {
+			continue
+		}
+		out = append(out, n)
+	}
+	if len(out) == 0 {
+		return fallback
+	}
+	return out
+}
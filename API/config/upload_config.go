@@ -0,0 +1,41 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// UploadConfig controls resumable chunked uploads.
+type UploadConfig struct {
+	// ChunkDir holds the partial files of uploads in progress. It must be
+	// on local disk whatever the storage driver.
+	ChunkDir string
+	// SessionTTL is how long an upload can take before it is discarded.
+	SessionTTL time.Duration
+	// MaxChunkSize caps the body of one append request.
+	MaxChunkSize int64
+	// MaxSessionsPerUser caps the unfinished uploads a user may have open,
+	// so one user cannot fill ChunkDir.
+	MaxSessionsPerUser int
+}
+
+// LoadUploadConfig reads the resumable upload settings from the environment.
+func LoadUploadConfig() UploadConfig {
+	cfg := UploadConfig{
+		ChunkDir:           getEnv("UPLOAD_CHUNK_DIR", filepath.Join(os.TempDir(), "forum-uploads")),
+		SessionTTL:         getDuration("UPLOAD_SESSION_TTL", 24*time.Hour),
+		MaxChunkSize:       int64(parseLimit(os.Getenv("UPLOAD_MAX_CHUNK_MB"), 5)) << 20,
+		MaxSessionsPerUser: parseLimit(os.Getenv("UPLOAD_MAX_SESSIONS_PER_USER"), 5),
+	}
+	if cfg.SessionTTL <= 0 {
+		cfg.SessionTTL = 24 * time.Hour
+	}
+	if cfg.MaxChunkSize <= 0 {
+		cfg.MaxChunkSize = 5 << 20
+	}
+	if cfg.MaxSessionsPerUser <= 0 {
+		cfg.MaxSessionsPerUser = 5
+	}
+	return cfg
+}
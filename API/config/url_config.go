@@ -0,0 +1,38 @@
+package config
+
+import (
+	"crypto/rand"
+	"log"
+	"strings"
+	"time"
+)
+
+// StaticURLConfig controls the URLs handed out for uploaded files.
+type StaticURLConfig struct {
+	// Base is the public URL of the /static/ route, with a trailing slash.
+	Base string
+	// Secret signs the URLs.
+	Secret []byte
+	// TTL is how long a signed URL stays valid; zero serves files unsigned.
+	TTL time.Duration
+}
+
+// LoadStaticURLConfig reads the URL settings from the environment. Without
+// IMAGE_URL_SECRET a random secret is used, so links stop working when the
+// server restarts and are not shared between instances.
+func LoadStaticURLConfig() StaticURLConfig {
+	cfg := StaticURLConfig{
+		Base:   getEnv("STATIC_BASE_URL", "http://localhost:8080/static/"),
+		Secret: []byte(getEnv("IMAGE_URL_SECRET", "")),
+		TTL:    getDuration("IMAGE_URL_TTL", time.Hour),
+	}
+	if !strings.HasSuffix(cfg.Base, "/") {
+		cfg.Base += "/"
+	}
+	if cfg.TTL > 0 && len(cfg.Secret) == 0 {
+		log.Println("IMAGE_URL_SECRET is not set; image links will not survive a restart")
+		cfg.Secret = make([]byte, 32)
+		rand.Read(cfg.Secret)
+	}
+	return cfg
+}
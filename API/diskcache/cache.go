@@ -0,0 +1,157 @@
+// Package diskcache keeps generated files on local disk up to a size limit,
+// evicting the least recently used ones.
+package diskcache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+type entry struct {
+	name string
+	size int64
+}
+
+// Cache maps string keys to files named after their SHA-256. Recency is kept
+// in memory; on start it is rebuilt from the files' modification times.
+type Cache struct {
+	dir string
+	max int64
+
+	mu      sync.Mutex
+	lru     *list.List // front is most recently used
+	entries map[string]*list.Element
+	size    int64
+}
+
+// New opens the cache in dir, creating it if needed. A max of zero disables
+// caching: Get always misses and Put stores nothing.
+func New(dir string, max int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	c := &Cache{dir: dir, max: max, lru: list.New(), entries: map[string]*list.Element{}}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type found struct {
+		entry
+		mod int64
+	}
+	var existing []found
+	for _, f := range files {
+		info, err := f.Info()
+		if err != nil || !info.Mode().IsRegular() || filepath.Ext(f.Name()) == ".tmp" {
+			os.Remove(filepath.Join(dir, f.Name()))
+			continue
+		}
+		existing = append(existing, found{entry{f.Name(), info.Size()}, info.ModTime().UnixNano()})
+	}
+	sort.Slice(existing, func(i, j int) bool { return existing[i].mod > existing[j].mod })
+	for _, f := range existing {
+		c.entries[f.name] = c.lru.PushBack(&entry{f.name, f.size})
+		c.size += f.size
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+func fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Get returns the cached data for key.
+func (c *Cache) Get(key string) ([]byte, bool) {
+	name := fileName(key)
+	c.mu.Lock()
+	el, ok := c.entries[name]
+	if ok {
+		c.lru.MoveToFront(el)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	data, err := os.ReadFile(filepath.Join(c.dir, name))
+	if err != nil {
+		c.mu.Lock()
+		c.remove(el)
+		c.mu.Unlock()
+		return nil, false
+	}
+	return data, true
+}
+
+// Put stores data under key and evicts old entries beyond the size limit.
+// Entries larger than the whole cache are not stored.
+func (c *Cache) Put(key string, data []byte) error {
+	size := int64(len(data))
+	if size > c.max {
+		return nil
+	}
+	name := fileName(key)
+	tmp, err := os.CreateTemp(c.dir, name+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.Rename(tmp.Name(), filepath.Join(c.dir, name)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if el, ok := c.entries[name]; ok {
+		c.size -= el.Value.(*entry).size
+		el.Value.(*entry).size = size
+		c.lru.MoveToFront(el)
+	} else {
+		c.entries[name] = c.lru.PushFront(&entry{name, size})
+	}
+	c.size += size
+	c.evict()
+	return nil
+}
+
+// evict removes least recently used entries until the cache fits. c.mu must
+// be held.
+func (c *Cache) evict() {
+	for c.size > c.max {
+		el := c.lru.Back()
+		if el == nil {
+			return
+		}
+		c.remove(el)
+	}
+}
+
+// remove drops an entry and its file. c.mu must be held.
+func (c *Cache) remove(el *list.Element) {
+	e := el.Value.(*entry)
+	if _, ok := c.entries[e.name]; !ok {
+		return
+	}
+	c.lru.Remove(el)
+	delete(c.entries, e.name)
+	c.size -= e.size
+	os.Remove(filepath.Join(c.dir, e.name))
+}
@@ -0,0 +1,214 @@
+// Package gc reconciles uploaded files with the database and removes the
+// ones nothing references any more.
+package gc
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"forum/repository"
+	"forum/storage"
+)
+
+// Prefix is the part of the store the collector owns.
+const Prefix = "images/"
+
+// IncomingPrefix holds raw uploads waiting for their processing job. The
+// job removes the file when it finishes, so files left here belong to
+// uploads whose job was never queued or whose row was deleted since.
+const IncomingPrefix = "incoming/"
+
+// variantExts are the extensions of modern-format variants stored next to a
+// file under its key plus the extension.
+var variantExts = []string{".webp", ".avif"}
+
+// Orphan is a stored file that no database row references.
+type Orphan struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size_bytes"`
+	ModTime time.Time `json:"modified_at"`
+	// Removed is set once the file was deleted; young orphans within the
+	// grace period and dry runs leave it false.
+	Removed bool `json:"removed"`
+}
+
+// Report summarises one sweep.
+type Report struct {
+	StartedAt     time.Time `json:"started_at"`
+	Duration      string    `json:"duration"`
+	DryRun        bool      `json:"dry_run"`
+	Scanned       int       `json:"scanned"`
+	Referenced    int       `json:"referenced"`
+	ReleasedBlobs int       `json:"released_blobs"`
+	Orphans       []Orphan  `json:"orphans"`
+	// Missing lists keys the database references that are not in storage.
+	Missing      []string `json:"missing"`
+	RemovedCount int      `json:"removed_count"`
+	RemovedBytes int64    `json:"removed_bytes"`
+	Errors       []string `json:"errors,omitempty"`
+}
+
+// String renders the report for logs and the command line.
+func (r *Report) String() string {
+	var b strings.Builder
+	mode := ""
+	if r.DryRun {
+		mode = " (dry run)"
+	}
+	fmt.Fprintf(&b, "image gc%s: scanned %d files, %d referenced, %d orphaned, %d missing, %d released blobs\n",
+		mode, r.Scanned, r.Referenced, len(r.Orphans), len(r.Missing), r.ReleasedBlobs)
+	for _, o := range r.Orphans {
+		state := "kept (grace period)"
+		switch {
+		case o.Removed:
+			state = "removed"
+		case r.DryRun:
+			state = "would remove"
+		}
+		fmt.Fprintf(&b, "  orphan  %s  %d bytes  %s  %s\n", o.Key, o.Size, o.ModTime.Format(time.RFC3339), state)
+	}
+	for _, key := range r.Missing {
+		fmt.Fprintf(&b, "  missing %s\n", key)
+	}
+	for _, e := range r.Errors {
+		fmt.Fprintf(&b, "  error   %s\n", e)
+	}
+	fmt.Fprintf(&b, "removed %d files (%d bytes) in %s", r.RemovedCount, r.RemovedBytes, r.Duration)
+	return b.String()
+}
+
+// Collector removes unreferenced uploads older than Grace.
+type Collector struct {
+	Repo   *repository.ImageRepository
+	Jobs   *repository.JobRepository
+	Store  storage.Storage
+	Grace  time.Duration
+	DryRun bool
+
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+// NewCollector returns a collector for the given repositories and store.
+func NewCollector(repo *repository.ImageRepository, jobs *repository.JobRepository, store storage.Storage, grace time.Duration, dryRun bool) *Collector {
+	return &Collector{Repo: repo, Jobs: jobs, Store: store, Grace: grace, DryRun: dryRun, now: time.Now}
+}
+
+// Run performs one sweep. Blobs whose last reference is gone are released
+// first (except in dry runs), then every file under Prefix that is neither
+// referenced nor a variant of a referenced file is an orphan, as is every
+// file under IncomingPrefix that no queued or running job is waiting to
+// read. Orphans older than the grace period are deleted.
+func (c *Collector) Run() (*Report, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	start := c.now()
+	report := &Report{StartedAt: start, DryRun: c.DryRun, Orphans: []Orphan{}, Missing: []string{}}
+
+	if !c.DryRun {
+		blobs, err := c.Repo.ReleaseBlobs()
+		if err != nil {
+			return nil, fmt.Errorf("release blobs: %v", err)
+		}
+		report.ReleasedBlobs = len(blobs)
+	}
+
+	// Read the references before listing: a file uploaded in between is
+	// then at worst seen as an orphan younger than the grace period.
+	refs, err := c.Repo.ReferencedKeys()
+	if err != nil {
+		return nil, fmt.Errorf("load referenced keys: %v", err)
+	}
+	pending, err := c.Jobs.PendingSourceKeys()
+	if err != nil {
+		return nil, fmt.Errorf("load pending uploads: %v", err)
+	}
+	objects, err := c.Store.List(Prefix)
+	if err != nil {
+		return nil, fmt.Errorf("list %s: %v", Prefix, err)
+	}
+	incoming, err := c.Store.List(IncomingPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("list %s: %v", IncomingPrefix, err)
+	}
+
+	present := make(map[string]bool, len(objects))
+	for _, obj := range objects {
+		present[obj.Key] = true
+	}
+	cutoff := start.Add(-c.Grace)
+	c.sweep(report, objects, func(key string) bool { return isReferenced(key, refs) }, cutoff)
+	c.sweep(report, incoming, func(key string) bool { return pending[key] }, cutoff)
+
+	for key := range refs {
+		if strings.HasPrefix(key, Prefix) && !present[key] {
+			report.Missing = append(report.Missing, key)
+		}
+	}
+	sort.Strings(report.Missing)
+	report.Duration = c.now().Sub(start).Round(time.Millisecond).String()
+	return report, nil
+}
+
+// sweep counts objects into report and deletes the unreferenced ones last
+// modified before cutoff.
+func (c *Collector) sweep(report *Report, objects []storage.ObjectInfo, referenced func(key string) bool, cutoff time.Time) {
+	for _, obj := range objects {
+		report.Scanned++
+		if referenced(obj.Key) {
+			report.Referenced++
+			continue
+		}
+		orphan := Orphan{Key: obj.Key, Size: obj.Size, ModTime: obj.ModTime}
+		if !c.DryRun && obj.ModTime.Before(cutoff) {
+			if err := c.Store.Delete(obj.Key); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("delete %s: %v", obj.Key, err))
+			} else {
+				orphan.Removed = true
+				report.RemovedCount++
+				report.RemovedBytes += obj.Size
+			}
+		}
+		report.Orphans = append(report.Orphans, orphan)
+	}
+}
+
+// Start runs a sweep every interval in the background and logs the reports.
+func (c *Collector) Start(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			report, err := c.Run()
+			if err != nil {
+				log.Printf("Image GC failed: %v", err)
+				continue
+			}
+			if len(report.Orphans) > 0 || len(report.Missing) > 0 || len(report.Errors) > 0 {
+				log.Print(report)
+			}
+		}
+	}()
+}
+
+func isReferenced(key string, refs map[string]bool) bool {
+	if refs[key] {
+		return true
+	}
+	ext := path.Ext(key)
+	for _, v := range variantExts {
+		if ext == v {
+			return refs[strings.TrimSuffix(key, ext)]
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package gc
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"forum/config"
+	"forum/repository"
+	"forum/storage"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestCollectorRun(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "forum.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, stmt := range []string{
+		config.CreateImagesTable,
+		config.CreateImageBlobsTable,
+		config.AddImagesContentHash,
+		config.CreateImageRenditionsTable,
+		config.CreateJobsTable,
+		`INSERT INTO images (image_id, post_id, user_id, file_path, thumbnail_path) VALUES ('i1', 'p1', 'u1', 'images/a.jpg', 'images/a_thumb.jpg')`,
+		`INSERT INTO jobs (job_id, kind, user_id, payload, status, run_at) VALUES
+			('j1', 'image.process', 'u1', '{"source_key": "incoming/queued.png"}', 'queued', CURRENT_TIMESTAMP),
+			('j2', 'image.process', 'u1', '{"source_key": "incoming/running.png"}', 'running', CURRENT_TIMESTAMP),
+			('j3', 'image.process', 'u1', '{"source_key": "incoming/done.png"}', 'done', CURRENT_TIMESTAMP),
+			('j4', 'image.process', 'u1', '{"source_key": "incoming/failed.png"}', 'failed', CURRENT_TIMESTAMP)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%v\n%s", err, stmt)
+		}
+	}
+
+	root := t.TempDir()
+	store, err := storage.NewLocalStorage(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	for key, aged := range map[string]bool{
+		"images/a.jpg":         true,
+		"images/a.jpg.webp":    true,
+		"images/a_thumb.jpg":   true,
+		"images/stray.jpg":     true,
+		"images/young.jpg":     false,
+		"incoming/queued.png":  true,
+		"incoming/running.png": true,
+		"incoming/done.png":    true,
+		"incoming/failed.png":  true,
+		"incoming/lost.png":    true,
+		"incoming/fresh.png":   false,
+	} {
+		if err := store.Put(key, strings.NewReader("data"), ""); err != nil {
+			t.Fatal(err)
+		}
+		if aged {
+			if err := os.Chtimes(filepath.Join(root, filepath.FromSlash(key)), old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	c := NewCollector(repository.NewImageRepository(db), repository.NewJobRepository(db), store, time.Hour, false)
+	report, err := c.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Scanned != 11 || report.Referenced != 5 || len(report.Errors) > 0 {
+		t.Errorf("scanned %d, referenced %d, errors %v", report.Scanned, report.Referenced, report.Errors)
+	}
+
+	var removed, kept []string
+	for _, o := range report.Orphans {
+		if o.Removed {
+			removed = append(removed, o.Key)
+		} else {
+			kept = append(kept, o.Key)
+		}
+	}
+	sort.Strings(removed)
+	sort.Strings(kept)
+	if want := []string{"images/stray.jpg", "incoming/done.png", "incoming/failed.png", "incoming/lost.png"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed %v, want %v", removed, want)
+	}
+	if want := []string{"images/young.jpg", "incoming/fresh.png"}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("kept %v, want %v", kept, want)
+	}
+
+	left, err := store.List(IncomingPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, obj := range left {
+		keys = append(keys, obj.Key)
+	}
+	sort.Strings(keys)
+	if want := []string{"incoming/fresh.png", "incoming/queued.png", "incoming/running.png"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("left %v, want %v", keys, want)
+	}
+}
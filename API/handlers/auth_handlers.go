@@ -313,5 +313,7 @@ func (h *AuthHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	utils.JSONResponse(w, user, http.StatusOK)
+	profile := *user
+	profile.AvatarURL = avatarURL(user.ID)
+	utils.JSONResponse(w, profile, http.StatusOK)
 }
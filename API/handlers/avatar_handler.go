@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/config"
+	"forum/imaging"
+	"forum/middleware"
+	"forum/models"
+	"forum/repository"
+	"forum/storage"
+	"forum/utils"
+)
+
+// avatarDir is the key prefix of uploaded avatars inside the storage
+// backend. Avatars are served by AvatarHandler, not under /static/.
+const avatarDir = "avatars"
+
+// defaultAvatarSize is served when a request does not ask for a size.
+const defaultAvatarSize = 64
+
+// avatarCacheAge is how long clients may reuse an avatar response. avatar_url
+// does not change when a user replaces their avatar, so it is kept short.
+const avatarCacheAge = 5 * time.Minute
+
+// avatarBaseURL is the public URL of the /avatars/ route; see
+// SetAvatarBaseURL.
+var avatarBaseURL = "http://localhost:8080/avatars/"
+
+// SetAvatarBaseURL sets the address every avatar_url points at.
+func SetAvatarBaseURL(base string) {
+	avatarBaseURL = base
+}
+
+// avatarURL is the address of a user's avatar, whichever picture it ends up
+// being. Clients can add ?s=<pixels> to pick a size.
+func avatarURL(userID string) string {
+	return avatarBaseURL + userID
+}
+
+// AvatarHandler stores profile pictures and serves them under
+// /avatars/{user_id}. Users without one get their OAuth provider's picture
+// or a generated identicon.
+type AvatarHandler struct {
+	Images  *ImageHandler
+	Avatars *repository.AvatarRepository
+	Config  config.AvatarConfig
+}
+
+func NewAvatarHandler(images *ImageHandler, avatars *repository.AvatarRepository, cfg config.AvatarConfig) *AvatarHandler {
+	return &AvatarHandler{Images: images, Avatars: avatars, Config: cfg}
+}
+
+// Upload replaces the current user's avatar. The image goes through the
+// same type, decoding limit and banned image checks as post images, is
+// cropped to a square around the optional fx/fy focal point and stored in
+// every configured size up to its own.
+func (h *AvatarHandler) Upload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := r.ParseMultipartForm(21 << 20); err != nil {
+		utils.ErrorResponse(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+	fx, err := parseFocus(r.FormValue("fx"))
+	if err != nil {
+		utils.ErrorResponse(w, "fx: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	fy, err := parseFocus(r.FormValue("fy"))
+	if err != nil {
+		utils.ErrorResponse(w, "fy: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	file, header, err := r.FormFile("image")
+	if err != nil {
+		utils.ErrorResponse(w, "Image file required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	if header.Size > maxUploadSize {
+		utils.ErrorResponse(w, "Image exceeds 20 MB limit", http.StatusBadRequest)
+		return
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to read image", http.StatusBadRequest)
+		return
+	}
+
+	contentType, _, ok := detectImageType(header.Filename, data)
+	if !ok {
+		utils.ErrorResponse(w, "Unsupported image type", http.StatusBadRequest)
+		return
+	}
+	if e := h.Images.checkDecodeLimits(data, contentType); e != nil {
+		e.write(w)
+		return
+	}
+	// Animated GIFs keep their first frame.
+	img, gifData, err := decodeUpload(data, contentType)
+	if err != nil {
+		utils.ReasonErrorResponse(w, "Failed to decode image", http.StatusBadRequest, "invalid_image", nil)
+		return
+	}
+	if gifData == nil {
+		img = imaging.Orient(img, imaging.ReadMetadata(data, contentType).Orientation)
+	}
+	if e := h.Images.checkBanned(imageHashes(img)); e != nil {
+		e.write(w)
+		return
+	}
+
+	side := min(img.Bounds().Dx(), img.Bounds().Dy())
+	square := cropAround(img, side, side, fx, fy)
+	// Avatars are stored as JPEG, or PNG when they use transparency.
+	contentType, ext := "image/jpeg", ".jpg"
+	if o, ok := square.(interface{ Opaque() bool }); ok && !o.Opaque() {
+		contentType, ext = "image/png", ".png"
+	}
+
+	// Avatars are never upscaled. The square is stored as it is under the
+	// first size at least as large, and the sizes above are left out so
+	// requests for them get that copy.
+	var sizes []int
+	for _, size := range h.Config.Sizes {
+		sizes = append(sizes, size)
+		if size >= side {
+			break
+		}
+	}
+	avatar := models.Avatar{
+		UserID: user.ID,
+		Dir:    path.Join(avatarDir, user.ID, contentHash(data)[:16]),
+		Ext:    ext,
+		Sizes:  sizes,
+	}
+	var buf bytes.Buffer
+	for i, size := range avatar.Sizes {
+		buf.Reset()
+		edge := min(size, side)
+		err := encodeImage(&buf, resizeImage(square, edge, edge), contentType)
+		if err == nil {
+			err = h.Images.Store.Put(avatarKey(&avatar, size), &buf, contentType)
+		}
+		if err != nil {
+			log.Printf("Failed to store avatar of user %s: %v", user.ID, err)
+			avatar.Sizes = avatar.Sizes[:i]
+			h.removeFiles(&avatar)
+			utils.ErrorResponse(w, "Failed to save avatar", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	previous, err := h.Avatars.Set(avatar)
+	if err != nil {
+		h.removeFiles(&avatar)
+		utils.ErrorResponse(w, "Failed to save avatar", http.StatusInternalServerError)
+		return
+	}
+	if previous != nil && previous.Dir != avatar.Dir {
+		h.removeFiles(previous)
+	}
+	utils.JSONResponse(w, map[string]string{"avatar_url": avatarURL(user.ID)}, http.StatusOK)
+}
+
+// Delete removes the current user's avatar, going back to the fallback.
+func (h *AvatarHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	previous, err := h.Avatars.Delete(user.ID)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to delete avatar", http.StatusInternalServerError)
+		return
+	}
+	if previous != nil {
+		h.removeFiles(previous)
+	}
+	utils.JSONResponse(w, map[string]string{"avatar_url": avatarURL(user.ID)}, http.StatusOK)
+}
+
+// ServeHTTP handles GET /avatars/{user_id}?s=<pixels>, mounted with the
+// /avatars/ prefix stripped. It serves the uploaded avatar in the smallest
+// stored size of at least s, redirects to the OAuth picture, or draws an
+// identicon.
+func (h *AvatarHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := r.URL.Path
+	if userID == "" || strings.Contains(userID, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	size := defaultAvatarSize
+	if raw := r.URL.Query().Get("s"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			http.Error(w, "s must be a positive number of pixels", http.StatusBadRequest)
+			return
+		}
+		size = n
+	}
+	cacheControl := "public, max-age=" + strconv.Itoa(int(avatarCacheAge/time.Second))
+
+	avatar, err := h.Avatars.Get(userID)
+	if err != nil {
+		http.Error(w, "Failed to load avatar", http.StatusInternalServerError)
+		return
+	}
+	if avatar != nil && len(avatar.Sizes) > 0 {
+		key := avatarKey(avatar, pickSize(avatar.Sizes, size))
+		body, info, err := h.Images.Store.Get(key)
+		if err == nil {
+			defer body.Close()
+			data, err := io.ReadAll(body)
+			if err != nil {
+				http.Error(w, "Failed to read avatar", http.StatusInternalServerError)
+				return
+			}
+			sum := sha256.Sum256([]byte(key))
+			w.Header().Set("Content-Type", info.ContentType)
+			w.Header().Set("Cache-Control", cacheControl)
+			w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+			http.ServeContent(w, r, "", info.ModTime, bytes.NewReader(data))
+			return
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "Failed to read avatar", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Avatar file %s of user %s is missing", key, userID)
+	}
+
+	oauthURL, err := h.Avatars.OAuthAvatarURL(userID)
+	if err != nil {
+		http.Error(w, "Failed to load avatar", http.StatusInternalServerError)
+		return
+	}
+	if oauthURL != "" {
+		w.Header().Set("Cache-Control", cacheControl)
+		http.Redirect(w, r, oauthURL, http.StatusFound)
+		return
+	}
+
+	exists, err := h.Avatars.UserExists(userID)
+	if err != nil {
+		http.Error(w, "Failed to load avatar", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+	size = pickSize(h.Config.Sizes, size)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, imaging.Identicon(userID, size)); err != nil {
+		http.Error(w, "Failed to draw avatar", http.StatusInternalServerError)
+		return
+	}
+	// Every user has their own identicon, so the ETag names the user too.
+	sum := sha256.Sum256([]byte(userID))
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("ETag", `"identicon-`+hex.EncodeToString(sum[:8])+`-`+strconv.Itoa(size)+`"`)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(buf.Bytes()))
+}
+
+// avatarKey is the storage key of one size of an avatar.
+func avatarKey(a *models.Avatar, size int) string {
+	return path.Join(a.Dir, strconv.Itoa(size)+a.Ext)
+}
+
+// pickSize returns the smallest of the ascending sizes that is at least
+// want, or the largest one.
+func pickSize(sizes []int, want int) int {
+	for _, s := range sizes {
+		if s >= want {
+			return s
+		}
+	}
+	return sizes[len(sizes)-1]
+}
+
+// removeFiles deletes the stored sizes of an avatar.
+func (h *AvatarHandler) removeFiles(a *models.Avatar) {
+	for _, size := range a.Sizes {
+		if err := h.Images.Store.Delete(avatarKey(a, size)); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Failed to delete avatar file: %v", err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"forum/config"
+	"forum/models"
+	"forum/repository"
+	"forum/storage"
+)
+
+// newAvatarTestHandler returns an AvatarHandler over a fresh database with
+// users u1 and u2, mounted under /avatars/ as in the routes.
+func newAvatarTestHandler(t *testing.T) (*AvatarHandler, http.Handler) {
+	t.Helper()
+	db := openTestDB(t,
+		config.CreateUserTable,
+		config.CreateUserAvatarsTable,
+		config.CreateOAuthTable,
+		config.CreateBannedImagesTable,
+		`INSERT INTO user (user_id, username, email) VALUES ('u1', 'alice', 'a@example.com'), ('u2', 'bob', 'b@example.com')`,
+	)
+	store, err := storage.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := &ImageHandler{
+		HashRepo: repository.NewImageHashRepository(db),
+		Store:    store,
+		Config:   config.ImageConfig{HashDistance: 10},
+	}
+	h := NewAvatarHandler(images, repository.NewAvatarRepository(db), config.AvatarConfig{Sizes: []int{32, 64, 128, 256}})
+	return h, http.StripPrefix("/avatars/", h)
+}
+
+func TestIdenticonETag(t *testing.T) {
+	_, srv := newAvatarTestHandler(t)
+	etag := func(path string) string {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" {
+			t.Fatalf("%s: status %d, %s", path, rec.Code, rec.Header().Get("Content-Type"))
+		}
+		return rec.Header().Get("ETag")
+	}
+	u1, u2 := etag("/avatars/u1?s=64"), etag("/avatars/u2?s=64")
+	if u1 == u2 {
+		t.Errorf("users share the ETag %s", u1)
+	}
+	if again := etag("/avatars/u1?s=64"); again != u1 {
+		t.Errorf("ETag changed from %s to %s", u1, again)
+	}
+	if big := etag("/avatars/u1?s=256"); big == u1 {
+		t.Errorf("sizes share the ETag %s", u1)
+	}
+
+	// A client holding another user's identicon must not get a 304
+	req := httptest.NewRequest(http.MethodGet, "/avatars/u2?s=64", nil)
+	req.Header.Set("If-None-Match", u1)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("status %d for another user's ETag", rec.Code)
+	}
+}
+
+// uploadAvatar posts a w x h picture as the avatar of user u1.
+func uploadAvatar(t *testing.T, h *AvatarHandler, w, hgt int) {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, hgt))
+	for y := 0; y < hgt; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 3), uint8(y * 3), 90, 255})
+		}
+	}
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("image", "avatar.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(part, img); err != nil {
+		t.Fatal(err)
+	}
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/forum/api/user/avatar", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req = req.WithContext(context.WithValue(req.Context(), "user", &models.User{ID: "u1"}))
+	rec := httptest.NewRecorder()
+	h.Upload(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestAvatarNotUpscaled(t *testing.T) {
+	h, srv := newAvatarTestHandler(t)
+	served := func(s string) image.Point {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/avatars/u1?s="+s, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("s=%s: status %d", s, rec.Code)
+		}
+		img, _, err := image.Decode(rec.Body)
+		if err != nil {
+			t.Fatalf("s=%s: %v", s, err)
+		}
+		return img.Bounds().Size()
+	}
+
+	for _, tt := range []struct {
+		w, h  int
+		sizes []int
+		// served maps requested sizes to the edge of the picture served
+		served map[string]int
+	}{
+		{80, 50, []int{32, 64}, map[string]int{"32": 32, "64": 50, "256": 50}},
+		{20, 30, []int{32}, map[string]int{"32": 20, "128": 20}},
+		{128, 128, []int{32, 64, 128}, map[string]int{"64": 64, "128": 128, "256": 128}},
+		{300, 400, []int{32, 64, 128, 256}, map[string]int{"256": 256, "512": 256}},
+	} {
+		uploadAvatar(t, h, tt.w, tt.h)
+		avatar, err := h.Avatars.Get("u1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(avatar.Sizes, tt.sizes) {
+			t.Errorf("%dx%d: stored sizes %v, want %v", tt.w, tt.h, avatar.Sizes, tt.sizes)
+		}
+		for s, edge := range tt.served {
+			if got := served(s); got != image.Pt(edge, edge) {
+				t.Errorf("%dx%d at s=%s: served %v, want %dx%d", tt.w, tt.h, s, got, edge, edge)
+			}
+		}
+	}
+}
@@ -60,6 +60,11 @@ func (h *CategoryHandler) GetCategoryByID(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	page, ok := postPage(w, r)
+	if !ok {
+		return
+	}
+
 	category, err := h.CategoryRepo.GetCategoryByID(id)
 	if err != nil {
 		utils.ErrorResponse(w, "Failed to load category", http.StatusInternalServerError)
@@ -71,28 +76,41 @@ func (h *CategoryHandler) GetCategoryByID(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	posts, err := h.PostRepo.GetPostsByCategoryWithUser(id)
+	posts, next, err := h.PostRepo.GetPostsByCategoryWithUser(id, page)
 	if err != nil {
 		utils.ErrorResponse(w, "Failed to fetch posts", http.StatusInternalServerError)
 		return
 	}
 
+	ids := make([]string, len(posts))
 	for i := range posts {
-		imgs, err := h.ImageRepo.GetByPostID(posts[i].ID)
-		if err != nil {
-			utils.ErrorResponse(w, "Failed to load images", http.StatusInternalServerError)
-			return
-		}
+		ids[i] = posts[i].ID
+	}
+	images, err := h.ImageRepo.GetByPostIDs(ids)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load images", http.StatusInternalServerError)
+		return
+	}
+	for i := range posts {
+		posts[i].AvatarURL = avatarURL(posts[i].UserID)
+		imgs := images[posts[i].ID]
+		posts[i].Images = postImages(imgs)
 		if len(imgs) > 0 {
-			posts[i].ImageURL = apiStaticBase + imgs[0].FilePath
-			posts[i].ThumbnailURL = apiStaticBase + imgs[0].ThumbnailPath
+			posts[i].ImageURL, posts[i].ImageAltText = staticURLs.URL(imgs[0].FilePath), imgs[0].AltText
+			posts[i].ThumbnailURL = staticURLs.URL(imgs[0].ThumbnailPath)
+			posts[i].Renditions, posts[i].SrcSet = renditionURLs(imgs[0].Renditions)
+			posts[i].BlurHash, posts[i].DominantColor = imgs[0].BlurHash, imgs[0].DominantColor
 		}
 	}
 
+	if posts == nil {
+		posts = []models.PostWithUser{}
+	}
 	categoryByID := models.CategoryWithPosts{
-		ID:    category.ID,
-		Name:  category.Name,
-		Posts: posts,
+		ID:         category.ID,
+		Name:       category.Name,
+		Posts:      posts,
+		NextCursor: encodeCursor(next),
 	}
 
 	utils.JSONResponse(w, categoryByID, http.StatusOK)
@@ -0,0 +1,378 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/config"
+	"forum/middleware"
+	"forum/models"
+	"forum/repository"
+	"forum/uploads"
+	"forum/utils"
+)
+
+var sha256Hex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// ChunkedUploadHandler implements resumable uploads: a session is opened
+// with the total size, the bytes are appended in chunks at explicit offsets,
+// and the finished file goes through the same pipeline as a direct upload.
+type ChunkedUploadHandler struct {
+	Images   *ImageHandler
+	Sessions *repository.UploadSessionRepository
+	Chunks   *uploads.ChunkStore
+	Config   config.UploadConfig
+}
+
+func NewChunkedUploadHandler(images *ImageHandler, sessions *repository.UploadSessionRepository, chunks *uploads.ChunkStore, cfg config.UploadConfig) *ChunkedUploadHandler {
+	return &ChunkedUploadHandler{Images: images, Sessions: sessions, Chunks: chunks, Config: cfg}
+}
+
+// sessionResponse is a session with the offset to resume from.
+type sessionResponse struct {
+	*models.UploadSession
+	Complete  bool  `json:"complete"`
+	ChunkSize int64 `json:"chunk_size"`
+}
+
+func (h *ChunkedUploadHandler) respond(w http.ResponseWriter, s *models.UploadSession, status int) {
+	utils.JSONResponse(w, sessionResponse{UploadSession: s, Complete: s.Received == s.Size, ChunkSize: h.Config.MaxChunkSize}, status)
+}
+
+// Init opens an upload session:
+// {"post_id", "filename", "size", "sha256" (optional), "alt_text", "caption"}.
+func (h *ChunkedUploadHandler) Init(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		PostID   string `json:"post_id"`
+		Filename string `json:"filename"`
+		Size     int64  `json:"size"`
+		SHA256   string `json:"sha256"`
+		AltText  string `json:"alt_text"`
+		Caption  string `json:"caption"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.PostID == "" {
+		utils.ReasonErrorResponse(w, "Post ID required", http.StatusBadRequest, "post_id_required", nil)
+		return
+	}
+	if e := h.Images.checkPostAuthor(req.PostID, user.ID); e != nil {
+		e.write(w)
+		return
+	}
+	if req.Size <= 0 {
+		utils.ErrorResponse(w, "Upload size required", http.StatusBadRequest)
+		return
+	}
+	if req.Size > maxUploadSize {
+		utils.ReasonErrorResponse(w, "Image exceeds 20 MB limit", http.StatusRequestEntityTooLarge, "upload_too_large",
+			map[string]interface{}{"size": req.Size, "max_size": maxUploadSize})
+		return
+	}
+	req.SHA256 = strings.ToLower(strings.TrimSpace(req.SHA256))
+	if req.SHA256 != "" && !sha256Hex.MatchString(req.SHA256) {
+		utils.ErrorResponse(w, "sha256 must be 64 hex digits", http.StatusBadRequest)
+		return
+	}
+	req.AltText, req.Caption = strings.TrimSpace(req.AltText), strings.TrimSpace(req.Caption)
+	if e := h.Images.checkImageText(req.AltText, req.Caption); e != nil {
+		e.write(w)
+		return
+	}
+	if e := h.Images.checkQuota(req.PostID, user.ID, req.Size); e != nil {
+		e.write(w)
+		return
+	}
+
+	session, err := h.Sessions.Create(models.UploadSession{
+		UserID:    user.ID,
+		PostID:    req.PostID,
+		Filename:  req.Filename,
+		Size:      req.Size,
+		Checksum:  req.SHA256,
+		AltText:   req.AltText,
+		Caption:   req.Caption,
+		ExpiresAt: time.Now().Add(h.Config.SessionTTL),
+	}, h.Config.MaxSessionsPerUser)
+	if errors.Is(err, repository.ErrTooManyUploads) {
+		utils.ReasonErrorResponse(w, fmt.Sprintf("At most %d uploads can be in progress at once", h.Config.MaxSessionsPerUser), http.StatusTooManyRequests, "too_many_uploads",
+			map[string]interface{}{"max_sessions": h.Config.MaxSessionsPerUser})
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to start upload", http.StatusInternalServerError)
+		return
+	}
+	if err := h.Chunks.Create(session.ID); err != nil {
+		h.Sessions.Delete(session.ID)
+		utils.ErrorResponse(w, "Failed to start upload", http.StatusInternalServerError)
+		return
+	}
+	h.respond(w, session, http.StatusCreated)
+}
+
+// loadSession returns the user's session, writing a 404 otherwise. Its
+// offset is taken from the stored data, which is authoritative.
+func (h *ChunkedUploadHandler) loadSession(w http.ResponseWriter, id, userID string) *models.UploadSession {
+	if id == "" {
+		utils.ErrorResponse(w, "Upload ID required", http.StatusBadRequest)
+		return nil
+	}
+	session, err := h.Sessions.GetByID(id)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load upload", http.StatusInternalServerError)
+		return nil
+	}
+	if session == nil || session.UserID != userID {
+		utils.ReasonErrorResponse(w, "Upload not found or expired", http.StatusNotFound, "upload_not_found", nil)
+		return nil
+	}
+	received, err := h.Chunks.Size(session.ID)
+	if err != nil {
+		utils.ReasonErrorResponse(w, "Upload not found or expired", http.StatusNotFound, "upload_not_found", nil)
+		return nil
+	}
+	session.Received = received
+	return session
+}
+
+// Append adds one chunk: POST ?id=<upload_id>&offset=<bytes received>
+// with the raw bytes as body and an optional X-Chunk-SHA256 header.
+func (h *ChunkedUploadHandler) Append(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		utils.ErrorResponse(w, "Invalid offset", http.StatusBadRequest)
+		return
+	}
+	session := h.loadSession(w, r.URL.Query().Get("id"), user.ID)
+	if session == nil {
+		return
+	}
+	if offset != session.Received {
+		utils.ReasonErrorResponse(w, "Offset does not match the received bytes", http.StatusConflict, "offset_mismatch",
+			map[string]interface{}{"offset": session.Received})
+		return
+	}
+
+	chunk, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.Config.MaxChunkSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			utils.ReasonErrorResponse(w, fmt.Sprintf("Chunks are limited to %d bytes", h.Config.MaxChunkSize), http.StatusRequestEntityTooLarge, "chunk_too_large",
+				map[string]interface{}{"max_chunk_size": h.Config.MaxChunkSize})
+			return
+		}
+		utils.ErrorResponse(w, "Failed to read chunk", http.StatusBadRequest)
+		return
+	}
+	if len(chunk) == 0 {
+		utils.ErrorResponse(w, "Empty chunk", http.StatusBadRequest)
+		return
+	}
+	if want := strings.ToLower(strings.TrimSpace(r.Header.Get("X-Chunk-SHA256"))); want != "" {
+		sum := sha256.Sum256(chunk)
+		if hex.EncodeToString(sum[:]) != want {
+			utils.ReasonErrorResponse(w, "Chunk checksum mismatch", http.StatusBadRequest, "chunk_checksum_mismatch",
+				map[string]interface{}{"offset": session.Received})
+			return
+		}
+	}
+
+	received, err := h.Chunks.Append(session.ID, offset, chunk, session.Size)
+	switch {
+	case errors.Is(err, uploads.ErrOffsetMismatch):
+		utils.ReasonErrorResponse(w, "Offset does not match the received bytes", http.StatusConflict, "offset_mismatch",
+			map[string]interface{}{"offset": received})
+		return
+	case errors.Is(err, uploads.ErrTooLarge):
+		utils.ReasonErrorResponse(w, "Chunk exceeds the declared upload size", http.StatusRequestEntityTooLarge, "upload_too_large",
+			map[string]interface{}{"offset": received, "size": session.Size})
+		return
+	case err != nil:
+		utils.ErrorResponse(w, "Failed to store chunk", http.StatusInternalServerError)
+		return
+	}
+	session.Received = received
+	if err := h.Sessions.SetReceived(session.ID, received); err != nil {
+		log.Printf("Failed to record progress of upload %s: %v", session.ID, err)
+	}
+	h.respond(w, session, http.StatusOK)
+}
+
+// Status returns the offset to resume from: GET ?id=<upload_id>.
+func (h *ChunkedUploadHandler) Status(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if session := h.loadSession(w, r.URL.Query().Get("id"), user.ID); session != nil {
+		h.respond(w, session, http.StatusOK)
+	}
+}
+
+// Finalize verifies a complete upload and hands it to the image pipeline:
+// {"upload_id"}. The response is that of a direct upload.
+func (h *ChunkedUploadHandler) Finalize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		UploadID string `json:"upload_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	session := h.loadSession(w, req.UploadID, user.ID)
+	if session == nil {
+		return
+	}
+	if session.Received != session.Size {
+		utils.ReasonErrorResponse(w, "Upload is incomplete", http.StatusConflict, "upload_incomplete",
+			map[string]interface{}{"offset": session.Received, "size": session.Size})
+		return
+	}
+
+	// The session is used up whatever the outcome below. Deleting it first
+	// makes sure only one of concurrent finalize calls creates the image.
+	claimed, err := h.Sessions.Claim(session.ID, user.ID)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to finalize upload", http.StatusInternalServerError)
+		return
+	}
+	if !claimed {
+		utils.ReasonErrorResponse(w, "Upload not found or expired", http.StatusNotFound, "upload_not_found", nil)
+		return
+	}
+	defer func() {
+		if err := h.Chunks.Remove(session.ID); err != nil {
+			log.Printf("Failed to remove upload data %s: %v", session.ID, err)
+		}
+	}()
+
+	data, err := h.Chunks.Read(session.ID)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to read upload", http.StatusInternalServerError)
+		return
+	}
+
+	if session.Checksum != "" && contentHash(data) != session.Checksum {
+		utils.ReasonErrorResponse(w, "Upload checksum mismatch", http.StatusBadRequest, "checksum_mismatch", nil)
+		return
+	}
+	if e := h.Images.checkPostAuthor(session.PostID, user.ID); e != nil {
+		e.write(w)
+		return
+	}
+	h.Images.acceptUpload(w, user.ID, session.PostID, session.Filename, data, session.AltText, session.Caption)
+}
+
+// Cancel abandons an upload: {"upload_id"}.
+func (h *ChunkedUploadHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		UploadID string `json:"upload_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	session := h.loadSession(w, req.UploadID, user.ID)
+	if session == nil {
+		return
+	}
+	h.discard(session.ID)
+	utils.JSONResponse(w, map[string]string{"status": "cancelled"}, http.StatusOK)
+}
+
+func (h *ChunkedUploadHandler) discard(id string) {
+	if err := h.Sessions.Delete(id); err != nil {
+		log.Printf("Failed to delete upload session %s: %v", id, err)
+	}
+	if err := h.Chunks.Remove(id); err != nil {
+		log.Printf("Failed to remove upload data %s: %v", id, err)
+	}
+}
+
+// StartCleanup periodically drops expired sessions and partial files left
+// without a live session, e.g. after their post was deleted.
+func (h *ChunkedUploadHandler) StartCleanup(interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			h.cleanup()
+		}
+	}()
+}
+
+func (h *ChunkedUploadHandler) cleanup() {
+	expired, err := h.Sessions.DeleteExpired(time.Now())
+	if err != nil {
+		log.Printf("Failed to delete expired uploads: %v", err)
+	}
+	for _, id := range expired {
+		h.Chunks.Remove(id)
+	}
+
+	stale, err := h.Chunks.Stale(time.Now().Add(-h.Config.SessionTTL))
+	if err != nil {
+		log.Printf("Failed to list upload data: %v", err)
+		return
+	}
+	for _, id := range stale {
+		if s, err := h.Sessions.GetByID(id); err == nil && s == nil {
+			h.Chunks.Remove(id)
+		}
+	}
+}
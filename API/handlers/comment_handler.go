@@ -22,7 +22,8 @@ func NewCommentHandler(repo *repository.CommentRepository, postRepo *repository.
 	return &CommentHandler{CommentRepo: repo, PostRepo: postRepo, NotificationRepo: notifRepo}
 }
 
-// CreateComment creates a new comment on a post for the authenticated user
+// CreateComment creates a new comment on a post for the authenticated user.
+// With parent_id the comment is a reply to another comment on the same post.
 func (h *CommentHandler) CreateComment(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -36,8 +37,9 @@ func (h *CommentHandler) CreateComment(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		PostID  string `json:"post_id"`
-		Content string `json:"content"`
+		PostID   string `json:"post_id"`
+		ParentID string `json:"parent_id"`
+		Content  string `json:"content"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		utils.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
@@ -53,6 +55,18 @@ func (h *CommentHandler) CreateComment(w http.ResponseWriter, r *http.Request) {
 		UserID:  user.ID,
 		Content: req.Content,
 	}
+	if req.ParentID != "" {
+		parent, err := h.CommentRepo.GetByID(req.ParentID)
+		if err != nil {
+			utils.ErrorResponse(w, "Failed to load parent comment", http.StatusInternalServerError)
+			return
+		}
+		if parent == nil || parent.PostID != req.PostID {
+			utils.ReasonErrorResponse(w, "Parent comment not found on this post", http.StatusBadRequest, "invalid_parent", nil)
+			return
+		}
+		comment.ParentID = &parent.ID
+	}
 
 	created, err := h.CommentRepo.Create(comment)
 	if err != nil {
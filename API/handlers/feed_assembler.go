@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"forum/models"
+	"forum/repository"
+)
+
+// feedAssembler builds the post responses of the listings. Everything a page
+// of posts shows, from categories to the reactions on comments, is loaded
+// with one query per kind of data rather than a few queries per post.
+type feedAssembler struct {
+	posts     *repository.PostRepository
+	comments  *repository.CommentRepository
+	reactions *repository.ReactionRepository
+	images    *repository.ImageRepository
+}
+
+func newFeedAssembler(postRepo *repository.PostRepository, commentRepo *repository.CommentRepository, reactionRepo *repository.ReactionRepository, imageRepo *repository.ImageRepository) *feedAssembler {
+	return &feedAssembler{posts: postRepo, comments: commentRepo, reactions: reactionRepo, images: imageRepo}
+}
+
+// feedBatch is what was loaded for a page of posts, keyed by post ID, or by
+// comment ID for commentReactions.
+type feedBatch struct {
+	categories       map[string][]models.Category
+	images           map[string][]models.Image
+	comments         map[string][]models.CommentWithUser
+	postReactions    map[string][]models.ReactionWithUser
+	commentReactions map[string][]models.ReactionWithUser
+}
+
+// load fetches the data shown with posts. The number of queries does not
+// depend on how many posts, comments or images there are.
+func (a *feedAssembler) load(posts []models.PostWithUser) (*feedBatch, error) {
+	ids := make([]string, len(posts))
+	for i, p := range posts {
+		ids[i] = p.ID
+	}
+
+	var b feedBatch
+	var err error
+	if b.categories, err = a.posts.GetCategoriesByPostIDs(ids); err != nil {
+		return nil, err
+	}
+	if b.images, err = a.images.GetByPostIDs(ids); err != nil {
+		return nil, err
+	}
+	if b.comments, err = a.comments.GetCommentsByPostIDs(ids); err != nil {
+		return nil, err
+	}
+	if b.postReactions, err = a.reactions.GetReactionsByPostIDs(ids); err != nil {
+		return nil, err
+	}
+	var commentIDs []string
+	for _, comments := range b.comments {
+		for _, c := range comments {
+			commentIDs = append(commentIDs, c.ID)
+		}
+	}
+	if b.commentReactions, err = a.reactions.GetReactionsByCommentIDs(commentIDs); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// postResponse builds the feed entry of a post, without its category.
+func (b *feedBatch) postResponse(post models.PostWithUser) PostResponse {
+	resp := PostResponse{
+		ID:        post.ID,
+		UserID:    post.UserID,
+		Username:  post.Username,
+		AvatarURL: avatarURL(post.UserID),
+		Title:     post.Title,
+		Content:   post.Content,
+		CreatedAt: post.CreatedAt,
+		Comments:  b.commentResponses(post.ID),
+		Reactions: reactionResponses(b.postReactions[post.ID]),
+	}
+	imgs := b.images[post.ID]
+	resp.Images = postImages(imgs)
+	if len(imgs) > 0 {
+		resp.ImageURL, resp.ImageAltText = staticURLs.URL(imgs[0].FilePath), imgs[0].AltText
+		resp.ThumbnailURL = staticURLs.URL(imgs[0].ThumbnailPath)
+		resp.Renditions, resp.SrcSet = renditionURLs(imgs[0].Renditions)
+		resp.BlurHash, resp.DominantColor = imgs[0].BlurHash, imgs[0].DominantColor
+	}
+	return resp
+}
+
+// myPostResponse builds the entry of a post in the created and liked posts
+// listings.
+func (b *feedBatch) myPostResponse(post models.PostWithUser) MyPostResponse {
+	resp := MyPostResponse{
+		ID:        post.ID,
+		UserID:    post.UserID,
+		Username:  post.Username,
+		AvatarURL: avatarURL(post.UserID),
+		Title:     post.Title,
+		Content:   post.Content,
+		CreatedAt: post.CreatedAt,
+		Comments:  b.commentResponses(post.ID),
+		Reactions: reactionResponses(b.postReactions[post.ID]),
+	}
+	for _, c := range b.categories[post.ID] {
+		resp.Categories = append(resp.Categories, CategoryInfo{ID: c.ID, Name: c.Name})
+	}
+	imgs := b.images[post.ID]
+	resp.Images = postImages(imgs)
+	if len(imgs) > 0 {
+		resp.ImageURL, resp.ImageAltText = staticURLs.URL(imgs[0].FilePath), imgs[0].AltText
+		resp.ThumbnailURL = staticURLs.URL(imgs[0].ThumbnailPath)
+		resp.Renditions, resp.SrcSet = renditionURLs(imgs[0].Renditions)
+		resp.BlurHash, resp.DominantColor = imgs[0].BlurHash, imgs[0].DominantColor
+	}
+	return resp
+}
+
+func (b *feedBatch) commentResponses(postID string) []CommentResponse {
+	comments := make([]CommentResponse, 0, len(b.comments[postID]))
+	for _, c := range b.comments[postID] {
+		comments = append(comments, CommentResponse{
+			ID:        c.ID,
+			UserID:    c.UserID,
+			Username:  c.Username,
+			AvatarURL: avatarURL(c.UserID),
+			Content:   c.Content,
+			CreatedAt: c.CreatedAt,
+			Reactions: reactionResponses(b.commentReactions[c.ID]),
+		})
+	}
+	return comments
+}
+
+func reactionResponses(reactions []models.ReactionWithUser) []ReactionResponse {
+	resp := make([]ReactionResponse, 0, len(reactions))
+	for _, r := range reactions {
+		resp = append(resp, ReactionResponse{
+			UserID:       r.UserID,
+			Username:     r.Username,
+			AvatarURL:    avatarURL(r.UserID),
+			ReactionType: r.ReactionType,
+			CreatedAt:    r.CreatedAt,
+		})
+	}
+	return resp
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"forum/middleware"
+	"forum/models"
+	"forum/repository"
+	"forum/utils"
+)
+
+const (
+	maxAltTextLength = 250
+	maxCaptionLength = 500
+)
+
+// postImages converts the images of a post, already in gallery order, into
+// response entries. It never returns nil so the JSON is always an array.
+func postImages(imgs []models.Image) []models.PostImage {
+	gallery := make([]models.PostImage, 0, len(imgs))
+	for _, img := range imgs {
+		renditions, srcset := renditionURLs(img.Renditions)
+		gallery = append(gallery, models.PostImage{
+			ID:            img.ID,
+			Position:      img.Position,
+			URL:           staticURLs.URL(img.FilePath),
+			ThumbnailURL:  staticURLs.URL(img.ThumbnailPath),
+			SrcSet:        srcset,
+			Renditions:    renditions,
+			AltText:       img.AltText,
+			Caption:       img.Caption,
+			Status:        img.Status,
+			BlurHash:      img.BlurHash,
+			DominantColor: img.DominantColor,
+		})
+	}
+	return gallery
+}
+
+// checkImageText validates the alt text and caption of an image. Alt text
+// may be left empty unless the forum is configured to require it.
+func (h *ImageHandler) checkImageText(altText, caption string) *imageError {
+	if altText == "" && h.Config.RequireAltText {
+		return &imageError{Status: http.StatusBadRequest, Reason: "alt_text_required", Message: "Alt text is required for images"}
+	}
+	if n := utf8.RuneCountInString(altText); n > maxAltTextLength {
+		return &imageError{Status: http.StatusBadRequest, Reason: "alt_text_too_long", Message: fmt.Sprintf("Alt text must be at most %d characters", maxAltTextLength),
+			Details: map[string]interface{}{"length": n, "max": maxAltTextLength}}
+	}
+	if n := utf8.RuneCountInString(caption); n > maxCaptionLength {
+		return &imageError{Status: http.StatusBadRequest, Reason: "caption_too_long", Message: fmt.Sprintf("Caption must be at most %d characters", maxCaptionLength),
+			Details: map[string]interface{}{"length": n, "max": maxCaptionLength}}
+	}
+	return nil
+}
+
+// authorizePost writes an error response and returns false unless postID
+// exists and was written by userID.
+func (h *ImageHandler) authorizePost(w http.ResponseWriter, postID, userID string) bool {
+	if e := h.checkPostAuthor(postID, userID); e != nil {
+		e.write(w)
+		return false
+	}
+	return true
+}
+
+// loadOwnedImage returns the image if it exists and belongs to a post by
+// userID, writing an error response otherwise.
+func (h *ImageHandler) loadOwnedImage(w http.ResponseWriter, imageID, userID string) *models.Image {
+	if imageID == "" {
+		utils.ErrorResponse(w, "Image ID required", http.StatusBadRequest)
+		return nil
+	}
+	img, err := h.ImageRepo.GetByID(imageID)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load image", http.StatusInternalServerError)
+		return nil
+	}
+	if img == nil {
+		utils.ErrorResponse(w, "Image not found", http.StatusNotFound)
+		return nil
+	}
+	if !h.authorizePost(w, img.PostID, userID) {
+		return nil
+	}
+	return img
+}
+
+// writeGallery responds with the post's images in their current order.
+func (h *ImageHandler) writeGallery(w http.ResponseWriter, postID string) {
+	imgs, err := h.ImageRepo.GetGalleryByPostID(postID)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load images", http.StatusInternalServerError)
+		return
+	}
+	utils.JSONResponse(w, map[string]interface{}{
+		"post_id": postID,
+		"images":  postImages(imgs),
+	}, http.StatusOK)
+}
+
+// Reorder sets the gallery order of a post. The body lists every image ID
+// of the post once, first image first.
+func (h *ImageHandler) Reorder(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		PostID   string   `json:"post_id"`
+		ImageIDs []string `json:"image_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.PostID == "" {
+		utils.ErrorResponse(w, "Post ID required", http.StatusBadRequest)
+		return
+	}
+	if !h.authorizePost(w, req.PostID, user.ID) {
+		return
+	}
+
+	if err := h.ImageRepo.Reorder(req.PostID, req.ImageIDs); err != nil {
+		if err == repository.ErrImageOrder {
+			utils.ErrorResponse(w, "image_ids must list every image of the post exactly once", http.StatusBadRequest)
+			return
+		}
+		utils.ErrorResponse(w, "Failed to reorder images", http.StatusInternalServerError)
+		return
+	}
+	h.writeGallery(w, req.PostID)
+}
+
+// UpdateImage changes the alt text and/or caption of an image. Omitted
+// fields are left unchanged.
+func (h *ImageHandler) UpdateImage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		ImageID string  `json:"image_id"`
+		AltText *string `json:"alt_text"`
+		Caption *string `json:"caption"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	img := h.loadOwnedImage(w, req.ImageID, user.ID)
+	if img == nil {
+		return
+	}
+
+	altText, caption := img.AltText, img.Caption
+	if req.AltText != nil {
+		altText = strings.TrimSpace(*req.AltText)
+	}
+	if req.Caption != nil {
+		caption = strings.TrimSpace(*req.Caption)
+	}
+	if e := h.checkImageText(altText, caption); e != nil {
+		e.write(w)
+		return
+	}
+
+	if err := h.ImageRepo.UpdateText(img.ID, altText, caption); err != nil {
+		utils.ErrorResponse(w, "Failed to update image", http.StatusInternalServerError)
+		return
+	}
+	h.writeGallery(w, img.PostID)
+}
+
+// DeleteImage removes one image from a post's gallery. Its files are
+// deleted once no other image shares them.
+func (h *ImageHandler) DeleteImage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		ImageID string `json:"image_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	img := h.loadOwnedImage(w, req.ImageID, user.ID)
+	if img == nil {
+		return
+	}
+
+	if err := h.ImageRepo.Delete(img.ID); err != nil {
+		utils.ErrorResponse(w, "Failed to delete image", http.StatusInternalServerError)
+		return
+	}
+	releaseBlobs(h.ImageRepo, h.Store)
+	h.writeGallery(w, img.PostID)
+}
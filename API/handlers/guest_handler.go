@@ -1,14 +1,24 @@
 package handlers
 
 import (
+	"forum/config"
+	"forum/models"
 	"forum/repository"
+	"forum/urlsign"
 	"forum/utils"
 	"net/http"
 	"time"
 )
 
-// apiStaticBase is the base URL for serving static files like uploaded images.
-const apiStaticBase = "http://localhost:8080/static/"
+// staticURLs builds the URLs of uploaded files like images. Without
+// SetStaticURLs they are unsigned and point at the local API.
+var staticURLs = urlsign.NewSigner(config.StaticURLConfig{Base: "http://localhost:8080/static/"})
+
+// SetStaticURLs makes every handler sign file URLs with s. The same signer
+// must be given to the StaticHandler that verifies them.
+func SetStaticURLs(s *urlsign.Signer) {
+	staticURLs = s
+}
 
 type GuestHandler struct {
 	categoryRepo *repository.CategoryRepository
@@ -16,11 +26,13 @@ type GuestHandler struct {
 	commentRepo  *repository.CommentRepository
 	reactionRepo *repository.ReactionRepository
 	imageRepo    *repository.ImageRepository
+	feed         *feedAssembler
 }
 
 type ReactionResponse struct {
 	UserID       string    `json:"user_id"`
 	Username     string    `json:"username"`
+	AvatarURL    string    `json:"avatar_url"`
 	ReactionType int       `json:"reaction_type"`
 	CreatedAt    time.Time `json:"created_at"`
 }
@@ -29,24 +41,32 @@ type CommentResponse struct {
 	ID        string             `json:"id"`
 	UserID    string             `json:"user_id"`
 	Username  string             `json:"username"`
+	AvatarURL string             `json:"avatar_url"`
 	Content   string             `json:"content"`
 	CreatedAt time.Time          `json:"created_at"`
 	Reactions []ReactionResponse `json:"reactions,omitempty"`
 }
 
 type PostResponse struct {
-	ID           string             `json:"id"`
-	UserID       string             `json:"user_id"`
-	Username     string             `json:"username"`
-	CategoryID   int                `json:"category_id"`
-	CategoryName string             `json:"category_name"` // NEW FIELD
-	Title        string             `json:"title"`         // Optional title field
-	Content      string             `json:"content"`
-	CreatedAt    time.Time          `json:"created_at"`
-	ImageURL     string             `json:"image_url,omitempty"`
-	ThumbnailURL string             `json:"thumbnail_url,omitempty"`
-	Comments     []CommentResponse  `json:"comments,omitempty"`
-	Reactions    []ReactionResponse `json:"reactions,omitempty"`
+	ID            string                `json:"id"`
+	UserID        string                `json:"user_id"`
+	Username      string                `json:"username"`
+	AvatarURL     string                `json:"avatar_url"`
+	CategoryID    int                   `json:"category_id"`
+	CategoryName  string                `json:"category_name"` // NEW FIELD
+	Title         string                `json:"title"`         // Optional title field
+	Content       string                `json:"content"`
+	CreatedAt     time.Time             `json:"created_at"`
+	ImageURL      string                `json:"image_url,omitempty"`
+	ImageAltText  string                `json:"image_alt_text,omitempty"`
+	ThumbnailURL  string                `json:"thumbnail_url,omitempty"`
+	SrcSet        string                `json:"srcset,omitempty"`
+	BlurHash      string                `json:"blurhash,omitempty"`
+	DominantColor string                `json:"dominant_color,omitempty"`
+	Renditions    []models.RenditionURL `json:"renditions,omitempty"`
+	Images        []models.PostImage    `json:"images"`
+	Comments      []CommentResponse     `json:"comments,omitempty"`
+	Reactions     []ReactionResponse    `json:"reactions,omitempty"`
 }
 
 type CategoryResponse struct {
@@ -57,6 +77,7 @@ type CategoryResponse struct {
 
 type GuestResponse struct {
 	Categories []CategoryResponse `json:"categories"`
+	NextCursor string             `json:"next_cursor,omitempty"`
 }
 
 func NewGuestHandler(
@@ -72,6 +93,7 @@ func NewGuestHandler(
 		commentRepo:  commentRepo,
 		reactionRepo: reactionRepo,
 		imageRepo:    imageRepo,
+		feed:         newFeedAssembler(postRepo, commentRepo, reactionRepo, imageRepo),
 	}
 }
 
@@ -82,28 +104,60 @@ func (h *GuestHandler) GuestView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	posts, err := h.postRepo.GetAllPosts()
+	page, ok := postPage(w, r)
+	if !ok {
+		return
+	}
+
+	posts, next, err := h.postRepo.GetAllPosts(page)
 	if err != nil {
 		utils.ErrorResponse(w, "Failed to fetch posts.", http.StatusInternalServerError)
 		return
 	}
 
-	comments, err := h.commentRepo.GetAllComments()
+	// Only the comments and reactions of the page's posts are listed, in the
+	// order of the posts.
+	ids := make([]string, len(posts))
+	for i, p := range posts {
+		ids[i] = p.ID
+	}
+	byPost, err := h.commentRepo.GetCommentsByPostIDs(ids)
 	if err != nil {
 		utils.ErrorResponse(w, "Failed to fetch comments.", http.StatusInternalServerError)
 		return
 	}
+	var comments []models.CommentWithUser
+	var commentIDs []string
+	for _, id := range ids {
+		for _, c := range byPost[id] {
+			comments = append(comments, c)
+			commentIDs = append(commentIDs, c.ID)
+		}
+	}
 
-	reactions, err := h.reactionRepo.GetAllReactions()
+	postReactions, err := h.reactionRepo.GetReactionsByPostIDs(ids)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to fetch reactions.", http.StatusInternalServerError)
+		return
+	}
+	commentReactions, err := h.reactionRepo.GetReactionsByCommentIDs(commentIDs)
 	if err != nil {
 		utils.ErrorResponse(w, "Failed to fetch reactions.", http.StatusInternalServerError)
 		return
 	}
+	var reactions []models.ReactionWithUser
+	for _, id := range ids {
+		reactions = append(reactions, postReactions[id]...)
+	}
+	for _, id := range commentIDs {
+		reactions = append(reactions, commentReactions[id]...)
+	}
 
 	response := map[string]interface{}{
-		"posts":     posts,
-		"comments":  comments,
-		"reactions": reactions,
+		"posts":       posts,
+		"comments":    comments,
+		"reactions":   reactions,
+		"next_cursor": encodeCursor(next),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -116,101 +170,52 @@ func (h *GuestHandler) GetGuestData(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	page, ok := postPage(w, r)
+	if !ok {
+		return
+	}
+
 	categories, err := h.categoryRepo.GetAll()
 	if err != nil {
 		utils.ErrorResponse(w, "Failed to load categories", http.StatusInternalServerError)
 		return
 	}
 
-	var response GuestResponse
-	for _, cat := range categories {
-		catResp := CategoryResponse{
+	posts, next, err := h.postRepo.GetPostsWithUser(page)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load posts", http.StatusInternalServerError)
+		return
+	}
+
+	response := GuestResponse{NextCursor: encodeCursor(next)}
+	byID := make(map[int]int, len(categories))
+	for i, cat := range categories {
+		byID[cat.ID] = i
+		response.Categories = append(response.Categories, CategoryResponse{
 			ID:    cat.ID,
 			Name:  cat.Name,
 			Posts: []PostResponse{}, // ✅ always initialized to avoid null
-		}
-
-		posts, err := h.postRepo.GetPostsByCategoryWithUser(cat.ID)
-
-		if err != nil {
-			utils.ErrorResponse(w, "Failed to load posts", http.StatusInternalServerError)
-			return
-		}
-
-		for _, post := range posts {
-			postResp := PostResponse{
-				ID:           post.ID,
-				UserID:       post.UserID,
-				Username:     post.Username,
-				CategoryID:   post.CategoryID,
-				CategoryName: cat.Name,   // ✅ inject category name
-				Title:        post.Title, // Optional title field
-				Content:      post.Content,
-				CreatedAt:    post.CreatedAt,
-				Comments:     []CommentResponse{},  // ✅ avoid null
-				Reactions:    []ReactionResponse{}, // ✅ avoid null
-			}
-			imgs, err := h.imageRepo.GetByPostID(post.ID)
-			if err != nil {
-				utils.ErrorResponse(w, "Failed to load images", http.StatusInternalServerError)
-				return
-			}
-			if len(imgs) > 0 {
-				postResp.ImageURL = apiStaticBase + imgs[0].FilePath
-				postResp.ThumbnailURL = apiStaticBase + imgs[0].ThumbnailPath
-			}
-
-			comments, err := h.commentRepo.GetCommentsByPostWithUser(post.ID)
-			if err != nil {
-				utils.ErrorResponse(w, "Failed to load comments", http.StatusInternalServerError)
-				return
-			}
+		})
+	}
 
-			for _, comment := range comments {
-				commentResp := CommentResponse{
-					ID:        comment.ID,
-					UserID:    comment.UserID,
-					Username:  comment.Username,
-					Content:   comment.Content,
-					CreatedAt: comment.CreatedAt,
-					Reactions: []ReactionResponse{}, // ✅ avoid null
-				}
-
-				reactions, err := h.reactionRepo.GetReactionsByCommentWithUser(comment.ID)
-				if err != nil {
-					utils.ErrorResponse(w, "Failed to load reactions", http.StatusInternalServerError)
-					return
-				}
-				for _, reaction := range reactions {
-					commentResp.Reactions = append(commentResp.Reactions, ReactionResponse{
-						UserID:       reaction.UserID,
-						Username:     reaction.Username,
-						ReactionType: reaction.ReactionType,
-						CreatedAt:    reaction.CreatedAt,
-					})
-				}
-
-				postResp.Comments = append(postResp.Comments, commentResp)
-			}
+	batch, err := h.feed.load(posts)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load posts", http.StatusInternalServerError)
+		return
+	}
 
-			reactions, err := h.reactionRepo.GetReactionsByPostWithUser(post.ID)
-			if err != nil {
-				utils.ErrorResponse(w, "Failed to load reactions", http.StatusInternalServerError)
-				return
-			}
-			for _, reaction := range reactions {
-				postResp.Reactions = append(postResp.Reactions, ReactionResponse{
-					UserID:       reaction.UserID,
-					Username:     reaction.Username,
-					ReactionType: reaction.ReactionType,
-					CreatedAt:    reaction.CreatedAt,
-				})
+	// The page is cut across the whole forum, then each post is listed under
+	// every category it belongs to.
+	for _, post := range posts {
+		postResp := batch.postResponse(post)
+		for _, cat := range batch.categories[post.ID] {
+			i, ok := byID[cat.ID]
+			if !ok {
+				continue
 			}
-
-			catResp.Posts = append(catResp.Posts, postResp)
+			postResp.CategoryID, postResp.CategoryName = cat.ID, cat.Name // ✅ inject category name
+			response.Categories[i].Posts = append(response.Categories[i].Posts, postResp)
 		}
-
-		response.Categories = append(response.Categories, catResp)
 	}
 
 	utils.JSONResponse(w, response, http.StatusOK)
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"forum/utils"
+)
+
+// imageError is a rejected image request. Reason is a stable identifier
+// clients can switch on; Details carries limits and current usage.
+type imageError struct {
+	Status  int
+	Reason  string
+	Message string
+	Details map[string]interface{}
+}
+
+func (e *imageError) write(w http.ResponseWriter) {
+	utils.ReasonErrorResponse(w, e.Message, e.Status, e.Reason, e.Details)
+}
+
+// checkPostAuthor allows changes to a post's images only by its author.
+func (h *ImageHandler) checkPostAuthor(postID, userID string) *imageError {
+	post, err := h.PostRepo.GetByID(postID)
+	if err != nil {
+		return &imageError{Status: http.StatusInternalServerError, Reason: "post_lookup_failed", Message: "Failed to load post"}
+	}
+	if post == nil {
+		return &imageError{Status: http.StatusNotFound, Reason: "post_not_found", Message: "Post not found",
+			Details: map[string]interface{}{"post_id": postID}}
+	}
+	if post.UserID != userID {
+		return &imageError{Status: http.StatusForbidden, Reason: "post_not_owned", Message: "Only the post's author can change its images",
+			Details: map[string]interface{}{"post_id": postID}}
+	}
+	return nil
+}
+
+// checkQuota rejects an upload of size bytes that would exceed the post's
+// image limit or the user's storage quota. size is the upload itself; the
+// stored renditions are only known after processing, and until then the
+// upload's size stands in for them in the quota of later uploads.
+func (h *ImageHandler) checkQuota(postID, userID string, size int64) *imageError {
+	if limit := h.Config.MaxImagesPerPost; limit > 0 {
+		count, err := h.ImageRepo.CountByPostID(postID)
+		if err != nil {
+			return &imageError{Status: http.StatusInternalServerError, Reason: "quota_lookup_failed", Message: "Failed to check image quota"}
+		}
+		if count >= limit {
+			return &imageError{Status: http.StatusConflict, Reason: "post_image_limit",
+				Message: fmt.Sprintf("A post can have at most %d images", limit),
+				Details: map[string]interface{}{"limit": limit, "used": count}}
+		}
+	}
+	if quota := h.Config.UserQuotaBytes; quota > 0 {
+		used, err := h.ImageRepo.StorageUsedByUser(userID)
+		if err != nil {
+			return &imageError{Status: http.StatusInternalServerError, Reason: "quota_lookup_failed", Message: "Failed to check storage quota"}
+		}
+		if used+size > quota {
+			return storageQuotaError(quota, used, size)
+		}
+	}
+	return nil
+}
+
+// storageQuotaError reports an upload of size bytes that does not fit in
+// what is left of the quota
+func storageQuotaError(quota, used, size int64) *imageError {
+	return &imageError{Status: http.StatusRequestEntityTooLarge, Reason: "user_storage_quota",
+		Message: fmt.Sprintf("Image storage quota of %d MB exceeded", quota>>20),
+		Details: map[string]interface{}{"limit_bytes": quota, "used_bytes": used, "upload_bytes": size}}
+}
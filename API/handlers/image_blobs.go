@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"path"
+	"sync"
+
+	"forum/models"
+	"forum/repository"
+	"forum/storage"
+)
+
+// contentHash returns the hex SHA-256 of an upload. Identical uploads share
+// one blob.
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// blobDir is the storage prefix holding every file of a blob: the original,
+// thumbnail, renditions and their variants. A two character fan-out keeps
+// directories small on local disk.
+func blobDir(hash string) string {
+	return path.Join(uploadBaseDir, hash[:2], hash)
+}
+
+// blobLocks serialises writing or referencing the files of a blob with
+// deleting them, per content hash.
+var blobLocks = struct {
+	sync.Mutex
+	held map[string]*blobLock
+}{held: make(map[string]*blobLock)}
+
+type blobLock struct {
+	sync.Mutex
+	users int
+}
+
+// lockBlob locks the blob of hash and returns the function unlocking it.
+// Entries are dropped once nobody holds or waits for them.
+func lockBlob(hash string) func() {
+	blobLocks.Lock()
+	l, ok := blobLocks.held[hash]
+	if !ok {
+		l = &blobLock{}
+		blobLocks.held[hash] = l
+	}
+	l.users++
+	blobLocks.Unlock()
+	l.Lock()
+	return func() {
+		l.Unlock()
+		blobLocks.Lock()
+		if l.users--; l.users == 0 {
+			delete(blobLocks.held, hash)
+		}
+		blobLocks.Unlock()
+	}
+}
+
+// releaseBlobs deletes the files of every blob that lost its last image
+// reference. Failures are only logged because the blob rows are already gone;
+// files left behind are picked up by the orphan collector in package gc.
+//
+// A new upload of the same content may register the blob again once its row
+// is gone, so the files are only deleted under the blob's lock and if no row
+// came back.
+func releaseBlobs(repo *repository.ImageRepository, store storage.Storage) {
+	blobs, err := repo.ReleaseBlobs()
+	if err != nil {
+		log.Printf("Failed to release image blobs: %v", err)
+		return
+	}
+	for _, b := range blobs {
+		deleteBlobFiles(repo, store, b)
+	}
+}
+
+// deleteBlobFiles deletes the files of a released blob unless it was
+// registered again.
+func deleteBlobFiles(repo *repository.ImageRepository, store storage.Storage, b models.ImageBlob) {
+	unlock := lockBlob(b.ContentHash)
+	defer unlock()
+	exists, err := repo.BlobExists(b.ContentHash)
+	if err != nil {
+		log.Printf("Failed to check blob %s: %v", b.ContentHash, err)
+		return
+	}
+	if exists {
+		return
+	}
+	objects, err := store.List(path.Dir(b.FilePath) + "/")
+	if err != nil {
+		log.Printf("Failed to list files of blob %s: %v", b.ContentHash, err)
+		return
+	}
+	for _, obj := range objects {
+		if err := store.Delete(obj.Key); err != nil {
+			log.Printf("Failed to delete %s: %v", obj.Key, err)
+		}
+	}
+}
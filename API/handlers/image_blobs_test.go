@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"forum/models"
+	"forum/repository"
+	"forum/storage"
+)
+
+// pausingStore stops the first Put of a blob original once paused is set,
+// until resume is closed.
+type pausingStore struct {
+	storage.Storage
+	paused  bool
+	reached chan struct{}
+	resume  chan struct{}
+}
+
+func (s *pausingStore) Put(key string, r io.Reader, contentType string) error {
+	if err := s.Storage.Put(key, r, contentType); err != nil {
+		return err
+	}
+	if s.paused && strings.HasSuffix(key, "/original.png") {
+		s.paused = false
+		close(s.reached)
+		<-s.resume
+	}
+	return nil
+}
+
+func TestReleaseBlobsWaitsForProcessing(t *testing.T) {
+	db := openTestDB(t, append(imageTables,
+		`INSERT INTO posts (post_id, user_id, title, content) VALUES ('p1', 'u1', 't', 'c')`,
+	)...)
+	local, err := storage.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &pausingStore{Storage: local, reached: make(chan struct{}), resume: make(chan struct{})}
+	repo := repository.NewImageRepository(db)
+	h := &ImageHandler{ImageRepo: repo, Store: store}
+
+	src := image.NewNRGBA(image.Rect(0, 0, 120, 80))
+	for y := 0; y < 80; y++ {
+		for x := 0; x < 120; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{uint8(x * 2), uint8(y * 3), 60, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	hash := contentHash(data)
+
+	// upload queues the picture as a new image and returns its job
+	upload := func(name string) *models.Job {
+		t.Helper()
+		img, err := repo.CreateProcessing(models.Image{PostID: "p1", UserID: "u1"}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := "incoming/" + name + ".png"
+		if err := local.Put(key, bytes.NewReader(data), "image/png"); err != nil {
+			t.Fatal(err)
+		}
+		payload, _ := json.Marshal(imageJobPayload{SourceKey: key, ContentType: "image/png", Ext: ".png", ContentHash: hash})
+		return &models.Job{ImageID: img.ID, Payload: string(payload)}
+	}
+
+	first := upload("first")
+	if err := h.ProcessJob(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Delete(first.ImageID); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new upload of the same picture writes the blob's files again while
+	// the reference of the first one is being released
+	second := upload("second")
+	store.paused = true
+	processed := make(chan error)
+	go func() { processed <- h.ProcessJob(second) }()
+	<-store.reached
+
+	released := make(chan struct{})
+	go func() {
+		releaseBlobs(repo, store)
+		close(released)
+	}()
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(5 * time.Millisecond) {
+		if exists, err := repo.BlobExists(hash); err != nil {
+			t.Fatal(err)
+		} else if !exists {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("blob was not released")
+		}
+	}
+	select {
+	case <-released:
+		t.Fatal("files were released while the blob was being processed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(store.resume)
+	if err := <-processed; err != nil {
+		t.Fatal(err)
+	}
+	<-released
+
+	img, err := repo.GetByID(second.ImageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Status != models.ImageReady {
+		t.Fatalf("status %q", img.Status)
+	}
+	if exists, err := repo.BlobExists(hash); err != nil || !exists {
+		t.Fatalf("blob registered: %v, %v", exists, err)
+	}
+	keys := []string{img.FilePath, img.ThumbnailPath}
+	for _, rd := range img.Renditions {
+		keys = append(keys, rd.FilePath)
+	}
+	for _, key := range keys {
+		if _, err := local.Stat(key); err != nil {
+			t.Errorf("%s: %v", key, err)
+		}
+	}
+}
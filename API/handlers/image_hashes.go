@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"fmt"
+	"image"
+	"net/http"
+	"strconv"
+
+	"forum/imaging"
+	"forum/middleware"
+	"forum/models"
+	"forum/utils"
+)
+
+// maxSimilarResults caps the images returned by Similar.
+const maxSimilarResults = 50
+
+// uploadHashes decodes an upload and returns its pHash and dHash as 16 hex
+// digits each, computed on the oriented image or the first frame of a GIF.
+func uploadHashes(data []byte, contentType string) (phash, dhash string, err error) {
+	img, gifData, err := decodeUpload(data, contentType)
+	if err != nil {
+		return "", "", err
+	}
+	if gifData == nil {
+		img = imaging.Orient(img, imaging.ReadMetadata(data, contentType).Orientation)
+	}
+	phash, dhash = imageHashes(img)
+	return phash, dhash, nil
+}
+
+// imageHashes returns the pHash and dHash of img as 16 hex digits each.
+func imageHashes(img image.Image) (phash, dhash string) {
+	return fmt.Sprintf("%016x", imaging.PHash(img)), fmt.Sprintf("%016x", imaging.DHash(img))
+}
+
+// checkBanned rejects an upload that looks like a banned image.
+func (h *ImageHandler) checkBanned(phash, dhash string) *imageError {
+	ban, distance, err := h.HashRepo.MatchBan(phash, dhash, h.Config.HashDistance)
+	if err != nil {
+		return &imageError{Status: http.StatusInternalServerError, Reason: "ban_lookup_failed", Message: "Failed to check banned images"}
+	}
+	if ban != nil {
+		return &imageError{Status: http.StatusForbidden, Reason: "banned_image", Message: "This image is not allowed on the forum",
+			Details: map[string]interface{}{"distance": distance, "reason": ban.Reason}}
+	}
+	return nil
+}
+
+// similarImage is an image found by Similar.
+type similarImage struct {
+	models.PostImage
+	PostID   string `json:"post_id"`
+	Distance int    `json:"distance"`
+}
+
+// Similar lists the processed images that look like a given one:
+// GET /forum/api/images/similar?id=<image_id>&distance=<bits>. distance
+// defaults to, and may not exceed twice, the configured hash distance.
+func (h *ImageHandler) Similar(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if middleware.GetCurrentUser(r) == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	maxDist := h.Config.HashDistance
+	if raw := query.Get("distance"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 || n > 2*h.Config.HashDistance {
+			utils.ReasonErrorResponse(w, fmt.Sprintf("distance must be between 0 and %d", 2*h.Config.HashDistance), http.StatusBadRequest, "invalid_distance", nil)
+			return
+		}
+		maxDist = n
+	}
+
+	img, err := h.ImageRepo.GetByID(query.Get("id"))
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load image", http.StatusInternalServerError)
+		return
+	}
+	if img == nil || img.Status != models.ImageReady {
+		utils.ReasonErrorResponse(w, "Image not found", http.StatusNotFound, "image_not_found", nil)
+		return
+	}
+	if img.PHash == "" {
+		utils.ReasonErrorResponse(w, "Image was uploaded before perceptual hashing", http.StatusConflict, "image_not_hashed", nil)
+		return
+	}
+
+	matches, err := h.HashRepo.FindSimilar(img.PHash, img.DHash, maxDist)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to search similar images", http.StatusInternalServerError)
+		return
+	}
+	similar := []similarImage{}
+	for _, m := range matches {
+		if len(similar) == maxSimilarResults {
+			break
+		}
+		if m.ImageID == img.ID {
+			continue
+		}
+		// The index is rebuilt periodically and may still list deleted images.
+		found, err := h.ImageRepo.GetByID(m.ImageID)
+		if err != nil {
+			utils.ErrorResponse(w, "Failed to load image", http.StatusInternalServerError)
+			return
+		}
+		if found == nil || found.Status != models.ImageReady {
+			continue
+		}
+		similar = append(similar, similarImage{PostImage: postImages([]models.Image{*found})[0], PostID: found.PostID, Distance: m.Distance})
+	}
+	utils.JSONResponse(w, map[string]interface{}{"image_id": img.ID, "distance": maxDist, "images": similar}, http.StatusOK)
+}
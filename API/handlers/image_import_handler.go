@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"forum/config"
+	"forum/middleware"
+	"forum/remote"
+	"forum/utils"
+)
+
+// importableTypes are the sniffed content types an imported file may have,
+// the same formats Upload accepts.
+var importableTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+
+// ImageImportHandler attaches images to posts from a URL instead of an
+// uploaded file. Downloads are done server side by a remote.Fetcher.
+type ImageImportHandler struct {
+	Images  *ImageHandler
+	Fetcher *remote.Fetcher
+	Config  config.ImportConfig
+}
+
+// NewImageImportHandler builds the handler and its fetcher. Imports are
+// never larger than direct uploads, whatever cfg.MaxBytes says.
+func NewImageImportHandler(images *ImageHandler, cfg config.ImportConfig) *ImageImportHandler {
+	cfg.MaxBytes = min(cfg.MaxBytes, maxUploadSize)
+	return &ImageImportHandler{Images: images, Fetcher: remote.NewFetcher(cfg), Config: cfg}
+}
+
+// Import downloads an image and adds it to a post as if it had been
+// uploaded: {"post_id", "url", "alt_text", "caption"}. The response is the
+// same as Upload's.
+func (h *ImageImportHandler) Import(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		PostID  string `json:"post_id"`
+		URL     string `json:"url"`
+		AltText string `json:"alt_text"`
+		Caption string `json:"caption"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.PostID == "" {
+		utils.ReasonErrorResponse(w, "Post ID required", http.StatusBadRequest, "post_id_required", nil)
+		return
+	}
+	req.URL = strings.TrimSpace(req.URL)
+	if req.URL == "" {
+		utils.ReasonErrorResponse(w, "URL required", http.StatusBadRequest, "invalid_url", nil)
+		return
+	}
+	if e := h.Images.checkPostAuthor(req.PostID, user.ID); e != nil {
+		e.write(w)
+		return
+	}
+	req.AltText, req.Caption = strings.TrimSpace(req.AltText), strings.TrimSpace(req.Caption)
+	if e := h.Images.checkImageText(req.AltText, req.Caption); e != nil {
+		e.write(w)
+		return
+	}
+
+	download, err := h.Fetcher.Fetch(r.Context(), req.URL, importableTypes...)
+	if err != nil {
+		importError(err, h.Config.MaxBytes).write(w)
+		return
+	}
+	// The sniffed type decides how the file is handled, not the URL's
+	// extension, so the name passed on has none.
+	h.Images.acceptUpload(w, user.ID, req.PostID, "", download.Data, req.AltText, req.Caption)
+}
+
+// importError converts a download failure into the response sent back.
+func importError(err error, maxBytes int64) *imageError {
+	var status *remote.StatusError
+	switch {
+	case errors.Is(err, remote.ErrInvalidURL):
+		return &imageError{Status: http.StatusBadRequest, Reason: "invalid_url", Message: "Only http and https URLs can be imported"}
+	case errors.Is(err, remote.ErrForbiddenAddress):
+		return &imageError{Status: http.StatusBadRequest, Reason: "url_not_allowed", Message: "This address cannot be imported from"}
+	case errors.Is(err, remote.ErrTooManyRedirects):
+		return &imageError{Status: http.StatusBadGateway, Reason: "too_many_redirects", Message: "The URL redirects too many times"}
+	case errors.Is(err, remote.ErrTooLarge):
+		return &imageError{Status: http.StatusRequestEntityTooLarge, Reason: "remote_too_large", Message: "The remote file is too large",
+			Details: map[string]interface{}{"limit_bytes": maxBytes}}
+	case errors.Is(err, remote.ErrUnsupportedType):
+		return &imageError{Status: http.StatusUnsupportedMediaType, Reason: "unsupported_type", Message: "The URL does not point to a JPEG, PNG, GIF or WebP image"}
+	case errors.Is(err, remote.ErrTimeout):
+		return &imageError{Status: http.StatusGatewayTimeout, Reason: "remote_timeout", Message: "The remote server took too long to respond"}
+	case errors.As(err, &status):
+		return &imageError{Status: http.StatusBadGateway, Reason: "remote_status", Message: "The remote server did not return the image",
+			Details: map[string]interface{}{"status": status.Code}}
+	}
+	log.Printf("Image import failed: %v", err)
+	return &imageError{Status: http.StatusBadGateway, Reason: "remote_unreachable", Message: "The remote server could not be reached"}
+}
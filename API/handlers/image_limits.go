@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	"forum/imaging"
+
+	"golang.org/x/image/webp"
+)
+
+// checkDecodeLimits reads only the header of an upload and rejects images
+// whose dimensions, frame count or estimated decoding memory exceed the
+// configured limits, before any pixels are decoded.
+func (h *ImageHandler) checkDecodeLimits(data []byte, contentType string) *imageError {
+	var cfg image.Config
+	var err error
+	r := bytes.NewReader(data)
+	switch contentType {
+	case "image/jpeg":
+		cfg, err = jpeg.DecodeConfig(r)
+	case "image/png":
+		cfg, err = png.DecodeConfig(r)
+	case "image/gif":
+		cfg, err = gif.DecodeConfig(r)
+	case "image/webp":
+		cfg, err = webp.DecodeConfig(r)
+	}
+	if err != nil || cfg.Width <= 0 || cfg.Height <= 0 {
+		return &imageError{Status: http.StatusBadRequest, Reason: "invalid_image", Message: "Failed to decode image"}
+	}
+
+	limits := h.Config
+	w, hgt := cfg.Width, cfg.Height
+	pixels := int64(w) * int64(hgt)
+	if (limits.MaxWidth > 0 && w > limits.MaxWidth) || (limits.MaxHeight > 0 && hgt > limits.MaxHeight) {
+		return &imageError{Status: http.StatusRequestEntityTooLarge, Reason: "image_dimensions",
+			Message: fmt.Sprintf("Image is %dx%d; the maximum is %dx%d", w, hgt, limits.MaxWidth, limits.MaxHeight),
+			Details: map[string]interface{}{"width": w, "height": hgt, "max_width": limits.MaxWidth, "max_height": limits.MaxHeight}}
+	}
+	if limits.MaxPixels > 0 && pixels > int64(limits.MaxPixels) {
+		return &imageError{Status: http.StatusRequestEntityTooLarge, Reason: "image_pixels",
+			Message: fmt.Sprintf("Image has %d pixels; the maximum is %d", pixels, limits.MaxPixels),
+			Details: map[string]interface{}{"pixels": pixels, "max_pixels": limits.MaxPixels}}
+	}
+
+	frames := 1
+	if contentType == "image/gif" {
+		if frames, err = imaging.GIFFrameCount(data); err != nil || frames == 0 {
+			return &imageError{Status: http.StatusBadRequest, Reason: "invalid_image", Message: "Failed to decode image"}
+		}
+		if limits.MaxFrames > 0 && frames > limits.MaxFrames {
+			return &imageError{Status: http.StatusRequestEntityTooLarge, Reason: "gif_frames",
+				Message: fmt.Sprintf("GIF has %d frames; the maximum is %d", frames, limits.MaxFrames),
+				Details: map[string]interface{}{"frames": frames, "max_frames": limits.MaxFrames}}
+		}
+	}
+
+	if budget := limits.DecodeBudgetBytes; budget > 0 {
+		if need := decodeMemory(cfg, frames); need > budget {
+			return &imageError{Status: http.StatusRequestEntityTooLarge, Reason: "decode_memory_budget",
+				Message: fmt.Sprintf("Image needs about %d MB to process; the limit is %d MB", need>>20, budget>>20),
+				Details: map[string]interface{}{"estimated_bytes": need, "budget_bytes": budget}}
+		}
+	}
+	return nil
+}
+
+// decodeMemory estimates the bytes needed to process an image: every
+// decoded frame in its native pixel format plus one RGBA working copy used
+// for orientation and resizing. Re-encoding an animation also needs a second
+// RGBA canvas for disposal, two index buffers and the optimised frames.
+func decodeMemory(cfg image.Config, frames int) int64 {
+	pixels := int64(cfg.Width) * int64(cfg.Height)
+	need := pixels*int64(frames)*bytesPerPixel(cfg.ColorModel) + pixels*4
+	if frames > 1 {
+		need += pixels*(4+2) + pixels*int64(frames)
+	}
+	return need
+}
+
+func bytesPerPixel(m color.Model) int64 {
+	if _, ok := m.(color.Palette); ok {
+		return 1
+	}
+	switch m {
+	case color.GrayModel, color.AlphaModel:
+		return 1
+	case color.Gray16Model, color.Alpha16Model:
+		return 2
+	case color.YCbCrModel:
+		return 3
+	case color.RGBA64Model, color.NRGBA64Model:
+		return 8
+	}
+	return 4
+}
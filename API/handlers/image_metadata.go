@@ -0,0 +1,34 @@
+package handlers
+
+import "forum/imaging"
+
+// exifPolicy applies the IMAGE_EXIF_KEEP allowlist to the metadata of an
+// upload. It returns the EXIF block to embed into the stored original (nil
+// when nothing is kept) and the names of every field that is dropped.
+// Thumbnails and renditions never carry metadata.
+func (h *ImageHandler) exifPolicy(meta *imaging.Metadata) ([]byte, []string) {
+	keep := make(map[string]bool, len(h.Config.ExifKeep))
+	for _, name := range h.Config.ExifKeep {
+		keep[name] = true
+	}
+
+	var kept []byte
+	keptNames := map[string]bool{}
+	if meta.Exif != nil && len(keep) > 0 {
+		// Keep never retains Orientation: it is applied to the pixels.
+		if x := meta.Exif.Keep(keep); len(x.Entries) > 0 {
+			kept = x.Encode()
+			for _, e := range x.Entries {
+				keptNames[e.Name()] = true
+			}
+		}
+	}
+
+	var stripped []string
+	for _, name := range meta.Fields {
+		if !keptNames[name] {
+			stripped = append(stripped, name)
+		}
+	}
+	return kept, stripped
+}
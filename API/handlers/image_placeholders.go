@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"image"
+
+	"forum/imaging"
+)
+
+// placeholders computes the BlurHash and dominant colour of an image from a
+// copy at most 32 pixels wide, so the UI can paint something before the
+// thumbnail loads.
+func placeholders(img image.Image) (blurHash, dominant string) {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if w == 0 || h == 0 {
+		return "", ""
+	}
+	small := img
+	if w > 32 || h > 32 {
+		nw, nh := 32, max(1, h*32/w)
+		if h > w {
+			nw, nh = max(1, w*32/h), 32
+		}
+		small = resizeImage(img, nw, nh)
+	}
+
+	// Four components along the longer side, three along the shorter.
+	xComp, yComp := 4, 3
+	if h > w {
+		xComp, yComp = 3, 4
+	}
+	blurHash = imaging.BlurHash(small, xComp, yComp)
+	if c, ok := imaging.DominantColor(small); ok {
+		dominant = imaging.HexColor(c)
+	}
+	return blurHash, dominant
+}
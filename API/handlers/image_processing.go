@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"time"
+
+	"forum/imaging"
+	"forum/jobs"
+	"forum/middleware"
+	"forum/models"
+	"forum/storage"
+	"forum/utils"
+
+	"golang.org/x/image/webp"
+)
+
+// imageJobPayload is stored with every image processing job.
+type imageJobPayload struct {
+	SourceKey   string `json:"source_key"`
+	ContentType string `json:"content_type"`
+	Ext         string `json:"ext"`
+	ContentHash string `json:"content_hash"`
+	// Quarantine keeps the image hidden once processed, see ImageScanner.
+	Quarantine bool `json:"quarantine,omitempty"`
+}
+
+// ProcessJob runs an image processing job: it writes the files of the
+// uploaded image and marks the image ready, or quarantined when the scanner
+// flagged it.
+func (h *ImageHandler) ProcessJob(job *models.Job) error {
+	var p imageJobPayload
+	if err := json.Unmarshal([]byte(job.Payload), &p); err != nil {
+		return jobs.Permanent(fmt.Errorf("invalid payload: %v", err))
+	}
+
+	pending, err := h.ImageRepo.GetByID(job.ImageID)
+	if err != nil {
+		return err
+	}
+	if pending == nil || pending.Status != models.ImageProcessing {
+		// Deleted (or already handled) while queued: nothing left to do.
+		h.Store.Delete(p.SourceKey)
+		return nil
+	}
+
+	body, _, err := h.Store.Get(p.SourceKey)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return jobs.Permanent(fmt.Errorf("upload %s is missing", p.SourceKey))
+		}
+		return err
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return err
+	}
+
+	// Another job may have processed the same bytes in the meantime. The
+	// blob is locked until the image references it, so that releasing an
+	// earlier copy cannot delete the files being written or reused.
+	unlock := lockBlob(p.ContentHash)
+	defer unlock()
+	var processed models.Image
+	if existing, err := h.ImageRepo.FindByHash(p.ContentHash); err != nil {
+		return err
+	} else if existing != nil {
+		processed = reuseBlob(existing, pending.PostID, pending.UserID)
+	} else if processed, err = h.processImage(data, p.ContentType, p.Ext, p.ContentHash); err != nil {
+		return err
+	}
+	processed.ID = pending.ID
+	processed.CreatedAt = pending.CreatedAt
+	if p.Quarantine {
+		processed.Status = models.ImageQuarantined
+	}
+
+	if err := h.ImageRepo.Complete(processed); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	// sql.ErrNoRows: the image was deleted while processing; any files left
+	// behind are removed by the orphan collector.
+	h.Store.Delete(p.SourceKey)
+	return nil
+}
+
+// ProcessJobFailed marks the image of a job that will not be retried as
+// failed and drops the raw upload.
+func (h *ImageHandler) ProcessJobFailed(job *models.Job, err error) {
+	if job.ImageID != "" {
+		if serr := h.ImageRepo.SetStatus(job.ImageID, models.ImageFailed); serr != nil {
+			log.Printf("Failed to mark image %s failed: %v", job.ImageID, serr)
+		}
+	}
+	var p imageJobPayload
+	if json.Unmarshal([]byte(job.Payload), &p) == nil && p.SourceKey != "" {
+		h.Store.Delete(p.SourceKey)
+	}
+}
+
+// processImage decodes an upload and stores the normalised original, its
+// thumbnail, renditions and variants under the blob directory of hash. The
+// returned image has every field but the IDs, post and user filled in. On
+// error the files written so far are removed; undecodable input is a
+// permanent error.
+func (h *ImageHandler) processImage(data []byte, contentType, ext, hash string) (models.Image, error) {
+	img, gifData, err := decodeUpload(data, contentType)
+	if err != nil {
+		return models.Image{}, jobs.Permanent(fmt.Errorf("decode image: %v", err))
+	}
+
+	// Every stored file is re-encoded from pixels, which drops all metadata.
+	// The orientation is baked into the pixels and only allowlisted EXIF
+	// fields are written back into the original.
+	meta := imaging.ReadMetadata(data, contentType)
+	if gifData == nil {
+		img = imaging.Orient(img, meta.Orientation)
+	}
+	keptExif, stripped := h.exifPolicy(meta)
+
+	// WebP uploads are stored as JPEG, or PNG when they use transparency, so
+	// every client can display the original; WebP is served as a variant.
+	if contentType == "image/webp" {
+		contentType, ext = "image/jpeg", ".jpg"
+		if o, ok := img.(interface{ Opaque() bool }); ok && !o.Opaque() {
+			contentType, ext = "image/png", ".png"
+		}
+	}
+
+	baseDir := blobDir(hash)
+	filePath := path.Join(baseDir, "original"+ext)
+	var buf bytes.Buffer
+	if contentType == "image/gif" {
+		err = h.encodeGIF(&buf, gifData, imaging.GIFOptions{})
+	} else {
+		err = encodeImage(&buf, img, contentType)
+	}
+	if err != nil {
+		return models.Image{}, fmt.Errorf("encode image: %v", err)
+	}
+	if keptExif != nil {
+		withExif := imaging.EmbedExif(buf.Bytes(), contentType, keptExif)
+		buf.Reset()
+		buf.Write(withExif)
+	}
+	originalSize := int64(buf.Len())
+	if err := h.Store.Put(filePath, &buf, contentType); err != nil {
+		return models.Image{}, fmt.Errorf("store image: %v", err)
+	}
+	written := []string{filePath}
+	cleanup := func() {
+		for _, key := range written {
+			h.Store.Delete(key)
+		}
+	}
+
+	thumbPath := path.Join(baseDir, "thumbnail"+ext)
+	buf.Reset()
+	var thumbImg image.Image
+	if contentType == "image/gif" {
+		err = h.encodeGIF(&buf, gifData, imaging.GIFOptions{
+			Width:       thumbnailSize,
+			Height:      thumbnailSize,
+			Letterbox:   true,
+			MaxFrames:   h.Config.GIFThumbMaxFrames,
+			MaxDuration: int(h.Config.GIFThumbMaxDuration / (10 * time.Millisecond)),
+		})
+	} else {
+		thumbImg = createThumbnail(img, contentType != "image/jpeg")
+		err = encodeImage(&buf, thumbImg, contentType)
+	}
+	if err != nil {
+		cleanup()
+		return models.Image{}, fmt.Errorf("encode thumbnail: %v", err)
+	}
+	thumbSize := int64(buf.Len())
+	if err := h.Store.Put(thumbPath, &buf, contentType); err != nil {
+		cleanup()
+		return models.Image{}, fmt.Errorf("store thumbnail: %v", err)
+	}
+	written = append(written, thumbPath)
+
+	// Animated GIFs have no variants.
+	if contentType != "image/gif" {
+		for _, v := range []struct {
+			key  string
+			img  image.Image
+			size int64
+		}{{filePath, img, originalSize}, {thumbPath, thumbImg, thumbSize}} {
+			keys, err := h.storeVariants(v.key, v.img, contentType, v.size)
+			if err != nil {
+				cleanup()
+				return models.Image{}, err
+			}
+			written = append(written, keys...)
+		}
+	}
+
+	renditions, err := h.storeRenditions(baseDir, ext, contentType, img, gifData, filePath, originalSize)
+	if err != nil {
+		cleanup()
+		return models.Image{}, err
+	}
+
+	blurHash, dominant := placeholders(img)
+
+	// Keys are relative to the storage root so they can be served via the
+	// /static/ route.
+	return models.Image{
+		FilePath:         filePath,
+		ThumbnailPath:    thumbPath,
+		Backend:          h.Store.Name(),
+		Orientation:      meta.Orientation,
+		StrippedMetadata: stripped,
+		ContentHash:      hash,
+		BlurHash:         blurHash,
+		DominantColor:    dominant,
+		Renditions:       renditions,
+	}, nil
+}
+
+// decodeUpload decodes an upload. Animated GIFs are decoded in full and
+// returned with their first frame as img.
+func decodeUpload(data []byte, contentType string) (img image.Image, gifData *gif.GIF, err error) {
+	switch contentType {
+	case "image/jpeg":
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	case "image/png":
+		img, err = png.Decode(bytes.NewReader(data))
+	case "image/gif":
+		gifData, err = gif.DecodeAll(bytes.NewReader(data))
+		if err == nil && len(gifData.Image) > 0 {
+			img = gifData.Image[0]
+		}
+	case "image/webp":
+		img, err = webp.Decode(bytes.NewReader(data))
+	default:
+		err = fmt.Errorf("unsupported content type %s", contentType)
+	}
+	if err == nil && img == nil {
+		err = errors.New("no image data")
+	}
+	return img, gifData, err
+}
+
+// JobStatus reports the state of one of the current user's jobs, with the
+// image once it is ready.
+func (h *ImageHandler) JobStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		utils.ErrorResponse(w, "Missing job ID", http.StatusBadRequest)
+		return
+	}
+	job, err := h.Jobs.Repo.GetByID(id)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load job", http.StatusInternalServerError)
+		return
+	}
+	if job == nil || job.UserID != user.ID {
+		utils.ErrorResponse(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	resp := struct {
+		*models.Job
+		Image *models.PostImage `json:"image,omitempty"`
+	}{Job: job}
+	if job.Status == models.JobDone && job.ImageID != "" {
+		img, err := h.ImageRepo.GetByID(job.ImageID)
+		if err != nil {
+			utils.ErrorResponse(w, "Failed to load image", http.StatusInternalServerError)
+			return
+		}
+		if img != nil {
+			resp.Image = &postImages([]models.Image{*img})[0]
+		}
+	}
+	utils.JSONResponse(w, resp, http.StatusOK)
+}
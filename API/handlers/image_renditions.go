@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"io"
+	"path"
+	"strings"
+
+	"forum/imaging"
+	"forum/models"
+)
+
+// storeRenditions writes one resized copy per configured rendition next to
+// the original, with their modern-format variants, and returns their
+// descriptions. The original itself is listed
+// as the "original" rendition. Renditions that would not be smaller than the
+// original are skipped. On error every file written so far is removed.
+func (h *ImageHandler) storeRenditions(baseDir, ext, contentType string, img image.Image, gifData *gif.GIF, original string, originalSize int64) ([]models.ImageRendition, error) {
+	sw := img.Bounds().Dx()
+	sh := img.Bounds().Dy()
+	if gifData != nil && gifData.Config.Width > 0 && gifData.Config.Height > 0 {
+		sw, sh = gifData.Config.Width, gifData.Config.Height
+	}
+	renditions := []models.ImageRendition{{
+		Name:        "original",
+		FilePath:    original,
+		Width:       sw,
+		Height:      sh,
+		ContentType: contentType,
+		SizeBytes:   originalSize,
+	}}
+
+	var written []string
+	cleanup := func() {
+		for _, key := range written {
+			h.Store.Delete(key)
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, spec := range h.Config.Renditions {
+		if spec.MaxWidth >= sw {
+			continue
+		}
+		nw, nh := fitWidth(sw, sh, spec.MaxWidth)
+
+		buf.Reset()
+		var err error
+		var resized image.Image
+		if contentType == "image/gif" {
+			err = h.encodeGIF(&buf, gifData, imaging.GIFOptions{Width: nw, Height: nh})
+		} else {
+			resized = resizeImage(img, nw, nh)
+			err = encodeImage(&buf, resized, contentType)
+		}
+		if err != nil {
+			cleanup()
+			return nil, fmt.Errorf("encode %s rendition: %v", spec.Name, err)
+		}
+
+		key := path.Join(baseDir, spec.Name+ext)
+		size := int64(buf.Len())
+		if err := h.Store.Put(key, &buf, contentType); err != nil {
+			cleanup()
+			return nil, fmt.Errorf("store %s rendition: %v", spec.Name, err)
+		}
+		written = append(written, key)
+		if resized != nil {
+			keys, err := h.storeVariants(key, resized, contentType, size)
+			if err != nil {
+				cleanup()
+				return nil, err
+			}
+			written = append(written, keys...)
+		}
+		renditions = append(renditions, models.ImageRendition{
+			Name:        spec.Name,
+			FilePath:    key,
+			Width:       nw,
+			Height:      nh,
+			ContentType: contentType,
+			SizeBytes:   size,
+		})
+	}
+	return renditions, nil
+}
+
+// fitWidth scales w x h down to maxWidth keeping the aspect ratio.
+func fitWidth(w, h, maxWidth int) (int, int) {
+	nh := int(float64(h) * float64(maxWidth) / float64(w))
+	if nh < 1 {
+		nh = 1
+	}
+	return maxWidth, nh
+}
+
+// renditionURLs converts stored renditions into response entries and a
+// srcset attribute value ("url 320w, url 800w, ...").
+func renditionURLs(renditions []models.ImageRendition) ([]models.RenditionURL, string) {
+	if len(renditions) == 0 {
+		return nil, ""
+	}
+	urls := make([]models.RenditionURL, 0, len(renditions))
+	srcset := make([]string, 0, len(renditions))
+	for _, rd := range renditions {
+		u := staticURLs.URL(rd.FilePath)
+		urls = append(urls, models.RenditionURL{Name: rd.Name, URL: u, Width: rd.Width, Height: rd.Height})
+		srcset = append(srcset, fmt.Sprintf("%s %dw", u, rd.Width))
+	}
+	return urls, strings.Join(srcset, ", ")
+}
+
+// encodeGIF writes an animation optimised by imaging.OptimizeGIF. When the
+// frames need no resizing, as for originals and thumbnails of small
+// animations, the source frames re-encoded as they are are kept instead if
+// that is smaller, e.g. for uploads that were already optimised.
+func (h *ImageHandler) encodeGIF(w io.Writer, src *gif.GIF, opts imaging.GIFOptions) error {
+	opts.Filter = resampleFilter
+	var optimised bytes.Buffer
+	if err := gif.EncodeAll(&optimised, imaging.OptimizeGIF(src, opts)); err != nil {
+		return err
+	}
+	if placed, ok := imaging.PlaceGIF(src, opts); ok {
+		var plain bytes.Buffer
+		if err := gif.EncodeAll(&plain, placed); err == nil && plain.Len() < optimised.Len() {
+			_, err = plain.WriteTo(w)
+			return err
+		}
+	}
+	_, err := optimised.WriteTo(w)
+	return err
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"context"
+	"log"
+
+	"forum/models"
+)
+
+// scanUpload runs the configured scanner on new content and returns its
+// verdict, or nil when scanning is off. A scanner failure flags the upload
+// unless the forum is configured to publish unscanned images.
+func (h *ImageHandler) scanUpload(data []byte, contentType string) *models.ScanVerdict {
+	if h.Scanner == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), h.Config.Scan.Timeout)
+	defer cancel()
+
+	verdict := &models.ScanVerdict{Scanner: h.Scanner.Name()}
+	result, err := h.Scanner.Scan(ctx, data, contentType)
+	if err != nil {
+		log.Printf("Image scanner %s failed: %v", verdict.Scanner, err)
+		verdict.Error = err.Error()
+		verdict.Flagged = h.Config.Scan.QuarantineOnError
+		return verdict
+	}
+	verdict.Flagged, verdict.Score, verdict.Labels = result.Flagged, result.Score, result.Labels
+	return verdict
+}
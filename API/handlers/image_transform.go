@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"forum/config"
+)
+
+// transformOptions describe one derived image served under /img/.
+type transformOptions struct {
+	Width, Height int
+	// Fit is "contain" (fit inside the box, never upscaled), "cover" (fill
+	// the box, cropping around the focal point), "fill" (stretch) or "crop"
+	// (cut the box out at the original scale around the focal point).
+	Fit string
+	// FocusX and FocusY place the focal point, from 0 (left/top) to 1.
+	FocusX, FocusY float64
+	// Format is "jpeg", "png" or "webp"; empty keeps the original's family.
+	Format string
+	// Quality only applies to JPEG.
+	Quality int
+}
+
+// parseTransform reads and validates the w, h, fit, fx, fy, fmt and q query
+// parameters. Sizes and qualities must be listed in cfg so the set of
+// possible derivatives, and with it the cache, stays bounded.
+func parseTransform(q url.Values, cfg config.TransformConfig) (transformOptions, error) {
+	o := transformOptions{Fit: "contain", FocusX: 0.5, FocusY: 0.5, Quality: cfg.DefaultQuality}
+	var err error
+	if o.Width, err = parseSize(q.Get("w"), cfg.Sizes); err != nil {
+		return o, fmt.Errorf("w: %v", err)
+	}
+	if o.Height, err = parseSize(q.Get("h"), cfg.Sizes); err != nil {
+		return o, fmt.Errorf("h: %v", err)
+	}
+	if o.Width == 0 && o.Height == 0 {
+		return o, fmt.Errorf("w or h is required")
+	}
+
+	if v := q.Get("fit"); v != "" {
+		o.Fit = strings.ToLower(v)
+	}
+	switch o.Fit {
+	case "contain":
+	case "cover", "fill", "crop":
+		if o.Width == 0 || o.Height == 0 {
+			return o, fmt.Errorf("fit=%s needs both w and h", o.Fit)
+		}
+	default:
+		return o, fmt.Errorf("fit must be contain, cover, fill or crop")
+	}
+
+	if o.FocusX, err = parseFocus(q.Get("fx")); err != nil {
+		return o, fmt.Errorf("fx: %v", err)
+	}
+	if o.FocusY, err = parseFocus(q.Get("fy")); err != nil {
+		return o, fmt.Errorf("fy: %v", err)
+	}
+
+	switch strings.ToLower(q.Get("fmt")) {
+	case "":
+	case "jpeg", "jpg":
+		o.Format = "jpeg"
+	case "png":
+		o.Format = "png"
+	case "webp":
+		o.Format = "webp"
+	default:
+		return o, fmt.Errorf("fmt must be jpeg, png or webp")
+	}
+
+	if v := q.Get("q"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || !containsInt(cfg.Qualities, n) && n != cfg.DefaultQuality {
+			return o, fmt.Errorf("q must be one of %s", joinInts(cfg.Qualities))
+		}
+		o.Quality = n
+	}
+	return o, nil
+}
+
+func parseSize(raw string, allowed []int) (int, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || !containsInt(allowed, n) {
+		return 0, fmt.Errorf("must be one of %s", joinInts(allowed))
+	}
+	return n, nil
+}
+
+// parseFocus parses a focal coordinate, rounded to tenths so nearly
+// identical requests share a cache entry.
+func parseFocus(raw string) (float64, error) {
+	if raw == "" {
+		return 0.5, nil
+	}
+	f, err := strconv.ParseFloat(raw, 64)
+	if err != nil || f < 0 || f > 1 {
+		return 0, fmt.Errorf("must be between 0 and 1")
+	}
+	return math.Round(f*10) / 10, nil
+}
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+func joinInts(list []int) string {
+	parts := make([]string, len(list))
+	for i, n := range list {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// resolve fills in the output format from the original's key and drops the
+// quality where it has no effect.
+func (o transformOptions) resolve(sourceKey string) transformOptions {
+	if o.Format == "" {
+		o.Format = "png"
+		if strings.HasSuffix(strings.ToLower(sourceKey), ".jpg") {
+			o.Format = "jpeg"
+		}
+	}
+	if o.Format != "jpeg" {
+		o.Quality = 0
+	}
+	return o
+}
+
+// canonical is the normalised form of resolved options, used both as cache
+// key and as signed payload.
+func (o transformOptions) canonical() string {
+	return fmt.Sprintf("w=%d&h=%d&fit=%s&fx=%.2f&fy=%.2f&fmt=%s&q=%d",
+		o.Width, o.Height, o.Fit, o.FocusX, o.FocusY, o.Format, o.Quality)
+}
+
+func (o transformOptions) contentType() string {
+	return "image/" + o.Format
+}
+
+// render applies the options to src.
+func (o transformOptions) render(src image.Image) image.Image {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	w, h := o.Width, o.Height
+
+	switch o.Fit {
+	case "fill":
+		return resizeImage(src, w, h)
+	case "crop":
+		return cropAround(src, min(w, sw), min(h, sh), o.FocusX, o.FocusY)
+	case "cover":
+		scale := math.Max(float64(w)/float64(sw), float64(h)/float64(sh))
+		cw := min(sw, max(1, int(math.Round(float64(w)/scale))))
+		ch := min(sh, max(1, int(math.Round(float64(h)/scale))))
+		return resizeImage(cropAround(src, cw, ch, o.FocusX, o.FocusY), w, h)
+	}
+
+	// contain
+	scale := 1.0
+	if w > 0 {
+		scale = math.Min(scale, float64(w)/float64(sw))
+	}
+	if h > 0 {
+		scale = math.Min(scale, float64(h)/float64(sh))
+	}
+	if scale == 1 {
+		return src
+	}
+	nw := max(1, int(math.Round(float64(sw)*scale)))
+	nh := max(1, int(math.Round(float64(sh)*scale)))
+	return resizeImage(src, nw, nh)
+}
+
+// cropAround copies a w x h region of src centred on the focal point as far
+// as the image bounds allow.
+func cropAround(src image.Image, w, h int, fx, fy float64) image.Image {
+	b := src.Bounds()
+	x := b.Min.X + int(math.Round(fx*float64(b.Dx())-float64(w)/2))
+	y := b.Min.Y + int(math.Round(fy*float64(b.Dy())-float64(h)/2))
+	x = max(b.Min.X, min(x, b.Max.X-w))
+	y = max(b.Min.Y, min(y, b.Max.Y-h))
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), src, image.Pt(x, y), draw.Src)
+	return dst
+}
+
+// encodeTransformed encodes img in the resolved format. JPEG output is
+// flattened onto white so transparent areas do not turn black.
+func (h *ImageHandler) encodeTransformed(img image.Image, o transformOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	switch o.Format {
+	case "jpeg":
+		flat := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+		drawBackground(flat, color.White)
+		draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+		if err := jpeg.Encode(&buf, flat, &jpeg.Options{Quality: o.Quality}); err != nil {
+			return nil, err
+		}
+	case "png":
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+	case "webp":
+		data, err := h.encodeVariant("webp", img, "image/png")
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			return nil, fmt.Errorf("no WebP encoder available")
+		}
+		return data, nil
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"log"
+
+	"forum/imaging"
+)
+
+// variantFormat is a modern encoding stored next to an image under the
+// original key plus Ext, e.g. "photo.jpg.webp".
+type variantFormat struct {
+	Name        string
+	Ext         string
+	ContentType string
+}
+
+// variantFormats lists the supported variants, best first. The static
+// handler serves the first one the client accepts.
+var variantFormats = []variantFormat{
+	{Name: "avif", Ext: ".avif", ContentType: "image/avif"},
+	{Name: "webp", Ext: ".webp", ContentType: "image/webp"},
+}
+
+// storeVariants encodes img in every configured variant format and stores
+// the results that are smaller than the original (size bytes). Encoder
+// failures only skip the variant; storage failures are returned. The keys
+// written are returned so callers can clean up.
+func (h *ImageHandler) storeVariants(key string, img image.Image, contentType string, size int64) ([]string, error) {
+	var written []string
+	for _, f := range variantFormats {
+		if !h.variantEnabled(f.Name) {
+			continue
+		}
+		data, err := h.encodeVariant(f.Name, img, contentType)
+		if err != nil {
+			log.Printf("Skipping %s variant of %s: %v", f.Name, key, err)
+			continue
+		}
+		if data == nil || int64(len(data)) >= size {
+			continue
+		}
+		vkey := key + f.Ext
+		if err := h.Store.Put(vkey, bytes.NewReader(data), f.ContentType); err != nil {
+			for _, k := range written {
+				h.Store.Delete(k)
+			}
+			return nil, fmt.Errorf("store %s variant: %v", f.Name, err)
+		}
+		written = append(written, vkey)
+	}
+	return written, nil
+}
+
+func (h *ImageHandler) variantEnabled(name string) bool {
+	for _, v := range h.Config.Variants {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeVariant returns nil without error when no encoder applies. The
+// built-in WebP encoder is lossless, so it is only worth running on
+// lossless sources.
+func (h *ImageHandler) encodeVariant(name string, img image.Image, contentType string) ([]byte, error) {
+	switch name {
+	case "webp":
+		if cmd := imaging.ParseCommandEncoder(h.Config.WebPEncoder); cmd != nil {
+			return cmd.Encode(img)
+		}
+		if contentType != "image/png" {
+			return nil, nil
+		}
+		var buf bytes.Buffer
+		if err := imaging.EncodeWebP(&buf, img); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "avif":
+		if cmd := imaging.ParseCommandEncoder(h.Config.AVIFEncoder); cmd != nil {
+			return cmd.Encode(img)
+		}
+	}
+	return nil, nil
+}
@@ -1,34 +1,68 @@
 package handlers
 
 import (
+	"bytes"
+	"errors"
 	"image"
 	"image/color"
 	"image/draw"
 	"image/gif"
 	"image/jpeg"
 	"image/png"
+	"io"
+	"log"
 	"net/http"
-	"os"
+	"path"
 	"path/filepath"
 	"strings"
-	"time"
 
+	"forum/config"
+	"forum/imaging"
+	"forum/jobs"
 	"forum/middleware"
 	"forum/models"
 	"forum/repository"
+	"forum/scanning"
+	"forum/storage"
 	"forum/utils"
 )
 
-// uploadBaseDir is relative to the API project root. Images will be served
-// from the API container under /static/.
-const uploadBaseDir = "uploads/images"
+// uploadBaseDir is the key prefix for uploaded images inside the storage
+// backend. Images will be served from the API container under /static/.
+// Each upload is stored once per content hash, see blobDir.
+const uploadBaseDir = "images"
+
+// maxUploadSize is the largest image accepted, in one request or in chunks.
+const maxUploadSize = 20 << 20
+
+// incomingDir holds raw uploads until their processing job has run. It is
+// outside uploadBaseDir, so it is never served. Files no pending job needs
+// are removed by the collector in package gc.
+const incomingDir = "incoming"
 
 type ImageHandler struct {
 	ImageRepo *repository.ImageRepository
+	PostRepo  *repository.PostRepository
+	HashRepo  *repository.ImageHashRepository
+	ScanRepo  *repository.ImageScanRepository
+	// Scanner checks new uploads; nil when scanning is off.
+	Scanner scanning.ImageScanner
+	Store   storage.Storage
+	Jobs    *jobs.Pool
+	Config  config.ImageConfig
+}
+
+func NewImageHandler(repo *repository.ImageRepository, postRepo *repository.PostRepository, hashRepo *repository.ImageHashRepository, scanRepo *repository.ImageScanRepository, scanner scanning.ImageScanner, store storage.Storage, pool *jobs.Pool, cfg config.ImageConfig) *ImageHandler {
+	return &ImageHandler{ImageRepo: repo, PostRepo: postRepo, HashRepo: hashRepo, ScanRepo: scanRepo, Scanner: scanner, Store: store, Jobs: pool, Config: cfg}
 }
 
-func NewImageHandler(repo *repository.ImageRepository) *ImageHandler {
-	return &ImageHandler{ImageRepo: repo}
+// uploadResponse is the created image with the ID of the job processing it;
+// JobID is empty when the upload reused an already processed image.
+// Quarantined images wait for a moderator once processed.
+type uploadResponse struct {
+	*models.Image
+	JobID       string `json:"job_id,omitempty"`
+	Quarantined bool   `json:"quarantined,omitempty"`
 }
 
 func (h *ImageHandler) Upload(w http.ResponseWriter, r *http.Request) {
@@ -50,7 +84,17 @@ func (h *ImageHandler) Upload(w http.ResponseWriter, r *http.Request) {
 
 	postID := r.FormValue("post_id")
 	if postID == "" {
-		utils.ErrorResponse(w, "Post ID required", http.StatusBadRequest)
+		utils.ReasonErrorResponse(w, "Post ID required", http.StatusBadRequest, "post_id_required", nil)
+		return
+	}
+	if e := h.checkPostAuthor(postID, user.ID); e != nil {
+		e.write(w)
+		return
+	}
+
+	altText, caption := strings.TrimSpace(r.FormValue("alt_text")), strings.TrimSpace(r.FormValue("caption"))
+	if e := h.checkImageText(altText, caption); e != nil {
+		e.write(w)
 		return
 	}
 
@@ -61,133 +105,191 @@ func (h *ImageHandler) Upload(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	if header.Size > 20<<20 {
+	if header.Size > maxUploadSize {
 		utils.ErrorResponse(w, "Image exceeds 20 MB limit", http.StatusBadRequest)
 		return
 	}
 
-	ext := strings.ToLower(filepath.Ext(header.Filename))
-	var contentType string
-	switch ext {
-	case ".jpg", ".jpeg":
-		contentType = "image/jpeg"
-		ext = ".jpg"
-	case ".png":
-		contentType = "image/png"
-	case ".gif":
-		contentType = "image/gif"
-	default:
-		buf := make([]byte, 512)
-		n, _ := file.Read(buf)
-		contentType = http.DetectContentType(buf[:n])
-		file.Seek(0, 0)
-		switch contentType {
-		case "image/jpeg":
-			ext = ".jpg"
-		case "image/png":
-			ext = ".png"
-		case "image/gif":
-			ext = ".gif"
-		default:
-			utils.ErrorResponse(w, "Unsupported image type", http.StatusBadRequest)
-			return
-		}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to read image", http.StatusBadRequest)
+		return
 	}
+	h.acceptUpload(w, user.ID, postID, header.Filename, data, altText, caption)
+}
 
-	var img image.Image
-	var gifData *gif.GIF
-	switch contentType {
-	case "image/jpeg":
-		img, err = jpeg.Decode(file)
-	case "image/png":
-		img, err = png.Decode(file)
-	case "image/gif":
-		gifData, err = gif.DecodeAll(file)
-		if err == nil && len(gifData.Image) > 0 {
-			img = gifData.Image[0]
-		}
-	}
-	if err != nil {
-		utils.ErrorResponse(w, "Failed to decode image", http.StatusBadRequest)
+// acceptUpload runs the checks shared by every way of uploading an image and
+// either reuses an identical processed image or queues a processing job.
+// The post must already be checked to belong to userID.
+func (h *ImageHandler) acceptUpload(w http.ResponseWriter, userID, postID, filename string, data []byte, altText, caption string) {
+	contentType, ext, ok := detectImageType(filename, data)
+	if !ok {
+		utils.ErrorResponse(w, "Unsupported image type", http.StatusBadRequest)
 		return
 	}
 
-	dateStr := time.Now().Format("2006-01-02")
-	baseDir := filepath.Join(uploadBaseDir, user.ID, dateStr)
-	thumbDir := filepath.Join(baseDir, "thumbnails")
-	if err := os.MkdirAll(thumbDir, 0755); err != nil {
-		utils.ErrorResponse(w, "Failed to create directory", http.StatusInternalServerError)
+	if e := h.checkQuota(postID, userID, int64(len(data))); e != nil {
+		e.write(w)
+		return
+	}
+	if e := h.checkDecodeLimits(data, contentType); e != nil {
+		e.write(w)
 		return
 	}
 
-	uuid := utils.GenerateUUID()
-	fileName := uuid + ext
-	filePath := filepath.Join(baseDir, fileName)
-	out, err := os.Create(filePath)
+	// The blob is locked until the reuse below references it, so that its
+	// files cannot be released in between
+	hash := contentHash(data)
+	unlock := lockBlob(hash)
+	defer unlock()
+	existing, err := h.ImageRepo.FindByHash(hash)
 	if err != nil {
 		utils.ErrorResponse(w, "Failed to save image", http.StatusInternalServerError)
 		return
 	}
-	if contentType == "image/gif" {
-		if err := gif.EncodeAll(out, gifData); err != nil {
-			out.Close()
-			utils.ErrorResponse(w, "Failed to save image", http.StatusInternalServerError)
-			return
-		}
-	} else {
-		if err := encodeImage(out, img, contentType); err != nil {
-			out.Close()
+
+	// Banned pictures are refused before anything is stored, so content not
+	// seen before is decoded here for its perceptual hashes, on top of the
+	// decoding done by the processing job.
+	var phash, dhash string
+	if existing != nil && existing.PHash != "" {
+		phash, dhash = existing.PHash, existing.DHash
+	} else if phash, dhash, err = uploadHashes(data, contentType); err != nil {
+		utils.ReasonErrorResponse(w, "Failed to decode image", http.StatusBadRequest, "invalid_image", nil)
+		return
+	}
+	if e := h.checkBanned(phash, dhash); e != nil {
+		e.write(w)
+		return
+	}
+
+	// Identical bytes were processed before: reference the existing blob.
+	// Its scan verdict stands, including a quarantine.
+	if existing != nil {
+		reused := reuseBlob(existing, postID, userID)
+		reused.PHash, reused.DHash = phash, dhash
+		reused.AltText, reused.Caption = altText, caption
+		created, err := h.ImageRepo.Create(reused)
+		if err != nil {
 			utils.ErrorResponse(w, "Failed to save image", http.StatusInternalServerError)
 			return
 		}
+		utils.JSONResponse(w, uploadResponse{Image: created, Quarantined: created.Status == models.ImageQuarantined}, http.StatusCreated)
+		return
 	}
-	out.Close()
 
-	thumbPath := filepath.Join(thumbDir, fileName)
-	outT, err := os.Create(thumbPath)
+	verdict := h.scanUpload(data, contentType)
+	quarantine := verdict != nil && verdict.Flagged
+
+	// Everything expensive (decoding, thumbnails, renditions, variants) runs
+	// in a background job; the image stays hidden until it is ready.
+	sourceKey := path.Join(incomingDir, utils.GenerateUUID()+ext)
+	if err := h.Store.Put(sourceKey, bytes.NewReader(data), contentType); err != nil {
+		utils.ErrorResponse(w, "Failed to save image", http.StatusInternalServerError)
+		return
+	}
+	// checkQuota ran before the upload was scanned and stored; the insert
+	// checks again so that uploads made in parallel cannot all pass.
+	pending, err := h.ImageRepo.CreateProcessing(models.Image{
+		PostID:      postID,
+		UserID:      userID,
+		Backend:     h.Store.Name(),
+		AltText:     altText,
+		Caption:     caption,
+		PHash:       phash,
+		DHash:       dhash,
+		SourceBytes: int64(len(data)),
+	}, h.Config.UserQuotaBytes)
+	if errors.Is(err, repository.ErrStorageQuota) {
+		h.Store.Delete(sourceKey)
+		used, _ := h.ImageRepo.StorageUsedByUser(userID)
+		storageQuotaError(h.Config.UserQuotaBytes, used, int64(len(data))).write(w)
+		return
+	}
 	if err != nil {
-		utils.ErrorResponse(w, "Failed to save thumbnail", http.StatusInternalServerError)
+		h.Store.Delete(sourceKey)
+		utils.ErrorResponse(w, "Failed to save image", http.StatusInternalServerError)
 		return
 	}
-
-	if contentType == "image/gif" {
-		thumbGIF := createThumbnailGIF(gifData)
-		if err := gif.EncodeAll(outT, thumbGIF); err != nil {
-			outT.Close()
-			utils.ErrorResponse(w, "Failed to save thumbnail", http.StatusInternalServerError)
-			return
-		}
-	} else {
-		thumbImg := createThumbnail(img, contentType != "image/jpeg")
-		if err := encodeImage(outT, thumbImg, contentType); err != nil {
-			outT.Close()
-			utils.ErrorResponse(w, "Failed to save thumbnail", http.StatusInternalServerError)
-			return
+	if verdict != nil {
+		verdict.ImageID, verdict.ContentHash = pending.ID, hash
+		if _, err := h.ScanRepo.Record(*verdict); err != nil {
+			log.Printf("Failed to record scan verdict of image %s: %v", pending.ID, err)
 		}
 	}
-	outT.Close()
-
-	// Store paths relative to the "uploads" directory so they can be served
-	// via the /static/ route.
-	relPath := filepath.ToSlash(strings.TrimPrefix(filePath, "uploads/"))
-	relThumb := filepath.ToSlash(strings.TrimPrefix(thumbPath, "uploads/"))
-	imgModel := models.Image{
-		PostID:        postID,
-		UserID:        user.ID,
-		FilePath:      relPath,
-		ThumbnailPath: relThumb,
-	}
-
-	created, err := h.ImageRepo.Create(imgModel)
+	job, err := h.Jobs.Enqueue(models.JobImageProcess, userID, pending.ID, imageJobPayload{
+		SourceKey:   sourceKey,
+		ContentType: contentType,
+		Ext:         ext,
+		ContentHash: hash,
+		Quarantine:  quarantine,
+	})
 	if err != nil {
-		utils.ErrorResponse(w, "Failed to save image", http.StatusInternalServerError)
+		h.ImageRepo.Delete(pending.ID)
+		h.Store.Delete(sourceKey)
+		utils.ErrorResponse(w, "Failed to queue image processing", http.StatusInternalServerError)
 		return
 	}
 
-	utils.JSONResponse(w, created, http.StatusCreated)
+	utils.JSONResponse(w, uploadResponse{Image: pending, JobID: job.ID, Quarantined: quarantine}, http.StatusAccepted)
 }
 
-func encodeImage(w *os.File, img image.Image, contentType string) error {
+// detectImageType returns the content type and stored extension of an
+// upload from its file name, sniffing the content when the extension is
+// unknown. WebP keeps ".webp" here; processing converts it.
+func detectImageType(filename string, data []byte) (contentType, ext string, ok bool) {
+	ext = strings.ToLower(filepath.Ext(filename))
+	switch ext {
+	case ".jpg", ".jpeg":
+		return "image/jpeg", ".jpg", true
+	case ".png":
+		return "image/png", ".png", true
+	case ".gif":
+		return "image/gif", ".gif", true
+	case ".webp":
+		return "image/webp", ".webp", true
+	}
+	switch contentType = http.DetectContentType(data); contentType {
+	case "image/jpeg":
+		return contentType, ".jpg", true
+	case "image/png":
+		return contentType, ".png", true
+	case "image/gif":
+		return contentType, ".gif", true
+	case "image/webp":
+		return contentType, ".webp", true
+	}
+	return "", "", false
+}
+
+// reuseBlob builds a new image for postID that shares the files of an
+// existing image with the same content.
+func reuseBlob(src *models.Image, postID, userID string) models.Image {
+	img := models.Image{
+		PostID:           postID,
+		UserID:           userID,
+		FilePath:         src.FilePath,
+		ThumbnailPath:    src.ThumbnailPath,
+		Backend:          src.Backend,
+		Orientation:      src.Orientation,
+		StrippedMetadata: src.StrippedMetadata,
+		ContentHash:      src.ContentHash,
+		BlurHash:         src.BlurHash,
+		DominantColor:    src.DominantColor,
+		PHash:            src.PHash,
+		DHash:            src.DHash,
+	}
+	if src.Status == models.ImageQuarantined {
+		img.Status = models.ImageQuarantined
+	}
+	for _, rd := range src.Renditions {
+		rd.ID, rd.ImageID = "", ""
+		img.Renditions = append(img.Renditions, rd)
+	}
+	return img
+}
+
+func encodeImage(w io.Writer, img image.Image, contentType string) error {
 	switch contentType {
 	case "image/jpeg":
 		return jpeg.Encode(w, img, &jpeg.Options{Quality: 90})
@@ -199,8 +301,11 @@ func encodeImage(w *os.File, img image.Image, contentType string) error {
 	return nil
 }
 
+// thumbnailSize is the edge of the square thumbnails.
+const thumbnailSize = 150
+
 func createThumbnail(src image.Image, keepAlpha bool) image.Image {
-	const size = 150
+	const size = thumbnailSize
 	sw := src.Bounds().Dx()
 	sh := src.Bounds().Dy()
 	scale := float64(size) / float64(sw)
@@ -210,84 +315,23 @@ func createThumbnail(src image.Image, keepAlpha bool) image.Image {
 	nw := int(float64(sw) * scale)
 	nh := int(float64(sh) * scale)
 	resized := resizeImage(src, nw, nh)
-	var dst draw.Image
-	if keepAlpha {
-		dst = image.NewNRGBA(image.Rect(0, 0, size, size))
-	} else {
-		d := image.NewRGBA(image.Rect(0, 0, size, size))
-		drawBackground(d, color.Black)
-		dst = d
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	if !keepAlpha {
+		drawBackground(dst, color.Black)
 	}
 	offX := (size - nw) / 2
 	offY := (size - nh) / 2
-	for y := 0; y < nh; y++ {
-		for x := 0; x < nw; x++ {
-			dst.Set(offX+x, offY+y, resized.At(x, y))
-		}
-	}
+	draw.Draw(dst, image.Rect(offX, offY, offX+nw, offY+nh), resized, image.Point{}, draw.Over)
 	return dst
 }
 
 func drawBackground(img *image.RGBA, c color.Color) {
-	bounds := img.Bounds()
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			img.Set(x, y, c)
-		}
-	}
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
 }
 
-func resizeImage(src image.Image, w, h int) *image.RGBA {
-	dst := image.NewRGBA(image.Rect(0, 0, w, h))
-	sb := src.Bounds()
-	sw := sb.Dx()
-	sh := sb.Dy()
-	for y := 0; y < h; y++ {
-		sy := sb.Min.Y + int(float64(y)*float64(sh)/float64(h))
-		for x := 0; x < w; x++ {
-			sx := sb.Min.X + int(float64(x)*float64(sw)/float64(w))
-			dst.Set(x, y, src.At(sx, sy))
-		}
-	}
-	return dst
-}
+// resampleFilter is used for every resize in the upload pipeline.
+var resampleFilter = imaging.CatmullRom
 
-func createThumbnailGIF(src *gif.GIF) *gif.GIF {
-	const size = 150
-	dstGif := &gif.GIF{LoopCount: src.LoopCount}
-	for i, frame := range src.Image {
-		rgba := image.NewRGBA(frame.Bounds())
-		draw.Draw(rgba, frame.Bounds(), frame, image.Point{}, draw.Over)
-
-		sw := rgba.Bounds().Dx()
-		sh := rgba.Bounds().Dy()
-		scale := float64(size) / float64(sw)
-		if sh > sw {
-			scale = float64(size) / float64(sh)
-		}
-		nw := int(float64(sw) * scale)
-		nh := int(float64(sh) * scale)
-
-		resized := resizeImage(rgba, nw, nh)
-		dstFrame := image.NewNRGBA(image.Rect(0, 0, size, size))
-		offX := (size - nw) / 2
-		offY := (size - nh) / 2
-		for y := 0; y < nh; y++ {
-			for x := 0; x < nw; x++ {
-				dstFrame.Set(offX+x, offY+y, resized.At(x, y))
-			}
-		}
-		pal := image.NewPaletted(dstFrame.Rect, frame.Palette)
-		draw.FloydSteinberg.Draw(pal, dstFrame.Rect, dstFrame, image.Point{})
-		dstGif.Image = append(dstGif.Image, pal)
-		if i < len(src.Delay) {
-			dstGif.Delay = append(dstGif.Delay, src.Delay[i])
-		} else {
-			dstGif.Delay = append(dstGif.Delay, 0)
-		}
-		if i < len(src.Disposal) {
-			dstGif.Disposal = append(dstGif.Disposal, src.Disposal[i])
-		}
-	}
-	return dstGif
+func resizeImage(src image.Image, w, h int) *image.RGBA {
+	return imaging.Resize(src, w, h, resampleFilter)
 }
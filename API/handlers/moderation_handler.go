@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/middleware"
+	"forum/models"
+	"forum/repository"
+	"forum/utils"
+)
+
+// ModerationHandler serves the moderator-only endpoints. Routes must be
+// wrapped in ModeratorMiddleware.RequireModerator.
+type ModerationHandler struct {
+	Images *ImageHandler
+}
+
+func NewModerationHandler(images *ImageHandler) *ModerationHandler {
+	return &ModerationHandler{Images: images}
+}
+
+// BannedImages lists the banned image hashes, newest first.
+func (h *ModerationHandler) BannedImages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	bans, err := h.Images.HashRepo.ListBans()
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load banned images", http.StatusInternalServerError)
+		return
+	}
+	utils.JSONResponse(w, bans, http.StatusOK)
+}
+
+// BanImage adds an image to the banned list, either one already on the
+// forum ({"image_id": ...}) or raw hashes ({"phash": ..., "dhash": ...}),
+// with an optional reason shown to rejected uploaders.
+func (h *ModerationHandler) BanImage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		ImageID string `json:"image_id"`
+		PHash   string `json:"phash"`
+		DHash   string `json:"dhash"`
+		Reason  string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	ban := models.BannedImage{
+		PHash:     strings.ToLower(strings.TrimSpace(req.PHash)),
+		DHash:     strings.ToLower(strings.TrimSpace(req.DHash)),
+		Reason:    strings.TrimSpace(req.Reason),
+		CreatedBy: user.ID,
+	}
+
+	if req.ImageID != "" {
+		img, err := h.Images.ImageRepo.GetByID(req.ImageID)
+		if err != nil {
+			utils.ErrorResponse(w, "Failed to load image", http.StatusInternalServerError)
+			return
+		}
+		if img == nil {
+			utils.ReasonErrorResponse(w, "Image not found", http.StatusNotFound, "image_not_found", nil)
+			return
+		}
+		if img.PHash == "" {
+			utils.ReasonErrorResponse(w, "Image was uploaded before perceptual hashing", http.StatusConflict, "image_not_hashed", nil)
+			return
+		}
+		ban.ImageID, ban.PHash, ban.DHash = img.ID, img.PHash, img.DHash
+	} else if !validHash(ban.PHash) || !validHash(ban.DHash) {
+		utils.ReasonErrorResponse(w, "image_id, or phash and dhash as 16 hex digits, required", http.StatusBadRequest, "invalid_hash", nil)
+		return
+	}
+
+	created, err := h.Images.HashRepo.Ban(ban)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to ban image", http.StatusInternalServerError)
+		return
+	}
+	utils.JSONResponse(w, created, http.StatusCreated)
+}
+
+// UnbanImage removes an entry from the banned list: {"id": ...}.
+func (h *ModerationHandler) UnbanImage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := h.Images.HashRepo.Unban(req.ID); err != nil {
+		if err == repository.ErrBanNotFound {
+			utils.ErrorResponse(w, "Banned image not found", http.StatusNotFound)
+			return
+		}
+		utils.ErrorResponse(w, "Failed to unban image", http.StatusInternalServerError)
+		return
+	}
+	utils.JSONResponse(w, map[string]string{"message": "Image unbanned"}, http.StatusOK)
+}
+
+// Moderation reports are paged with limit and offset parameters.
+const (
+	reportPageSize    = 50
+	maxReportPageSize = 200
+)
+
+// reportedImage is an image listed in a moderation report.
+type reportedImage struct {
+	models.PostImage
+	PostID    string    `json:"post_id"`
+	UserID    string    `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// newReportedImage describes img for a moderation report.
+func newReportedImage(img models.Image) reportedImage {
+	return reportedImage{PostImage: postImages([]models.Image{img})[0], PostID: img.PostID, UserID: img.UserID, CreatedAt: img.CreatedAt}
+}
+
+// reportPage reads the limit and offset of a report request, writing an
+// error response and returning false when they are invalid.
+func reportPage(w http.ResponseWriter, r *http.Request) (limit, offset int, ok bool) {
+	query := r.URL.Query()
+	limit = reportPageSize
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > maxReportPageSize {
+			utils.ReasonErrorResponse(w, fmt.Sprintf("limit must be between 1 and %d", maxReportPageSize), http.StatusBadRequest, "invalid_limit", nil)
+			return 0, 0, false
+		}
+		limit = n
+	}
+	if raw := query.Get("offset"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			utils.ReasonErrorResponse(w, "offset must be a non-negative number", http.StatusBadRequest, "invalid_offset", nil)
+			return 0, 0, false
+		}
+		offset = n
+	}
+	return limit, offset, true
+}
+
+// MissingAltText reports the processed images that have no alt text, newest
+// first: GET /forum/api/moderation/images/missing-alt?limit=&offset=.
+func (h *ModerationHandler) MissingAltText(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limit, offset, ok := reportPage(w, r)
+	if !ok {
+		return
+	}
+
+	imgs, total, err := h.Images.ImageRepo.ListMissingAltText(limit, offset)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load images", http.StatusInternalServerError)
+		return
+	}
+	report := make([]reportedImage, len(imgs))
+	for i, img := range imgs {
+		report[i] = newReportedImage(img)
+	}
+	utils.JSONResponse(w, map[string]interface{}{"total": total, "limit": limit, "offset": offset, "images": report}, http.StatusOK)
+}
+
+// validHash reports whether s is a 64-bit hash written as 16 hex digits.
+func validHash(s string) bool {
+	if len(s) != 16 {
+		return false
+	}
+	_, err := strconv.ParseUint(s, 16, 64)
+	return err == nil
+}
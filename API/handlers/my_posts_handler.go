@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"forum/middleware"
+	"forum/models"
 	"forum/repository"
 	"forum/utils"
 )
@@ -14,10 +15,12 @@ type MyPostsHandler struct {
 	CommentRepo  *repository.CommentRepository
 	ReactionRepo *repository.ReactionRepository
 	ImageRepo    *repository.ImageRepository
+	feed         *feedAssembler
 }
 
 func NewMyPostsHandler(postRepo *repository.PostRepository, commentRepo *repository.CommentRepository, reactionRepo *repository.ReactionRepository, imageRepo *repository.ImageRepository) *MyPostsHandler {
-	return &MyPostsHandler{PostRepo: postRepo, CommentRepo: commentRepo, ReactionRepo: reactionRepo, ImageRepo: imageRepo}
+	return &MyPostsHandler{PostRepo: postRepo, CommentRepo: commentRepo, ReactionRepo: reactionRepo, ImageRepo: imageRepo,
+		feed: newFeedAssembler(postRepo, commentRepo, reactionRepo, imageRepo)}
 }
 
 type CategoryInfo struct {
@@ -26,17 +29,31 @@ type CategoryInfo struct {
 }
 
 type MyPostResponse struct {
-	ID           string             `json:"id"`
-	UserID       string             `json:"user_id"`
-	Username     string             `json:"username"`
-	Categories   []CategoryInfo     `json:"categories"`
-	Title        string             `json:"title"`
-	Content      string             `json:"content"`
-	ImageURL     string             `json:"image_url,omitempty"`
-	ThumbnailURL string             `json:"thumbnail_url,omitempty"`
-	CreatedAt    time.Time          `json:"created_at"`
-	Comments     []CommentResponse  `json:"comments,omitempty"`
-	Reactions    []ReactionResponse `json:"reactions,omitempty"`
+	ID            string                `json:"id"`
+	UserID        string                `json:"user_id"`
+	Username      string                `json:"username"`
+	AvatarURL     string                `json:"avatar_url"`
+	Categories    []CategoryInfo        `json:"categories"`
+	Title         string                `json:"title"`
+	Content       string                `json:"content"`
+	ImageURL      string                `json:"image_url,omitempty"`
+	ImageAltText  string                `json:"image_alt_text,omitempty"`
+	ThumbnailURL  string                `json:"thumbnail_url,omitempty"`
+	SrcSet        string                `json:"srcset,omitempty"`
+	BlurHash      string                `json:"blurhash,omitempty"`
+	DominantColor string                `json:"dominant_color,omitempty"`
+	Renditions    []models.RenditionURL `json:"renditions,omitempty"`
+	Images        []models.PostImage    `json:"images"`
+	CreatedAt     time.Time             `json:"created_at"`
+	Comments      []CommentResponse     `json:"comments,omitempty"`
+	Reactions     []ReactionResponse    `json:"reactions,omitempty"`
+}
+
+// PostListResponse is a page of the posts of a user listing, with the
+// cursor to pass back for the next one.
+type PostListResponse struct {
+	Posts      []MyPostResponse `json:"posts"`
+	NextCursor string           `json:"next_cursor,omitempty"`
 }
 
 func (h *MyPostsHandler) GetMyPosts(w http.ResponseWriter, r *http.Request) {
@@ -51,94 +68,26 @@ func (h *MyPostsHandler) GetMyPosts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	posts, err := h.PostRepo.GetPostsByUser(user.ID)
+	page, ok := postPage(w, r)
+	if !ok {
+		return
+	}
+
+	posts, next, err := h.PostRepo.GetPostsByUser(user.ID, page)
 	if err != nil {
 		utils.ErrorResponse(w, "Failed to load posts", http.StatusInternalServerError)
 		return
 	}
 
-	var response []MyPostResponse
-	for _, post := range posts {
-		categories, err := h.PostRepo.GetCategoriesByPostID(post.ID)
-		if err != nil {
-			utils.ErrorResponse(w, "Failed to load categories", http.StatusInternalServerError)
-			return
-		}
-		var catInfo []CategoryInfo
-		for _, c := range categories {
-			catInfo = append(catInfo, CategoryInfo{ID: c.ID, Name: c.Name})
-		}
-
-		comments, err := h.CommentRepo.GetCommentsByPostWithUser(post.ID)
-		if err != nil {
-			utils.ErrorResponse(w, "Failed to load comments", http.StatusInternalServerError)
-			return
-		}
-		var commentResp []CommentResponse
-		for _, c := range comments {
-			cr := CommentResponse{
-				ID:        c.ID,
-				UserID:    c.UserID,
-				Username:  c.Username,
-				Content:   c.Content,
-				CreatedAt: c.CreatedAt,
-				Reactions: []ReactionResponse{},
-			}
-			reactions, err := h.ReactionRepo.GetReactionsByCommentWithUser(c.ID)
-			if err != nil {
-				utils.ErrorResponse(w, "Failed to load reactions", http.StatusInternalServerError)
-				return
-			}
-			for _, r := range reactions {
-				cr.Reactions = append(cr.Reactions, ReactionResponse{
-					UserID:       r.UserID,
-					Username:     r.Username,
-					ReactionType: r.ReactionType,
-					CreatedAt:    r.CreatedAt,
-				})
-			}
-			commentResp = append(commentResp, cr)
-		}
-
-		reactions, err := h.ReactionRepo.GetReactionsByPostWithUser(post.ID)
-		if err != nil {
-			utils.ErrorResponse(w, "Failed to load reactions", http.StatusInternalServerError)
-			return
-		}
-		var reactResp []ReactionResponse
-		for _, r := range reactions {
-			reactResp = append(reactResp, ReactionResponse{
-				UserID:       r.UserID,
-				Username:     r.Username,
-				ReactionType: r.ReactionType,
-				CreatedAt:    r.CreatedAt,
-			})
-		}
-
-		imgs, err := h.ImageRepo.GetByPostID(post.ID)
-		if err != nil {
-			utils.ErrorResponse(w, "Failed to load images", http.StatusInternalServerError)
-			return
-		}
-		var imgURL, thumbURL string
-		if len(imgs) > 0 {
-			imgURL = apiStaticBase + imgs[0].FilePath
-			thumbURL = apiStaticBase + imgs[0].ThumbnailPath
-		}
+	batch, err := h.feed.load(posts)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load posts", http.StatusInternalServerError)
+		return
+	}
 
-		response = append(response, MyPostResponse{
-			ID:           post.ID,
-			UserID:       post.UserID,
-			Username:     post.Username,
-			Categories:   catInfo,
-			Title:        post.Title,
-			Content:      post.Content,
-			ImageURL:     imgURL,
-			ThumbnailURL: thumbURL,
-			CreatedAt:    post.CreatedAt,
-			Comments:     commentResp,
-			Reactions:    reactResp,
-		})
+	response := PostListResponse{Posts: []MyPostResponse{}, NextCursor: encodeCursor(next)}
+	for _, post := range posts {
+		response.Posts = append(response.Posts, batch.myPostResponse(post))
 	}
 
 	utils.JSONResponse(w, response, http.StatusOK)
@@ -33,6 +33,9 @@ func (h *NotificationHandler) GetNotifications(w http.ResponseWriter, r *http.Re
 		utils.ErrorResponse(w, "Failed to load notifications", http.StatusInternalServerError)
 		return
 	}
+	for i := range notifs {
+		notifs[i].ActorAvatarURL = avatarURL(notifs[i].ActorID)
+	}
 	utils.JSONResponse(w, notifs, http.StatusOK)
 }
 
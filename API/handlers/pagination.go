@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/models"
+	"forum/utils"
+)
+
+const (
+	postPageSize    = 20
+	maxPostPageSize = 100
+)
+
+// postPage reads the limit and cursor query parameters of a post listing,
+// writing an error response when either is invalid. Without a cursor the
+// listing starts at the newest post.
+func postPage(w http.ResponseWriter, r *http.Request) (models.PostPage, bool) {
+	query := r.URL.Query()
+	page := models.PostPage{Limit: postPageSize}
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > maxPostPageSize {
+			utils.ReasonErrorResponse(w, fmt.Sprintf("limit must be between 1 and %d", maxPostPageSize), http.StatusBadRequest, "invalid_limit", nil)
+			return page, false
+		}
+		page.Limit = n
+	}
+	if raw := query.Get("cursor"); raw != "" {
+		after, err := decodeCursor(raw)
+		if err != nil {
+			utils.ReasonErrorResponse(w, "Invalid cursor", http.StatusBadRequest, "invalid_cursor", nil)
+			return page, false
+		}
+		page.After = after
+	}
+	return page, true
+}
+
+// encodeCursor turns a cursor into the opaque next_cursor string of a
+// response, empty when there is no next page.
+func encodeCursor(c *models.PostCursor) string {
+	if c == nil {
+		return ""
+	}
+	raw := c.CreatedAt.Format(time.RFC3339Nano) + "|" + c.PostID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor reverses encodeCursor. The time keeps its UTC offset, so it
+// is written back to the database exactly as the post's was.
+func decodeCursor(s string) (*models.PostCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	createdAt, postID, found := strings.Cut(string(raw), "|")
+	if !found || postID == "" {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return nil, err
+	}
+	return &models.PostCursor{CreatedAt: t, PostID: postID}, nil
+}
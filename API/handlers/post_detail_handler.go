@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"forum/middleware"
+	"forum/models"
+	"forum/repository"
+	"forum/repository/user"
+	"forum/utils"
+)
+
+// postDetailPrefix is followed by the post ID in the detail URL.
+const postDetailPrefix = "/forum/api/posts/"
+
+// PostDetailHandler serves a single post with everything shown on its page.
+type PostDetailHandler struct {
+	PostRepo *repository.PostRepository
+	UserRepo *user.UserRepository
+	feed     *feedAssembler
+}
+
+// NewPostDetailHandler creates a new PostDetailHandler
+func NewPostDetailHandler(postRepo *repository.PostRepository, commentRepo *repository.CommentRepository, reactionRepo *repository.ReactionRepository, imageRepo *repository.ImageRepository, userRepo *user.UserRepository) *PostDetailHandler {
+	return &PostDetailHandler{
+		PostRepo: postRepo,
+		UserRepo: userRepo,
+		feed:     newFeedAssembler(postRepo, commentRepo, reactionRepo, imageRepo),
+	}
+}
+
+// ReactionSummary counts the likes and dislikes of a post or comment.
+type ReactionSummary struct {
+	Likes    int `json:"likes"`
+	Dislikes int `json:"dislikes"`
+}
+
+// CommentThread is a comment with the replies to it, oldest first.
+type CommentThread struct {
+	ID         string           `json:"id"`
+	ParentID   string           `json:"parent_id,omitempty"`
+	UserID     string           `json:"user_id"`
+	Username   string           `json:"username"`
+	AvatarURL  string           `json:"avatar_url"`
+	Content    string           `json:"content"`
+	CreatedAt  time.Time        `json:"created_at"`
+	Reactions  ReactionSummary  `json:"reactions"`
+	MyReaction int              `json:"my_reaction"`
+	Replies    []*CommentThread `json:"replies"`
+}
+
+// PostDetailResponse is a post with its comments threaded. MyReaction is
+// the viewer's reaction type, 0 when they have not reacted or are a guest.
+type PostDetailResponse struct {
+	ID           string             `json:"id"`
+	UserID       string             `json:"user_id"`
+	Username     string             `json:"username"`
+	AvatarURL    string             `json:"avatar_url"`
+	Categories   []CategoryInfo     `json:"categories"`
+	Title        string             `json:"title"`
+	Content      string             `json:"content"`
+	CreatedAt    time.Time          `json:"created_at"`
+	UpdatedAt    *time.Time         `json:"updated_at,omitempty"`
+	Images       []models.PostImage `json:"images"`
+	Comments     []*CommentThread   `json:"comments"`
+	CommentCount int                `json:"comment_count"`
+	Reactions    ReactionSummary    `json:"reactions"`
+	MyReaction   int                `json:"my_reaction"`
+}
+
+// GetPost returns one post: GET /forum/api/posts/{id}. Guests may read it;
+// for a logged-in viewer my_reaction tells how they reacted.
+func (h *PostDetailHandler) GetPost(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, postDetailPrefix)
+	if id == "" || strings.Contains(id, "/") {
+		utils.ErrorResponse(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	post, err := h.PostRepo.GetByID(id)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load post", http.StatusInternalServerError)
+		return
+	}
+	if post == nil {
+		utils.ReasonErrorResponse(w, "Post not found", http.StatusNotFound, "post_not_found", nil)
+		return
+	}
+	author, err := h.UserRepo.GetByID(post.UserID)
+	if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+		utils.ErrorResponse(w, "Failed to load post author", http.StatusInternalServerError)
+		return
+	}
+
+	withUser := models.PostWithUser{ID: post.ID, UserID: post.UserID, Title: post.Title, Content: post.Content, CreatedAt: post.CreatedAt}
+	if author != nil {
+		withUser.Username = author.Username
+	}
+	batch, err := h.feed.load([]models.PostWithUser{withUser})
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load post", http.StatusInternalServerError)
+		return
+	}
+
+	var viewerID string
+	if viewer := middleware.GetCurrentUser(r); viewer != nil {
+		viewerID = viewer.ID
+	}
+	resp := PostDetailResponse{
+		ID:         post.ID,
+		UserID:     post.UserID,
+		Username:   withUser.Username,
+		AvatarURL:  avatarURL(post.UserID),
+		Categories: []CategoryInfo{},
+		Title:      post.Title,
+		Content:    post.Content,
+		CreatedAt:  post.CreatedAt,
+		UpdatedAt:  post.UpdatedAt,
+		Images:     postImages(batch.images[post.ID]),
+	}
+	for _, c := range batch.categories[post.ID] {
+		resp.Categories = append(resp.Categories, CategoryInfo{ID: c.ID, Name: c.Name})
+	}
+	resp.Reactions, resp.MyReaction = summarizeReactions(batch.postReactions[post.ID], viewerID)
+	resp.Comments, resp.CommentCount = batch.commentThreads(post.ID, viewerID)
+	utils.JSONResponse(w, resp, http.StatusOK)
+}
+
+// commentThreads nests the comments of a post under the ones they reply to
+// and returns the top-level comments with the total count. A reply whose
+// parent is gone is shown at the top level.
+func (b *feedBatch) commentThreads(postID, viewerID string) ([]*CommentThread, int) {
+	comments := b.comments[postID]
+	byID := make(map[string]*CommentThread, len(comments))
+	for _, c := range comments {
+		t := &CommentThread{
+			ID:        c.ID,
+			ParentID:  c.ParentID,
+			UserID:    c.UserID,
+			Username:  c.Username,
+			AvatarURL: avatarURL(c.UserID),
+			Content:   c.Content,
+			CreatedAt: c.CreatedAt,
+			Replies:   []*CommentThread{},
+		}
+		t.Reactions, t.MyReaction = summarizeReactions(b.commentReactions[c.ID], viewerID)
+		byID[c.ID] = t
+	}
+
+	threads := []*CommentThread{}
+	for _, c := range comments {
+		t := byID[c.ID]
+		if parent, ok := byID[c.ParentID]; ok && c.ParentID != c.ID {
+			parent.Replies = append(parent.Replies, t)
+			continue
+		}
+		threads = append(threads, t)
+	}
+	return threads, len(comments)
+}
+
+// summarizeReactions counts reactions by type and finds the one viewerID
+// made, if any.
+func summarizeReactions(reactions []models.ReactionWithUser, viewerID string) (ReactionSummary, int) {
+	var sum ReactionSummary
+	mine := 0
+	for _, r := range reactions {
+		switch r.ReactionType {
+		case 1:
+			sum.Likes++
+		case 2:
+			sum.Dislikes++
+		}
+		if viewerID != "" && r.UserID == viewerID {
+			mine = r.ReactionType
+		}
+	}
+	return sum, mine
+}
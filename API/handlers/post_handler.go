@@ -8,17 +8,20 @@ import (
 	"forum/middleware"
 	"forum/models"
 	"forum/repository"
+	"forum/storage"
 	"forum/utils"
 )
 
 // PostHandler handles post related endpoints
 type PostHandler struct {
-	PostRepo *repository.PostRepository
+	PostRepo  *repository.PostRepository
+	ImageRepo *repository.ImageRepository
+	Store     storage.Storage
 }
 
 // NewPostHandler creates a new PostHandler
-func NewPostHandler(repo *repository.PostRepository) *PostHandler {
-	return &PostHandler{PostRepo: repo}
+func NewPostHandler(repo *repository.PostRepository, imageRepo *repository.ImageRepository, store storage.Storage) *PostHandler {
+	return &PostHandler{PostRepo: repo, ImageRepo: imageRepo, Store: store}
 }
 
 // UpdatePost updates a post owned by the authenticated user
@@ -87,6 +90,9 @@ func (h *PostHandler) DeletePost(w http.ResponseWriter, r *http.Request) {
 		utils.ErrorResponse(w, "Failed to delete post", http.StatusInternalServerError)
 		return
 	}
+	// The post's images were removed by the cascade; drop blobs nothing
+	// references any more.
+	releaseBlobs(h.ImageRepo, h.Store)
 	utils.JSONResponse(w, map[string]string{"status": "deleted"}, http.StatusOK)
 }
 
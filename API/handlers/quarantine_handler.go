@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"forum/middleware"
+	"forum/models"
+	"forum/storage"
+	"forum/utils"
+)
+
+// quarantinePreviewPath serves the files of quarantined images to
+// moderators, since /static/ hides them.
+const quarantinePreviewPath = "/forum/api/moderation/quarantine/file"
+
+// quarantinedImage is an image waiting for review with the verdict that
+// put it in quarantine.
+type quarantinedImage struct {
+	ID           string              `json:"id"`
+	PostID       string              `json:"post_id"`
+	UserID       string              `json:"user_id"`
+	AltText      string              `json:"alt_text"`
+	Caption      string              `json:"caption"`
+	PreviewURL   string              `json:"preview_url"`
+	ThumbnailURL string              `json:"thumbnail_url"`
+	Verdict      *models.ScanVerdict `json:"verdict,omitempty"`
+	CreatedAt    time.Time           `json:"created_at"`
+}
+
+// Quarantine lists the images waiting for review, longest waiting first:
+// GET /forum/api/moderation/quarantine?limit=&offset=.
+func (h *ModerationHandler) Quarantine(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limit, offset, ok := reportPage(w, r)
+	if !ok {
+		return
+	}
+
+	imgs, total, err := h.Images.ImageRepo.ListQuarantined(limit, offset)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load images", http.StatusInternalServerError)
+		return
+	}
+	list := make([]quarantinedImage, len(imgs))
+	for i, img := range imgs {
+		verdict, err := h.Images.ScanRepo.LatestForImage(img.ID)
+		if err != nil {
+			utils.ErrorResponse(w, "Failed to load scan verdicts", http.StatusInternalServerError)
+			return
+		}
+		id := url.QueryEscape(img.ID)
+		list[i] = quarantinedImage{
+			ID:           img.ID,
+			PostID:       img.PostID,
+			UserID:       img.UserID,
+			AltText:      img.AltText,
+			Caption:      img.Caption,
+			PreviewURL:   quarantinePreviewPath + "?id=" + id,
+			ThumbnailURL: quarantinePreviewPath + "?id=" + id + "&size=thumbnail",
+			Verdict:      verdict,
+			CreatedAt:    img.CreatedAt,
+		}
+	}
+	utils.JSONResponse(w, map[string]interface{}{"total": total, "limit": limit, "offset": offset, "images": list}, http.StatusOK)
+}
+
+// QuarantineFile serves the original, or with size=thumbnail the thumbnail,
+// of a quarantined image: GET /forum/api/moderation/quarantine/file?id=.
+func (h *ModerationHandler) QuarantineFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	img := h.loadQuarantined(w, r.URL.Query().Get("id"))
+	if img == nil {
+		return
+	}
+	key := img.FilePath
+	if r.URL.Query().Get("size") == "thumbnail" {
+		key = img.ThumbnailPath
+	}
+
+	body, info, err := h.Images.Store.Get(key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			utils.ErrorResponse(w, "Image file not found", http.StatusNotFound)
+			return
+		}
+		utils.ErrorResponse(w, "Failed to read image", http.StatusInternalServerError)
+		return
+	}
+	defer body.Close()
+	w.Header().Set("Content-Type", info.ContentType)
+	w.Header().Set("Cache-Control", "private, no-store")
+	if r.Method == http.MethodHead {
+		return
+	}
+	io.Copy(w, body)
+}
+
+// ApproveImage publishes a quarantined image, and every other image with
+// the same content: {"image_id": ...}.
+func (h *ModerationHandler) ApproveImage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		ImageID string `json:"image_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	img := h.loadQuarantined(w, req.ImageID)
+	if img == nil {
+		return
+	}
+
+	n, err := h.Images.ScanRepo.Approve(img.ContentHash, user.ID)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to approve image", http.StatusInternalServerError)
+		return
+	}
+	utils.JSONResponse(w, map[string]interface{}{"message": "Image approved", "published": n}, http.StatusOK)
+}
+
+// RejectImage deletes a quarantined image, and every other quarantined image
+// with the same content: {"image_id": ..., "ban": true, "reason": ...}. With
+// ban the picture is also added to the banned images list.
+func (h *ModerationHandler) RejectImage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user := middleware.GetCurrentUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		ImageID string `json:"image_id"`
+		Ban     bool   `json:"ban"`
+		Reason  string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.ErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	img := h.loadQuarantined(w, req.ImageID)
+	if img == nil {
+		return
+	}
+
+	if req.Ban && img.PHash != "" {
+		ban := models.BannedImage{PHash: img.PHash, DHash: img.DHash, Reason: strings.TrimSpace(req.Reason), ImageID: img.ID, CreatedBy: user.ID}
+		if _, err := h.Images.HashRepo.Ban(ban); err != nil {
+			utils.ErrorResponse(w, "Failed to ban image", http.StatusInternalServerError)
+			return
+		}
+	}
+	ids, err := h.Images.ScanRepo.Reject(img.ContentHash, user.ID)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to reject image", http.StatusInternalServerError)
+		return
+	}
+	for _, id := range ids {
+		if err := h.Images.ImageRepo.Delete(id); err != nil {
+			log.Printf("Failed to delete rejected image %s: %v", id, err)
+		}
+	}
+	releaseBlobs(h.Images.ImageRepo, h.Images.Store)
+	utils.JSONResponse(w, map[string]interface{}{"message": "Image rejected", "deleted": len(ids), "banned": req.Ban && img.PHash != ""}, http.StatusOK)
+}
+
+// loadQuarantined returns a quarantined image, writing an error response
+// when there is none with id.
+func (h *ModerationHandler) loadQuarantined(w http.ResponseWriter, id string) *models.Image {
+	if id == "" {
+		utils.ErrorResponse(w, "Image ID required", http.StatusBadRequest)
+		return nil
+	}
+	img, err := h.Images.ImageRepo.GetByID(id)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load image", http.StatusInternalServerError)
+		return nil
+	}
+	if img == nil || img.Status != models.ImageQuarantined {
+		utils.ReasonErrorResponse(w, "Quarantined image not found", http.StatusNotFound, "image_not_quarantined", nil)
+		return nil
+	}
+	return img
+}
@@ -125,6 +125,9 @@ func (h *ReactionHandler) CreateReact(w http.ResponseWriter, r *http.Request) {
 		utils.ErrorResponse(w, "Failed to load reactions", http.StatusInternalServerError)
 		return
 	}
+	for i := range reactions {
+		reactions[i].AvatarURL = avatarURL(reactions[i].UserID)
+	}
 
 	utils.JSONResponse(w, reactions, http.StatusOK)
 }
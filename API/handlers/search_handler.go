@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"forum/models"
+	"forum/repository"
+	"forum/utils"
+)
+
+// maxSearchLength caps the search text, in characters.
+const maxSearchLength = 200
+
+// SearchHandler serves full-text search over posts and comments.
+type SearchHandler struct {
+	SearchRepo *repository.SearchRepository
+}
+
+// NewSearchHandler creates a new SearchHandler
+func NewSearchHandler(searchRepo *repository.SearchRepository) *SearchHandler {
+	return &SearchHandler{SearchRepo: searchRepo}
+}
+
+// SearchResultResponse is a post or comment found by a search. TitleHTML and
+// SnippetHTML are escaped text with the matched terms in <mark> elements.
+type SearchResultResponse struct {
+	Type        string    `json:"type"`
+	PostID      string    `json:"post_id"`
+	CommentID   string    `json:"comment_id,omitempty"`
+	UserID      string    `json:"user_id"`
+	Username    string    `json:"username"`
+	AvatarURL   string    `json:"avatar_url"`
+	TitleHTML   string    `json:"title_html"`
+	SnippetHTML string    `json:"snippet_html"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Search finds posts and comments, best match first:
+// GET /forum/api/search?q=&type=&category=&author=&from=&to=&limit=&offset=.
+func (h *SearchHandler) Search(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q, ok := searchQuery(w, r)
+	if !ok {
+		return
+	}
+
+	found, total, err := h.SearchRepo.Search(q)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to search", http.StatusInternalServerError)
+		return
+	}
+	results := make([]SearchResultResponse, len(found))
+	for i, res := range found {
+		results[i] = SearchResultResponse{
+			Type:        res.Kind,
+			PostID:      res.PostID,
+			CommentID:   res.CommentID,
+			UserID:      res.UserID,
+			Username:    res.Username,
+			AvatarURL:   avatarURL(res.UserID),
+			TitleHTML:   markMatches(res.Title),
+			SnippetHTML: markMatches(res.Snippet),
+			CreatedAt:   res.CreatedAt,
+		}
+	}
+	utils.JSONResponse(w, map[string]interface{}{"total": total, "limit": q.Limit, "offset": q.Offset, "results": results}, http.StatusOK)
+}
+
+// Unavailable answers search requests when SQLite has no FTS5 support.
+func (h *SearchHandler) Unavailable(w http.ResponseWriter, r *http.Request) {
+	utils.ReasonErrorResponse(w, "Search is not available on this server", http.StatusServiceUnavailable, "search_disabled", nil)
+}
+
+// searchQuery reads the search parameters, writing an error response when
+// one is invalid.
+func searchQuery(w http.ResponseWriter, r *http.Request) (models.SearchQuery, bool) {
+	query := r.URL.Query()
+	q := models.SearchQuery{Terms: strings.TrimSpace(query.Get("q")), Author: strings.TrimSpace(query.Get("author")), Limit: postPageSize}
+	if q.Terms == "" {
+		utils.ReasonErrorResponse(w, "Search text required", http.StatusBadRequest, "missing_query", nil)
+		return q, false
+	}
+	if utf8.RuneCountInString(q.Terms) > maxSearchLength {
+		utils.ReasonErrorResponse(w, fmt.Sprintf("Search text must be at most %d characters", maxSearchLength), http.StatusBadRequest, "query_too_long", nil)
+		return q, false
+	}
+
+	switch query.Get("type") {
+	case "", "all":
+	case "posts":
+		q.Kind = models.SearchPosts
+	case "comments":
+		q.Kind = models.SearchComments
+	default:
+		utils.ReasonErrorResponse(w, "type must be posts, comments or all", http.StatusBadRequest, "invalid_type", nil)
+		return q, false
+	}
+
+	if raw := query.Get("category"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			utils.ReasonErrorResponse(w, "Invalid category ID", http.StatusBadRequest, "invalid_category", nil)
+			return q, false
+		}
+		q.CategoryID = n
+	}
+
+	var err error
+	if q.From, err = searchDate(query.Get("from"), false); err != nil {
+		utils.ReasonErrorResponse(w, "from must be a date (YYYY-MM-DD) or an RFC 3339 time", http.StatusBadRequest, "invalid_date", nil)
+		return q, false
+	}
+	if q.Until, err = searchDate(query.Get("to"), true); err != nil {
+		utils.ReasonErrorResponse(w, "to must be a date (YYYY-MM-DD) or an RFC 3339 time", http.StatusBadRequest, "invalid_date", nil)
+		return q, false
+	}
+	if !q.From.IsZero() && !q.Until.IsZero() && !q.From.Before(q.Until) {
+		utils.ReasonErrorResponse(w, "from must be before to", http.StatusBadRequest, "invalid_date", nil)
+		return q, false
+	}
+
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > maxPostPageSize {
+			utils.ReasonErrorResponse(w, fmt.Sprintf("limit must be between 1 and %d", maxPostPageSize), http.StatusBadRequest, "invalid_limit", nil)
+			return q, false
+		}
+		q.Limit = n
+	}
+	if raw := query.Get("offset"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			utils.ReasonErrorResponse(w, "offset must be a non-negative number", http.StatusBadRequest, "invalid_offset", nil)
+			return q, false
+		}
+		q.Offset = n
+	}
+	return q, true
+}
+
+// searchDate parses a from or to bound. A bare date covers the whole day, so
+// as the end of a range it stands for the start of the next day.
+func searchDate(raw string, end bool) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t.UTC(), nil
+	}
+	t, err := time.Parse(time.DateOnly, raw)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// markMatches escapes text from a search result and turns the markers around
+// matched terms into <mark> elements.
+func markMatches(s string) string {
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, models.SearchMarkStart, "<mark>")
+	return strings.ReplaceAll(s, models.SearchMarkEnd, "</mark>")
+}
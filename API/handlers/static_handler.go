@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/repository"
+	"forum/storage"
+	"forum/urlsign"
+)
+
+// StaticHandler serves uploaded files from the configured storage backend.
+// It replaces the plain http.FileServer so the API can run with any driver,
+// only serves URLs signed by URLs while signing is enabled and hides the
+// files of quarantined images.
+type StaticHandler struct {
+	Store storage.Storage
+	URLs  *urlsign.Signer
+	Scans *repository.ImageScanRepository
+}
+
+func NewStaticHandler(store storage.Storage, urls *urlsign.Signer, scans *repository.ImageScanRepository) *StaticHandler {
+	return &StaticHandler{Store: store, URLs: urls, Scans: scans}
+}
+
+func (h *StaticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Only processed images are public; raw uploads waiting for their job
+	// live outside uploadBaseDir.
+	key, err := storage.CleanKey(strings.TrimPrefix(r.URL.Path, "/"))
+	if err != nil || strings.HasSuffix(r.URL.Path, "/") || !strings.HasPrefix(key, uploadBaseDir+"/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	var expires time.Time
+	if h.URLs.Enabled() {
+		expires, err = h.URLs.Verify(key, r.URL.Query())
+		switch {
+		case errors.Is(err, urlsign.ErrExpired):
+			http.Error(w, "Link expired", http.StatusGone)
+			return
+		case err != nil:
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	// Files live under uploadBaseDir/<xx>/<content hash>/, see blobDir.
+	if parts := strings.Split(key, "/"); len(parts) > 3 {
+		quarantined, err := h.Scans.IsQuarantined(parts[2])
+		if err != nil {
+			http.Error(w, "Failed to read file", http.StatusInternalServerError)
+			return
+		}
+		if quarantined {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	body, info, err := h.open(w, r, key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidKey) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		return
+	}
+	defer body.Close()
+
+	// Uploads are stored under unique keys and never rewritten. Signed
+	// responses may only be cached by the client, and not past the expiry.
+	if expires.IsZero() {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		maxAge := int(time.Until(expires) / time.Second)
+		w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(maxAge)+", immutable")
+	}
+	w.Header().Set("Content-Type", info.ContentType)
+	if info.ETag != "" {
+		w.Header().Set("ETag", info.ETag)
+	}
+
+	// Local files can seek, which gives us range and conditional requests for free.
+	if rs, ok := body.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, key, info.ModTime, rs)
+		return
+	}
+
+	if !info.ModTime.IsZero() {
+		w.Header().Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	}
+	if info.Size > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	io.Copy(w, body)
+}
+
+// open returns the best representation of key for the request. For JPEG and
+// PNG keys the AVIF or WebP variant stored next to the file is preferred
+// when the Accept header lists its type; the response then varies on Accept.
+func (h *StaticHandler) open(w http.ResponseWriter, r *http.Request, key string) (io.ReadCloser, *storage.ObjectInfo, error) {
+	switch strings.ToLower(path.Ext(key)) {
+	case ".jpg", ".jpeg", ".png":
+	default:
+		return h.Store.Get(key)
+	}
+
+	w.Header().Add("Vary", "Accept")
+	accept := r.Header.Get("Accept")
+	for _, f := range variantFormats {
+		if !acceptsType(accept, f.ContentType) {
+			continue
+		}
+		body, info, err := h.Store.Get(key + f.Ext)
+		if err == nil {
+			return body, info, nil
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			return nil, nil, err
+		}
+	}
+	return h.Store.Get(key)
+}
+
+// acceptsType reports whether an Accept header explicitly lists mediaType
+// with a non-zero quality. Wildcards do not count: browsers send "*/*" even
+// when they cannot decode newer formats.
+func acceptsType(accept, mediaType string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(fields[0]), mediaType) {
+			continue
+		}
+		for _, param := range fields[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.TrimSpace(name) == "q" {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && q <= 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"forum/config"
+	"forum/diskcache"
+	"forum/middleware"
+	"forum/models"
+	"forum/urlsign"
+	"forum/utils"
+)
+
+// TransformHandler serves resized and cropped derivatives of processed
+// images under /img/{id}, rendering each one once and caching it on disk.
+type TransformHandler struct {
+	Images *ImageHandler
+	URLs   *urlsign.Signer
+	Cache  *diskcache.Cache
+	Config config.TransformConfig
+
+	mu       sync.Mutex
+	inflight map[string]*transformCall
+}
+
+// transformCall lets concurrent requests for the same derivative wait for a
+// single render.
+type transformCall struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+func NewTransformHandler(images *ImageHandler, urls *urlsign.Signer, cache *diskcache.Cache, cfg config.TransformConfig) *TransformHandler {
+	return &TransformHandler{Images: images, URLs: urls, Cache: cache, Config: cfg, inflight: map[string]*transformCall{}}
+}
+
+// signedKey is the payload signed for a derivative of image id.
+func signedKey(id string, o transformOptions) string {
+	return "img/" + id + "?" + o.canonical()
+}
+
+// loadImage returns a processed image that the forum shows, or nil when
+// there is none with id. As on /static/, an image is hidden while any copy
+// of its file waits for a moderator.
+func (h *TransformHandler) loadImage(id string) (*models.Image, error) {
+	if id == "" || strings.Contains(id, "/") {
+		return nil, nil
+	}
+	img, err := h.Images.ImageRepo.GetByID(id)
+	if err != nil || img == nil || img.Status != models.ImageReady || img.FilePath == "" {
+		return nil, err
+	}
+	post, err := h.Images.PostRepo.GetByID(img.PostID)
+	if err != nil || post == nil {
+		return nil, err
+	}
+	if img.ContentHash != "" {
+		quarantined, err := h.Images.ScanRepo.IsQuarantined(img.ContentHash)
+		if err != nil || quarantined {
+			return nil, err
+		}
+	}
+	return img, nil
+}
+
+// urlParams are the only query parameters the URL endpoint accepts.
+var urlParams = map[string]bool{"id": true, "w": true, "h": true, "fit": true, "fx": true, "fy": true, "fmt": true, "q": true}
+
+// ServeHTTP handles GET /img/{id}?w=&h=&fit=&fx=&fy=&fmt=&q=, mounted with
+// the /img/ prefix stripped. While URL signing is enabled the request must
+// carry a signature from URL.
+func (h *TransformHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	opts, err := parseTransform(r.URL.Query(), h.Config)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id := r.URL.Path
+	img, err := h.loadImage(id)
+	if err != nil {
+		http.Error(w, "Failed to load image", http.StatusInternalServerError)
+		return
+	}
+	if img == nil {
+		http.NotFound(w, r)
+		return
+	}
+	opts = opts.resolve(img.FilePath)
+
+	var expires time.Time
+	if h.URLs.Enabled() {
+		expires, err = h.URLs.Verify(signedKey(id, opts), r.URL.Query())
+		switch {
+		case errors.Is(err, urlsign.ErrExpired):
+			http.Error(w, "Link expired", http.StatusGone)
+			return
+		case err != nil:
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	// Derivatives depend only on the stored original, which images sharing a
+	// blob have in common.
+	cacheKey := img.FilePath + "?" + opts.canonical()
+	data, err := h.derivative(cacheKey, img.FilePath, opts)
+	if err != nil {
+		log.Printf("Failed to transform image %s: %v", id, err)
+		http.Error(w, "Failed to transform image", http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha256.Sum256([]byte(cacheKey))
+	w.Header().Set("Content-Type", opts.contentType())
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	if expires.IsZero() {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		maxAge := int(time.Until(expires) / time.Second)
+		w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(maxAge)+", immutable")
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+// derivative returns the cached derivative or renders it, sharing the work
+// with concurrent requests for the same key.
+func (h *TransformHandler) derivative(cacheKey, sourceKey string, opts transformOptions) ([]byte, error) {
+	if data, ok := h.Cache.Get(cacheKey); ok {
+		return data, nil
+	}
+
+	h.mu.Lock()
+	if call, ok := h.inflight[cacheKey]; ok {
+		h.mu.Unlock()
+		<-call.done
+		return call.data, call.err
+	}
+	call := &transformCall{done: make(chan struct{})}
+	h.inflight[cacheKey] = call
+	h.mu.Unlock()
+
+	call.data, call.err = h.render(sourceKey, opts)
+	if call.err == nil {
+		if err := h.Cache.Put(cacheKey, call.data); err != nil {
+			log.Printf("Failed to cache transformed image: %v", err)
+		}
+	}
+
+	h.mu.Lock()
+	delete(h.inflight, cacheKey)
+	h.mu.Unlock()
+	close(call.done)
+	return call.data, call.err
+}
+
+func (h *TransformHandler) render(sourceKey string, opts transformOptions) ([]byte, error) {
+	body, _, err := h.Images.Store.Get(sourceKey)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	// Originals were checked against the decode limits when uploaded and are
+	// already oriented.
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return h.Images.encodeTransformed(opts.render(src), opts)
+}
+
+// URL returns the address of a derivative for signed-in clients, signed
+// while URL signing is enabled: GET /forum/api/images/transform?id=<image_id>
+// with the /img/ parameters. Each parameter may be given once and anything
+// else is refused, so only derivatives /img/ would render get signed.
+func (h *TransformHandler) URL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.ErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if middleware.GetCurrentUser(r) == nil {
+		utils.ErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	query := r.URL.Query()
+	for k, v := range query {
+		if !urlParams[k] || len(v) != 1 {
+			utils.ErrorResponse(w, "Unsupported parameter "+k, http.StatusBadRequest)
+			return
+		}
+	}
+	opts, err := parseTransform(query, h.Config)
+	if err != nil {
+		utils.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id := query.Get("id")
+	img, err := h.loadImage(id)
+	if err != nil {
+		utils.ErrorResponse(w, "Failed to load image", http.StatusInternalServerError)
+		return
+	}
+	if img == nil {
+		utils.ErrorResponse(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	opts = opts.resolve(img.FilePath)
+
+	params := url.Values{}
+	if opts.Width > 0 {
+		params.Set("w", strconv.Itoa(opts.Width))
+	}
+	if opts.Height > 0 {
+		params.Set("h", strconv.Itoa(opts.Height))
+	}
+	params.Set("fit", opts.Fit)
+	params.Set("fx", strconv.FormatFloat(opts.FocusX, 'f', 2, 64))
+	params.Set("fy", strconv.FormatFloat(opts.FocusY, 'f', 2, 64))
+	params.Set("fmt", opts.Format)
+	if opts.Quality > 0 {
+		params.Set("q", strconv.Itoa(opts.Quality))
+	}
+	if h.URLs.Enabled() {
+		for k, v := range h.URLs.Sign(signedKey(id, opts)) {
+			params[k] = v
+		}
+	}
+	utils.JSONResponse(w, map[string]string{"url": h.Config.BaseURL + id + "?" + params.Encode()}, http.StatusOK)
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"forum/config"
+	"forum/diskcache"
+	"forum/repository"
+	"forum/storage"
+	"forum/urlsign"
+
+	_ "github.com/mattn/go-sqlite3"
+	"golang.org/x/image/webp"
+)
+
+// openTestDB returns a database in a temporary directory with stmts applied.
+func openTestDB(t *testing.T, stmts ...string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "forum.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%v\n%s", err, stmt)
+		}
+	}
+	return db
+}
+
+// imageTables is the part of the schema ImageRepository reads.
+var imageTables = []string{
+	config.CreatePostsTable,
+	config.CreateImagesTable,
+	config.AddImagesStorageBackend,
+	config.AddImagesOrientation,
+	config.AddImagesStrippedMetadata,
+	config.CreateImageBlobsTable,
+	config.AddImagesContentHash,
+	config.AddImagesPosition,
+	config.AddImagesAltText,
+	config.AddImagesCaption,
+	config.AddImagesStatus,
+	config.AddImagesBlurHash,
+	config.AddImagesDominantColor,
+	config.AddImagesPHash,
+	config.AddImagesDHash,
+	config.AddImagesSourceBytes,
+	config.CreateImageBlobRefTrigger,
+	config.CreateImageBlobUnrefTrigger,
+	config.CreateImageBlobRehashTrigger,
+	config.CreateImageRenditionsTable,
+}
+
+func TestTransformWebP(t *testing.T) {
+	db := openTestDB(t, append(imageTables,
+		`INSERT INTO posts (post_id, user_id, title, content) VALUES ('p1', 'u1', 't', 'c')`,
+		`INSERT INTO images (image_id, post_id, user_id, file_path, thumbnail_path, status) VALUES ('i1', 'p1', 'u1', 'images/a.png', 'images/a_thumb.png', 'ready')`,
+	)...)
+	store, err := storage.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := image.NewNRGBA(image.Rect(0, 0, 200, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y * 2), 80, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put("images/a.png", &buf, "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := diskcache.New(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.TransformConfig{Sizes: []int{64, 128}, Qualities: []int{80}, DefaultQuality: 80}
+	signer := urlsign.NewSigner(config.StaticURLConfig{Secret: []byte("secret"), TTL: time.Hour})
+	images := &ImageHandler{
+		ImageRepo: repository.NewImageRepository(db),
+		PostRepo:  repository.NewPostRepository(db),
+		ScanRepo:  repository.NewImageScanRepository(db),
+		Store:     store,
+	}
+	h := http.StripPrefix("/img/", NewTransformHandler(images, signer, cache, cfg))
+
+	q := url.Values{"w": {"128"}, "fmt": {"webp"}}
+	opts, err := parseTransform(q, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range signer.Sign(signedKey("i1", opts.resolve("images/a.png"))) {
+		q[k] = v
+	}
+
+	// The second request is answered from the cache
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/img/i1?"+q.Encode(), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "image/webp" {
+			t.Fatalf("Content-Type %q", ct)
+		}
+		got, err := webp.Decode(rec.Body)
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if b := got.Bounds(); b.Dx() != 128 || b.Dy() != 64 {
+			t.Fatalf("got %v, want 128x64", b)
+		}
+		r, g, _, _ := got.At(127, 63).RGBA()
+		if r>>8 < 190 || g>>8 < 190 {
+			t.Errorf("bottom right corner = %v", got.At(127, 63))
+		}
+	}
+
+	// A signature for another format does not carry over
+	q.Set("fmt", "png")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/img/i1?"+q.Encode(), nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("png with the webp signature: status %d", rec.Code)
+	}
+}
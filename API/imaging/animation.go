@@ -0,0 +1,267 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+)
+
+// GIFSize returns the logical screen size of an animation, falling back to
+// the union of its frames.
+func GIFSize(g *gif.GIF) (int, int) {
+	if g.Config.Width > 0 && g.Config.Height > 0 {
+		return g.Config.Width, g.Config.Height
+	}
+	var r image.Rectangle
+	for _, frame := range g.Image {
+		r = r.Union(frame.Bounds())
+	}
+	return r.Max.X, r.Max.Y
+}
+
+// ComposeFrames renders an animation the way a browser shows it: each frame
+// is drawn over the canvas left by the previous one, after applying that
+// frame's disposal method. fn is called with the full canvas after every
+// frame and must not keep it; returning false stops early.
+func ComposeFrames(g *gif.GIF, fn func(i int, canvas *image.RGBA) bool) {
+	w, h := GIFSize(g)
+	canvas := image.NewRGBA(image.Rect(0, 0, w, h))
+	var saved *image.RGBA
+	for i, frame := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			if saved == nil {
+				saved = image.NewRGBA(canvas.Rect)
+			}
+			copy(saved.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		if !fn(i, canvas) {
+			return
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			copy(canvas.Pix, saved.Pix)
+		}
+	}
+}
+
+// GIFOptions control OptimizeGIF.
+type GIFOptions struct {
+	// Width and Height of the output; zero keeps the source size.
+	Width, Height int
+	// Letterbox fits the animation inside Width x Height keeping its aspect
+	// ratio, centred on a transparent background, instead of stretching it.
+	Letterbox bool
+	Filter    Filter
+	// MaxFrames and MaxDuration (in hundredths of a second) cut the
+	// animation short; zero means no limit.
+	MaxFrames   int
+	MaxDuration int
+}
+
+// OptimizeGIF re-encodes an animation for size. Frames are composited with
+// their disposal methods, optionally resized, and mapped without dithering
+// onto one global palette built by median cut, with index 0 reserved for
+// transparency. Each frame then only stores the rectangle that changed since
+// the previous one, with unchanged pixels inside it transparent; frames that
+// change nothing are merged into the previous frame's delay.
+func OptimizeGIF(src *gif.GIF, opts GIFOptions) *gif.GIF {
+	sw, sh := GIFSize(src)
+	w, h, area := gifLayout(src, opts)
+	kept := keptFrames(src, opts)
+
+	hist := NewHistogram(255)
+	ComposeFrames(src, func(i int, canvas *image.RGBA) bool {
+		if i >= kept {
+			return false
+		}
+		hist.Add(canvas)
+		return true
+	})
+	pal := append(color.Palette{color.RGBA{}}, hist.MedianCut(255)...)
+	mapper := newPaletteMapper(pal, 1)
+
+	out := &gif.GIF{
+		LoopCount: src.LoopCount,
+		Config:    image.Config{ColorModel: pal, Width: w, Height: h},
+	}
+	cur := make([]uint8, w*h)
+	shown := make([]uint8, w*h)
+	resize := area.Dx() != sw || area.Dy() != sh
+
+	ComposeFrames(src, func(i int, canvas *image.RGBA) bool {
+		if i >= kept {
+			return false
+		}
+		delay := 0
+		if i < len(src.Delay) {
+			delay = src.Delay[i]
+		}
+
+		frame := canvas
+		if resize {
+			frame = Resize(canvas, area.Dx(), area.Dy(), opts.Filter)
+		}
+		for y := 0; y < area.Dy(); y++ {
+			row := frame.Pix[y*frame.Stride:]
+			dst := cur[(area.Min.Y+y)*w+area.Min.X:]
+			for x := 0; x < area.Dx(); x++ {
+				if r, g, b, ok := straight(row[x*4:]); ok {
+					dst[x] = mapper.index(r, g, b)
+				} else {
+					dst[x] = 0
+				}
+			}
+		}
+
+		if len(out.Image) == 0 {
+			appendFrame(out, pal, image.Rect(0, 0, w, h), cur, nil, delay)
+			copy(shown, cur)
+			return true
+		}
+
+		minX, minY, maxX, maxY := w, h, -1, -1
+		clears := false
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				j := y*w + x
+				if cur[j] == shown[j] {
+					continue
+				}
+				if cur[j] == 0 {
+					clears = true
+				}
+				minX, maxX = min(minX, x), max(maxX, x)
+				minY, maxY = min(minY, y), max(maxY, y)
+			}
+		}
+		changed := image.Rect(minX, minY, maxX+1, maxY+1)
+
+		last := len(out.Image) - 1
+		switch {
+		case maxX < 0:
+			out.Delay[last] += delay
+		case clears:
+			// Pixels turning transparent cannot be drawn over the canvas:
+			// let the previous frame clear the whole canvas instead.
+			if prev := out.Image[last]; prev.Rect != image.Rect(0, 0, w, h) {
+				full := image.NewPaletted(image.Rect(0, 0, w, h), pal)
+				for y := prev.Rect.Min.Y; y < prev.Rect.Max.Y; y++ {
+					copy(full.Pix[y*w+prev.Rect.Min.X:], prev.Pix[(y-prev.Rect.Min.Y)*prev.Stride:][:prev.Rect.Dx()])
+				}
+				out.Image[last] = full
+			}
+			out.Disposal[last] = gif.DisposalBackground
+			appendFrame(out, pal, image.Rect(0, 0, w, h), cur, nil, delay)
+			copy(shown, cur)
+		default:
+			appendFrame(out, pal, changed, cur, shown, delay)
+			for y := changed.Min.Y; y < changed.Max.Y; y++ {
+				copy(shown[y*w+changed.Min.X:y*w+changed.Max.X], cur[y*w+changed.Min.X:y*w+changed.Max.X])
+			}
+		}
+		return true
+	})
+	return out
+}
+
+// PlaceGIF returns the frames of src kept by opts, unchanged but moved to
+// where OptimizeGIF would place them on a canvas of the output size. It
+// returns false when opts needs the frames resized. The result shares its
+// frames' pixels with src.
+func PlaceGIF(src *gif.GIF, opts GIFOptions) (*gif.GIF, bool) {
+	sw, sh := GIFSize(src)
+	w, h, area := gifLayout(src, opts)
+	if area.Dx() != sw || area.Dy() != sh {
+		return nil, false
+	}
+	kept := keptFrames(src, opts)
+
+	out := &gif.GIF{
+		LoopCount:       src.LoopCount,
+		Config:          image.Config{ColorModel: src.Config.ColorModel, Width: w, Height: h},
+		BackgroundIndex: src.BackgroundIndex,
+	}
+	for i, frame := range src.Image[:kept] {
+		moved := *frame
+		moved.Rect = frame.Rect.Add(area.Min)
+		out.Image = append(out.Image, &moved)
+		delay := 0
+		if i < len(src.Delay) {
+			delay = src.Delay[i]
+		}
+		out.Delay = append(out.Delay, delay)
+		if src.Disposal != nil {
+			var disposal byte
+			if i < len(src.Disposal) {
+				disposal = src.Disposal[i]
+			}
+			out.Disposal = append(out.Disposal, disposal)
+		}
+	}
+	return out, true
+}
+
+// gifLayout returns the output size for opts and the area of it the source
+// frames cover. A letterboxed animation is scaled down to fit but never up.
+func gifLayout(src *gif.GIF, opts GIFOptions) (w, h int, area image.Rectangle) {
+	sw, sh := GIFSize(src)
+	w, h = sw, sh
+	if opts.Width > 0 && opts.Height > 0 {
+		w, h = opts.Width, opts.Height
+	}
+	area = image.Rect(0, 0, w, h)
+	if opts.Letterbox && (w != sw || h != sh) {
+		scale := min(float64(w)/float64(sw), float64(h)/float64(sh), 1)
+		nw, nh := max(1, int(float64(sw)*scale)), max(1, int(float64(sh)*scale))
+		area = image.Rect((w-nw)/2, (h-nh)/2, (w-nw)/2+nw, (h-nh)/2+nh)
+	}
+	return w, h, area
+}
+
+// keptFrames returns how many frames of src fit the frame and duration caps
+// of opts. The first frame is always kept.
+func keptFrames(src *gif.GIF, opts GIFOptions) int {
+	elapsed := 0
+	for i := range src.Image {
+		if opts.MaxFrames > 0 && i >= opts.MaxFrames {
+			return i
+		}
+		if opts.MaxDuration > 0 && i > 0 && elapsed >= opts.MaxDuration {
+			return i
+		}
+		if i < len(src.Delay) {
+			elapsed += src.Delay[i]
+		}
+	}
+	return len(src.Image)
+}
+
+// appendFrame adds the rect part of the w-wide index buffer cur as a frame.
+// With shown set, pixels equal to it are made transparent so the canvas
+// shows through and the frame compresses better.
+func appendFrame(out *gif.GIF, pal color.Palette, rect image.Rectangle, cur, shown []uint8, delay int) {
+	w := out.Config.Width
+	frame := image.NewPaletted(rect, pal)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		dst := frame.Pix[(y-rect.Min.Y)*frame.Stride:]
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			j := y*w + x
+			if shown == nil || cur[j] != shown[j] {
+				dst[x-rect.Min.X] = cur[j]
+			}
+		}
+	}
+	out.Image = append(out.Image, frame)
+	out.Delay = append(out.Delay, delay)
+	out.Disposal = append(out.Disposal, gif.DisposalNone)
+}
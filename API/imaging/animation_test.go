@@ -0,0 +1,105 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+// stripes returns an animation of n w x h frames, each 10 hundredths of a
+// second, with stripes moving one pixel per frame.
+func stripes(w, h, n int) *gif.GIF {
+	pal := color.Palette{color.RGBA{255, 255, 255, 255}, color.RGBA{200, 0, 0, 255}, color.RGBA{0, 0, 200, 255}}
+	g := &gif.GIF{Config: image.Config{ColorModel: pal, Width: w, Height: h}}
+	for i := 0; i < n; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, w, h), pal)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				frame.SetColorIndex(x, y, uint8((x+i)/4%3))
+			}
+		}
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10)
+		g.Disposal = append(g.Disposal, gif.DisposalNone)
+	}
+	return g
+}
+
+func TestOptimizeGIFLetterboxDoesNotUpscale(t *testing.T) {
+	out := OptimizeGIF(stripes(90, 60, 3), GIFOptions{Width: 150, Height: 150, Letterbox: true, Filter: CatmullRom})
+	if out.Config.Width != 150 || out.Config.Height != 150 {
+		t.Fatalf("canvas %dx%d, want 150x150", out.Config.Width, out.Config.Height)
+	}
+	// The 90x60 frames are centred as they are
+	area := image.Rect(30, 45, 120, 105)
+	first := out.Image[0]
+	for y := 0; y < 150; y++ {
+		for x := 0; x < 150; x++ {
+			opaque := first.ColorIndexAt(x, y) != 0
+			if opaque != (image.Point{x, y}.In(area)) {
+				t.Fatalf("pixel (%d,%d) opaque = %v", x, y, opaque)
+			}
+		}
+	}
+
+	// Larger animations are still scaled down to fit
+	out = OptimizeGIF(stripes(300, 100, 2), GIFOptions{Width: 150, Height: 150, Letterbox: true, Filter: CatmullRom})
+	if b := out.Image[0].Bounds(); b != image.Rect(0, 0, 150, 150) {
+		t.Fatalf("first frame %v", b)
+	}
+	if out.Image[0].ColorIndexAt(75, 40) != 0 || out.Image[0].ColorIndexAt(75, 75) == 0 {
+		t.Error("300x100 not letterboxed to 150x50")
+	}
+}
+
+func TestPlaceGIF(t *testing.T) {
+	src := stripes(90, 60, 12)
+	placed, ok := PlaceGIF(src, GIFOptions{Width: 150, Height: 150, Letterbox: true, MaxDuration: 50})
+	if !ok {
+		t.Fatal("frames that fit were not placed")
+	}
+	if placed.Config.Width != 150 || placed.Config.Height != 150 {
+		t.Errorf("canvas %dx%d, want 150x150", placed.Config.Width, placed.Config.Height)
+	}
+	// 50 hundredths of a second are five frames
+	if len(placed.Image) != 5 || len(placed.Delay) != 5 || len(placed.Disposal) != 5 {
+		t.Fatalf("%d frames, %d delays, %d disposals, want 5", len(placed.Image), len(placed.Delay), len(placed.Disposal))
+	}
+	for i, frame := range placed.Image {
+		if frame.Rect != image.Rect(30, 45, 120, 105) {
+			t.Errorf("frame %d at %v", i, frame.Rect)
+		}
+		if frame.ColorIndexAt(30, 45) != src.Image[i].ColorIndexAt(0, 0) {
+			t.Errorf("frame %d moved without its pixels", i)
+		}
+	}
+	if src.Image[0].Rect != image.Rect(0, 0, 90, 60) {
+		t.Errorf("source frame moved to %v", src.Image[0].Rect)
+	}
+
+	if _, ok := PlaceGIF(src, GIFOptions{Width: 45, Height: 30}); ok {
+		t.Error("frames placed although they need resizing")
+	}
+	if _, ok := PlaceGIF(stripes(300, 100, 1), GIFOptions{Width: 150, Height: 150, Letterbox: true}); ok {
+		t.Error("frames placed although they need scaling down")
+	}
+}
+
+func TestKeptFrames(t *testing.T) {
+	src := stripes(4, 4, 10)
+	for _, c := range []struct {
+		opts GIFOptions
+		want int
+	}{
+		{GIFOptions{}, 10},
+		{GIFOptions{MaxFrames: 3}, 3},
+		{GIFOptions{MaxDuration: 25}, 3},
+		{GIFOptions{MaxDuration: 1}, 1},
+		{GIFOptions{MaxFrames: 20, MaxDuration: 1000}, 10},
+	} {
+		if got := keptFrames(src, c.opts); got != c.want {
+			t.Errorf("keptFrames(%+v) = %d, want %d", c.opts, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package imaging
+
+import (
+	"image"
+	"sync"
+)
+
+// reduceGap is the smallest scale left to the filter after box reduction.
+// Below two the box average, which aliases fine detail, does most of the
+// work; a wider gap costs filter taps for little visible gain.
+const reduceGap = 2
+
+// maxBoxSamples caps the pixels read per block edge by boxRows. Larger blocks
+// are averaged over an evenly spread grid of their pixels, which keeps the
+// cost of a thumbnail independent of the size of the source.
+const maxBoxSamples = 2
+
+// boxRows reads a source through the average of its k x k blocks. Partial
+// blocks at the right and bottom edges are averaged over the pixels present.
+// Pixels are summed as integers straight from the typed buffers, and YCbCr
+// sources are averaged and filtered before their conversion to RGB, with
+// chroma sampled as densely as luma in chroma pixels.
+type boxRows struct {
+	w, h int
+	// ys are the source rows read, ends the end of each block row's.
+	ys, ends []int
+	// colInv is one over the number of samples a block takes from each row.
+	colInv []float32
+	// sum sets out to the sums of each block over rows ys, four per block.
+	// For YCbCr sources it only sets Y, the first of three per block.
+	sum func(ys []int, out []float32)
+
+	// For YCbCr sources sumChroma sets Cb and Cr from rows cys, cEnds ends
+	// each block row's, and chromaInv is one over the chroma samples a
+	// block takes from each row.
+	sumChroma  func(ys []int, out []float32)
+	cys, cEnds []int
+	chromaInv  []float32
+}
+
+// columnSums holds the per-column sums of dense YCbCr block rows.
+var columnSums = sync.Pool{New: func() any { return new([]uint16) }}
+
+func newBoxRows(src image.Image, k int) *boxRows {
+	sb := src.Bounds()
+	xs, xEnds := boxSamples(sb.Dx(), k)
+	b := &boxRows{w: len(xEnds), h: (sb.Dy() + k - 1) / k}
+	b.ys, b.ends = boxSamples(sb.Dy(), k)
+	b.colInv = make([]float32, b.w)
+	x0 := 0
+	for bx, end := range xEnds {
+		b.colInv[bx] = 1 / float32(end-x0)
+		x0 = end
+	}
+	// Without sampling every block reads a contiguous run of each row. A
+	// block reads at most maxBoxSamples rows either way.
+	dense := k <= maxBoxSamples
+
+	switch src := src.(type) {
+	case *image.YCbCr:
+		b.sum = func(ys []int, out []float32) {
+			var rows [maxBoxSamples][]uint8
+			for i, y := range ys {
+				rows[i] = src.Y[src.YOffset(sb.Min.X, sb.Min.Y+y):]
+			}
+			if dense {
+				// Add the rows up first, then each block's run of the sums.
+				cols := columnSums.Get().(*[]uint16)
+				if len(*cols) < sb.Dx() {
+					*cols = make([]uint16, sb.Dx())
+				}
+				sums := (*cols)[:sb.Dx()]
+				for x, v := range rows[0][:len(sums)] {
+					sums[x] = uint16(v)
+				}
+				for _, row := range rows[1:len(ys)] {
+					for x, v := range row[:len(sums)] {
+						sums[x] += uint16(v)
+					}
+				}
+				x0 := 0
+				for bx, end := range xEnds {
+					var s uint32
+					for _, v := range sums[x0:end] {
+						s += uint32(v)
+					}
+					out[bx*3] = float32(s)
+					x0 = end
+				}
+				columnSums.Put(cols)
+				return
+			}
+			x0 := 0
+			for bx, end := range xEnds {
+				var s uint32
+				for _, row := range rows[:len(ys)] {
+					for _, x := range xs[x0:end] {
+						s += uint32(row[x])
+					}
+				}
+				out[bx*3] = float32(s)
+				x0 = end
+			}
+		}
+		b.chroma(src, xs, xEnds)
+	case *image.RGBA:
+		b.sum = func(ys []int, out []float32) {
+			var rows [maxBoxSamples][]uint8
+			for i, y := range ys {
+				rows[i] = src.Pix[src.PixOffset(sb.Min.X, sb.Min.Y+y):]
+			}
+			x0 := 0
+			for bx, end := range xEnds {
+				var r, g, bl, a uint32
+				for _, row := range rows[:len(ys)] {
+					for i := x0; i < end; i++ {
+						x := i
+						if !dense {
+							x = xs[i]
+						}
+						p := row[x*4 : x*4+4 : x*4+4]
+						r += uint32(p[0])
+						g += uint32(p[1])
+						bl += uint32(p[2])
+						a += uint32(p[3])
+					}
+				}
+				setBlock(out[bx*4:bx*4+4:bx*4+4], float32(r), float32(g), float32(bl), float32(a))
+				x0 = end
+			}
+		}
+	case *image.NRGBA:
+		b.sum = func(ys []int, out []float32) {
+			var rows [maxBoxSamples][]uint8
+			for i, y := range ys {
+				rows[i] = src.Pix[src.PixOffset(sb.Min.X, sb.Min.Y+y):]
+			}
+			x0 := 0
+			for bx, end := range xEnds {
+				var r, g, bl, a uint32
+				for _, row := range rows[:len(ys)] {
+					for _, x := range xs[x0:end] {
+						p := row[x*4 : x*4+4 : x*4+4]
+						pa := uint32(p[3])
+						r += uint32(p[0]) * pa
+						g += uint32(p[1]) * pa
+						bl += uint32(p[2]) * pa
+						a += pa
+					}
+				}
+				setBlock(out[bx*4:bx*4+4:bx*4+4], float32(r)/255, float32(g)/255, float32(bl)/255, float32(a))
+				x0 = end
+			}
+		}
+	case *image.Paletted:
+		var palette [256][4]uint32
+		for i, c := range src.Palette {
+			r, g, bl, a := c.RGBA()
+			palette[i] = [4]uint32{r >> 8, g >> 8, bl >> 8, a >> 8}
+		}
+		b.sum = func(ys []int, out []float32) {
+			var rows [maxBoxSamples][]uint8
+			for i, y := range ys {
+				rows[i] = src.Pix[src.PixOffset(sb.Min.X, sb.Min.Y+y):]
+			}
+			x0 := 0
+			for bx, end := range xEnds {
+				var r, g, bl, a uint32
+				for _, row := range rows[:len(ys)] {
+					for _, x := range xs[x0:end] {
+						c := &palette[row[x]]
+						r += c[0]
+						g += c[1]
+						bl += c[2]
+						a += c[3]
+					}
+				}
+				setBlock(out[bx*4:bx*4+4:bx*4+4], float32(r), float32(g), float32(bl), float32(a))
+				x0 = end
+			}
+		}
+	default:
+		reader := newRowReader(src)
+		b.sum = func(ys []int, out []float32) {
+			clear(out)
+			row := make([]float32, sb.Dx()*4)
+			for _, y := range ys {
+				reader.read(y, row)
+				x0 := 0
+				for bx, end := range xEnds {
+					a := out[bx*4 : bx*4+4 : bx*4+4]
+					for _, x := range xs[x0:end] {
+						p := row[x*4 : x*4+4 : x*4+4]
+						a[0] += p[0]
+						a[1] += p[1]
+						a[2] += p[2]
+						a[3] += p[3]
+					}
+					x0 = end
+				}
+			}
+		}
+	}
+	return b
+}
+
+// chroma sets up the Cb and Cr sums of a YCbCr source, read under the luma
+// samples xs and ys but no more densely than luma is.
+func (b *boxRows) chroma(src *image.YCbCr, xs, xEnds []int) {
+	sb := src.Rect
+	hs := chromaShift(src.SubsampleRatio)
+	vs := chromaVShift(src.SubsampleRatio)
+
+	y0 := 0
+	for _, end := range b.ends {
+		var under []int
+		for _, y := range b.ys[y0:end] {
+			if len(under) == 0 || (sb.Min.Y+under[len(under)-1])>>vs != (sb.Min.Y+y)>>vs {
+				under = append(under, y)
+			}
+		}
+		b.cys = append(b.cys, spread(under, max(1, (end-y0)>>vs))...)
+		b.cEnds = append(b.cEnds, len(b.cys))
+		y0 = end
+	}
+
+	var cxs, cEnds []int
+	x0 := 0
+	for _, end := range xEnds {
+		var under []int
+		for _, x := range xs[x0:end] {
+			cx := (sb.Min.X+x)>>hs - sb.Min.X>>hs
+			if len(under) == 0 || under[len(under)-1] != cx {
+				under = append(under, cx)
+			}
+		}
+		cxs = append(cxs, spread(under, max(1, (end-x0)>>hs))...)
+		cEnds = append(cEnds, len(cxs))
+		x0 = end
+	}
+	b.chromaInv = make([]float32, len(cEnds))
+	x0 = 0
+	for bx, end := range cEnds {
+		b.chromaInv[bx] = 1 / float32(end-x0)
+		x0 = end
+	}
+
+	b.sumChroma = func(ys []int, out []float32) {
+		var cbs, crs [maxBoxSamples][]uint8
+		for i, y := range ys {
+			off := src.COffset(sb.Min.X, sb.Min.Y+y)
+			cbs[i], crs[i] = src.Cb[off:], src.Cr[off:]
+		}
+		x0 := 0
+		for bx, end := range cEnds {
+			var scb, scr uint32
+			for i := range ys {
+				cb, cr := cbs[i], crs[i]
+				for _, cx := range cxs[x0:end] {
+					scb += uint32(cb[cx])
+					scr += uint32(cr[cx])
+				}
+			}
+			out[bx*3+1] = float32(scb)
+			out[bx*3+2] = float32(scr)
+			x0 = end
+		}
+	}
+}
+
+func (b *boxRows) size() (int, int) {
+	return b.w, b.h
+}
+
+func (b *boxRows) ycc() bool {
+	return b.sumChroma != nil
+}
+
+// read fills out with the averages of block row by: Y, Cb and Cr for YCbCr
+// sources, premultiplied RGBA otherwise.
+func (b *boxRows) read(by int, out []float32) {
+	y0 := 0
+	if by > 0 {
+		y0 = b.ends[by-1]
+	}
+	b.sum(b.ys[y0:b.ends[by]], out)
+	rowInv := 1 / float32(b.ends[by]-y0)
+
+	if b.sumChroma == nil {
+		out = out[:b.w*4]
+		for bx, inv := range b.colInv {
+			inv *= rowInv
+			a := out[bx*4 : bx*4+4 : bx*4+4]
+			a[0] *= inv
+			a[1] *= inv
+			a[2] *= inv
+			a[3] *= inv
+		}
+		return
+	}
+
+	c0 := 0
+	if by > 0 {
+		c0 = b.cEnds[by-1]
+	}
+	b.sumChroma(b.cys[c0:b.cEnds[by]], out)
+	chromaRowInv := 1 / float32(b.cEnds[by]-c0)
+	out = out[:b.w*3]
+	for bx, inv := range b.colInv {
+		cinv := b.chromaInv[bx] * chromaRowInv
+		a := out[bx*3 : bx*3+3 : bx*3+3]
+		a[0] *= inv * rowInv
+		a[1] *= cinv
+		a[2] *= cinv
+	}
+}
+
+func setBlock(a []float32, r, g, b, alpha float32) {
+	a[0], a[1], a[2], a[3] = r, g, b, alpha
+}
+
+// boxSamples returns the positions read for blocks of k out of n pixels,
+// and for each block the end of its positions.
+func boxSamples(n, k int) (pos, ends []int) {
+	blocks := (n + k - 1) / k
+	ends = make([]int, blocks)
+	for b := 0; b < blocks; b++ {
+		lo := b * k
+		m := min(k, n-lo)
+		if m <= maxBoxSamples {
+			for i := 0; i < m; i++ {
+				pos = append(pos, lo+i)
+			}
+		} else {
+			for i := 0; i < maxBoxSamples; i++ {
+				pos = append(pos, lo+(2*i+1)*m/(2*maxBoxSamples))
+			}
+		}
+		ends[b] = len(pos)
+	}
+	return pos, ends
+}
+
+// yCbCrToRGBf is yCbCrToRGB for filtered, fractional samples.
+func yCbCrToRGBf(y, cb, cr float32) (float32, float32, float32) {
+	cb -= 128
+	cr -= 128
+	return clampf(y + 1.402*cr), clampf(y - 0.344136*cb - 0.714136*cr), clampf(y + 1.772*cb)
+}
+
+func clampf(v float32) float32 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return v
+}
+
+// spread returns n of the values in list, evenly spaced, or all of them if
+// there are no more than n.
+func spread(list []int, n int) []int {
+	if len(list) <= n {
+		return list
+	}
+	out := make([]int, n)
+	for i := range out {
+		out[i] = list[(2*i+1)*len(list)/(2*n)]
+	}
+	return out
+}
@@ -0,0 +1,325 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrInvalidExif is returned for EXIF blocks that cannot be parsed.
+var ErrInvalidExif = errors.New("imaging: invalid exif data")
+
+// EXIF IFD names used in ExifEntry.IFD.
+const (
+	IFD0    = "IFD0"
+	IFDExif = "Exif"
+	IFDGPS  = "GPS"
+)
+
+const (
+	tagOrientation  = 0x0112
+	tagExifPointer  = 0x8769
+	tagGPSPointer   = 0x8825
+	tagInteropPoint = 0xA005
+	maxIFDEntries   = 512
+)
+
+// typeSizes maps TIFF field types to their size in bytes.
+var typeSizes = map[uint16]uint32{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8,
+}
+
+// tagNames covers the tags commonly written by cameras and phones. Unknown
+// tags are reported as hex IDs.
+var tagNames = map[string]map[uint16]string{
+	IFD0: {
+		0x010E: "ImageDescription", 0x010F: "Make", 0x0110: "Model",
+		0x0112: "Orientation", 0x011A: "XResolution", 0x011B: "YResolution",
+		0x0128: "ResolutionUnit", 0x0131: "Software", 0x0132: "DateTime",
+		0x013B: "Artist", 0x013E: "WhitePoint", 0x013F: "PrimaryChromaticities",
+		0x0213: "YCbCrPositioning", 0x8298: "Copyright",
+		0x8769: "ExifIFDPointer", 0x8825: "GPSInfo",
+	},
+	IFDExif: {
+		0x829A: "ExposureTime", 0x829D: "FNumber", 0x8822: "ExposureProgram",
+		0x8827: "ISOSpeedRatings", 0x9000: "ExifVersion",
+		0x9003: "DateTimeOriginal", 0x9004: "DateTimeDigitized",
+		0x9010: "OffsetTime", 0x9011: "OffsetTimeOriginal",
+		0x9101: "ComponentsConfiguration", 0x9201: "ShutterSpeedValue",
+		0x9202: "ApertureValue", 0x9203: "BrightnessValue",
+		0x9204: "ExposureBiasValue", 0x9207: "MeteringMode", 0x9209: "Flash",
+		0x920A: "FocalLength", 0x927C: "MakerNote", 0x9286: "UserComment",
+		0x9290: "SubSecTime", 0x9291: "SubSecTimeOriginal",
+		0xA000: "FlashpixVersion", 0xA001: "ColorSpace",
+		0xA002: "PixelXDimension", 0xA003: "PixelYDimension",
+		0xA005: "InteroperabilityIFDPointer", 0xA402: "ExposureMode",
+		0xA403: "WhiteBalance", 0xA405: "FocalLengthIn35mmFilm",
+		0xA406: "SceneCaptureType", 0xA420: "ImageUniqueID",
+		0xA430: "CameraOwnerName", 0xA431: "BodySerialNumber",
+		0xA432: "LensSpecification", 0xA433: "LensMake", 0xA434: "LensModel",
+		0xA435: "LensSerialNumber",
+	},
+	IFDGPS: {
+		0x0000: "GPSVersionID", 0x0001: "GPSLatitudeRef", 0x0002: "GPSLatitude",
+		0x0003: "GPSLongitudeRef", 0x0004: "GPSLongitude",
+		0x0005: "GPSAltitudeRef", 0x0006: "GPSAltitude",
+		0x0007: "GPSTimeStamp", 0x000C: "GPSSpeedRef", 0x000D: "GPSSpeed",
+		0x0010: "GPSImgDirectionRef", 0x0011: "GPSImgDirection",
+		0x0012: "GPSMapDatum", 0x001B: "GPSProcessingMethod",
+		0x001D: "GPSDateStamp",
+	},
+}
+
+// ExifEntry is one field of an EXIF block. Value holds the raw value bytes in
+// the byte order of the block it came from.
+type ExifEntry struct {
+	IFD   string
+	Tag   uint16
+	Type  uint16
+	Count uint32
+	Value []byte
+}
+
+// Name returns the tag's well-known name or its hex ID.
+func (e ExifEntry) Name() string {
+	if name, ok := tagNames[e.IFD][e.Tag]; ok {
+		return name
+	}
+	return fmt.Sprintf("%s:0x%04X", e.IFD, e.Tag)
+}
+
+// Exif is a parsed EXIF (TIFF) block.
+type Exif struct {
+	order        binary.ByteOrder
+	Entries      []ExifEntry
+	HasThumbnail bool
+}
+
+// ParseExif parses a TIFF-structured EXIF block, the payload that follows
+// "Exif\x00\x00" in a JPEG APP1 segment or the content of a PNG eXIf chunk.
+// Only IFD0, the Exif sub-IFD and the GPS sub-IFD are read.
+func ParseExif(b []byte) (*Exif, error) {
+	if len(b) < 8 {
+		return nil, ErrInvalidExif
+	}
+	var order binary.ByteOrder
+	switch string(b[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, ErrInvalidExif
+	}
+	if order.Uint16(b[2:4]) != 42 {
+		return nil, ErrInvalidExif
+	}
+
+	x := &Exif{order: order}
+	next, err := x.readIFD(b, order.Uint32(b[4:8]), IFD0)
+	if err != nil {
+		return nil, err
+	}
+	// A second IFD in the chain holds the embedded preview thumbnail.
+	x.HasThumbnail = next != 0 && int(next) < len(b)
+
+	for _, e := range x.Entries {
+		var ifd string
+		switch {
+		case e.IFD == IFD0 && e.Tag == tagExifPointer:
+			ifd = IFDExif
+		case e.IFD == IFD0 && e.Tag == tagGPSPointer:
+			ifd = IFDGPS
+		default:
+			continue
+		}
+		if len(e.Value) < 4 {
+			continue
+		}
+		if _, err := x.readIFD(b, order.Uint32(e.Value), ifd); err != nil {
+			return nil, err
+		}
+	}
+	return x, nil
+}
+
+func (x *Exif) readIFD(b []byte, off uint32, ifd string) (uint32, error) {
+	if uint64(off)+2 > uint64(len(b)) {
+		return 0, ErrInvalidExif
+	}
+	n := int(x.order.Uint16(b[off:]))
+	if n > maxIFDEntries || uint64(off)+2+uint64(n)*12+4 > uint64(len(b)) {
+		return 0, ErrInvalidExif
+	}
+	p := off + 2
+	for i := 0; i < n; i++ {
+		ent := b[p : p+12]
+		p += 12
+		typ := x.order.Uint16(ent[2:4])
+		count := x.order.Uint32(ent[4:8])
+		size, ok := typeSizes[typ]
+		if !ok {
+			continue
+		}
+		total := uint64(size) * uint64(count)
+		var val []byte
+		if total <= 4 {
+			val = append([]byte(nil), ent[8:8+total]...)
+		} else {
+			voff := uint64(x.order.Uint32(ent[8:12]))
+			if voff+total > uint64(len(b)) {
+				continue
+			}
+			val = append([]byte(nil), b[voff:voff+total]...)
+		}
+		x.Entries = append(x.Entries, ExifEntry{IFD: ifd, Tag: x.order.Uint16(ent[0:2]), Type: typ, Count: count, Value: val})
+	}
+	return x.order.Uint32(b[p:]), nil
+}
+
+// Orientation returns the EXIF orientation (1-8), or 1 when absent or invalid.
+func (x *Exif) Orientation() int {
+	for _, e := range x.Entries {
+		if e.IFD == IFD0 && e.Tag == tagOrientation && e.Type == 3 && len(e.Value) >= 2 {
+			if o := int(x.order.Uint16(e.Value)); o >= 1 && o <= 8 {
+				return o
+			}
+		}
+	}
+	return 1
+}
+
+// FieldNames lists the names of the fields present, excluding the pointers
+// that only link sub-IFDs.
+func (x *Exif) FieldNames() []string {
+	var names []string
+	for _, e := range x.Entries {
+		if isPointer(e) {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	if x.HasThumbnail {
+		names = append(names, "Thumbnail")
+	}
+	return names
+}
+
+// Keep returns a copy holding only the fields whose names are in keep.
+// Orientation is never kept because the pixels are rotated on upload, and
+// sub-IFD pointers are rebuilt by Encode.
+func (x *Exif) Keep(keep map[string]bool) *Exif {
+	out := &Exif{order: x.order}
+	for _, e := range x.Entries {
+		if isPointer(e) || (e.IFD == IFD0 && e.Tag == tagOrientation) {
+			continue
+		}
+		if keep[e.Name()] {
+			out.Entries = append(out.Entries, e)
+		}
+	}
+	return out
+}
+
+func isPointer(e ExifEntry) bool {
+	switch {
+	case e.IFD == IFD0 && (e.Tag == tagExifPointer || e.Tag == tagGPSPointer):
+		return true
+	case e.IFD == IFDExif && e.Tag == tagInteropPoint:
+		return true
+	}
+	return false
+}
+
+// Encode serialises the block back into TIFF form. Entries of the Exif and
+// GPS sub-IFDs get the matching pointers in IFD0.
+func (x *Exif) Encode() []byte {
+	groups := map[string][]ExifEntry{}
+	for _, e := range x.Entries {
+		groups[e.IFD] = append(groups[e.IFD], e)
+	}
+	ifd0 := append([]ExifEntry(nil), groups[IFD0]...)
+	var subs []string
+	for _, ifd := range []string{IFDExif, IFDGPS} {
+		if len(groups[ifd]) == 0 {
+			continue
+		}
+		tag := uint16(tagExifPointer)
+		if ifd == IFDGPS {
+			tag = tagGPSPointer
+		}
+		ifd0 = append(ifd0, ExifEntry{IFD: IFD0, Tag: tag, Type: 4, Count: 1, Value: make([]byte, 4)})
+		subs = append(subs, ifd)
+	}
+	groups[IFD0] = ifd0
+	order := append([]string{IFD0}, subs...)
+
+	// First pass: place every IFD and the out-of-line values.
+	offsets := map[string]uint32{}
+	pos := uint32(8)
+	for _, ifd := range order {
+		offsets[ifd] = pos
+		pos += 2 + 12*uint32(len(groups[ifd])) + 4
+	}
+	dataStart := pos
+
+	var data bytes.Buffer
+	valueOff := map[string][]uint32{}
+	for _, ifd := range order {
+		entries := groups[ifd]
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Tag < entries[j].Tag })
+		offs := make([]uint32, len(entries))
+		for i, e := range entries {
+			if len(e.Value) > 4 {
+				offs[i] = dataStart + uint32(data.Len())
+				data.Write(e.Value)
+				if data.Len()%2 == 1 {
+					data.WriteByte(0)
+				}
+			}
+		}
+		valueOff[ifd] = offs
+	}
+
+	// Second pass: write header, IFDs and data.
+	var out bytes.Buffer
+	if x.order == binary.BigEndian {
+		out.WriteString("MM")
+	} else {
+		out.WriteString("II")
+	}
+	buf := make([]byte, 4)
+	x.order.PutUint16(buf, 42)
+	out.Write(buf[:2])
+	x.order.PutUint32(buf, 8)
+	out.Write(buf)
+
+	for _, ifd := range order {
+		entries := groups[ifd]
+		x.order.PutUint16(buf, uint16(len(entries)))
+		out.Write(buf[:2])
+		for i, e := range entries {
+			ent := make([]byte, 12)
+			x.order.PutUint16(ent[0:], e.Tag)
+			x.order.PutUint16(ent[2:], e.Type)
+			x.order.PutUint32(ent[4:], e.Count)
+			switch {
+			case ifd == IFD0 && e.Tag == tagExifPointer:
+				x.order.PutUint32(ent[8:], offsets[IFDExif])
+			case ifd == IFD0 && e.Tag == tagGPSPointer:
+				x.order.PutUint32(ent[8:], offsets[IFDGPS])
+			case len(e.Value) > 4:
+				x.order.PutUint32(ent[8:], valueOff[ifd][i])
+			default:
+				copy(ent[8:], e.Value)
+			}
+			out.Write(ent)
+		}
+		x.order.PutUint32(buf, 0)
+		out.Write(buf)
+	}
+	out.Write(data.Bytes())
+	return out.Bytes()
+}
@@ -0,0 +1,248 @@
+package imaging
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// field is one entry of a hand-built IFD.
+type field struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte
+}
+
+func short(order binary.ByteOrder, v uint16) field {
+	b := make([]byte, 2)
+	order.PutUint16(b, v)
+	return field{typ: 3, count: 1, value: b}
+}
+
+func ascii(s string) field {
+	return field{typ: 2, count: uint32(len(s) + 1), value: append([]byte(s), 0)}
+}
+
+func tagged(tag uint16, f field) field {
+	f.tag = tag
+	return f
+}
+
+// buildTIFF lays out an EXIF block by hand: the header, IFD0 with pointers
+// to the Exif and GPS IFDs when they have entries, the IFDs themselves and
+// then the values that do not fit in their entries. With thumb set, IFD0
+// links to an empty second IFD as a thumbnail would.
+func buildTIFF(order binary.ByteOrder, ifd0, exif, gps []field, thumb bool) []byte {
+	ifd0 = append([]field(nil), ifd0...)
+	if len(exif) > 0 {
+		ifd0 = append(ifd0, field{tag: tagExifPointer, typ: 4, count: 1})
+	}
+	if len(gps) > 0 {
+		ifd0 = append(ifd0, field{tag: tagGPSPointer, typ: 4, count: 1})
+	}
+	ifds := [][]field{ifd0, exif, gps}
+	offsets := make([]uint32, len(ifds))
+	pos := uint32(8)
+	for i, ifd := range ifds {
+		if len(ifd) > 0 {
+			offsets[i] = pos
+			pos += 2 + 12*uint32(len(ifd)) + 4
+		}
+	}
+	thumbAt := pos
+	if thumb {
+		pos += 6
+	}
+
+	out := make([]byte, pos)
+	if order == binary.LittleEndian {
+		copy(out, "II")
+	} else {
+		copy(out, "MM")
+	}
+	order.PutUint16(out[2:], 42)
+	order.PutUint32(out[4:], 8)
+	for i, ifd := range ifds {
+		if len(ifd) == 0 {
+			continue
+		}
+		p := offsets[i]
+		order.PutUint16(out[p:], uint16(len(ifd)))
+		p += 2
+		for _, f := range ifd {
+			order.PutUint16(out[p:], f.tag)
+			order.PutUint16(out[p+2:], f.typ)
+			order.PutUint32(out[p+4:], f.count)
+			switch {
+			case i == 0 && f.tag == tagExifPointer:
+				order.PutUint32(out[p+8:], offsets[1])
+			case i == 0 && f.tag == tagGPSPointer:
+				order.PutUint32(out[p+8:], offsets[2])
+			case len(f.value) > 4:
+				order.PutUint32(out[p+8:], uint32(len(out)))
+				out = append(out, f.value...)
+			default:
+				copy(out[p+8:], f.value)
+			}
+			p += 12
+		}
+		if i == 0 && thumb {
+			order.PutUint32(out[p:], thumbAt)
+		}
+	}
+	return out
+}
+
+// camera is the block a phone might write: orientation, make and model in
+// IFD0, capture time in the Exif IFD and a position in the GPS IFD.
+func camera(order binary.ByteOrder, orientation uint16, thumb bool) []byte {
+	return buildTIFF(order,
+		[]field{
+			tagged(0x010F, ascii("Phone Maker")),
+			tagged(0x0110, ascii("X1")),
+			tagged(tagOrientation, short(order, orientation)),
+			tagged(0x8298, ascii("Jane Doe")),
+		},
+		[]field{tagged(0x9003, ascii("2024:05:01 12:00:00"))},
+		[]field{tagged(0x0001, ascii("N"))},
+		thumb)
+}
+
+func TestParseExifByteOrders(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for o := uint16(1); o <= 8; o++ {
+			x, err := ParseExif(camera(order, o, false))
+			if err != nil {
+				t.Fatalf("%v orientation %d: %v", order, o, err)
+			}
+			if got := x.Orientation(); got != int(o) {
+				t.Errorf("%v: orientation %d, want %d", order, got, o)
+			}
+		}
+
+		x, err := ParseExif(camera(order, 6, true))
+		if err != nil {
+			t.Fatalf("%v: %v", order, err)
+		}
+		want := []string{"Make", "Model", "Orientation", "Copyright", "DateTimeOriginal", "GPSLatitudeRef", "Thumbnail"}
+		if got := x.FieldNames(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: fields %v, want %v", order, got, want)
+		}
+		for _, e := range x.Entries {
+			if e.Name() == "Make" && string(e.Value) != "Phone Maker\x00" {
+				t.Errorf("%v: Make = %q", order, e.Value)
+			}
+		}
+	}
+}
+
+func TestExifOrientationOutOfRange(t *testing.T) {
+	order := binary.BigEndian
+	for _, o := range []uint16{0, 9, 0xFFFF} {
+		x, err := ParseExif(camera(order, o, false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := x.Orientation(); got != 1 {
+			t.Errorf("orientation %d read as %d, want 1", o, got)
+		}
+	}
+	// Orientation stored as a LONG is not the SHORT the standard requires
+	long := field{tag: tagOrientation, typ: 4, count: 1, value: []byte{0, 0, 0, 6}}
+	x, err := ParseExif(buildTIFF(order, []field{long}, nil, nil, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := x.Orientation(); got != 1 {
+		t.Errorf("LONG orientation read as %d", got)
+	}
+}
+
+func TestExifKeepAndEncode(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		x, err := ParseExif(camera(order, 6, true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Orientation is dropped even when asked for
+		kept := x.Keep(map[string]bool{"Copyright": true, "GPSLatitudeRef": true, "Orientation": true})
+		back, err := ParseExif(kept.Encode())
+		if err != nil {
+			t.Fatalf("%v: encoded block does not parse: %v", order, err)
+		}
+		if got, want := back.FieldNames(), []string{"Copyright", "GPSLatitudeRef"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: kept %v, want %v", order, got, want)
+		}
+		if back.Orientation() != 1 {
+			t.Errorf("%v: orientation survived Keep", order)
+		}
+		for _, e := range back.Entries {
+			if e.Name() == "Copyright" && string(e.Value) != "Jane Doe\x00" {
+				t.Errorf("%v: Copyright = %q", order, e.Value)
+			}
+		}
+
+		if empty := x.Keep(nil); len(empty.Entries) != 0 {
+			t.Errorf("%v: Keep(nil) kept %v", order, empty.FieldNames())
+		}
+	}
+}
+
+func TestParseExifMalformed(t *testing.T) {
+	le := binary.LittleEndian
+	valid := camera(le, 6, true)
+	patch := func(at int, b ...byte) []byte {
+		out := append([]byte(nil), valid...)
+		copy(out[at:], b)
+		return out
+	}
+	// IFD0 starts at 8; its third entry is Orientation and the fifth the
+	// Exif pointer.
+	entry := func(i int) int { return 8 + 2 + 12*i }
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{"empty", nil, true},
+		{"short header", []byte("II*\x00"), true},
+		{"bad byte order", patch(0, 'I', 'M'), true},
+		{"bad magic", patch(2, 43, 0), true},
+		{"IFD0 past the end", patch(4, 0xFF, 0xFF, 0, 0), true},
+		{"IFD0 offset overflows", patch(4, 0xFF, 0xFF, 0xFF, 0xFF), true},
+		{"too many entries", patch(8, 0xFF, 0x7F), true},
+		{"entries past the end", patch(8, 40, 0), true},
+		{"Exif pointer past the end", patch(entry(4)+8, 0xF0, 0xFF, 0xFF, 0xFF), true},
+		{"value past the end", patch(entry(0)+8, 0xF0, 0xFF, 0, 0), false},
+		{"value length overflows", patch(entry(0)+4, 0xFF, 0xFF, 0xFF, 0xFF), false},
+		{"unknown type", patch(entry(2)+2, 99, 0), false},
+		{"thumbnail past the end", patch(entry(6), 0xFF, 0xFF, 0, 0), false},
+	}
+	for _, tt := range tests {
+		x, err := ParseExif(tt.data)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error %v", tt.name, err)
+			continue
+		}
+		if err == nil {
+			x.Orientation()
+			x.FieldNames()
+			x.Keep(map[string]bool{"Make": true}).Encode()
+		}
+	}
+
+	// No prefix of a valid block may panic, nor any single corrupted byte
+	for n := range valid {
+		if x, err := ParseExif(valid[:n]); err == nil {
+			x.FieldNames()
+		}
+	}
+	for i := range valid {
+		for _, v := range []byte{0, 0x80, 0xFF} {
+			if x, err := ParseExif(patch(i, v)); err == nil {
+				x.Keep(map[string]bool{"Make": true, "GPSLatitudeRef": true}).Encode()
+			}
+		}
+	}
+}
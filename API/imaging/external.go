@@ -0,0 +1,69 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CommandEncoder encodes images with an external program such as cwebp or
+// avifenc. Args is the command line; the placeholders {in} and {out} are
+// replaced with the paths of a temporary PNG input and the expected output.
+type CommandEncoder struct {
+	Args    []string
+	Timeout time.Duration
+}
+
+// ParseCommandEncoder splits a command line such as
+// "avifenc --speed 8 {in} {out}". It returns nil for an empty string.
+func ParseCommandEncoder(cmdline string) *CommandEncoder {
+	args := strings.Fields(cmdline)
+	if len(args) == 0 {
+		return nil
+	}
+	return &CommandEncoder{Args: args, Timeout: 30 * time.Second}
+}
+
+// Encode runs the command on img and returns the encoded bytes.
+func (c *CommandEncoder) Encode(img image.Image) ([]byte, error) {
+	if c == nil || len(c.Args) == 0 {
+		return nil, errors.New("imaging: no encoder command configured")
+	}
+	dir, err := os.MkdirTemp("", "imaging-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	in := filepath.Join(dir, "in.png")
+	out := filepath.Join(dir, "out")
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(in, buf.Bytes(), 0600); err != nil {
+		return nil, err
+	}
+
+	args := make([]string, len(c.Args))
+	for i, a := range c.Args {
+		args[i] = strings.NewReplacer("{in}", in, "{out}", out).Replace(a)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("imaging: %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return os.ReadFile(out)
+}
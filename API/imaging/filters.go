@@ -0,0 +1,74 @@
+package imaging
+
+import (
+	"math"
+	"strings"
+)
+
+// Filter is a separable reconstruction kernel used by Resize.
+type Filter struct {
+	Name string
+	// Support is the kernel radius in source pixels at a 1:1 scale.
+	Support float64
+	Kernel  func(x float64) float64
+}
+
+// Bilinear is a triangle filter. Cheap, slightly soft.
+var Bilinear = Filter{
+	Name:    "bilinear",
+	Support: 1,
+	Kernel: func(x float64) float64 {
+		x = math.Abs(x)
+		if x < 1 {
+			return 1 - x
+		}
+		return 0
+	},
+}
+
+// CatmullRom is the cubic filter with B=0, C=0.5. Sharp with little ringing;
+// a good default for photos.
+var CatmullRom = Filter{
+	Name:    "catmullrom",
+	Support: 2,
+	Kernel: func(x float64) float64 {
+		x = math.Abs(x)
+		switch {
+		case x < 1:
+			return (1.5*x-2.5)*x*x + 1
+		case x < 2:
+			return ((-0.5*x+2.5)*x-4)*x + 2
+		}
+		return 0
+	},
+}
+
+// Lanczos3 is a windowed sinc with three lobes. Sharpest of the set and the
+// most expensive.
+var Lanczos3 = Filter{
+	Name:    "lanczos",
+	Support: 3,
+	Kernel: func(x float64) float64 {
+		x = math.Abs(x)
+		if x == 0 {
+			return 1
+		}
+		if x < 3 {
+			px := math.Pi * x
+			return 3 * math.Sin(px) * math.Sin(px/3) / (px * px)
+		}
+		return 0
+	},
+}
+
+// FilterByName returns the filter with the given name, falling back to
+// CatmullRom for unknown names.
+func FilterByName(name string) Filter {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "bilinear", "linear":
+		return Bilinear
+	case "lanczos", "lanczos3":
+		return Lanczos3
+	}
+	return CatmullRom
+}
@@ -0,0 +1,65 @@
+package imaging
+
+import (
+	"errors"
+)
+
+var errGIFFormat = errors.New("imaging: malformed GIF")
+
+// GIFFrameCount counts the frames of a GIF by walking its block structure,
+// without decompressing any image data. It is cheap enough to run before
+// gif.DecodeAll to reject animations with too many frames.
+func GIFFrameCount(data []byte) (int, error) {
+	if len(data) < 13 || string(data[:3]) != "GIF" {
+		return 0, errGIFFormat
+	}
+	pos := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		pos += 3 << (flags&0x07 + 1)
+	}
+
+	// skipSubBlocks advances past a sequence of length-prefixed sub-blocks
+	// terminated by a zero length.
+	skipSubBlocks := func() bool {
+		for pos < len(data) {
+			n := int(data[pos])
+			pos++
+			if n == 0 {
+				return true
+			}
+			pos += n
+		}
+		return false
+	}
+
+	frames := 0
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // extension: label, then sub-blocks
+			pos += 2
+			if !skipSubBlocks() {
+				return frames, errGIFFormat
+			}
+		case 0x2C: // image descriptor
+			if pos+10 > len(data) {
+				return frames, errGIFFormat
+			}
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&0x07 + 1)
+			}
+			pos++ // LZW minimum code size
+			if !skipSubBlocks() {
+				return frames, errGIFFormat
+			}
+			frames++
+		case 0x3B: // trailer
+			return frames, nil
+		default:
+			return frames, errGIFFormat
+		}
+	}
+	// Truncated data: the decoder reports the error itself.
+	return frames, nil
+}
@@ -0,0 +1,68 @@
+package imaging
+
+import (
+	"crypto/sha256"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Identicon draws a size x size avatar from seed: a horizontally mirrored
+// 5x5 pattern in one colour on a light background, both derived from the
+// SHA-256 of seed so every seed always gets the same picture.
+func Identicon(seed string, size int) *image.RGBA {
+	sum := sha256.Sum256([]byte(seed))
+	fg := hslColor(float64(uint16(sum[0])<<8|uint16(sum[1]))/65536*360, 0.45+float64(sum[2])/255*0.2, 0.45+float64(sum[3])/255*0.15)
+	bg := color.RGBA{240, 240, 240, 255}
+
+	// Columns 0-2 are taken from the hash, 3 and 4 mirror 1 and 0.
+	var cells [5][5]bool
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 3; x++ {
+			bit := y*3 + x
+			on := sum[4+bit/8]>>(bit%8)&1 == 1
+			cells[y][x], cells[y][4-x] = on, on
+		}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	// A margin of half a cell on every side.
+	cell := float64(size) / 6
+	for y := 0; y < size; y++ {
+		cy := int(math.Floor((float64(y) - cell/2) / cell))
+		for x := 0; x < size; x++ {
+			cx := int(math.Floor((float64(x) - cell/2) / cell))
+			c := bg
+			if cx >= 0 && cx < 5 && cy >= 0 && cy < 5 && cells[cy][cx] {
+				c = fg
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// hslColor converts a hue in degrees and saturation and lightness in [0, 1]
+// to an opaque colour.
+func hslColor(h, s, l float64) color.RGBA {
+	c := (1 - math.Abs(2*l-1)) * s
+	hp := h / 60
+	x := c * (1 - math.Abs(math.Mod(hp, 2)-1))
+	var r, g, b float64
+	switch {
+	case hp < 1:
+		r, g = c, x
+	case hp < 2:
+		r, g = x, c
+	case hp < 3:
+		g, b = c, x
+	case hp < 4:
+		g, b = x, c
+	case hp < 5:
+		r, b = x, c
+	default:
+		r, b = c, x
+	}
+	m := l - c/2
+	return color.RGBA{uint8(math.Round((r + m) * 255)), uint8(math.Round((g + m) * 255)), uint8(math.Round((b + m) * 255)), 255}
+}
@@ -0,0 +1,260 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// Metadata summarises the metadata carried by an encoded image.
+type Metadata struct {
+	// Orientation is the EXIF orientation (1-8); 1 means upright.
+	Orientation int
+	// Exif is the parsed EXIF block, if any.
+	Exif *Exif
+	// Fields names every metadata item found: EXIF tags plus container
+	// level blocks such as XMP, ICC profiles and comments.
+	Fields []string
+}
+
+// ReadMetadata scans a JPEG, PNG, GIF or WebP file for metadata. Unknown formats
+// and malformed blocks yield an empty result rather than an error so a
+// broken EXIF segment never blocks an upload.
+func ReadMetadata(data []byte, contentType string) *Metadata {
+	m := &Metadata{Orientation: 1}
+	switch contentType {
+	case "image/jpeg":
+		m.scanJPEG(data)
+	case "image/png":
+		m.scanPNG(data)
+	case "image/gif":
+		m.scanGIF(data)
+	case "image/webp":
+		m.scanWebP(data)
+	}
+	if m.Exif != nil {
+		m.Orientation = m.Exif.Orientation()
+		m.Fields = append(m.Exif.FieldNames(), m.Fields...)
+	}
+	return m
+}
+
+func (m *Metadata) setExif(b []byte) {
+	if x, err := ParseExif(b); err == nil {
+		m.Exif = x
+	} else {
+		m.Fields = append(m.Fields, "EXIF")
+	}
+}
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	xmpHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	iccHeader  = []byte("ICC_PROFILE\x00")
+)
+
+func (m *Metadata) scanJPEG(b []byte) {
+	if len(b) < 4 || b[0] != 0xFF || b[1] != 0xD8 {
+		return
+	}
+	p := 2
+	for p+4 <= len(b) {
+		if b[p] != 0xFF {
+			return
+		}
+		marker := b[p+1]
+		if marker == 0xD8 || (marker >= 0xD0 && marker <= 0xD7) || marker == 0x01 || marker == 0xFF {
+			p++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			// Start of scan: no more metadata segments follow.
+			return
+		}
+		n := int(binary.BigEndian.Uint16(b[p+2:]))
+		if n < 2 || p+2+n > len(b) {
+			return
+		}
+		seg := b[p+4 : p+2+n]
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(seg, exifHeader):
+			if m.Exif == nil {
+				m.setExif(seg[len(exifHeader):])
+			}
+		case marker == 0xE1 && bytes.HasPrefix(seg, xmpHeader):
+			m.Fields = append(m.Fields, "XMP")
+		case marker == 0xE2 && bytes.HasPrefix(seg, iccHeader):
+			m.Fields = appendOnce(m.Fields, "ICCProfile")
+		case marker == 0xED:
+			m.Fields = append(m.Fields, "IPTC")
+		case marker == 0xFE:
+			m.Fields = append(m.Fields, "Comment")
+		}
+		p += 2 + n
+	}
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+func (m *Metadata) scanPNG(b []byte) {
+	if !bytes.HasPrefix(b, pngSignature) {
+		return
+	}
+	p := len(pngSignature)
+	for p+12 <= len(b) {
+		n := int(binary.BigEndian.Uint32(b[p:]))
+		if n < 0 || p+12+n > len(b) {
+			return
+		}
+		typ := string(b[p+4 : p+8])
+		data := b[p+8 : p+8+n]
+		switch typ {
+		case "eXIf":
+			m.setExif(data)
+		case "tEXt", "zTXt", "iTXt":
+			key := data
+			if i := bytes.IndexByte(data, 0); i >= 0 {
+				key = data[:i]
+			}
+			m.Fields = append(m.Fields, "PNG:"+string(key))
+		case "tIME", "iCCP":
+			m.Fields = append(m.Fields, "PNG:"+typ)
+		case "IEND":
+			return
+		}
+		p += 12 + n
+	}
+}
+
+func (m *Metadata) scanGIF(b []byte) {
+	if len(b) < 13 || !bytes.HasPrefix(b, []byte("GIF")) {
+		return
+	}
+	p := 13
+	if b[10]&0x80 != 0 {
+		p += 3 * (1 << (int(b[10]&0x07) + 1))
+	}
+	skipBlocks := func() bool {
+		for p < len(b) {
+			n := int(b[p])
+			p++
+			if n == 0 {
+				return true
+			}
+			p += n
+		}
+		return false
+	}
+	for p < len(b) {
+		switch b[p] {
+		case 0x21: // extension
+			if p+2 > len(b) {
+				return
+			}
+			label := b[p+1]
+			p += 2
+			switch label {
+			case 0xFE:
+				m.Fields = append(m.Fields, "Comment")
+			case 0xFF:
+				if p+12 <= len(b) && b[p] == 11 {
+					app := string(b[p+1 : p+12])
+					if app != "NETSCAPE2.0" && app != "ANIMEXTS1.0" {
+						m.Fields = append(m.Fields, "GIF:"+app)
+					}
+				}
+			}
+			if !skipBlocks() {
+				return
+			}
+		case 0x2C: // image descriptor
+			if p+10 > len(b) {
+				return
+			}
+			flags := b[p+9]
+			p += 10
+			if flags&0x80 != 0 {
+				p += 3 * (1 << (int(flags&0x07) + 1))
+			}
+			p++ // LZW minimum code size
+			if !skipBlocks() {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (m *Metadata) scanWebP(b []byte) {
+	if len(b) < 12 || string(b[0:4]) != "RIFF" || string(b[8:12]) != "WEBP" {
+		return
+	}
+	p := 12
+	for p+8 <= len(b) {
+		n := int(binary.LittleEndian.Uint32(b[p+4:]))
+		if n < 0 || p+8+n > len(b) {
+			return
+		}
+		data := b[p+8 : p+8+n]
+		switch string(b[p : p+4]) {
+		case "EXIF":
+			m.setExif(bytes.TrimPrefix(data, exifHeader))
+		case "XMP ":
+			m.Fields = append(m.Fields, "XMP")
+		case "ICCP":
+			m.Fields = append(m.Fields, "ICCProfile")
+		}
+		p += 8 + n + n&1
+	}
+}
+
+func appendOnce(list []string, v string) []string {
+	for _, s := range list {
+		if s == v {
+			return list
+		}
+	}
+	return append(list, v)
+}
+
+// EmbedExif inserts a TIFF-encoded EXIF block into an encoded JPEG (as an
+// APP1 segment after SOI) or PNG (as an eXIf chunk before the first IDAT).
+// Other formats, or blocks too large for a JPEG segment, are returned as is.
+func EmbedExif(data []byte, contentType string, tiff []byte) []byte {
+	if len(tiff) == 0 {
+		return data
+	}
+	switch contentType {
+	case "image/jpeg":
+		payload := append(append([]byte(nil), exifHeader...), tiff...)
+		if len(payload)+2 > 0xFFFF || len(data) < 2 {
+			return data
+		}
+		seg := make([]byte, 4, 4+len(payload))
+		seg[0], seg[1] = 0xFF, 0xE1
+		binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+		seg = append(seg, payload...)
+		out := make([]byte, 0, len(data)+len(seg))
+		out = append(out, data[:2]...)
+		out = append(out, seg...)
+		return append(out, data[2:]...)
+	case "image/png":
+		idat := bytes.Index(data, []byte("IDAT"))
+		if idat < 4 {
+			return data
+		}
+		at := idat - 4
+		chunk := make([]byte, 8, 12+len(tiff))
+		binary.BigEndian.PutUint32(chunk, uint32(len(tiff)))
+		copy(chunk[4:], "eXIf")
+		chunk = append(chunk, tiff...)
+		crc := crc32.ChecksumIEEE(chunk[4:])
+		chunk = binary.BigEndian.AppendUint32(chunk, crc)
+		out := make([]byte, 0, len(data)+len(chunk))
+		out = append(out, data[:at]...)
+		out = append(out, chunk...)
+		return append(out, data[at:]...)
+	}
+	return data
+}
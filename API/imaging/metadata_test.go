@@ -0,0 +1,196 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"reflect"
+	"testing"
+)
+
+// jpegSegment returns a JPEG marker segment holding payload.
+func jpegSegment(marker byte, payload []byte) []byte {
+	seg := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+// pngChunk returns a PNG chunk with a valid CRC.
+func pngChunk(typ string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(append(chunk, typ...), data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// taggedJPEG is a JPEG carrying EXIF, XMP, an ICC profile and a comment.
+func taggedJPEG(t *testing.T, tiff []byte) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, photo(16, 12), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	var out []byte
+	out = append(out, data[:2]...)
+	out = append(out, jpegSegment(0xE1, append([]byte("Exif\x00\x00"), tiff...))...)
+	out = append(out, jpegSegment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>"))...)
+	out = append(out, jpegSegment(0xE2, []byte("ICC_PROFILE\x00\x01\x01profile"))...)
+	out = append(out, jpegSegment(0xFE, []byte("taken at home"))...)
+	return append(out, data[2:]...)
+}
+
+// taggedPNG is a PNG carrying EXIF, a text chunk and a modification time.
+func taggedPNG(t *testing.T, tiff []byte) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, photo(16, 12)); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	at := bytes.Index(data, []byte("IDAT")) - 4
+	var out []byte
+	out = append(out, data[:at]...)
+	out = append(out, pngChunk("eXIf", tiff)...)
+	out = append(out, pngChunk("tEXt", []byte("Author\x00Jane"))...)
+	out = append(out, pngChunk("tIME", []byte{0x07, 0xE8, 5, 1, 12, 0, 0})...)
+	return append(out, data[at:]...)
+}
+
+func TestReadMetadata(t *testing.T) {
+	tiff := camera(binary.BigEndian, 6, false)
+	fields := []string{"Make", "Model", "Orientation", "Copyright", "DateTimeOriginal", "GPSLatitudeRef"}
+	for _, tt := range []struct {
+		contentType string
+		data        []byte
+		want        []string
+	}{
+		{"image/jpeg", taggedJPEG(t, tiff), append(fields, "XMP", "ICCProfile", "Comment")},
+		{"image/png", taggedPNG(t, tiff), append(fields, "PNG:Author", "PNG:tIME")},
+	} {
+		m := ReadMetadata(tt.data, tt.contentType)
+		if m.Orientation != 6 {
+			t.Errorf("%s: orientation %d", tt.contentType, m.Orientation)
+		}
+		if !reflect.DeepEqual(m.Fields, tt.want) {
+			t.Errorf("%s: fields %v, want %v", tt.contentType, m.Fields, tt.want)
+		}
+	}
+}
+
+// Re-encoding from pixels, as every stored file is, drops all metadata.
+func TestReencodeStripsMetadata(t *testing.T) {
+	tiff := camera(binary.LittleEndian, 6, true)
+
+	img, err := jpeg.Decode(bytes.NewReader(taggedJPEG(t, tiff)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if m := ReadMetadata(buf.Bytes(), "image/jpeg"); m.Exif != nil || len(m.Fields) > 0 || m.Orientation != 1 {
+		t.Errorf("JPEG kept %v", m.Fields)
+	}
+
+	img, err = png.Decode(bytes.NewReader(taggedPNG(t, tiff)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	if m := ReadMetadata(buf.Bytes(), "image/png"); m.Exif != nil || len(m.Fields) > 0 || m.Orientation != 1 {
+		t.Errorf("PNG kept %v", m.Fields)
+	}
+}
+
+func TestEmbedExif(t *testing.T) {
+	x, err := ParseExif(camera(binary.BigEndian, 6, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := x.Keep(map[string]bool{"Copyright": true, "DateTimeOriginal": true}).Encode()
+
+	var jpg, pngData bytes.Buffer
+	if err := jpeg.Encode(&jpg, photo(16, 12), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(&pngData, photo(16, 12)); err != nil {
+		t.Fatal(err)
+	}
+	decoders := map[string]func([]byte) (image.Image, error){
+		"image/jpeg": func(b []byte) (image.Image, error) { return jpeg.Decode(bytes.NewReader(b)) },
+		"image/png":  func(b []byte) (image.Image, error) { return png.Decode(bytes.NewReader(b)) },
+	}
+	for contentType, data := range map[string][]byte{"image/jpeg": jpg.Bytes(), "image/png": pngData.Bytes()} {
+		out := EmbedExif(data, contentType, kept)
+		if _, err := decoders[contentType](out); err != nil {
+			t.Fatalf("%s no longer decodes: %v", contentType, err)
+		}
+		m := ReadMetadata(out, contentType)
+		if want := []string{"Copyright", "DateTimeOriginal"}; !reflect.DeepEqual(m.Fields, want) {
+			t.Errorf("%s: fields %v, want %v", contentType, m.Fields, want)
+		}
+		if m.Orientation != 1 {
+			t.Errorf("%s: orientation %d", contentType, m.Orientation)
+		}
+
+		if got := EmbedExif(data, contentType, nil); !bytes.Equal(got, data) {
+			t.Errorf("%s: changed by an empty block", contentType)
+		}
+	}
+
+	// Formats without a place for EXIF, and blocks too large for a JPEG
+	// segment, leave the file as it was
+	gifData := []byte("GIF89a")
+	if got := EmbedExif(gifData, "image/gif", kept); !bytes.Equal(got, gifData) {
+		t.Error("GIF changed")
+	}
+	if got := EmbedExif(jpg.Bytes(), "image/jpeg", make([]byte, 0x10000)); !bytes.Equal(got, jpg.Bytes()) {
+		t.Error("oversized block embedded")
+	}
+}
+
+// Truncated or corrupted files must never panic, whatever their type claims.
+func TestReadMetadataMalformed(t *testing.T) {
+	tiff := camera(binary.LittleEndian, 3, true)
+	webp := []byte("RIFF\x00\x00\x00\x00WEBP")
+	webp = append(webp, "EXIF"...)
+	webp = binary.LittleEndian.AppendUint32(webp, uint32(len(tiff)))
+	webp = append(webp, tiff...)
+	gif := []byte("GIF89a\x01\x00\x01\x00\x80\x00\x00\x00\x00\x00\xff\xff\xff" +
+		"\x21\xfe\x03abc\x00" +
+		"\x21\xff\x0bXMP DataXMP\x01x\x00" +
+		"\x2c\x00\x00\x00\x00\x01\x00\x01\x00\x00\x02\x02\x44\x01\x00\x3b")
+
+	files := map[string][]byte{
+		"image/jpeg": taggedJPEG(t, tiff),
+		"image/png":  taggedPNG(t, tiff),
+		"image/gif":  gif,
+		"image/webp": webp,
+	}
+	for contentType, data := range files {
+		if m := ReadMetadata(data, contentType); len(m.Fields) == 0 {
+			t.Errorf("%s: no metadata found in the intact file", contentType)
+		}
+		for n := range data {
+			ReadMetadata(data[:n], contentType)
+		}
+		for i := range data {
+			for _, v := range []byte{0, 0x7F, 0xFF} {
+				corrupt := append([]byte(nil), data...)
+				corrupt[i] = v
+				ReadMetadata(corrupt, contentType)
+			}
+		}
+	}
+
+	// A broken EXIF block is reported as such rather than failing the read
+	broken := append([]byte{0xFF, 0xD8}, jpegSegment(0xE1, []byte("Exif\x00\x00MM\x00\x2b"))...)
+	if m := ReadMetadata(broken, "image/jpeg"); m.Orientation != 1 || !reflect.DeepEqual(m.Fields, []string{"EXIF"}) {
+		t.Errorf("broken EXIF: orientation %d, fields %v", m.Orientation, m.Fields)
+	}
+}
@@ -0,0 +1,64 @@
+package imaging
+
+import "image"
+
+// ToRGBA converts src into a premultiplied RGBA image with bounds at (0, 0).
+func ToRGBA(src image.Image) *image.RGBA {
+	sb := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, sb.Dx(), sb.Dy()))
+	reader := newRowReader(src)
+	row := make([]float32, sb.Dx()*4)
+	for y := 0; y < sb.Dy(); y++ {
+		reader.read(y, row)
+		out := dst.Pix[y*dst.Stride : y*dst.Stride+sb.Dx()*4]
+		for i, v := range row {
+			out[i] = clamp8(v)
+		}
+	}
+	return dst
+}
+
+// Orient returns src transformed so that an image tagged with the given EXIF
+// orientation displays upright. Orientation 1 (or any invalid value) returns
+// src unchanged.
+func Orient(src image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	s := ToRGBA(src)
+	w, h := s.Rect.Dx(), s.Rect.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	// srcAt maps a destination pixel to the source pixel it shows.
+	var srcAt func(x, y int) (int, int)
+	switch orientation {
+	case 2: // mirrored horizontally
+		srcAt = func(x, y int) (int, int) { return w - 1 - x, y }
+	case 3: // rotated 180
+		srcAt = func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }
+	case 4: // mirrored vertically
+		srcAt = func(x, y int) (int, int) { return x, h - 1 - y }
+	case 5: // transposed
+		srcAt = func(x, y int) (int, int) { return y, x }
+	case 6: // rotated 90 clockwise to display
+		srcAt = func(x, y int) (int, int) { return y, h - 1 - x }
+	case 7: // transversed
+		srcAt = func(x, y int) (int, int) { return w - 1 - y, h - 1 - x }
+	case 8: // rotated 90 counter-clockwise to display
+		srcAt = func(x, y int) (int, int) { return w - 1 - y, x }
+	}
+
+	for y := 0; y < dh; y++ {
+		out := dst.Pix[y*dst.Stride:]
+		for x := 0; x < dw; x++ {
+			sx, sy := srcAt(x, y)
+			in := s.Pix[sy*s.Stride+sx*4:]
+			copy(out[x*4:x*4+4], in[:4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,62 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestOrient(t *testing.T) {
+	// A 3x2 source with a distinct colour per pixel
+	src := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{uint8(x * 100), uint8(y * 100), 50, 255})
+		}
+	}
+	topLeft, topRight, bottomLeft := src.NRGBAAt(0, 0), src.NRGBAAt(2, 0), src.NRGBAAt(0, 1)
+
+	// Where the source's corners end up once displayed upright
+	tests := []struct {
+		orientation int
+		size        image.Point
+		tl, tr, bl  image.Point
+	}{
+		{2, image.Pt(3, 2), image.Pt(2, 0), image.Pt(0, 0), image.Pt(2, 1)},
+		{3, image.Pt(3, 2), image.Pt(2, 1), image.Pt(0, 1), image.Pt(2, 0)},
+		{4, image.Pt(3, 2), image.Pt(0, 1), image.Pt(2, 1), image.Pt(0, 0)},
+		{5, image.Pt(2, 3), image.Pt(0, 0), image.Pt(0, 2), image.Pt(1, 0)},
+		{6, image.Pt(2, 3), image.Pt(1, 0), image.Pt(1, 2), image.Pt(0, 0)},
+		{7, image.Pt(2, 3), image.Pt(1, 2), image.Pt(1, 0), image.Pt(0, 2)},
+		{8, image.Pt(2, 3), image.Pt(0, 2), image.Pt(0, 0), image.Pt(1, 2)},
+	}
+	at := func(img image.Image, p image.Point) color.NRGBA {
+		return color.NRGBAModel.Convert(img.At(p.X, p.Y)).(color.NRGBA)
+	}
+	for _, tt := range tests {
+		dst := Orient(src, tt.orientation)
+		if got := dst.Bounds().Size(); got != tt.size {
+			t.Errorf("orientation %d: size %v, want %v", tt.orientation, got, tt.size)
+			continue
+		}
+		if at(dst, tt.tl) != topLeft || at(dst, tt.tr) != topRight || at(dst, tt.bl) != bottomLeft {
+			t.Errorf("orientation %d: corners moved wrongly", tt.orientation)
+		}
+		// Every source pixel is shown exactly once
+		seen := map[color.NRGBA]int{}
+		for y := 0; y < tt.size.Y; y++ {
+			for x := 0; x < tt.size.X; x++ {
+				seen[at(dst, image.Pt(x, y))]++
+			}
+		}
+		if len(seen) != 6 {
+			t.Errorf("orientation %d: %d distinct pixels, want 6", tt.orientation, len(seen))
+		}
+	}
+
+	for _, o := range []int{1, 0, 9, -1} {
+		if dst := Orient(src, o); dst != image.Image(src) {
+			t.Errorf("orientation %d changed the image", o)
+		}
+	}
+}
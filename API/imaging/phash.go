@@ -0,0 +1,98 @@
+package imaging
+
+import (
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// PHash returns the 64-bit perceptual hash of img: the signs, relative to
+// their median, of the lowest 8x8 frequencies of the DCT of a 32x32
+// greyscale copy. It survives resizing, recompression and small colour
+// changes.
+func PHash(img image.Image) uint64 {
+	const n = 32
+	lum := luminance(img, n, n)
+
+	// Separable DCT-II, rows then columns; only the first 8 coefficients
+	// of each are needed.
+	var cos [8][n]float64
+	for k := 0; k < 8; k++ {
+		for i := 0; i < n; i++ {
+			cos[k][i] = math.Cos(math.Pi / n * (float64(i) + 0.5) * float64(k))
+		}
+	}
+	var rows [n][8]float64
+	for y := 0; y < n; y++ {
+		for k := 0; k < 8; k++ {
+			var s float64
+			for x := 0; x < n; x++ {
+				s += lum[y*n+x] * cos[k][x]
+			}
+			rows[y][k] = s
+		}
+	}
+	var coef [64]float64
+	for ky := 0; ky < 8; ky++ {
+		for kx := 0; kx < 8; kx++ {
+			var s float64
+			for y := 0; y < n; y++ {
+				s += rows[y][kx] * cos[ky][y]
+			}
+			coef[ky*8+kx] = s
+		}
+	}
+
+	// The DC term only reflects overall brightness and is left out of the
+	// median.
+	sorted := append([]float64(nil), coef[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[31] + sorted[32]) / 2
+
+	var hash uint64
+	for i, c := range coef {
+		if c > median {
+			hash |= 1 << (63 - i)
+		}
+	}
+	return hash
+}
+
+// DHash returns the 64-bit difference hash of img: whether each pixel of a
+// 9x8 greyscale copy is brighter than its right neighbour. It is cheaper
+// and more sensitive to local changes than PHash.
+func DHash(img image.Image) uint64 {
+	lum := luminance(img, 9, 8)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if lum[y*9+x] > lum[y*9+x+1] {
+				hash |= 1 << (63 - (y*8 + x))
+			}
+		}
+	}
+	return hash
+}
+
+// HammingDistance is the number of bits that differ between two hashes.
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// luminance resizes img to w x h and returns the luma of every pixel, with
+// transparent areas composited onto white so a cut-out and the same image
+// on a white background hash alike.
+func luminance(img image.Image, w, h int) []float64 {
+	small := Resize(img, w, h, Bilinear)
+	out := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		row := small.Pix[y*small.Stride:]
+		for x := 0; x < w; x++ {
+			p := row[x*4:]
+			white := 255 - float64(p[3])
+			out[y*w+x] = 0.299*(float64(p[0])+white) + 0.587*(float64(p[1])+white) + 0.114*(float64(p[2])+white)
+		}
+	}
+	return out
+}
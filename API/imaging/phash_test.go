@@ -0,0 +1,165 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"math"
+	"testing"
+)
+
+// hashDistance is the default IMAGE_HASH_DISTANCE.
+const hashDistance = 10
+
+// The hashes of scene(256, 192, 1). They are pinned so that any change to
+// the hashing, which would make stored hashes and bans incomparable with new
+// ones, is deliberate.
+const (
+	pinnedPHash = 0xa9d22f07f178658a
+	pinnedDHash = 0x0000406030383020
+)
+
+// scene returns a w x h picture of soft discs on a gradient, seeded so that
+// different seeds give unrelated pictures.
+func scene(w, h int, seed int) *image.NRGBA {
+	type disc struct{ x, y, r, v float64 }
+	var discs []disc
+	s := uint32(seed*2654435761 + 1)
+	next := func() float64 {
+		s ^= s << 13
+		s ^= s >> 17
+		s ^= s << 5
+		return float64(s%1000) / 1000
+	}
+	for i := 0; i < 6; i++ {
+		discs = append(discs, disc{next(), next(), 0.1 + 0.25*next(), 255 * next()})
+	}
+	tilt := next()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			fx, fy := float64(x)/float64(w), float64(y)/float64(h)
+			v := 60 + 80*(tilt*fx+(1-tilt)*fy)
+			for _, d := range discs {
+				if math.Hypot(fx-d.x, fy-d.y) < d.r {
+					v = (v + d.v) / 2
+				}
+			}
+			c := uint8(v)
+			img.SetNRGBA(x, y, color.NRGBA{c, c / 2, 255 - c, 255})
+		}
+	}
+	return img
+}
+
+func bothDistances(a, b image.Image) (int, int) {
+	return HammingDistance(PHash(a), PHash(b)), HammingDistance(DHash(a), DHash(b))
+}
+
+func TestHammingDistance(t *testing.T) {
+	for _, tt := range []struct {
+		a, b uint64
+		want int
+	}{
+		{0, 0, 0},
+		{0, 1, 1},
+		{0, math.MaxUint64, 64},
+		{0xF0F0, 0x0F0F, 16},
+		{1 << 63, 1, 2},
+	} {
+		if got := HammingDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("HammingDistance(%x, %x) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestDHashGradients(t *testing.T) {
+	// Brightness rising to the right never beats its right neighbour, and
+	// falling always does.
+	rising := image.NewGray(image.Rect(0, 0, 90, 40))
+	falling := image.NewGray(image.Rect(0, 0, 90, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 90; x++ {
+			rising.SetGray(x, y, color.Gray{uint8(x * 2)})
+			falling.SetGray(x, y, color.Gray{uint8(255 - x*2)})
+		}
+	}
+	if got := DHash(rising); got != 0 {
+		t.Errorf("DHash(rising) = %016x", got)
+	}
+	if got := DHash(falling); got != math.MaxUint64 {
+		t.Errorf("DHash(falling) = %016x", got)
+	}
+}
+
+func TestPHashValues(t *testing.T) {
+	img := scene(256, 192, 1)
+	if got, want := PHash(img), uint64(pinnedPHash); got != want {
+		t.Errorf("PHash = %016x, want %016x", got, want)
+	}
+	if got, want := DHash(img), uint64(pinnedDHash); got != want {
+		t.Errorf("DHash = %016x, want %016x", got, want)
+	}
+
+	// Inverting the picture negates every AC coefficient, which flips every
+	// bit but the DC one.
+	inv := image.NewNRGBA(img.Rect)
+	for i, v := range img.Pix {
+		if i%4 == 3 {
+			inv.Pix[i] = v
+		} else {
+			inv.Pix[i] = 255 - v
+		}
+	}
+	if d := HammingDistance(PHash(img), PHash(inv)); d < 60 {
+		t.Errorf("inverted picture at distance %d", d)
+	}
+}
+
+func TestHashesMatchNearDuplicates(t *testing.T) {
+	src := scene(400, 300, 7)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: 40}); err != nil {
+		t.Fatal(err)
+	}
+	recompressed, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	brighter := image.NewNRGBA(src.Rect)
+	for i, v := range src.Pix {
+		brighter.Pix[i] = uint8(min(int(v)+12, 255))
+	}
+	// A cut-out hashes like the same picture on white
+	cutout := image.NewNRGBA(src.Rect)
+	onWhite := image.NewNRGBA(src.Rect)
+	draw.Draw(onWhite, onWhite.Rect, image.NewUniform(color.White), image.Point{}, draw.Src)
+	inner := image.Rect(60, 40, 340, 260)
+	draw.Draw(cutout, inner, src, inner.Min, draw.Src)
+	draw.Draw(onWhite, inner, src, inner.Min, draw.Src)
+	if p, d := bothDistances(cutout, onWhite); p > hashDistance || d > hashDistance {
+		t.Errorf("cut-out: pHash distance %d, dHash distance %d", p, d)
+	}
+
+	for name, img := range map[string]image.Image{
+		"recompressed": recompressed,
+		"resized":      Resize(src, 123, 92, CatmullRom),
+		"enlarged":     Resize(src, 800, 600, Bilinear),
+		"brighter":     brighter,
+	} {
+		p, d := bothDistances(src, img)
+		if p > hashDistance || d > hashDistance {
+			t.Errorf("%s: pHash distance %d, dHash distance %d", name, p, d)
+		}
+	}
+
+	for seed := 8; seed < 14; seed++ {
+		p, d := bothDistances(src, scene(400, 300, seed))
+		if max(p, d) <= hashDistance {
+			t.Errorf("unrelated scene %d: pHash distance %d, dHash distance %d", seed, p, d)
+		}
+	}
+}
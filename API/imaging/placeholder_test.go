@@ -0,0 +1,88 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"testing"
+)
+
+// gradient is a fixed 32x24 test picture: red grows to the right, green
+// downwards and blue shrinks along the diagonal.
+func gradient() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 32, 24))
+	for y := 0; y < 24; y++ {
+		for x := 0; x < 32; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 8), uint8(y * 10), uint8(255 - (x*4 + y*3)), 255})
+		}
+	}
+	return img
+}
+
+func TestBlurHash(t *testing.T) {
+	// Reference hashes of gradient() computed independently, following the
+	// encoder published at https://blurha.sh.
+	for _, tt := range []struct {
+		x, y int
+		want string
+	}{
+		{4, 3, "LxH2812yw#XAmLWZjuf8gLfkfQfk"},
+		{1, 1, "00H281"},
+		{9, 9, "|xH2812yw#XAa~ogWrogWrmLWZjuf8fRf8fRf8fRgLfkfQfkfQfjfQfjfQn-WrjufRfRfRfRfRfRe?fRfQfQfQfQfQfQfQogWrjufRfRfRfQfRfQe?fRfQfQfQfQfQfQfQogWrjufRfRfRfQfRfQesfRfQfQfQfQfQfQfQ"},
+	} {
+		got := BlurHash(gradient(), tt.x, tt.y)
+		if got != tt.want {
+			t.Errorf("%dx%d components: got %q, want %q", tt.x, tt.y, got, tt.want)
+		}
+		// One size character, one for the AC range, four for the DC colour
+		// and two per AC component
+		if len(got) != 4+2*tt.x*tt.y {
+			t.Errorf("%dx%d components: length %d", tt.x, tt.y, len(got))
+		}
+		if size := strings.IndexByte(base83Chars, got[0]); size%9+1 != tt.x || size/9+1 != tt.y {
+			t.Errorf("%dx%d components: size character %q", tt.x, tt.y, got[0])
+		}
+	}
+
+	// Component counts are clamped to 1-9
+	if got := BlurHash(gradient(), 0, 12); len(got) != 4+2*9 || got[0] != base83Chars[8*9] {
+		t.Errorf("clamped components: %q", got)
+	}
+
+	// Transparent areas are blended onto white
+	white := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	draw.Draw(white, white.Rect, image.NewUniform(color.White), image.Point{}, draw.Src)
+	if a, b := BlurHash(image.NewNRGBA(white.Rect), 3, 3), BlurHash(white, 3, 3); a != b {
+		t.Errorf("transparent %q, white %q", a, b)
+	}
+
+	if got := BlurHash(image.NewNRGBA(image.Rect(0, 0, 0, 0)), 4, 3); got != "" {
+		t.Errorf("empty image: %q", got)
+	}
+}
+
+func TestDominantColor(t *testing.T) {
+	red := color.RGBA{200, 30, 30, 255}
+	blue := color.RGBA{20, 40, 210, 255}
+	img := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	draw.Draw(img, img.Rect, image.NewUniform(blue), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, 28, 30), image.NewUniform(red), image.Point{}, draw.Src)
+	c, ok := DominantColor(img)
+	if !ok || c != red {
+		t.Errorf("got %v, %v, want %v", c, ok, red)
+	}
+	if got := HexColor(c); got != "#c81e1e" {
+		t.Errorf("HexColor = %q", got)
+	}
+
+	// Transparent pixels do not count, however many there are
+	sparse := image.NewNRGBA(image.Rect(0, 0, 40, 30))
+	draw.Draw(sparse, image.Rect(0, 0, 5, 5), image.NewUniform(blue), image.Point{}, draw.Src)
+	if c, ok := DominantColor(sparse); !ok || c != blue {
+		t.Errorf("mostly transparent: got %v, %v, want %v", c, ok, blue)
+	}
+	if _, ok := DominantColor(image.NewNRGBA(image.Rect(0, 0, 10, 10))); ok {
+		t.Error("fully transparent image has a dominant colour")
+	}
+}
@@ -0,0 +1,222 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// Histogram counts the opaque colours of one or more images for MedianCut.
+// Colours are kept exactly while there are few of them, so images with a
+// small palette (most GIFs) quantise without loss, and in 15-bit bins
+// otherwise.
+type Histogram struct {
+	limit int
+	exact map[uint32]int // nil once more than limit colours were seen
+	bins  []colorBin
+}
+
+type colorBin struct {
+	n       uint64
+	r, g, b uint64 // sums of the 8-bit components
+}
+
+// NewHistogram returns a histogram that can reproduce up to limit colours
+// exactly.
+func NewHistogram(limit int) *Histogram {
+	return &Histogram{limit: limit, exact: map[uint32]int{}, bins: make([]colorBin, 1<<15)}
+}
+
+// Add counts the pixels of img that are at least half opaque. Large images
+// are sampled on a grid so the cost stays bounded.
+func (h *Histogram) Add(img *image.RGBA) {
+	b := img.Bounds()
+	step := 1
+	for (b.Dx()/step)*(b.Dy()/step) > 512*512 {
+		step++
+	}
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		row := img.Pix[img.PixOffset(b.Min.X, y):]
+		for x := 0; x < b.Dx(); x += step {
+			r, g, bl, ok := straight(row[x*4:])
+			if !ok {
+				continue
+			}
+			if h.exact != nil {
+				h.exact[uint32(r)<<16|uint32(g)<<8|uint32(bl)]++
+				if len(h.exact) > h.limit {
+					h.exact = nil
+				}
+			}
+			bin := &h.bins[int(r>>3)<<10|int(g>>3)<<5|int(bl>>3)]
+			bin.n++
+			bin.r += uint64(r)
+			bin.g += uint64(g)
+			bin.b += uint64(bl)
+		}
+	}
+}
+
+// straight returns the non-premultiplied colour of an RGBA pixel, and false
+// when it is less than half opaque.
+func straight(p []uint8) (r, g, b uint8, ok bool) {
+	a := uint32(p[3])
+	if a < 128 {
+		return 0, 0, 0, false
+	}
+	if a == 255 {
+		return p[0], p[1], p[2], true
+	}
+	return uint8(uint32(p[0]) * 255 / a), uint8(uint32(p[1]) * 255 / a), uint8(uint32(p[2]) * 255 / a), true
+}
+
+// MedianCut returns a palette of at most n opaque colours for the counted
+// pixels: the exact colours when there are few enough, otherwise the average
+// colours of n boxes obtained by repeatedly splitting the most populated box
+// at the median of its widest channel. The most common colours come first.
+func (h *Histogram) MedianCut(n int) color.Palette {
+	if h.exact != nil && len(h.exact) <= n {
+		keys := make([]uint32, 0, len(h.exact))
+		for k := range h.exact {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return h.exact[keys[i]] > h.exact[keys[j]] })
+		pal := make(color.Palette, len(keys))
+		for i, k := range keys {
+			pal[i] = color.RGBA{uint8(k >> 16), uint8(k >> 8), uint8(k), 255}
+		}
+		return pal
+	}
+
+	var all []int
+	for i, bin := range h.bins {
+		if bin.n > 0 {
+			all = append(all, i)
+		}
+	}
+	if len(all) == 0 {
+		return nil
+	}
+	boxes := []*colorBox{h.newBox(all)}
+	for len(boxes) < n {
+		best := -1
+		for i, b := range boxes {
+			if b.splittable() && (best < 0 || b.n > boxes[best].n) {
+				best = i
+			}
+		}
+		if best < 0 {
+			break
+		}
+		lo, hi := h.split(boxes[best])
+		boxes[best] = lo
+		boxes = append(boxes, hi)
+	}
+
+	sort.Slice(boxes, func(i, j int) bool { return boxes[i].n > boxes[j].n })
+	pal := make(color.Palette, 0, len(boxes))
+	for _, b := range boxes {
+		var r, g, bl uint64
+		for _, i := range b.bins {
+			r += h.bins[i].r
+			g += h.bins[i].g
+			bl += h.bins[i].b
+		}
+		pal = append(pal, color.RGBA{uint8(r / b.n), uint8(g / b.n), uint8(bl / b.n), 255})
+	}
+	return pal
+}
+
+// colorBox is a set of non-empty bins with the range of their 5-bit
+// coordinates.
+type colorBox struct {
+	bins     []int
+	n        uint64
+	min, max [3]int
+}
+
+func binChannel(bin, c int) int {
+	return bin >> (10 - 5*c) & 31
+}
+
+func (h *Histogram) newBox(bins []int) *colorBox {
+	b := &colorBox{bins: bins, min: [3]int{31, 31, 31}}
+	for _, i := range bins {
+		b.n += h.bins[i].n
+		for c := 0; c < 3; c++ {
+			v := binChannel(i, c)
+			b.min[c] = min(b.min[c], v)
+			b.max[c] = max(b.max[c], v)
+		}
+	}
+	return b
+}
+
+func (b *colorBox) splittable() bool {
+	return len(b.bins) > 1
+}
+
+func (b *colorBox) widest() int {
+	c := 0
+	for i := 1; i < 3; i++ {
+		if b.max[i]-b.min[i] > b.max[c]-b.min[c] {
+			c = i
+		}
+	}
+	return c
+}
+
+// split divides a box at the population median of its widest channel.
+func (h *Histogram) split(b *colorBox) (*colorBox, *colorBox) {
+	c := b.widest()
+	sort.Slice(b.bins, func(i, j int) bool { return binChannel(b.bins[i], c) < binChannel(b.bins[j], c) })
+	var acc uint64
+	cut := 1
+	for i, bin := range b.bins[:len(b.bins)-1] {
+		acc += h.bins[bin].n
+		cut = i + 1
+		if acc*2 >= b.n {
+			break
+		}
+	}
+	lo := append([]int(nil), b.bins[:cut]...)
+	return h.newBox(lo), h.newBox(b.bins[cut:])
+}
+
+// paletteMapper finds the nearest palette entry for straight colours,
+// remembering earlier answers.
+type paletteMapper struct {
+	pal   color.Palette
+	rgb   [][3]int32
+	first int // index of the first opaque entry
+	cache map[uint32]uint8
+}
+
+func newPaletteMapper(pal color.Palette, first int) *paletteMapper {
+	m := &paletteMapper{pal: pal, first: first, cache: map[uint32]uint8{}}
+	m.rgb = make([][3]int32, len(pal))
+	for i, c := range pal {
+		r, g, b, _ := c.RGBA()
+		m.rgb[i] = [3]int32{int32(r >> 8), int32(g >> 8), int32(b >> 8)}
+	}
+	return m
+}
+
+func (m *paletteMapper) index(r, g, b uint8) uint8 {
+	key := uint32(r)<<16 | uint32(g)<<8 | uint32(b)
+	if i, ok := m.cache[key]; ok {
+		return i
+	}
+	best, bestDist := m.first, int32(1<<30)
+	for i := m.first; i < len(m.rgb); i++ {
+		dr, dg, db := m.rgb[i][0]-int32(r), m.rgb[i][1]-int32(g), m.rgb[i][2]-int32(b)
+		if d := dr*dr + dg*dg + db*db; d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	if len(m.cache) > 1<<16 {
+		m.cache = map[uint32]uint8{}
+	}
+	m.cache[key] = uint8(best)
+	return uint8(best)
+}
@@ -0,0 +1,365 @@
+// Package imaging contains the pixel processing used by the upload pipeline.
+// Its functions read typed pixel buffers directly instead of going through
+// image.Image.At/Set, which allocate a color.Color per pixel.
+package imaging
+
+import (
+	"image"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// weights holds the contribution of a run of source pixels to one
+// destination pixel.
+type weights struct {
+	start  int
+	coeffs []float32
+}
+
+// computeWeights precomputes the kernel taps mapping srcN samples onto dstN.
+// When downscaling the kernel is stretched so every source pixel contributes.
+func computeWeights(srcN, dstN int, f Filter) []weights {
+	scale := float64(srcN) / float64(dstN)
+	fscale := math.Max(scale, 1)
+	support := f.Support * fscale
+
+	out := make([]weights, dstN)
+	for i := range out {
+		center := (float64(i) + 0.5) * scale
+		start := int(math.Floor(center - support))
+		end := int(math.Ceil(center + support))
+		if start < 0 {
+			start = 0
+		}
+		if end > srcN {
+			end = srcN
+		}
+		coeffs := make([]float32, 0, end-start)
+		var sum float64
+		for j := start; j < end; j++ {
+			w := f.Kernel((float64(j) + 0.5 - center) / fscale)
+			coeffs = append(coeffs, float32(w))
+			sum += w
+		}
+		if sum != 0 {
+			for k := range coeffs {
+				coeffs[k] = float32(float64(coeffs[k]) / sum)
+			}
+		}
+		out[i] = weights{start: start, coeffs: coeffs}
+	}
+	return out
+}
+
+// rowSource yields rows of float samples: premultiplied RGBA, four per
+// pixel, or for opaque YCbCr sources Y, Cb and Cr, three per pixel. read is
+// safe to call from several goroutines as long as each passes its own out
+// slice.
+type rowSource interface {
+	size() (w, h int)
+	ycc() bool
+	read(y int, out []float32)
+}
+
+// Resize scales src to w x h with the given filter. The result is
+// premultiplied RGBA, which keeps edges of transparent images free of halos.
+func Resize(src image.Image, w, h int, f Filter) *image.RGBA {
+	sb := src.Bounds()
+	if w <= 0 || h <= 0 || sb.Empty() {
+		return image.NewRGBA(image.Rect(0, 0, max(w, 0), max(h, 0)))
+	}
+
+	// Large reductions read the source through a box average of k x k
+	// blocks, so the filter only has to cover the remaining reduceGap or
+	// more.
+	var rows rowSource = newRowReader(src)
+	scale := math.Min(float64(sb.Dx())/float64(w), float64(sb.Dy())/float64(h))
+	if k := int(scale / reduceGap); k >= 2 {
+		rows = newBoxRows(src, k)
+	}
+	return resizeRows(rows, w, h, f)
+}
+
+// resizeRows scales the rows of a source to w x h with the given filter.
+func resizeRows(rows rowSource, w, h int, f Filter) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	sw, sh := rows.size()
+	hw := computeWeights(sw, w, f)
+	vw := computeWeights(sh, h, f)
+
+	// Rows are split into bands processed in parallel. Each band keeps its
+	// own cache of horizontally filtered source rows.
+	bands := runtime.GOMAXPROCS(0)
+	if bands > h {
+		bands = h
+	}
+	per := (h + bands - 1) / bands
+	var wg sync.WaitGroup
+	for y0 := 0; y0 < h; y0 += per {
+		y1 := y0 + per
+		if y1 > h {
+			y1 = h
+		}
+		wg.Add(1)
+		go func(y0, y1 int) {
+			defer wg.Done()
+			resizeBand(dst, rows, hw, vw, y0, y1)
+		}(y0, y1)
+	}
+	wg.Wait()
+	return dst
+}
+
+func resizeBand(dst *image.RGBA, rows rowSource, hw, vw []weights, y0, y1 int) {
+	w := dst.Rect.Dx()
+
+	// The vertical window only moves forward, so a ring of filtered rows as
+	// tall as the widest window is enough.
+	span := 0
+	for _, v := range vw[y0:y1] {
+		if len(v.coeffs) > span {
+			span = len(v.coeffs)
+		}
+	}
+	// YCbCr rows are filtered as such and only converted to RGB once
+	// filtered, which saves the alpha channel and most conversions.
+	ycc := rows.ycc()
+	ch := 4
+	if ycc {
+		ch = 3
+	}
+	ring := make([][]float32, span)
+	tags := make([]int, span)
+	for i := range ring {
+		ring[i] = make([]float32, w*ch)
+		tags[i] = -1
+	}
+	sw, _ := rows.size()
+	srcRow := make([]float32, sw*ch)
+	taps := make([][]float32, span)
+
+	row := func(sy int) []float32 {
+		slot := sy % span
+		if tags[slot] != sy {
+			rows.read(sy, srcRow)
+			if ycc {
+				filterRow3(ring[slot], srcRow, hw)
+			} else {
+				filterRow(ring[slot], srcRow, hw)
+			}
+			tags[slot] = sy
+		}
+		return ring[slot]
+	}
+
+	for dy := y0; dy < y1; dy++ {
+		v := vw[dy]
+		taps := taps[:len(v.coeffs)]
+		for k := range taps {
+			taps[k] = row(v.start + k)
+		}
+		out := dst.Pix[dy*dst.Stride : dy*dst.Stride+w*4]
+		if ycc {
+			for x := 0; x < w; x++ {
+				var yy, cb, cr float32
+				for k, c := range v.coeffs {
+					p := taps[k][x*3 : x*3+3 : x*3+3]
+					yy += p[0] * c
+					cb += p[1] * c
+					cr += p[2] * c
+				}
+				r, g, b := yCbCrToRGBf(yy, cb, cr)
+				o := out[x*4 : x*4+4 : x*4+4]
+				o[0], o[1], o[2], o[3] = uint8(r+0.5), uint8(g+0.5), uint8(b+0.5), 255
+			}
+			continue
+		}
+		for x := 0; x < w; x++ {
+			var r, g, b, a float32
+			for k, c := range v.coeffs {
+				p := taps[k][x*4 : x*4+4 : x*4+4]
+				r += p[0] * c
+				g += p[1] * c
+				b += p[2] * c
+				a += p[3] * c
+			}
+			o := out[x*4 : x*4+4 : x*4+4]
+			o[3] = clamp8(a)
+			o[0] = min(clamp8(r), o[3])
+			o[1] = min(clamp8(g), o[3])
+			o[2] = min(clamp8(b), o[3])
+		}
+	}
+}
+
+// filterRow3 applies the horizontal taps to one YCbCr source row.
+func filterRow3(dst, src []float32, hw []weights) {
+	for x, wt := range hw {
+		var y, cb, cr float32
+		s := src[wt.start*3:]
+		for i, c := range wt.coeffs {
+			p := s[i*3 : i*3+3 : i*3+3]
+			y += p[0] * c
+			cb += p[1] * c
+			cr += p[2] * c
+		}
+		d := dst[x*3 : x*3+3 : x*3+3]
+		d[0], d[1], d[2] = y, cb, cr
+	}
+}
+
+// filterRow applies the horizontal taps to one premultiplied source row.
+func filterRow(dst, src []float32, hw []weights) {
+	for x, wt := range hw {
+		var r, g, b, a float32
+		s := src[wt.start*4:]
+		for i, c := range wt.coeffs {
+			p := s[i*4 : i*4+4 : i*4+4]
+			r += p[0] * c
+			g += p[1] * c
+			b += p[2] * c
+			a += p[3] * c
+		}
+		d := dst[x*4 : x*4+4 : x*4+4]
+		d[0], d[1], d[2], d[3] = r, g, b, a
+	}
+}
+
+func clamp8(v float32) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
+
+// rowReader converts one source row at a time into premultiplied float
+// samples, with fast paths for the buffer types produced by the std decoders.
+type rowReader struct {
+	src     image.Image
+	bounds  image.Rectangle
+	width   int
+	palette [][4]float32
+}
+
+func newRowReader(src image.Image) *rowReader {
+	rr := &rowReader{src: src, bounds: src.Bounds(), width: src.Bounds().Dx()}
+	if p, ok := src.(*image.Paletted); ok {
+		rr.palette = make([][4]float32, 256)
+		for i, c := range p.Palette {
+			r, g, b, a := c.RGBA()
+			rr.palette[i] = [4]float32{float32(r >> 8), float32(g >> 8), float32(b >> 8), float32(a >> 8)}
+		}
+	}
+	return rr
+}
+
+func (rr *rowReader) size() (int, int) {
+	return rr.width, rr.bounds.Dy()
+}
+
+func (rr *rowReader) ycc() bool {
+	return false
+}
+
+// read fills out with row y (relative to the source bounds). It is safe to
+// call from several goroutines as long as each passes its own out slice.
+func (rr *rowReader) read(y int, out []float32) {
+	sy := rr.bounds.Min.Y + y
+	minX := rr.bounds.Min.X
+	w := rr.width
+
+	switch src := rr.src.(type) {
+	case *image.RGBA:
+		pix := src.Pix[src.PixOffset(minX, sy):]
+		for x := 0; x < w*4; x++ {
+			out[x] = float32(pix[x])
+		}
+	case *image.NRGBA:
+		pix := src.Pix[src.PixOffset(minX, sy):]
+		for x := 0; x < w; x++ {
+			a := float32(pix[x*4+3])
+			out[x*4] = float32(pix[x*4]) * a / 255
+			out[x*4+1] = float32(pix[x*4+1]) * a / 255
+			out[x*4+2] = float32(pix[x*4+2]) * a / 255
+			out[x*4+3] = a
+		}
+	case *image.YCbCr:
+		yRow := src.Y[src.YOffset(minX, sy):]
+		cBase := src.COffset(minX, sy)
+		hs := chromaShift(src.SubsampleRatio)
+		for x := 0; x < w; x++ {
+			ci := cBase + ((minX+x)>>hs - minX>>hs)
+			r, g, b := yCbCrToRGB(yRow[x], src.Cb[ci], src.Cr[ci])
+			out[x*4] = r
+			out[x*4+1] = g
+			out[x*4+2] = b
+			out[x*4+3] = 255
+		}
+	case *image.Gray:
+		pix := src.Pix[src.PixOffset(minX, sy):]
+		for x := 0; x < w; x++ {
+			v := float32(pix[x])
+			out[x*4], out[x*4+1], out[x*4+2], out[x*4+3] = v, v, v, 255
+		}
+	case *image.Paletted:
+		pix := src.Pix[src.PixOffset(minX, sy):]
+		for x := 0; x < w; x++ {
+			c := rr.palette[pix[x]]
+			out[x*4], out[x*4+1], out[x*4+2], out[x*4+3] = c[0], c[1], c[2], c[3]
+		}
+	default:
+		for x := 0; x < w; x++ {
+			r, g, b, a := src.At(minX+x, sy).RGBA()
+			out[x*4] = float32(r >> 8)
+			out[x*4+1] = float32(g >> 8)
+			out[x*4+2] = float32(b >> 8)
+			out[x*4+3] = float32(a >> 8)
+		}
+	}
+}
+
+// chromaShift returns log2 of the horizontal chroma subsampling factor.
+func chromaShift(r image.YCbCrSubsampleRatio) uint {
+	switch r {
+	case image.YCbCrSubsampleRatio422, image.YCbCrSubsampleRatio420:
+		return 1
+	case image.YCbCrSubsampleRatio411, image.YCbCrSubsampleRatio410:
+		return 2
+	}
+	return 0
+}
+
+// chromaVShift returns log2 of the vertical chroma subsampling factor.
+func chromaVShift(r image.YCbCrSubsampleRatio) uint {
+	switch r {
+	case image.YCbCrSubsampleRatio420, image.YCbCrSubsampleRatio440, image.YCbCrSubsampleRatio410:
+		return 1
+	}
+	return 0
+}
+
+// yCbCrToRGB is the JFIF conversion used by image/color, inlined here to
+// avoid a function call per pixel.
+func yCbCrToRGB(y, cb, cr uint8) (float32, float32, float32) {
+	yy := int32(y) * 0x10101
+	cb1 := int32(cb) - 128
+	cr1 := int32(cr) - 128
+	r := (yy + 91881*cr1) >> 16
+	g := (yy - 22554*cb1 - 46802*cr1) >> 16
+	b := (yy + 116130*cb1) >> 16
+	return clampChannel(r), clampChannel(g), clampChannel(b)
+}
+
+func clampChannel(v int32) float32 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return float32(v)
+}
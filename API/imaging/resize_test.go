@@ -0,0 +1,155 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// photo returns a w x h 4:2:0 image like the ones the JPEG decoder produces:
+// fine diagonal stripes in luma over smooth colour gradients.
+func photo(w, h int) *image.YCbCr {
+	img := image.NewYCbCr(image.Rect(0, 0, w, h), image.YCbCrSubsampleRatio420)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Y[img.YOffset(x, y)] = uint8((x*7 + y*3) % 256)
+		}
+	}
+	cw, ch := (w+1)/2, (h+1)/2
+	for y := 0; y < ch; y++ {
+		for x := 0; x < cw; x++ {
+			img.Cb[y*img.CStride+x] = uint8(x * 255 / cw)
+			img.Cr[y*img.CStride+x] = uint8(255 - y*255/ch)
+		}
+	}
+	return img
+}
+
+// oldResizeImage is resizeImage as the upload handler had it before this
+// package: nearest-neighbour through image.At and Set.
+func oldResizeImage(src image.Image, w, h int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	sb := src.Bounds()
+	for y := 0; y < h; y++ {
+		sy := sb.Min.Y + int(float64(y)*float64(sb.Dy())/float64(h))
+		for x := 0; x < w; x++ {
+			sx := sb.Min.X + int(float64(x)*float64(sb.Dx())/float64(w))
+			dst.Set(x, y, src.At(sx, sy))
+		}
+	}
+	return dst
+}
+
+func TestResizeKeepsFlatColour(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 97, 61))
+	want := color.NRGBA{R: 200, G: 120, B: 40, A: 255}
+	for y := 0; y < 61; y++ {
+		for x := 0; x < 97; x++ {
+			src.SetNRGBA(x, y, want)
+		}
+	}
+	for _, f := range []Filter{Bilinear, CatmullRom, Lanczos3} {
+		for _, size := range [][2]int{{10, 6}, {40, 25}, {150, 100}} {
+			dst := Resize(src, size[0], size[1], f)
+			if b := dst.Bounds(); b.Dx() != size[0] || b.Dy() != size[1] {
+				t.Fatalf("%s: got %v, want %dx%d", f.Name, b, size[0], size[1])
+			}
+			for y := 0; y < size[1]; y++ {
+				for x := 0; x < size[0]; x++ {
+					if got := dst.RGBAAt(x, y); got != (color.RGBA{R: 200, G: 120, B: 40, A: 255}) {
+						t.Fatalf("%s %dx%d: pixel (%d,%d) = %v", f.Name, size[0], size[1], x, y, got)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestResizeEmpty(t *testing.T) {
+	dst := Resize(image.NewRGBA(image.Rect(0, 0, 0, 0)), 10, 10, CatmullRom)
+	if dst.Bounds().Dx() != 10 || dst.Bounds().Dy() != 10 {
+		t.Fatalf("got %v", dst.Bounds())
+	}
+	if dst := Resize(photo(8, 8), 0, 5, CatmullRom); !dst.Bounds().Empty() {
+		t.Fatalf("got %v", dst.Bounds())
+	}
+}
+
+// gradients returns large sources of each pixel layout Resize reads itself,
+// plus a Gray one for the generic reader, all smooth enough that the box
+// stage should change little.
+func gradients(w, h int) map[string]image.Image {
+	ycc := image.NewYCbCr(image.Rect(0, 0, w, h), image.YCbCrSubsampleRatio420)
+	rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+	nrgba := image.NewNRGBA(image.Rect(0, 0, w, h))
+	gray := image.NewGray(image.Rect(0, 0, w, h))
+	pal := image.NewPaletted(image.Rect(0, 0, w, h), nil)
+	for i := 0; i < 256; i++ {
+		pal.Palette = append(pal.Palette, color.RGBA{uint8(i), uint8(255 - i), 128, 255})
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8(x * 255 / w)
+			u := uint8(y * 255 / h)
+			ycc.Y[ycc.YOffset(x, y)] = v
+			ycc.Cb[ycc.COffset(x, y)] = u
+			ycc.Cr[ycc.COffset(x, y)] = 255 - v
+			rgba.SetRGBA(x, y, color.RGBA{v / 2, u / 2, 64, 128})
+			nrgba.SetNRGBA(x, y, color.NRGBA{v, u, 200, 255 - u/2})
+			gray.SetGray(x, y, color.Gray{v})
+			pal.SetColorIndex(x, y, v)
+		}
+	}
+	return map[string]image.Image{
+		"YCbCr": ycc, "RGBA": rgba, "NRGBA": nrgba, "Gray": gray, "Paletted": pal,
+	}
+}
+
+func TestResizeBoxMatchesFilter(t *testing.T) {
+	for name, src := range gradients(1203, 901) {
+		for _, size := range [][2]int{{150, 112}, {300, 225}} {
+			want := resizeRows(newRowReader(src), size[0], size[1], CatmullRom)
+			got := Resize(src, size[0], size[1], CatmullRom)
+			worst := 0
+			for i := range want.Pix {
+				worst = max(worst, int(want.Pix[i])-int(got.Pix[i]), int(got.Pix[i])-int(want.Pix[i]))
+			}
+			if worst > 6 {
+				t.Errorf("%s %dx%d: box stage changed a sample by %d", name, size[0], size[1], worst)
+			}
+		}
+	}
+}
+
+// The benchmarks scale a 12 MP photo to a 150 px thumbnail and to an 800 px
+// rendition, the two sizes the upload pipeline produces, with each filter and
+// with oldResizeImage for comparison.
+var benchSizes = []struct {
+	name string
+	w, h int
+}{
+	{"thumbnail", 150, 112},
+	{"medium", 800, 600},
+}
+
+func BenchmarkResize(b *testing.B) {
+	src := photo(4000, 3000)
+	for _, s := range benchSizes {
+		b.Run(s.name, func(b *testing.B) {
+			b.Run("oldResizeImage", func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					oldResizeImage(src, s.w, s.h)
+				}
+			})
+			for _, f := range []Filter{Bilinear, CatmullRom, Lanczos3} {
+				b.Run(f.Name, func(b *testing.B) {
+					b.ReportAllocs()
+					for i := 0; i < b.N; i++ {
+						Resize(src, s.w, s.h, f)
+					}
+				})
+			}
+		})
+	}
+}
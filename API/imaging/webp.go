@@ -0,0 +1,693 @@
+package imaging
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// EncodeWebP writes img as a lossless WebP (VP8L) file. The encoder applies
+// the subtract-green and predictor transforms followed by LZ77 and Huffman
+// coding; it does not use colour caches or meta prefix codes, so it trades a
+// few percent of size for a small, dependency-free implementation.
+func EncodeWebP(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width < 1 || height < 1 || width > 1<<14 || height > 1<<14 {
+		return errors.New("imaging: image size not supported by webp")
+	}
+
+	argb, hasAlpha := toARGB(img)
+	subtractGreen(argb)
+	modes, tileW := predict(argb, width, height)
+
+	bw := &bitWriter{}
+	bw.write(0x2f, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	if hasAlpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	bw.write(0, 3) // version
+
+	// Transforms are listed in the order the encoder applied them; the
+	// decoder undoes them in reverse.
+	bw.write(1, 1)
+	bw.write(transformSubtractGreen, 2)
+	bw.write(1, 1)
+	bw.write(transformPredictor, 2)
+	bw.write(predictorBits-2, 3)
+	encodeEntropyImage(bw, modes, tileW, false)
+	bw.write(0, 1) // no more transforms
+
+	encodeEntropyImage(bw, argb, width, true)
+	data := bw.finish()
+
+	pad := len(data) & 1
+	header := make([]byte, 20)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(4+8+len(data)+pad))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(data)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if pad == 1 {
+		_, err := w.Write([]byte{0})
+		return err
+	}
+	return nil
+}
+
+const (
+	transformPredictor     = 0
+	transformSubtractGreen = 2
+
+	// predictorBits is log2 of the predictor tile size.
+	predictorBits = 4
+
+	maxCodeLength = 15
+	// lz77Window limits how far back matches are searched, in pixels.
+	lz77Window   = 1 << 18
+	lz77MaxChain = 24
+	lz77MinMatch = 3
+	lz77MaxMatch = 4096
+	numLiterals  = 256
+	numLengths   = 24
+	numDistances = 40
+	// distanceMapSize is the number of short 2D distance codes that precede
+	// plain linear distances.
+	distanceMapSize = 120
+)
+
+// toARGB converts img into non-premultiplied 0xAARRGGBB pixels.
+func toARGB(img image.Image) ([]uint32, bool) {
+	b := img.Bounds()
+	src, ok := img.(*image.NRGBA)
+	if !ok {
+		src = image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(src, src.Rect, img, b.Min, draw.Src)
+		b = src.Rect
+	}
+	out := make([]uint32, b.Dx()*b.Dy())
+	hasAlpha := false
+	i := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := src.Pix[src.PixOffset(b.Min.X, y):]
+		for x := 0; x < b.Dx(); x++ {
+			p := row[x*4 : x*4+4]
+			if p[3] != 0xff {
+				hasAlpha = true
+			}
+			out[i] = uint32(p[3])<<24 | uint32(p[0])<<16 | uint32(p[1])<<8 | uint32(p[2])
+			i++
+		}
+	}
+	return out, hasAlpha
+}
+
+func subtractGreen(pix []uint32) {
+	for i, p := range pix {
+		g := (p >> 8) & 0xff
+		r := ((p >> 16) - g) & 0xff
+		b := (p - g) & 0xff
+		pix[i] = p&0xff00ff00 | r<<16 | b
+	}
+}
+
+// predict replaces pix with prediction residuals, choosing the best of the
+// 14 VP8L predictors per tile. It returns the tile mode image and its width.
+func predict(pix []uint32, width, height int) ([]uint32, int) {
+	size := 1 << predictorBits
+	tileW := (width + size - 1) / size
+	tileH := (height + size - 1) / size
+	modes := make([]uint32, tileW*tileH)
+	chosen := make([]int, tileW*tileH)
+
+	// Mode selection only reads pix, so tile rows are scored in parallel.
+	var wg sync.WaitGroup
+	rows := make(chan int, tileH)
+	for ty := 0; ty < tileH; ty++ {
+		rows <- ty
+	}
+	close(rows)
+	for n := min(runtime.GOMAXPROCS(0), tileH); n > 0; n-- {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ty := range rows {
+				for tx := 0; tx < tileW; tx++ {
+					best := bestPredictor(pix, width, height, tx*size, ty*size, size)
+					chosen[ty*tileW+tx] = best
+					modes[ty*tileW+tx] = 0xff000000 | uint32(best)<<8
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Residuals are computed from the original pixels, walking backwards so
+	// every prediction still sees unmodified neighbours.
+	for i := len(pix) - 1; i >= 0; i-- {
+		x, y := i%width, i/width
+		var pred uint32
+		switch {
+		case x == 0 && y == 0:
+			pred = 0xff000000
+		case y == 0:
+			pred = pix[i-1]
+		case x == 0:
+			pred = pix[i-width]
+		default:
+			pred = predictor(chosen[(y>>predictorBits)*tileW+x>>predictorBits], pix, i, width)
+		}
+		pix[i] = sub(pix[i], pred)
+	}
+	return modes, tileW
+}
+
+// bestPredictor returns the mode with the smallest residuals over the tile
+// at (x0, y0). Pixels on the first row and column are skipped because the
+// decoder always predicts them from their single neighbour.
+func bestPredictor(pix []uint32, width, height, x0, y0, size int) int {
+	best, bestCost := 0, -1
+	for mode := 0; mode < 14; mode++ {
+		cost := 0
+		for y := max(y0, 1); y < min(y0+size, height); y++ {
+			for x := max(x0, 1); x < min(x0+size, width); x++ {
+				i := y*width + x
+				cost += residualCost(sub(pix[i], predictor(mode, pix, i, width)))
+			}
+			if bestCost >= 0 && cost >= bestCost {
+				break
+			}
+		}
+		if bestCost < 0 || cost < bestCost {
+			best, bestCost = mode, cost
+		}
+	}
+	return best
+}
+
+// predictor computes the VP8L prediction for pixel i (x > 0, y > 0). The
+// top-right neighbour of the last column is the first pixel of the current
+// row, which is exactly what linear indexing yields.
+func predictor(mode int, pix []uint32, i, width int) uint32 {
+	l := pix[i-1]
+	t := pix[i-width]
+	tl := pix[i-width-1]
+	tr := pix[i-width+1]
+	switch mode {
+	case 0:
+		return 0xff000000
+	case 1:
+		return l
+	case 2:
+		return t
+	case 3:
+		return tr
+	case 4:
+		return tl
+	case 5:
+		return average2(average2(l, tr), t)
+	case 6:
+		return average2(l, tl)
+	case 7:
+		return average2(l, t)
+	case 8:
+		return average2(tl, t)
+	case 9:
+		return average2(t, tr)
+	case 10:
+		return average2(average2(l, tl), average2(t, tr))
+	case 11:
+		return selectPredictor(l, t, tl)
+	case 12:
+		return clampAddSubtractFull(l, t, tl)
+	default:
+		return clampAddSubtractHalf(average2(l, t), tl)
+	}
+}
+
+func channel(p uint32, shift uint) int { return int(p>>shift) & 0xff }
+
+func average2(a, b uint32) uint32 {
+	return (((a ^ b) & 0xfefefefe) >> 1) + (a & b)
+}
+
+func selectPredictor(l, t, tl uint32) uint32 {
+	pl, pt := 0, 0
+	for _, s := range []uint{24, 16, 8, 0} {
+		p := channel(l, s) + channel(t, s) - channel(tl, s)
+		pl += abs(p - channel(l, s))
+		pt += abs(p - channel(t, s))
+	}
+	if pl < pt {
+		return l
+	}
+	return t
+}
+
+func clampAddSubtractFull(a, b, c uint32) uint32 {
+	var out uint32
+	for _, s := range []uint{24, 16, 8, 0} {
+		out |= uint32(clampByte(channel(a, s)+channel(b, s)-channel(c, s))) << s
+	}
+	return out
+}
+
+func clampAddSubtractHalf(a, b uint32) uint32 {
+	var out uint32
+	for _, s := range []uint{24, 16, 8, 0} {
+		ca := channel(a, s)
+		out |= uint32(clampByte(ca+(ca-channel(b, s))/2)) << s
+	}
+	return out
+}
+
+func clampByte(v int) int {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return v
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// sub subtracts b from a per channel, modulo 256.
+func sub(a, b uint32) uint32 {
+	ag := (a | 0x00ff00ff) - (b & 0xff00ff00)
+	rb := (a | 0xff00ff00) - (b & 0x00ff00ff)
+	return ag&0xff00ff00 | rb&0x00ff00ff
+}
+
+// residualCost approximates the entropy of a residual by the magnitude of
+// its channels taken as signed bytes.
+func residualCost(p uint32) int {
+	return abs(int(int8(p>>24))) + abs(int(int8(p>>16))) + abs(int(int8(p>>8))) + abs(int(int8(p)))
+}
+
+// token is either a literal pixel or an LZ77 backward reference.
+type token struct {
+	argb   uint32
+	length int // 0 for literals
+	dist   int // distance code
+}
+
+// encodeEntropyImage writes an entropy-coded image: colour cache and meta
+// prefix flags, the five prefix codes and the coded pixels.
+func encodeEntropyImage(bw *bitWriter, pix []uint32, width int, topLevel bool) {
+	bw.write(0, 1) // no colour cache
+	if topLevel {
+		bw.write(0, 1) // single prefix code group
+	}
+
+	tokens := lz77(pix, width)
+	var (
+		green = make([]int, numLiterals+numLengths)
+		red   = make([]int, numLiterals)
+		blue  = make([]int, numLiterals)
+		alpha = make([]int, numLiterals)
+		dist  = make([]int, numDistances)
+	)
+	for _, t := range tokens {
+		if t.length == 0 {
+			green[(t.argb>>8)&0xff]++
+			red[(t.argb>>16)&0xff]++
+			blue[t.argb&0xff]++
+			alpha[t.argb>>24]++
+			continue
+		}
+		lp, _, _ := prefixEncode(t.length)
+		green[numLiterals+lp]++
+		dp, _, _ := prefixEncode(t.dist)
+		dist[dp]++
+	}
+
+	codes := make([]*huffmanCode, 5)
+	for i, h := range [][]int{green, red, blue, alpha, dist} {
+		codes[i] = newHuffmanCode(h, maxCodeLength)
+		codes[i].writeTo(bw)
+	}
+
+	for _, t := range tokens {
+		if t.length == 0 {
+			codes[0].writeSymbol(bw, int(t.argb>>8)&0xff)
+			codes[1].writeSymbol(bw, int(t.argb>>16)&0xff)
+			codes[2].writeSymbol(bw, int(t.argb)&0xff)
+			codes[3].writeSymbol(bw, int(t.argb>>24))
+			continue
+		}
+		p, n, extra := prefixEncode(t.length)
+		codes[0].writeSymbol(bw, numLiterals+p)
+		bw.write(extra, n)
+		p, n, extra = prefixEncode(t.dist)
+		codes[4].writeSymbol(bw, p)
+		bw.write(extra, n)
+	}
+}
+
+// prefixEncode splits a length or distance code (>= 1) into its prefix
+// symbol, number of extra bits and extra bits value.
+func prefixEncode(v int) (int, uint, uint32) {
+	d := v - 1
+	if d < 4 {
+		return d, 0, 0
+	}
+	h := 0
+	for d>>(h+1) != 0 {
+		h++
+	}
+	second := (d >> (h - 1)) & 1
+	n := uint(h - 1)
+	return 2*h + second, n, uint32(d & (1<<n - 1))
+}
+
+// lz77 tokenises pix with a hash chain over pixel pairs.
+func lz77(pix []uint32, width int) []token {
+	const hashBits = 16
+	head := make([]int32, 1<<hashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, len(pix))
+	hash := func(i int) uint32 {
+		h := uint64(pix[i])*0x9E3779B97F4A7C15 ^ uint64(pix[i+1])*0xC2B2AE3D27D4EB4F
+		return uint32(h >> (64 - hashBits))
+	}
+	insert := func(i int) {
+		if i+1 < len(pix) {
+			h := hash(i)
+			prev[i] = head[h]
+			head[h] = int32(i)
+		}
+	}
+	matchLen := func(i, j int) int {
+		n := 0
+		limit := min(lz77MaxMatch, len(pix)-i)
+		for n < limit && pix[i+n] == pix[j+n] {
+			n++
+		}
+		return n
+	}
+
+	tokens := make([]token, 0, len(pix)/2)
+	for i := 0; i < len(pix); {
+		bestLen, bestDist := 0, 0
+		if i+1 < len(pix) {
+			// The pixel to the left and the one above have the cheapest
+			// distance codes, so try them before the hash chain.
+			for _, d := range []int{1, width} {
+				if d <= i {
+					if n := matchLen(i, i-d); n > bestLen {
+						bestLen, bestDist = n, d
+					}
+				}
+			}
+			for j, chain := int(head[hash(i)]), 0; j >= 0 && i-j <= lz77Window && chain < lz77MaxChain; j, chain = int(prev[j]), chain+1 {
+				if n := matchLen(i, j); n > bestLen+2 {
+					bestLen, bestDist = n, i-j
+				}
+			}
+		}
+		if bestLen < lz77MinMatch {
+			tokens = append(tokens, token{argb: pix[i]})
+			insert(i)
+			i++
+			continue
+		}
+		tokens = append(tokens, token{length: bestLen, dist: distanceCode(bestDist, width)})
+		for k := 0; k < bestLen; k++ {
+			insert(i + k)
+		}
+		i += bestLen
+	}
+	return tokens
+}
+
+// distanceCode maps a linear pixel distance to a VP8L distance code, using
+// the 2D codes for the left and top neighbours.
+func distanceCode(d, width int) int {
+	switch d {
+	case width:
+		return 1
+	case 1:
+		return 2
+	}
+	return d + distanceMapSize
+}
+
+// huffmanCode is a canonical prefix code for one alphabet.
+type huffmanCode struct {
+	lengths []uint8
+	codes   []uint32
+	// used counts the symbols with a non-zero length. A code with a single
+	// symbol is written with zero bits.
+	used int
+}
+
+func newHuffmanCode(counts []int, limit int) *huffmanCode {
+	hc := &huffmanCode{lengths: huffmanLengths(counts, limit)}
+	for _, l := range hc.lengths {
+		if l > 0 {
+			hc.used++
+		}
+	}
+	hc.codes = canonicalCodes(hc.lengths)
+	return hc
+}
+
+func (hc *huffmanCode) writeSymbol(bw *bitWriter, sym int) {
+	if hc.used > 1 {
+		bw.write(hc.codes[sym], uint(hc.lengths[sym]))
+	}
+}
+
+// writeTo writes the code definition, using the compact "simple" form when
+// at most two symbols below 256 are used.
+func (hc *huffmanCode) writeTo(bw *bitWriter) {
+	var syms []int
+	for s, l := range hc.lengths {
+		if l > 0 {
+			syms = append(syms, s)
+		}
+	}
+	if len(syms) == 0 {
+		syms = []int{0}
+	}
+	if len(syms) <= 2 && syms[len(syms)-1] < 256 {
+		bw.write(1, 1)
+		bw.write(uint32(len(syms)-1), 1)
+		if syms[0] < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(syms[0]), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(syms[0]), 8)
+		}
+		if len(syms) == 2 {
+			bw.write(uint32(syms[1]), 8)
+		}
+		return
+	}
+
+	bw.write(0, 1)
+	type rle struct {
+		sym   int
+		extra uint32
+		bits  uint
+	}
+	var ops []rle
+	lengths := hc.lengths
+	for i := 0; i < len(lengths); {
+		v := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == v {
+			run++
+		}
+		i += run
+		if v == 0 {
+			for run >= 11 {
+				n := min(run, 138)
+				ops = append(ops, rle{18, uint32(n - 11), 7})
+				run -= n
+			}
+			if run >= 3 {
+				ops = append(ops, rle{17, uint32(run - 3), 3})
+				run = 0
+			}
+			for ; run > 0; run-- {
+				ops = append(ops, rle{0, 0, 0})
+			}
+			continue
+		}
+		ops = append(ops, rle{int(v), 0, 0})
+		run--
+		for run >= 3 {
+			n := min(run, 6)
+			ops = append(ops, rle{16, uint32(n - 3), 2})
+			run -= n
+		}
+		for ; run > 0; run-- {
+			ops = append(ops, rle{int(v), 0, 0})
+		}
+	}
+
+	counts := make([]int, 19)
+	for _, op := range ops {
+		counts[op.sym]++
+	}
+	clc := newHuffmanCode(counts, 7)
+	order := [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	n := 4
+	for i, s := range order {
+		if clc.lengths[s] > 0 && i+1 > n {
+			n = i + 1
+		}
+	}
+	bw.write(uint32(n-4), 4)
+	for _, s := range order[:n] {
+		bw.write(uint32(clc.lengths[s]), 3)
+	}
+	bw.write(0, 1) // code lengths cover the whole alphabet
+	for _, op := range ops {
+		clc.writeSymbol(bw, op.sym)
+		if op.bits > 0 {
+			bw.write(op.extra, op.bits)
+		}
+	}
+}
+
+// huffmanLengths computes code lengths no longer than limit. When the
+// optimal code is too deep the counts are flattened and the code rebuilt.
+func huffmanLengths(counts []int, limit int) []uint8 {
+	lengths := make([]uint8, len(counts))
+	type node struct {
+		count       int
+		left, right int
+		sym         int
+	}
+	work := append([]int(nil), counts...)
+	for {
+		var nodes []node
+		var live []int
+		for s, c := range work {
+			if c > 0 {
+				nodes = append(nodes, node{count: c, left: -1, right: -1, sym: s})
+				live = append(live, len(nodes)-1)
+			}
+		}
+		switch len(live) {
+		case 0:
+			return lengths
+		case 1:
+			lengths[nodes[0].sym] = 1
+			return lengths
+		}
+		for len(live) > 1 {
+			sort.Slice(live, func(i, j int) bool {
+				a, b := nodes[live[i]], nodes[live[j]]
+				if a.count != b.count {
+					return a.count < b.count
+				}
+				return live[i] < live[j]
+			})
+			nodes = append(nodes, node{count: nodes[live[0]].count + nodes[live[1]].count, left: live[0], right: live[1], sym: -1})
+			live = append([]int{len(nodes) - 1}, live[2:]...)
+		}
+
+		maxDepth := 0
+		var walk func(n, depth int)
+		walk = func(n, depth int) {
+			if nodes[n].sym >= 0 {
+				lengths[nodes[n].sym] = uint8(depth)
+				maxDepth = max(maxDepth, depth)
+				return
+			}
+			walk(nodes[n].left, depth+1)
+			walk(nodes[n].right, depth+1)
+		}
+		walk(live[0], 0)
+		if maxDepth <= limit {
+			return lengths
+		}
+		for s, c := range work {
+			if c > 0 {
+				work[s] = c>>1 | 1
+			}
+		}
+	}
+}
+
+// canonicalCodes assigns canonical codes, stored bit-reversed because the
+// bitstream is written least significant bit first.
+func canonicalCodes(lengths []uint8) []uint32 {
+	var count [maxCodeLength + 1]uint32
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [maxCodeLength + 2]uint32
+	code := uint32(0)
+	for l := 1; l <= maxCodeLength; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	codes := make([]uint32, len(lengths))
+	for s, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		c := next[l]
+		next[l]++
+		var rev uint32
+		for k := uint8(0); k < l; k++ {
+			rev = rev<<1 | (c>>k)&1
+		}
+		codes[s] = rev
+	}
+	return codes
+}
+
+// bitWriter packs bits least significant first, as VP8L expects.
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+func (bw *bitWriter) write(v uint32, n uint) {
+	bw.acc |= uint64(v) << bw.nbits
+	bw.nbits += n
+	for bw.nbits >= 8 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc >>= 8
+		bw.nbits -= 8
+	}
+}
+
+func (bw *bitWriter) finish() []byte {
+	if bw.nbits > 0 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc, bw.nbits = 0, 0
+	}
+	return bw.buf
+}
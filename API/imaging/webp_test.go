@@ -0,0 +1,103 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// roundTrip encodes img, decodes it with x/image/webp and checks that every
+// pixel came back unchanged.
+func roundTrip(t *testing.T, img image.Image) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := EncodeWebP(&buf, img); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	got, err := webp.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	b := img.Bounds()
+	if got.Bounds().Dx() != b.Dx() || got.Bounds().Dy() != b.Dy() {
+		t.Fatalf("decoded %v, want %dx%d", got.Bounds(), b.Dx(), b.Dy())
+	}
+	want := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(want, want.Rect, img, b.Min, draw.Src)
+	gb := got.Bounds()
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			g := color.NRGBAModel.Convert(got.At(gb.Min.X+x, gb.Min.Y+y)).(color.NRGBA)
+			if w := want.NRGBAAt(x, y); g != w {
+				t.Fatalf("pixel (%d,%d) = %v, want %v", x, y, g, w)
+			}
+		}
+	}
+}
+
+func TestEncodeWebPOpaque(t *testing.T) {
+	roundTrip(t, photo(64, 48))
+}
+
+func TestEncodeWebPTransparent(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 30))
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 40; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 6), uint8(y * 8), 90, uint8((x + y) * 4)})
+		}
+	}
+	// Fully transparent pixels keep their colour in lossless WebP
+	img.SetNRGBA(0, 0, color.NRGBA{12, 34, 56, 0})
+	roundTrip(t, img)
+}
+
+func TestEncodeWebPSingleColour(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 33, 17))
+	draw.Draw(img, img.Rect, image.NewUniform(color.RGBA{10, 200, 30, 255}), image.Point{}, draw.Src)
+	roundTrip(t, img)
+	roundTrip(t, image.NewNRGBA(image.Rect(0, 0, 1, 1)))
+}
+
+func TestEncodeWebPOddSizes(t *testing.T) {
+	for _, size := range [][2]int{{1, 7}, {7, 1}, {17, 3}, {31, 33}, {129, 65}} {
+		img := image.NewNRGBA(image.Rect(0, 0, size[0], size[1]))
+		for y := 0; y < size[1]; y++ {
+			for x := 0; x < size[0]; x++ {
+				img.SetNRGBA(x, y, color.NRGBA{uint8(x * 37), uint8(y * 11), uint8(x ^ y), 255})
+			}
+		}
+		roundTrip(t, img)
+	}
+	// A sub-image whose bounds do not start at the origin
+	roundTrip(t, photo(100, 80).SubImage(image.Rect(13, 7, 90, 61)))
+}
+
+func TestEncodeWebPManyColours(t *testing.T) {
+	// 64K distinct colours, far more than a 256-entry palette holds, laid out
+	// so that both LZ77 matches and literals are needed.
+	img := image.NewNRGBA(image.Rect(0, 0, 256, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 256; x++ {
+			c := color.NRGBA{uint8(x), uint8(y), uint8(x*y + y/7), 255}
+			if y >= 256 {
+				c = img.NRGBAAt(x, y-256)
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	roundTrip(t, img)
+}
+
+func TestEncodeWebPRejectsBadSizes(t *testing.T) {
+	var buf bytes.Buffer
+	if err := EncodeWebP(&buf, image.NewNRGBA(image.Rect(0, 0, 0, 5))); err == nil {
+		t.Error("empty image encoded")
+	}
+	if err := EncodeWebP(&buf, image.NewNRGBA(image.Rect(0, 0, 1<<14+1, 1))); err == nil {
+		t.Error("image wider than 16384 encoded")
+	}
+}
@@ -0,0 +1,187 @@
+// Package jobs runs background work persisted in the jobs table with a
+// pool of workers, retrying failures with exponential backoff.
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"forum/config"
+	"forum/models"
+	"forum/repository"
+)
+
+// RunFunc performs a job. Returning an error schedules a retry unless the
+// error is wrapped with Permanent or the job used its last attempt.
+type RunFunc func(job *models.Job) error
+
+// FailFunc is called once when a job fails for good, so the owner of the
+// job can record the failure and clean up.
+type FailFunc func(job *models.Job, err error)
+
+type handler struct {
+	run    RunFunc
+	failed FailFunc
+}
+
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks an error that retrying cannot fix, such as undecodable
+// input.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// Pool claims due jobs and runs them with the handler registered for their
+// kind.
+type Pool struct {
+	Repo *repository.JobRepository
+	cfg  config.JobConfig
+
+	mu       sync.RWMutex
+	handlers map[string]handler
+	wake     chan struct{}
+}
+
+// NewPool returns a pool; register handlers with Handle, then call Start.
+func NewPool(repo *repository.JobRepository, cfg config.JobConfig) *Pool {
+	return &Pool{
+		Repo:     repo,
+		cfg:      cfg,
+		handlers: make(map[string]handler),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Handle registers the functions for one kind of job. failed may be nil.
+func (p *Pool) Handle(kind string, run RunFunc, failed FailFunc) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers[kind] = handler{run: run, failed: failed}
+}
+
+// Enqueue stores a job with a JSON-encoded payload and wakes a worker.
+func (p *Pool) Enqueue(kind, userID, imageID string, payload interface{}) (*models.Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	job, err := p.Repo.Create(models.Job{
+		Kind:        kind,
+		UserID:      userID,
+		ImageID:     imageID,
+		Payload:     string(data),
+		MaxAttempts: p.cfg.MaxAttempts,
+	})
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// Start requeues jobs interrupted by a previous shutdown and starts the
+// workers.
+func (p *Pool) Start() {
+	if n, err := p.Repo.RequeueRunning(); err != nil {
+		log.Printf("Failed to requeue interrupted jobs: %v", err)
+	} else if n > 0 {
+		log.Printf("Requeued %d interrupted jobs", n)
+	}
+	for i := 0; i < p.cfg.Workers; i++ {
+		go p.work()
+	}
+}
+
+func (p *Pool) work() {
+	ticker := time.NewTicker(p.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		job, err := p.Repo.ClaimNext()
+		if err != nil {
+			log.Printf("Failed to claim job: %v", err)
+		}
+		if job != nil {
+			p.run(job)
+			continue
+		}
+		select {
+		case <-p.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Pool) run(job *models.Job) {
+	p.mu.RLock()
+	h, ok := p.handlers[job.Kind]
+	p.mu.RUnlock()
+
+	var err error
+	if !ok {
+		err = Permanent(fmt.Errorf("no handler for job kind %q", job.Kind))
+	} else {
+		err = safeRun(h.run, job)
+	}
+	if err == nil {
+		if err := p.Repo.Complete(job.ID); err != nil {
+			log.Printf("Failed to complete job %s: %v", job.ID, err)
+		}
+		return
+	}
+
+	var perm *permanentError
+	if errors.As(err, &perm) || job.Attempts >= job.MaxAttempts {
+		log.Printf("Job %s (%s) failed after %d attempts: %v", job.ID, job.Kind, job.Attempts, err)
+		if ferr := p.Repo.Fail(job.ID, err.Error()); ferr != nil {
+			log.Printf("Failed to mark job %s failed: %v", job.ID, ferr)
+		}
+		if ok && h.failed != nil {
+			h.failed(job, err)
+		}
+		return
+	}
+
+	delay := p.backoff(job.Attempts)
+	log.Printf("Job %s (%s) attempt %d failed, retrying in %s: %v", job.ID, job.Kind, job.Attempts, delay, err)
+	if rerr := p.Repo.Retry(job.ID, time.Now().Add(delay), err.Error()); rerr != nil {
+		log.Printf("Failed to reschedule job %s: %v", job.ID, rerr)
+	}
+}
+
+// backoff is RetryBackoff doubled for every attempt after the first, capped
+// at MaxBackoff.
+func (p *Pool) backoff(attempts int) time.Duration {
+	delay := p.cfg.RetryBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if p.cfg.MaxBackoff > 0 && delay >= p.cfg.MaxBackoff {
+			return p.cfg.MaxBackoff
+		}
+	}
+	return delay
+}
+
+// safeRun turns a panicking handler into an error so one bad job cannot
+// stop a worker.
+func safeRun(run RunFunc, job *models.Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return run(job)
+}
@@ -17,7 +17,7 @@ func (c *CORSMiddleware) Handler(next http.Handler) http.Handler {
 		w.Header().Set("Access-Control-Allow-Origin", c.allowedOrigin)
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-CSRF-Token")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-CSRF-Token, X-Chunk-SHA256")
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		w.Header().Set("X-Frame-Options", "DENY")	
 		w.Header().Set("X-XSS-Protection", "1; mode=block")
@@ -39,3 +39,14 @@ func (c *CORSMiddleware) Handler(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Static applies the CORS and security headers without the no-cache ones,
+// leaving caching to the wrapped handler. It is used for uploaded files.
+func (c *CORSMiddleware) Static(next http.Handler) http.Handler {
+	return c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, name := range []string{"Cache-Control", "Pragma", "Expires", "Surrogate-Control"} {
+			w.Header().Del(name)
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"forum/config"
+	"forum/models"
+	"forum/utils"
+)
+
+// ModeratorMiddleware restricts routes to the configured moderators
+type ModeratorMiddleware struct {
+	moderators map[string]bool
+}
+
+// NewModeratorMiddleware creates a ModeratorMiddleware for the users listed in cfg
+func NewModeratorMiddleware(cfg config.ModerationConfig) *ModeratorMiddleware {
+	m := &ModeratorMiddleware{moderators: make(map[string]bool)}
+	for _, name := range cfg.Moderators {
+		m.moderators[name] = true
+	}
+	return m
+}
+
+// IsModerator reports whether user is listed by username or email
+func (m *ModeratorMiddleware) IsModerator(user *models.User) bool {
+	if user == nil {
+		return false
+	}
+	return m.moderators[strings.ToLower(user.Username)] || m.moderators[strings.ToLower(user.Email)]
+}
+
+// RequireModerator rejects requests from users who are not moderators. It
+// must run after RequireAuth.
+func (m *ModeratorMiddleware) RequireModerator(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := GetCurrentUser(r)
+		if !m.IsModerator(user) {
+			log.Printf("ModeratorMiddleware [WARN]: Non-moderator request to %s", r.URL.Path)
+			utils.ErrorResponse(w, "Moderator access required", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
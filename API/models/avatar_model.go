@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Avatar is a profile picture uploaded by a user, stored as a square image
+// of each of Sizes under Dir
+type Avatar struct {
+	UserID    string    `json:"user_id"`
+	Dir       string    `json:"dir"`
+	Ext       string    `json:"ext"`
+	Sizes     []int     `json:"sizes"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
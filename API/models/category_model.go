@@ -7,7 +7,8 @@ type Category struct {
 }
 
 type CategoryWithPosts struct {
-	ID    int            `json:"id"`
-	Name  string         `json:"name"`
-	Posts []PostWithUser `json:"posts"`
+	ID         int            `json:"id"`
+	Name       string         `json:"name"`
+	Posts      []PostWithUser `json:"posts"`
+	NextCursor string         `json:"next_cursor,omitempty"`
 }
\ No newline at end of file
@@ -5,6 +5,7 @@ import "time"
 type Comment struct {
 	ID        string     `json:"id"`
 	PostID    string     `json:"post_id"`
+	ParentID  *string    `json:"parent_id,omitempty"`
 	UserID    string     `json:"user_id"`
 	Content   string     `json:"content"`
 	CreatedAt time.Time  `json:"created_at"`
@@ -16,6 +17,7 @@ type Comment struct {
 type CommentWithUser struct {
 	ID        string    `json:"id"`
 	PostID    string    `json:"post_id"`
+	ParentID  string    `json:"parent_id,omitempty"`
 	UserID    string    `json:"user_id"`
 	Username  string    `json:"username"`
 	Content   string    `json:"content"`
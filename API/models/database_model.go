@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -14,7 +15,7 @@ import (
 
 // Database version constants
 const (
-	CURRENT_DB_VERSION = 5 // Updated to version 5 for notifications
+	CURRENT_DB_VERSION = 20 // Updated to version 20 for the source size of images
 	INITIAL_VERSION    = 1
 )
 
@@ -69,6 +70,134 @@ func GetMigrations() []Migration {
 				config.IdxNotificationsActorID,
 			},
 		},
+		{
+			Version:     6,
+			Description: "Record storage backend for images",
+			SQL: []string{
+				config.AddImagesStorageBackend,
+			},
+		},
+		{
+			Version:     7,
+			Description: "Add image renditions table",
+			SQL: []string{
+				config.CreateImageRenditionsTable,
+				config.IdxImageRenditionsImageID,
+			},
+		},
+		{
+			Version:     8,
+			Description: "Record EXIF orientation and stripped metadata for images",
+			SQL: []string{
+				config.AddImagesOrientation,
+				config.AddImagesStrippedMetadata,
+			},
+		},
+		{
+			Version:     9,
+			Description: "Add content-addressed image blobs with reference counting",
+			SQL: []string{
+				config.CreateImageBlobsTable,
+				config.AddImagesContentHash,
+				config.CreateImageBlobRefTrigger,
+				config.CreateImageBlobUnrefTrigger,
+				config.IdxImagesContentHash,
+				config.IdxImageBlobsRefCount,
+			},
+		},
+		{
+			Version:     10,
+			Description: "Add position, alt text and caption to images for galleries",
+			SQL: []string{
+				config.AddImagesPosition,
+				config.AddImagesAltText,
+				config.AddImagesCaption,
+				config.BackfillImagesPosition,
+				config.IdxImagesPostPosition,
+			},
+		},
+		{
+			Version:     11,
+			Description: "Add background job queue and image processing status",
+			SQL: []string{
+				config.AddImagesStatus,
+				config.CreateImageBlobRehashTrigger,
+				config.CreateJobsTable,
+				config.IdxJobsStatusRunAt,
+				config.IdxJobsImageID,
+			},
+		},
+		{
+			Version:     12,
+			Description: "Add upload sessions for resumable chunked uploads",
+			SQL: []string{
+				config.CreateUploadSessionsTable,
+				config.IdxUploadSessionsExpiresAt,
+			},
+		},
+		{
+			Version:     13,
+			Description: "Add BlurHash placeholder and dominant colour to images",
+			SQL: []string{
+				config.AddImagesBlurHash,
+				config.AddImagesDominantColor,
+			},
+		},
+		{
+			Version:     14,
+			Description: "Add perceptual hashes to images and the banned images list",
+			SQL: []string{
+				config.AddImagesPHash,
+				config.AddImagesDHash,
+				config.CreateBannedImagesTable,
+			},
+		},
+		{
+			Version:     15,
+			Description: "Add user avatars",
+			SQL: []string{
+				config.CreateUserAvatarsTable,
+			},
+		},
+		{
+			Version:     16,
+			Description: "Add image scan verdicts for quarantined uploads",
+			SQL: []string{
+				config.CreateImageScanVerdictsTable,
+				config.IdxImagesStatus,
+				config.IdxImageScanVerdictsImageID,
+				config.IdxImageScanVerdictsContentHash,
+			},
+		},
+		{
+			Version:     17,
+			Description: "Index posts by creation time for paginated listings",
+			SQL: []string{
+				config.IdxPostsCreatedAt,
+				config.IdxPostsUserCreatedAt,
+			},
+		},
+		{
+			Version:     18,
+			Description: "Add parent comments for threaded replies",
+			SQL: []string{
+				config.AddCommentsParentID,
+				config.IdxCommentsParentID,
+			},
+		},
+		// The search index needs FTS5, so ensureSearchIndex builds it after
+		// the migrations when SQLite has it
+		{
+			Version:     19,
+			Description: "Add full-text search over posts and comments",
+		},
+		{
+			Version:     20,
+			Description: "Record the source size of images for the storage quota",
+			SQL: []string{
+				config.AddImagesSourceBytes,
+			},
+		},
 		// Add future migrations here
 	}
 }
@@ -77,15 +206,13 @@ func GetMigrations() []Migration {
 func InitDB() (*sql.DB, error) {
 	dbPath := filepath.Join("./database", "forum.db")
 
-	firstTime := false
 	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
-		firstTime = true
 		if err := os.MkdirAll("./database", 0755); err != nil {
 			return nil, fmt.Errorf("failed to create database directory: %v", err)
 		}
 	}
 
-	db, err := sql.Open("sqlite3", dbPath+"?_foreign_keys=on")
+	db, err := sql.Open("sqlite3", dbPath+"?_foreign_keys=on&_busy_timeout=5000")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %v", err)
 	}
@@ -98,22 +225,17 @@ func InitDB() (*sql.DB, error) {
 	db.SetMaxOpenConns(10)
 	db.SetMaxIdleConns(5)
 
-	if firstTime {
-		if err := createTables(db); err != nil {
-			db.Close()
-			return nil, fmt.Errorf("failed to create tables: %v", err)
-		}
-		if err := createIndexes(db); err != nil {
-			db.Close()
-			return nil, fmt.Errorf("failed to create indexes: %v", err)
-		}
-		if err := populateCategories(db, config.Categories); err != nil {
-			db.Close()
-			return nil, fmt.Errorf("failed to populate categories: %v", err)
-		}
-		if err := setDatabaseVersion(db, CURRENT_DB_VERSION); err != nil {
+	// A database without a version is empty, either new or left by a first
+	// start that failed, since the schema is created in one transaction
+	version, err := getDatabaseVersion(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if version == 0 {
+		if err := initSchema(db); err != nil {
 			db.Close()
-			return nil, fmt.Errorf("failed to set database version: %v", err)
+			return nil, err
 		}
 		fmt.Println("Database initialized successfully.")
 	} else {
@@ -124,9 +246,94 @@ func InitDB() (*sql.DB, error) {
 		fmt.Println("Database migrations completed successfully.")
 	}
 
+	// go-sqlite3 only compiles FTS5 in with the sqlite_fts5 build tag. Without
+	// it the server still runs, with search disabled.
+	if HasFTS5(db) {
+		err = ensureSearchIndex(db)
+	} else {
+		fmt.Println("Warning: SQLite has no FTS5 support, search is disabled (build with -tags sqlite_fts5 to enable it)")
+		err = dropSearchTriggers(db)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
 	return db, nil
 }
 
+// searchTriggers are the triggers keeping the search index up to date
+var searchTriggers = []string{
+	"trg_posts_search_insert",
+	"trg_posts_search_delete",
+	"trg_posts_search_update",
+	"trg_comments_search_insert",
+	"trg_comments_search_delete",
+	"trg_comments_search_update",
+}
+
+// HasFTS5 reports whether SQLite was built with FTS5, which search needs
+func HasFTS5(db *sql.DB) bool {
+	var fts5 bool
+	err := db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5)
+	return err == nil && fts5
+}
+
+// ensureSearchIndex creates the search index unless all its triggers are in
+// place. It also rebuilds an index left stale by a start without FTS5, which
+// drops the triggers.
+func ensureSearchIndex(db *sql.DB) error {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name IN ('` + strings.Join(searchTriggers, "', '") + `')`).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to check search triggers: %v", err)
+	}
+	if count == len(searchTriggers) {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	statements := []string{
+		config.CreatePostsSearchTable,
+		config.CreateCommentsSearchTable,
+		`DELETE FROM posts_search;`,
+		`DELETE FROM comments_search;`,
+		config.FillPostsSearch,
+		config.FillCommentsSearch,
+		config.CreatePostsSearchInsertTrigger,
+		config.CreatePostsSearchDeleteTrigger,
+		config.CreatePostsSearchUpdateTrigger,
+		config.CreateCommentsSearchInsertTrigger,
+		config.CreateCommentsSearchDeleteTrigger,
+		config.CreateCommentsSearchUpdateTrigger,
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to build search index: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to build search index: %v", err)
+	}
+	fmt.Println("Search index built.")
+	return nil
+}
+
+// dropSearchTriggers removes the search triggers left by a build with FTS5,
+// since writes to posts and comments would fail on them
+func dropSearchTriggers(db *sql.DB) error {
+	for _, name := range searchTriggers {
+		if _, err := db.Exec("DROP TRIGGER IF EXISTS " + name); err != nil {
+			return fmt.Errorf("failed to drop search trigger %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
 func createDatabaseVersionTable(db *sql.DB) error {
 	_, err := db.Exec(`
 		CREATE TABLE IF NOT EXISTS database_version (
@@ -299,13 +506,34 @@ func runMigrations(db *sql.DB) error {
 	return nil
 }
 
-func createTables(db *sql.DB) error {
+// initSchema creates the current schema in an empty database, with its
+// indexes and categories. The tables are created as the migrations leave
+// them, so the version is recorded in the same transaction: a database
+// never has the new columns without the version that skips the migrations
+// adding them.
+func initSchema(db *sql.DB) error {
 	tx, err := db.Begin()
 	if err != nil {
 		return fmt.Errorf("begin tx: %v", err)
 	}
 	defer tx.Rollback()
 
+	if err := createTables(tx); err != nil {
+		return fmt.Errorf("failed to create tables: %v", err)
+	}
+	if err := createIndexes(tx); err != nil {
+		return fmt.Errorf("failed to create indexes: %v", err)
+	}
+	if err := populateCategories(tx, config.Categories); err != nil {
+		return fmt.Errorf("failed to populate categories: %v", err)
+	}
+	if _, err := tx.Exec("INSERT INTO database_version (version) VALUES (?)", CURRENT_DB_VERSION); err != nil {
+		return fmt.Errorf("failed to set database version: %v", err)
+	}
+	return tx.Commit()
+}
+
+func createTables(tx *sql.Tx) error {
 	statements := []string{
 		config.CreateUserTable,
 		config.CreateUserAuthTable,
@@ -313,9 +541,33 @@ func createTables(db *sql.DB) error {
 		config.CreateCategoriesTable,
 		config.CreatePostsTable,
 		config.CreateCommentsTable,
+		config.AddCommentsParentID,
 		config.CreateReactionsTable,
 		config.CreateImagesTable,
+		config.AddImagesStorageBackend,
+		config.AddImagesOrientation,
+		config.AddImagesStrippedMetadata,
+		config.CreateImageBlobsTable,
+		config.AddImagesContentHash,
+		config.AddImagesPosition,
+		config.AddImagesAltText,
+		config.AddImagesCaption,
+		config.AddImagesStatus,
+		config.AddImagesBlurHash,
+		config.AddImagesDominantColor,
+		config.AddImagesPHash,
+		config.AddImagesDHash,
+		config.AddImagesSourceBytes,
+		config.CreateImageBlobRefTrigger,
+		config.CreateImageBlobUnrefTrigger,
+		config.CreateImageBlobRehashTrigger,
+		config.CreateImageRenditionsTable,
 		config.CreateNotificationsTable,
+		config.CreateJobsTable,
+		config.CreateUploadSessionsTable,
+		config.CreateBannedImagesTable,
+		config.CreateUserAvatarsTable,
+		config.CreateImageScanVerdictsTable,
 		config.CreatePostCategoriesTable,
 		config.CreateOAuthTable,
 		// Add OAuth state table for new installations
@@ -333,27 +585,33 @@ func createTables(db *sql.DB) error {
 			return fmt.Errorf("statement %d failed: %v\nSQL: %s", i+1, err, stmt)
 		}
 	}
-
-	return tx.Commit()
+	return nil
 }
 
-func createIndexes(db *sql.DB) error {
-	tx, err := db.Begin()
-	if err != nil {
-		return fmt.Errorf("begin tx: %v", err)
-	}
-	defer tx.Rollback()
-
+func createIndexes(tx *sql.Tx) error {
 	indexes := []string{
 		config.IdxPostsUserID,
+		config.IdxPostsCreatedAt,
+		config.IdxPostsUserCreatedAt,
 		config.IdxPostCategoriesPostID,
 		config.IdxPostCategoriesCategoryID,
 		config.IdxCommentsPostID,
 		config.IdxCommentsUserID,
+		config.IdxCommentsParentID,
 		config.IdxReactionsUserID,
 		config.IdxReactionsPostID,
 		config.IdxReactionsCommentID,
 		config.IdxImagesPostID,
+		config.IdxImagesPostPosition,
+		config.IdxImagesContentHash,
+		config.IdxImagesStatus,
+		config.IdxImageBlobsRefCount,
+		config.IdxJobsStatusRunAt,
+		config.IdxJobsImageID,
+		config.IdxUploadSessionsExpiresAt,
+		config.IdxImageRenditionsImageID,
+		config.IdxImageScanVerdictsImageID,
+		config.IdxImageScanVerdictsContentHash,
 		config.IdxNotificationsUserID,
 		config.IdxNotificationsActorID,
 		// OAuth indexes
@@ -369,21 +627,14 @@ func createIndexes(db *sql.DB) error {
 			return fmt.Errorf("failed to create index: %v\nSQL: %s", err, stmt)
 		}
 	}
-
-	return tx.Commit()
+	return nil
 }
 
-func populateCategories(db *sql.DB, categories []string) error {
+func populateCategories(tx *sql.Tx, categories []string) error {
 	if len(categories) == 0 {
 		return nil
 	}
 
-	tx, err := db.Begin()
-	if err != nil {
-		return fmt.Errorf("begin tx: %v", err)
-	}
-	defer tx.Rollback()
-
 	stmt, err := tx.Prepare(`INSERT OR IGNORE INTO categories (name) VALUES (?)`)
 	if err != nil {
 		return fmt.Errorf("prepare stmt: %v", err)
@@ -396,10 +647,6 @@ func populateCategories(db *sql.DB, categories []string) error {
 		}
 	}
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("commit tx: %v", err)
-	}
-
 	fmt.Println("Categories populated (duplicates ignored).")
 	return nil
 }
@@ -2,11 +2,121 @@ package models
 
 import "time"
 
+// Image processing states
+const (
+	ImageProcessing = "processing"
+	ImageReady      = "ready"
+	ImageFailed     = "failed"
+	// ImageQuarantined images were flagged by the upload scanner and stay
+	// hidden until a moderator approves them.
+	ImageQuarantined = "quarantined"
+)
+
 type Image struct {
-	ID            string    `json:"id"`
-	PostID        string    `json:"post_id"`
-	UserID        string    `json:"user_id"`
+	ID               string           `json:"id"`
+	PostID           string           `json:"post_id"`
+	UserID           string           `json:"user_id"`
+	FilePath         string           `json:"file_path"`
+	ThumbnailPath    string           `json:"thumbnail_path"`
+	Backend          string           `json:"storage_backend"`
+	Orientation      int              `json:"orientation"`
+	StrippedMetadata []string         `json:"stripped_metadata,omitempty"`
+	ContentHash      string           `json:"content_hash,omitempty"`
+	Position         int              `json:"position"`
+	AltText          string           `json:"alt_text"`
+	Caption          string           `json:"caption"`
+	Status           string           `json:"status"`
+	BlurHash         string           `json:"blurhash,omitempty"`
+	DominantColor    string           `json:"dominant_color,omitempty"`
+	PHash            string           `json:"phash,omitempty"`
+	DHash            string           `json:"dhash,omitempty"`
+	SourceBytes      int64            `json:"-"`
+	CreatedAt        time.Time        `json:"created_at"`
+	Renditions       []ImageRendition `json:"renditions,omitempty"`
+}
+
+// ImageBlob is a processed upload shared by every image with the same content
+type ImageBlob struct {
+	ContentHash   string    `json:"content_hash"`
 	FilePath      string    `json:"file_path"`
 	ThumbnailPath string    `json:"thumbnail_path"`
+	Backend       string    `json:"storage_backend"`
+	RefCount      int       `json:"ref_count"`
 	CreatedAt     time.Time `json:"created_at"`
 }
+
+// ImageRendition is a resized copy of an image, or the original itself
+type ImageRendition struct {
+	ID          string    `json:"id"`
+	ImageID     string    `json:"image_id"`
+	Name        string    `json:"name"`
+	FilePath    string    `json:"file_path"`
+	Width       int       `json:"width"`
+	Height      int       `json:"height"`
+	ContentType string    `json:"content_type"`
+	SizeBytes   int64     `json:"size_bytes"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// PostImage is one image of a post's gallery as exposed in API responses
+type PostImage struct {
+	ID            string         `json:"id"`
+	Position      int            `json:"position"`
+	URL           string         `json:"url"`
+	ThumbnailURL  string         `json:"thumbnail_url"`
+	SrcSet        string         `json:"srcset,omitempty"`
+	Renditions    []RenditionURL `json:"renditions,omitempty"`
+	AltText       string         `json:"alt_text"`
+	Caption       string         `json:"caption"`
+	Status        string         `json:"status"`
+	BlurHash      string         `json:"blurhash,omitempty"`
+	DominantColor string         `json:"dominant_color,omitempty"`
+}
+
+// RenditionURL is a rendition as exposed in API responses
+type RenditionURL struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// BannedImage is the perceptual hash of an image moderators banned
+type BannedImage struct {
+	ID        string    `json:"id"`
+	PHash     string    `json:"phash"`
+	DHash     string    `json:"dhash"`
+	Reason    string    `json:"reason"`
+	ImageID   string    `json:"image_id,omitempty"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ImageMatch is an image whose perceptual hash is within Distance bits of
+// the one searched for
+type ImageMatch struct {
+	ImageID  string
+	Distance int
+}
+
+// Scan verdict decisions taken by moderators on quarantined images
+const (
+	ScanApproved = "approved"
+	ScanRejected = "rejected"
+)
+
+// ScanVerdict is what the upload scanner said about an image
+type ScanVerdict struct {
+	ID          string     `json:"id"`
+	ImageID     string     `json:"image_id,omitempty"`
+	ContentHash string     `json:"content_hash"`
+	Scanner     string     `json:"scanner"`
+	Flagged     bool       `json:"flagged"`
+	Score       float64    `json:"score"`
+	Labels      []string   `json:"labels"`
+	Error       string     `json:"error,omitempty"`
+	Decision    string     `json:"decision,omitempty"`
+	ReviewedBy  string     `json:"reviewed_by,omitempty"`
+	ReviewedAt  *time.Time `json:"reviewed_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
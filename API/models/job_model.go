@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// Job states
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// JobImageProcess writes the files of an uploaded image: the normalised
+// original, thumbnail, renditions and variants
+const JobImageProcess = "image.process"
+
+// Job is a unit of background work persisted in the jobs table
+type Job struct {
+	ID          string    `json:"id"`
+	Kind        string    `json:"kind"`
+	UserID      string    `json:"user_id"`
+	ImageID     string    `json:"image_id,omitempty"`
+	Payload     string    `json:"-"`
+	Status      string    `json:"status"`
+	Attempts    int       `json:"attempts"`
+	MaxAttempts int       `json:"max_attempts"`
+	LastError   string    `json:"last_error,omitempty"`
+	RunAt       time.Time `json:"run_at"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
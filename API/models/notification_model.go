@@ -14,8 +14,9 @@ type Notification struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
-// NotificationWithActor includes the username of the actor
+// NotificationWithActor includes the username and avatar of the actor
 type NotificationWithActor struct {
 	Notification
-	ActorUsername string `json:"actor_username"`
+	ActorUsername  string `json:"actor_username"`
+	ActorAvatarURL string `json:"actor_avatar_url"`
 }
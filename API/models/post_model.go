@@ -14,13 +14,34 @@ type Post struct {
 
 // PostWithUser is a post along with the username of its author
 type PostWithUser struct {
-	ID           string    `json:"id"`
-	UserID       string    `json:"user_id"`
-	Username     string    `json:"username"`
-	CategoryID   int       `json:"category_id"`
-	Title        string    `json:"title"`
-	Content      string    `json:"content"`
-	CreatedAt    time.Time `json:"created_at"`
-	ImageURL     string    `json:"image_url,omitempty"`
-	ThumbnailURL string    `json:"thumbnail_url,omitempty"`
+	ID            string         `json:"id"`
+	UserID        string         `json:"user_id"`
+	Username      string         `json:"username"`
+	AvatarURL     string         `json:"avatar_url"`
+	CategoryID    int            `json:"category_id"`
+	Title         string         `json:"title"`
+	Content       string         `json:"content"`
+	CreatedAt     time.Time      `json:"created_at"`
+	ImageURL      string         `json:"image_url,omitempty"`
+	ImageAltText  string         `json:"image_alt_text,omitempty"`
+	ThumbnailURL  string         `json:"thumbnail_url,omitempty"`
+	SrcSet        string         `json:"srcset,omitempty"`
+	BlurHash      string         `json:"blurhash,omitempty"`
+	DominantColor string         `json:"dominant_color,omitempty"`
+	Renditions    []RenditionURL `json:"renditions,omitempty"`
+	Images        []PostImage    `json:"images"`
+}
+
+// PostCursor marks the position of a post in a listing ordered by creation
+// time, newest first. Posts created at the same time are ordered by ID.
+type PostCursor struct {
+	CreatedAt time.Time
+	PostID    string
+}
+
+// PostPage selects the posts of a listing that come after a cursor, or the
+// first ones when After is nil.
+type PostPage struct {
+	Limit int
+	After *PostCursor
 }
@@ -15,6 +15,7 @@ type Reaction struct {
 type ReactionWithUser struct {
 	UserID       string    `json:"user_id"`
 	Username     string    `json:"username"`
+	AvatarURL    string    `json:"avatar_url"`
 	ReactionType int       `json:"reaction_type"`
 	PostID       *string   `json:"post_id,omitempty"`
 	CommentID    *string   `json:"comment_id,omitempty"`
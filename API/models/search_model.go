@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// Kinds of search results
+const (
+	SearchPosts    = "post"
+	SearchComments = "comment"
+)
+
+// SearchQuery is a full-text search with its filters. Terms is the text
+// typed by the user: words must all match, and "quoted words" must match as
+// a phrase. Zero values leave a filter off.
+type SearchQuery struct {
+	Terms      string
+	Kind       string // SearchPosts, SearchComments or empty for both
+	CategoryID int
+	Author     string // username
+	From       time.Time
+	Until      time.Time // exclusive
+	Limit      int
+	Offset     int
+}
+
+// SearchResult is a post or comment matching a search. Title is the title
+// of the post, or of the post a comment is on. In Title and Snippet the
+// matched terms sit between SearchMarkStart and SearchMarkEnd.
+type SearchResult struct {
+	Kind      string
+	PostID    string
+	CommentID string
+	UserID    string
+	Username  string
+	Title     string
+	Snippet   string
+	CreatedAt time.Time
+	Rank      float64
+}
+
+// Markers around the matched terms in search snippets. They are control
+// characters so they cannot be confused with text the user wrote.
+const (
+	SearchMarkStart = "\x02"
+	SearchMarkEnd   = "\x03"
+)
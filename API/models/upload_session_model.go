@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// UploadSession is a resumable upload in progress
+type UploadSession struct {
+	ID        string    `json:"upload_id"`
+	UserID    string    `json:"user_id"`
+	PostID    string    `json:"post_id"`
+	Filename  string    `json:"filename"`
+	Size      int64     `json:"size"`
+	Received  int64     `json:"offset"`
+	Checksum  string    `json:"sha256,omitempty"`
+	AltText   string    `json:"alt_text"`
+	Caption   string    `json:"caption"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -0,0 +1,219 @@
+// Package remote downloads files from user-supplied URLs without letting
+// them reach the server's own network.
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+
+	"forum/config"
+)
+
+var (
+	// ErrInvalidURL is returned for URLs that are not plain http(s) links.
+	ErrInvalidURL = errors.New("remote: only http and https URLs without credentials are allowed")
+	// ErrForbiddenAddress is returned when the host resolves to a loopback,
+	// private, link-local or otherwise internal address.
+	ErrForbiddenAddress = errors.New("remote: address not allowed")
+	// ErrTooManyRedirects is returned when the redirect limit is exceeded.
+	ErrTooManyRedirects = errors.New("remote: too many redirects")
+	// ErrTooLarge is returned when the body exceeds the size limit.
+	ErrTooLarge = errors.New("remote: file too large")
+	// ErrUnsupportedType is returned when the sniffed content type is not
+	// one of the accepted ones.
+	ErrUnsupportedType = errors.New("remote: unsupported content type")
+	// ErrTimeout is returned when the download takes too long.
+	ErrTimeout = errors.New("remote: download timed out")
+)
+
+// StatusError is returned when the server answers with anything but 200.
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("remote: server answered %d", e.Code)
+}
+
+// Download is a fetched file.
+type Download struct {
+	Data []byte
+	// ContentType is sniffed from the data; the server's header is ignored.
+	ContentType string
+	// URL is the address the file was finally read from, after redirects.
+	URL string
+}
+
+// blockedPrefixes are special-purpose ranges not covered by the netip
+// predicates used in allowedAddr.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"),   // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),   // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),     // reserved, broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),    // NAT64, embeds IPv4 addresses
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // local-use NAT64
+	netip.MustParsePrefix("2002::/16"),       // 6to4, embeds IPv4 addresses
+	netip.MustParsePrefix("2001::/32"),       // Teredo
+	netip.MustParsePrefix("100::/64"),        // discard-only
+	netip.MustParsePrefix("2001:db8::/32"),   // documentation
+	netip.MustParsePrefix("fec0::/10"),       // deprecated site-local
+	netip.MustParsePrefix("::ffff:0:0:0/96"), // IPv4-translated
+}
+
+// allowedAddr reports whether ip is a public unicast address.
+func allowedAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return false
+	}
+	for _, p := range blockedPrefixes {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Fetcher downloads files over HTTP. The address of every connection,
+// including those made for redirects, is checked after DNS resolution, so a
+// public name pointing at an internal address is refused too.
+type Fetcher struct {
+	client   *http.Client
+	maxBytes int64
+}
+
+// NewFetcher returns a Fetcher with the limits of cfg. Proxy settings from
+// the environment are ignored since the proxy would connect on our behalf.
+func NewFetcher(cfg config.ImportConfig) *Fetcher {
+	if cfg.AllowPrivate {
+		return newFetcher(cfg, nil)
+	}
+	return newFetcher(cfg, func(ap netip.AddrPort) bool { return allowedAddr(ap.Addr()) })
+}
+
+// newFetcher returns a Fetcher that only connects to the addresses allow
+// accepts, or to any address if allow is nil.
+func newFetcher(cfg config.ImportConfig, allow func(netip.AddrPort) bool) *Fetcher {
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if allow != nil {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil || !allow(addrPort) {
+				return ErrForbiddenAddress
+			}
+			return nil
+		}
+	}
+	transport := &http.Transport{
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   cfg.Timeout,
+		ResponseHeaderTimeout: cfg.Timeout,
+		DisableKeepAlives:     true,
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   cfg.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > cfg.MaxRedirects {
+				return ErrTooManyRedirects
+			}
+			return checkURL(req.URL)
+		},
+	}
+	return &Fetcher{client: client, maxBytes: cfg.MaxBytes}
+}
+
+// checkURL accepts absolute http and https URLs without user info.
+func checkURL(u *url.URL) error {
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" || u.User != nil {
+		return ErrInvalidURL
+	}
+	return nil
+}
+
+// Fetch downloads rawURL. The first bytes are sniffed before the rest is
+// read, and the download stops as soon as it goes over the size limit.
+// accept lists the content types allowed; empty allows any.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string, accept ...string) (*Download, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, ErrInvalidURL
+	}
+	if err := checkURL(u); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, ErrInvalidURL
+	}
+	req.Header.Set("Accept", "image/*")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, classify(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{Code: resp.StatusCode}
+	}
+	if resp.ContentLength > f.maxBytes {
+		return nil, ErrTooLarge
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(resp.Body, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, classify(err)
+	}
+	head = head[:n]
+	contentType := http.DetectContentType(head)
+	if !accepted(contentType, accept) {
+		return nil, ErrUnsupportedType
+	}
+
+	rest, err := io.ReadAll(io.LimitReader(resp.Body, f.maxBytes-int64(n)+1))
+	if err != nil {
+		return nil, classify(err)
+	}
+	if int64(n+len(rest)) > f.maxBytes {
+		return nil, ErrTooLarge
+	}
+	return &Download{Data: append(head, rest...), ContentType: contentType, URL: resp.Request.URL.String()}, nil
+}
+
+func accepted(contentType string, accept []string) bool {
+	if len(accept) == 0 {
+		return true
+	}
+	for _, t := range accept {
+		if t == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// classify maps transport errors to the package's sentinel errors where
+// possible.
+func classify(err error) error {
+	for _, known := range []error{ErrForbiddenAddress, ErrTooManyRedirects, ErrInvalidURL} {
+		if errors.Is(err, known) {
+			return known
+		}
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return ErrTimeout
+	}
+	return err
+}
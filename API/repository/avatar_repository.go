@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"forum/models"
+)
+
+type AvatarRepository struct {
+	db *sql.DB
+}
+
+func NewAvatarRepository(db *sql.DB) *AvatarRepository {
+	return &AvatarRepository{db: db}
+}
+
+// Get returns the avatar uploaded by a user, or nil if there is none
+func (r *AvatarRepository) Get(userID string) (*models.Avatar, error) {
+	var a models.Avatar
+	var sizes string
+	err := r.db.QueryRow(`SELECT user_id, dir, ext, sizes, updated_at FROM user_avatars WHERE user_id = ?`, userID).
+		Scan(&a.UserID, &a.Dir, &a.Ext, &sizes, &a.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	json.Unmarshal([]byte(sizes), &a.Sizes)
+	return &a, nil
+}
+
+// Set records a new avatar for a user and returns the one it replaces, if any
+func (r *AvatarRepository) Set(a models.Avatar) (*models.Avatar, error) {
+	sizes, err := json.Marshal(a.Sizes)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := r.Get(a.UserID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.db.Exec(`
+		INSERT INTO user_avatars (user_id, dir, ext, sizes, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET dir = excluded.dir, ext = excluded.ext, sizes = excluded.sizes, updated_at = excluded.updated_at`,
+		a.UserID, a.Dir, a.Ext, string(sizes), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return previous, nil
+}
+
+// Delete removes the avatar of a user and returns it, or nil if there was none
+func (r *AvatarRepository) Delete(userID string) (*models.Avatar, error) {
+	previous, err := r.Get(userID)
+	if err != nil || previous == nil {
+		return nil, err
+	}
+	if _, err := r.db.Exec(`DELETE FROM user_avatars WHERE user_id = ?`, userID); err != nil {
+		return nil, err
+	}
+	return previous, nil
+}
+
+// OAuthAvatarURL returns the picture of the most recently updated OAuth
+// account of a user that has one, or "" if there is none
+func (r *AvatarRepository) OAuthAvatarURL(userID string) (string, error) {
+	var url string
+	err := r.db.QueryRow(`
+		SELECT provider_avatar_url FROM oauth_accounts
+		WHERE user_id = ? AND provider_avatar_url IS NOT NULL AND provider_avatar_url != ''
+		ORDER BY updated_at DESC
+		LIMIT 1`, userID).Scan(&url)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return url, err
+}
+
+// UserExists reports whether a user with the given ID exists
+func (r *AvatarRepository) UserExists(userID string) (bool, error) {
+	var n int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM user WHERE user_id = ?`, userID).Scan(&n)
+	return n > 0, err
+}
@@ -0,0 +1,168 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"forum/models"
+)
+
+// maxBatchIDs caps the IDs bound in one IN list, well under the 999 bound
+// parameters older SQLite builds allow per statement.
+const maxBatchIDs = 500
+
+// queryIn runs query once per chunk of ids, with the %s in it replaced by
+// the chunk's placeholders, and calls scan for every row.
+func queryIn(db *sql.DB, query string, ids []string, scan func(*sql.Rows) error) error {
+	for len(ids) > 0 {
+		chunk := ids[:min(len(ids), maxBatchIDs)]
+		ids = ids[len(chunk):]
+
+		args := make([]interface{}, len(chunk))
+		for i, id := range chunk {
+			args[i] = id
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(chunk)), ", ")
+		rows, err := db.Query(fmt.Sprintf(query, placeholders), args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			if err := scan(rows); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetCategoriesByPostIDs returns the categories of several posts, keyed by
+// post ID.
+func (r *PostRepository) GetCategoriesByPostIDs(postIDs []string) (map[string][]models.Category, error) {
+	categories := make(map[string][]models.Category, len(postIDs))
+	err := queryIn(r.db, `
+		SELECT pc.post_id, c.category_id, c.name
+		FROM post_categories pc
+		JOIN categories c ON pc.category_id = c.category_id
+		WHERE pc.post_id IN (%s)
+		ORDER BY c.category_id`, postIDs, func(rows *sql.Rows) error {
+		var postID string
+		var c models.Category
+		if err := rows.Scan(&postID, &c.ID, &c.Name); err != nil {
+			return err
+		}
+		categories[postID] = append(categories[postID], c)
+		return nil
+	})
+	return categories, err
+}
+
+// GetCommentsByPostIDs returns the comments of several posts with their
+// authors, oldest first, keyed by post ID.
+func (r *CommentRepository) GetCommentsByPostIDs(postIDs []string) (map[string][]models.CommentWithUser, error) {
+	comments := make(map[string][]models.CommentWithUser, len(postIDs))
+	err := queryIn(r.db, `
+		SELECT c.comment_id, c.post_id, c.parent_id, c.user_id, u.username, c.content, c.created_at
+		FROM comments c JOIN user u ON c.user_id = u.user_id
+		WHERE c.post_id IN (%s)
+		ORDER BY c.created_at, c.rowid`, postIDs, func(rows *sql.Rows) error {
+		var c models.CommentWithUser
+		var parentID sql.NullString
+		if err := rows.Scan(&c.ID, &c.PostID, &parentID, &c.UserID, &c.Username, &c.Content, &c.CreatedAt); err != nil {
+			return err
+		}
+		c.ParentID = parentID.String
+		comments[c.PostID] = append(comments[c.PostID], c)
+		return nil
+	})
+	return comments, err
+}
+
+// GetReactionsByPostIDs returns the reactions to several posts with the
+// usernames of who reacted, keyed by post ID.
+func (r *ReactionRepository) GetReactionsByPostIDs(postIDs []string) (map[string][]models.ReactionWithUser, error) {
+	reactions := make(map[string][]models.ReactionWithUser, len(postIDs))
+	err := queryIn(r.db, `
+		SELECT r.user_id, u.username, r.reaction_type, r.post_id, r.created_at
+		FROM reactions r JOIN user u ON r.user_id = u.user_id
+		WHERE r.post_id IN (%s)
+		ORDER BY r.created_at, r.rowid`, postIDs, func(rows *sql.Rows) error {
+		var rr models.ReactionWithUser
+		if err := rows.Scan(&rr.UserID, &rr.Username, &rr.ReactionType, &rr.PostID, &rr.CreatedAt); err != nil {
+			return err
+		}
+		reactions[*rr.PostID] = append(reactions[*rr.PostID], rr)
+		return nil
+	})
+	return reactions, err
+}
+
+// GetReactionsByCommentIDs returns the reactions to several comments with
+// the usernames of who reacted, keyed by comment ID.
+func (r *ReactionRepository) GetReactionsByCommentIDs(commentIDs []string) (map[string][]models.ReactionWithUser, error) {
+	reactions := make(map[string][]models.ReactionWithUser, len(commentIDs))
+	err := queryIn(r.db, `
+		SELECT r.user_id, u.username, r.reaction_type, r.comment_id, r.created_at
+		FROM reactions r JOIN user u ON r.user_id = u.user_id
+		WHERE r.comment_id IN (%s)
+		ORDER BY r.created_at, r.rowid`, commentIDs, func(rows *sql.Rows) error {
+		var rr models.ReactionWithUser
+		if err := rows.Scan(&rr.UserID, &rr.Username, &rr.ReactionType, &rr.CommentID, &rr.CreatedAt); err != nil {
+			return err
+		}
+		reactions[*rr.CommentID] = append(reactions[*rr.CommentID], rr)
+		return nil
+	})
+	return reactions, err
+}
+
+// GetByPostIDs returns the ready images of several posts in gallery order
+// with their renditions, keyed by post ID.
+func (r *ImageRepository) GetByPostIDs(postIDs []string) (map[string][]models.Image, error) {
+	images := make(map[string][]models.Image, len(postIDs))
+	var imageIDs []string
+	err := queryIn(r.db, `SELECT `+imageColumns+` FROM images
+		WHERE post_id IN (%s) AND status = '`+models.ImageReady+`'
+		ORDER BY position ASC, created_at ASC`, postIDs, func(rows *sql.Rows) error {
+		img, err := scanImage(rows)
+		if err != nil {
+			return err
+		}
+		images[img.PostID] = append(images[img.PostID], img)
+		imageIDs = append(imageIDs, img.ID)
+		return nil
+	})
+	if err != nil || len(imageIDs) == 0 {
+		return images, err
+	}
+
+	renditions := make(map[string][]models.ImageRendition, len(imageIDs))
+	err = queryIn(r.db, `
+		SELECT rendition_id, image_id, name, file_path, width, height, content_type, size_bytes, created_at
+		FROM image_renditions
+		WHERE image_id IN (%s)
+		ORDER BY width ASC`, imageIDs, func(rows *sql.Rows) error {
+		var rd models.ImageRendition
+		if err := rows.Scan(&rd.ID, &rd.ImageID, &rd.Name, &rd.FilePath, &rd.Width, &rd.Height, &rd.ContentType, &rd.SizeBytes, &rd.CreatedAt); err != nil {
+			return err
+		}
+		renditions[rd.ImageID] = append(renditions[rd.ImageID], rd)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, imgs := range images {
+		for i := range imgs {
+			imgs[i].Renditions = renditions[imgs[i].ID]
+		}
+	}
+	return images, nil
+}
@@ -0,0 +1,279 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"forum/config"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openFeedDB creates a database with the tables and indexes the feed reads.
+func openFeedDB(tb testing.TB) *sql.DB {
+	tb.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(tb.TempDir(), "forum.db")+"?_foreign_keys=on")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+
+	for _, stmt := range []string{
+		config.CreateUserTable,
+		config.CreateCategoriesTable,
+		config.CreatePostsTable,
+		config.CreatePostCategoriesTable,
+		config.CreateCommentsTable,
+		config.AddCommentsParentID,
+		config.CreateReactionsTable,
+		config.CreateImagesTable,
+		config.AddImagesStorageBackend,
+		config.AddImagesOrientation,
+		config.AddImagesStrippedMetadata,
+		config.CreateImageBlobsTable,
+		config.AddImagesContentHash,
+		config.AddImagesPosition,
+		config.AddImagesAltText,
+		config.AddImagesCaption,
+		config.AddImagesStatus,
+		config.AddImagesBlurHash,
+		config.AddImagesDominantColor,
+		config.AddImagesPHash,
+		config.AddImagesDHash,
+		config.AddImagesSourceBytes,
+		config.CreateImageRenditionsTable,
+		config.IdxPostCategoriesPostID,
+		config.IdxCommentsPostID,
+		config.IdxReactionsPostID,
+		config.IdxReactionsCommentID,
+		config.IdxImagesPostPosition,
+		config.IdxImageRenditionsImageID,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			tb.Fatalf("%v\n%s", err, stmt)
+		}
+	}
+	return db
+}
+
+// seedFeed adds posts by a handful of users, each post with one category,
+// one image with two renditions, comments, and reactions to the post and to
+// every comment. It returns the post IDs.
+func seedFeed(tb testing.TB, db *sql.DB, posts, commentsPerPost int) []string {
+	tb.Helper()
+	tx, err := db.Begin()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	exec := func(query string, args ...interface{}) {
+		if _, err := tx.Exec(query, args...); err != nil {
+			tb.Fatalf("%v\n%s", err, query)
+		}
+	}
+	const users = 5
+	for u := 0; u < users; u++ {
+		exec(`INSERT INTO user (user_id, username, email) VALUES (?, ?, ?)`, fmt.Sprint("u", u), fmt.Sprint("user", u), fmt.Sprintf("user%d@example.com", u))
+	}
+	exec(`INSERT INTO categories (category_id, name) VALUES (1, 'General'), (2, 'Photos')`)
+
+	now := time.Now()
+	ids := make([]string, posts)
+	for p := range ids {
+		postID := fmt.Sprint("p", p)
+		ids[p] = postID
+		author := fmt.Sprint("u", p%users)
+		exec(`INSERT INTO posts (post_id, user_id, title, content, created_at) VALUES (?, ?, ?, ?, ?)`, postID, author, "title", "content", now)
+		exec(`INSERT INTO post_categories (post_id, category_id) VALUES (?, ?)`, postID, 1+p%2)
+		exec(`INSERT INTO images (image_id, post_id, user_id, file_path, thumbnail_path) VALUES (?, ?, ?, 'a.jpg', 't.jpg')`, "i"+postID, postID, author)
+		for _, name := range []string{"original", "small"} {
+			exec(`INSERT INTO image_renditions (rendition_id, image_id, name, file_path, width, height, content_type) VALUES (?, ?, ?, 'a.jpg', 10, 10, 'image/jpeg')`, name+postID, "i"+postID, name)
+		}
+		for u := 0; u < 2; u++ {
+			exec(`INSERT INTO reactions (user_id, reaction_type, post_id) VALUES (?, 1, ?)`, fmt.Sprint("u", u), postID)
+		}
+		for c := 0; c < commentsPerPost; c++ {
+			commentID := fmt.Sprintf("c%d-%d", p, c)
+			exec(`INSERT INTO comments (comment_id, post_id, user_id, content, created_at) VALUES (?, ?, ?, 'comment', ?)`, commentID, postID, fmt.Sprint("u", c%users), now)
+			exec(`INSERT INTO reactions (user_id, reaction_type, comment_id) VALUES (?, 2, ?)`, fmt.Sprint("u", c%users), commentID)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		tb.Fatal(err)
+	}
+	return ids
+}
+
+func TestQueryInChunks(t *testing.T) {
+	db := openFeedDB(t)
+	ids := seedFeed(t, db, 2*maxBatchIDs+234, 0)
+
+	for _, n := range []int{0, 1, maxBatchIDs, maxBatchIDs + 1, len(ids)} {
+		// COUNT(*) gives one row per query, so the rows are the chunk sizes
+		var chunks []int
+		err := queryIn(db, `SELECT COUNT(*) FROM posts WHERE post_id IN (%s)`, ids[:n], func(rows *sql.Rows) error {
+			var count int
+			if err := rows.Scan(&count); err != nil {
+				return err
+			}
+			chunks = append(chunks, count)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%d IDs: %v", n, err)
+		}
+
+		var want []int
+		for left := n; left > 0; left -= maxBatchIDs {
+			want = append(want, min(left, maxBatchIDs))
+		}
+		if !reflect.DeepEqual(chunks, want) {
+			t.Errorf("%d IDs: chunks %v, want %v", n, chunks, want)
+		}
+	}
+}
+
+func TestBatchMatchesPerPost(t *testing.T) {
+	db := openFeedDB(t)
+	ids := seedFeed(t, db, maxBatchIDs+20, 3)
+	posts, comments, reactions, images := NewPostRepository(db), NewCommentRepository(db), NewReactionRepository(db), NewImageRepository(db)
+
+	categories, err := posts.GetCategoriesByPostIDs(ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byPost, err := comments.GetCommentsByPostIDs(ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postReactions, err := reactions.GetReactionsByPostIDs(ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imgs, err := images.GetByPostIDs(ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var commentIDs []string
+	for _, cs := range byPost {
+		for _, c := range cs {
+			commentIDs = append(commentIDs, c.ID)
+		}
+	}
+	commentReactions, err := reactions.GetReactionsByCommentIDs(commentIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range ids {
+		cats, err := posts.GetCategoriesByPostID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(categories[id]) != 1 || categories[id][0] != cats[0] {
+			t.Errorf("post %s: categories %v, want %v", id, categories[id], cats)
+		}
+		cs, err := comments.GetCommentsByPostWithUser(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(byPost[id]) != len(cs) {
+			t.Fatalf("post %s: %d comments, want %d", id, len(byPost[id]), len(cs))
+		}
+		for _, c := range cs {
+			rs, err := reactions.GetReactionsByCommentWithUser(c.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(commentReactions[c.ID]) != len(rs) {
+				t.Errorf("comment %s: %d reactions, want %d", c.ID, len(commentReactions[c.ID]), len(rs))
+			}
+		}
+		rs, err := reactions.GetReactionsByPostWithUser(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(postReactions[id]) != len(rs) {
+			t.Errorf("post %s: %d reactions, want %d", id, len(postReactions[id]), len(rs))
+		}
+		if len(imgs[id]) != 1 || len(imgs[id][0].Renditions) != 2 {
+			t.Errorf("post %s: images %+v", id, imgs[id])
+		}
+	}
+}
+
+// The feed benchmarks load what a page of 50 posts shows, each post with 10
+// comments, from a database of 2000 such posts: once with the queries per
+// post and per comment the feed used to make, and once with the batches.
+const (
+	benchPosts    = 2000
+	benchPage     = 50
+	benchComments = 10
+)
+
+func BenchmarkFeedPerPost(b *testing.B) {
+	db := openFeedDB(b)
+	ids := seedFeed(b, db, benchPosts, benchComments)[:benchPage]
+	posts, comments, reactions, images := NewPostRepository(db), NewCommentRepository(db), NewReactionRepository(db), NewImageRepository(db)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, id := range ids {
+			if _, err := posts.GetCategoriesByPostID(id); err != nil {
+				b.Fatal(err)
+			}
+			if _, err := images.GetByPostID(id); err != nil {
+				b.Fatal(err)
+			}
+			cs, err := comments.GetCommentsByPostWithUser(id)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for _, c := range cs {
+				if _, err := reactions.GetReactionsByCommentWithUser(c.ID); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if _, err := reactions.GetReactionsByPostWithUser(id); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkFeedBatched(b *testing.B) {
+	db := openFeedDB(b)
+	ids := seedFeed(b, db, benchPosts, benchComments)[:benchPage]
+	posts, comments, reactions, images := NewPostRepository(db), NewCommentRepository(db), NewReactionRepository(db), NewImageRepository(db)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := posts.GetCategoriesByPostIDs(ids); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := images.GetByPostIDs(ids); err != nil {
+			b.Fatal(err)
+		}
+		byPost, err := comments.GetCommentsByPostIDs(ids)
+		if err != nil {
+			b.Fatal(err)
+		}
+		var commentIDs []string
+		for _, cs := range byPost {
+			for _, c := range cs {
+				commentIDs = append(commentIDs, c.ID)
+			}
+		}
+		if _, err := reactions.GetReactionsByCommentIDs(commentIDs); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := reactions.GetReactionsByPostIDs(ids); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
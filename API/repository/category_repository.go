@@ -72,7 +72,7 @@ func (r *CategoryRepository) GetCategoryByID(id int) (*models.Category, error) {
 
 // // repository/comment_repository.go
 func (r *CommentRepository) GetCommentsByPostWithUser(postID string) ([]models.CommentWithUser, error) {
-	query := `SELECT c.comment_id, c.post_id, c.user_id, u.username, c.content, c.created_at
+	query := `SELECT c.comment_id, c.post_id, c.parent_id, c.user_id, u.username, c.content, c.created_at
 			  FROM comments c JOIN user u ON c.user_id = u.user_id
 			  WHERE c.post_id = ?`
 
@@ -85,25 +85,28 @@ func (r *CommentRepository) GetCommentsByPostWithUser(postID string) ([]models.C
 	var comments []models.CommentWithUser
 	for rows.Next() {
 		var c models.CommentWithUser
-		if err := rows.Scan(&c.ID, &c.PostID, &c.UserID, &c.Username, &c.Content, &c.CreatedAt); err != nil {
+		var parentID sql.NullString
+		if err := rows.Scan(&c.ID, &c.PostID, &parentID, &c.UserID, &c.Username, &c.Content, &c.CreatedAt); err != nil {
 			return nil, err
 		}
+		c.ParentID = parentID.String
 		comments = append(comments, c)
 	}
 	return comments, nil
 }
 
-func (r *PostRepository) GetPostsByCategoryWithUser(categoryID int) ([]models.PostWithUser, error) {
+// GetPostsByCategoryWithUser returns a page of the posts in a category with
+// their authors, newest first, and the cursor of the next page.
+func (r *PostRepository) GetPostsByCategoryWithUser(categoryID int, page models.PostPage) ([]models.PostWithUser, *models.PostCursor, error) {
+	cond, tail, args := pageSQL(page)
 	rows, err := r.db.Query(`
 		SELECT p.post_id, p.user_id, u.username, pc.category_id, p.title, p.content, p.created_at
 		FROM posts p
 		JOIN post_categories pc ON p.post_id = pc.post_id
 		JOIN user u ON p.user_id = u.user_id
-		WHERE pc.category_id = ?
-		ORDER BY p.created_at DESC
-	`, categoryID)
+		WHERE pc.category_id = ? AND `+cond+tail, append([]interface{}{categoryID}, args...)...)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer rows.Close()
 
@@ -112,9 +115,12 @@ func (r *PostRepository) GetPostsByCategoryWithUser(categoryID int) ([]models.Po
 		var post models.PostWithUser
 		err := rows.Scan(&post.ID, &post.UserID, &post.Username, &post.CategoryID, &post.Title, &post.Content, &post.CreatedAt)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		posts = append(posts, post)
 	}
-	return posts, nil
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	return splitPage(posts, page.Limit)
 }
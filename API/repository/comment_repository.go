@@ -41,8 +41,8 @@ func (r *CommentRepository) GetAllComments() ([]models.Comment, error) {
 func (r *CommentRepository) Create(comment models.Comment) (*models.Comment, error) {
 	comment.ID = utils.GenerateUUID()
 	comment.CreatedAt = time.Now()
-	_, err := r.db.Exec(`INSERT INTO comments (comment_id, post_id, user_id, content, created_at) VALUES (?, ?, ?, ?, ?)`,
-		comment.ID, comment.PostID, comment.UserID, comment.Content, comment.CreatedAt)
+	_, err := r.db.Exec(`INSERT INTO comments (comment_id, post_id, parent_id, user_id, content, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		comment.ID, comment.PostID, comment.ParentID, comment.UserID, comment.Content, comment.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -51,9 +51,9 @@ func (r *CommentRepository) Create(comment models.Comment) (*models.Comment, err
 
 // GetByID retrieves a comment by ID
 func (r *CommentRepository) GetByID(id string) (*models.Comment, error) {
-	row := r.db.QueryRow(`SELECT comment_id, post_id, user_id, content, created_at, updated_at FROM comments WHERE comment_id = ?`, id)
+	row := r.db.QueryRow(`SELECT comment_id, post_id, parent_id, user_id, content, created_at, updated_at FROM comments WHERE comment_id = ?`, id)
 	var c models.Comment
-	err := row.Scan(&c.ID, &c.PostID, &c.UserID, &c.Content, &c.CreatedAt, &c.UpdatedAt)
+	err := row.Scan(&c.ID, &c.PostID, &c.ParentID, &c.UserID, &c.Content, &c.CreatedAt, &c.UpdatedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
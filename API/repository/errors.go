@@ -13,4 +13,8 @@ var (
 	ErrOAuthStateNotFound   = errors.New("oauth state not found")
 	ErrOAuthStateExpired    = errors.New("oauth state expired")
 	ErrOAuthAccountExists   = errors.New("oauth account already exists")
+	ErrImageOrder           = errors.New("image order must list every image of the post once")
+	ErrBanNotFound          = errors.New("banned image not found")
+	ErrTooManyUploads       = errors.New("too many uploads in progress")
+	ErrStorageQuota         = errors.New("storage quota exceeded")
 )
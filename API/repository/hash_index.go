@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"math/bits"
+	"strconv"
+)
+
+// imageHash is the pair of 64-bit perceptual hashes stored for an image.
+type imageHash struct {
+	p, d uint64
+}
+
+// parseImageHash parses the hex pHash and dHash of an image; ok is false for
+// images that were not hashed.
+func parseImageHash(phash, dhash string) (imageHash, bool) {
+	p, err := strconv.ParseUint(phash, 16, 64)
+	if err != nil {
+		return imageHash{}, false
+	}
+	d, err := strconv.ParseUint(dhash, 16, 64)
+	if err != nil {
+		return imageHash{}, false
+	}
+	return imageHash{p: p, d: d}, true
+}
+
+// distance is the larger of the Hamming distances of both hashes, so two
+// images only count as alike when both hashes agree. As the maximum of two
+// metrics it is a metric itself, which the BK-tree relies on.
+func (a imageHash) distance(b imageHash) int {
+	return max(bits.OnesCount64(a.p^b.p), bits.OnesCount64(a.d^b.d))
+}
+
+// hashIndex is a BK-tree over image hashes: every child sits at a distinct
+// distance from its parent, so by the triangle inequality a search within
+// radius r only descends into children whose distance to the node is within
+// r of the query's.
+type hashIndex struct {
+	root *hashNode
+}
+
+type hashNode struct {
+	hash     imageHash
+	ids      []string // every entry with exactly this hash
+	children map[int]*hashNode
+}
+
+// hashMatch is an entry found by search.
+type hashMatch struct {
+	id       string
+	distance int
+}
+
+func (t *hashIndex) add(h imageHash, id string) {
+	if t.root == nil {
+		t.root = &hashNode{hash: h, ids: []string{id}}
+		return
+	}
+	n := t.root
+	for {
+		d := n.hash.distance(h)
+		if d == 0 {
+			n.ids = append(n.ids, id)
+			return
+		}
+		child, ok := n.children[d]
+		if !ok {
+			if n.children == nil {
+				n.children = make(map[int]*hashNode)
+			}
+			n.children[d] = &hashNode{hash: h, ids: []string{id}}
+			return
+		}
+		n = child
+	}
+}
+
+// search returns every entry within maxDist of h.
+func (t *hashIndex) search(h imageHash, maxDist int) []hashMatch {
+	var matches []hashMatch
+	if t.root == nil {
+		return nil
+	}
+	stack := []*hashNode{t.root}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		d := n.hash.distance(h)
+		if d <= maxDist {
+			for _, id := range n.ids {
+				matches = append(matches, hashMatch{id: id, distance: d})
+			}
+		}
+		for cd, child := range n.children {
+			if cd >= d-maxDist && cd <= d+maxDist {
+				stack = append(stack, child)
+			}
+		}
+	}
+	return matches
+}
@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"forum/config"
+	"forum/models"
+)
+
+// randomHashes returns n hashes in a few tight clusters, so that searches
+// find neighbours at every distance, and the IDs they are indexed under.
+func randomHashes(rng *rand.Rand, n int) ([]imageHash, []string) {
+	centres := make([]imageHash, 8)
+	for i := range centres {
+		centres[i] = imageHash{p: rng.Uint64(), d: rng.Uint64()}
+	}
+	hashes := make([]imageHash, n)
+	ids := make([]string, n)
+	for i := range hashes {
+		h := centres[rng.Intn(len(centres))]
+		for flips := rng.Intn(12); flips > 0; flips-- {
+			h.p ^= 1 << rng.Intn(64)
+			h.d ^= 1 << rng.Intn(64)
+		}
+		hashes[i], ids[i] = h, fmt.Sprintf("img%d", i)
+	}
+	// Exact duplicates share a node
+	hashes[1] = hashes[0]
+	return hashes, ids
+}
+
+func sortMatches(m []hashMatch) []hashMatch {
+	sort.Slice(m, func(i, j int) bool { return m[i].id < m[j].id })
+	return m
+}
+
+func TestImageHashDistance(t *testing.T) {
+	a := imageHash{p: 0b1011, d: 0}
+	for _, tt := range []struct {
+		b    imageHash
+		want int
+	}{
+		{a, 0},
+		{imageHash{p: 0b1010, d: 0}, 1},
+		{imageHash{p: 0b1011, d: 0b111}, 3},
+		{imageHash{p: 0, d: 0b1}, 3},
+	} {
+		if got := a.distance(tt.b); got != tt.want || tt.b.distance(a) != got {
+			t.Errorf("distance(%v, %v) = %d, want %d both ways", a, tt.b, got, tt.want)
+		}
+	}
+
+	if h, ok := parseImageHash("00000000000000ff", "8000000000000000"); !ok || h != (imageHash{p: 0xff, d: 1 << 63}) {
+		t.Errorf("parsed %v, %v", h, ok)
+	}
+	for _, bad := range [][2]string{{"", ""}, {"ff", "zz"}, {"10000000000000000", "0"}} {
+		if _, ok := parseImageHash(bad[0], bad[1]); ok {
+			t.Errorf("parsed %q, %q", bad[0], bad[1])
+		}
+	}
+}
+
+func TestHashIndexMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	hashes, ids := randomHashes(rng, 2000)
+	var index hashIndex
+	if got := index.search(hashes[0], 64); got != nil {
+		t.Fatalf("empty index found %v", got)
+	}
+	for i, h := range hashes {
+		index.add(h, ids[i])
+	}
+
+	queries := append(hashes[:50:50], imageHash{p: rng.Uint64(), d: rng.Uint64()})
+	for _, q := range queries {
+		for _, radius := range []int{0, 3, 10, 20, 64} {
+			var want []hashMatch
+			for i, h := range hashes {
+				if d := h.distance(q); d <= radius {
+					want = append(want, hashMatch{id: ids[i], distance: d})
+				}
+			}
+			got := index.search(q, radius)
+			if !reflect.DeepEqual(sortMatches(got), sortMatches(want)) {
+				t.Fatalf("radius %d: found %d entries, brute force %d", radius, len(got), len(want))
+			}
+		}
+	}
+}
+
+func TestBanListFollowsChanges(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "forum.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(config.CreateBannedImagesTable); err != nil {
+		t.Fatal(err)
+	}
+	repo := NewImageHashRepository(db)
+
+	rng := rand.New(rand.NewSource(2))
+	hashes, _ := randomHashes(rng, 200)
+	hex := func(h imageHash) (string, string) {
+		return fmt.Sprintf("%016x", h.p), fmt.Sprintf("%016x", h.d)
+	}
+	banned := map[string]imageHash{}
+	for _, h := range hashes[:100] {
+		p, d := hex(h)
+		ban, err := repo.Ban(models.BannedImage{PHash: p, DHash: d, Reason: "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		banned[ban.ID] = h
+	}
+
+	// check compares MatchBan with a scan of what is still banned
+	check := func() {
+		t.Helper()
+		for _, q := range hashes {
+			best := -1
+			for _, h := range banned {
+				if d := h.distance(q); d <= 6 && (best < 0 || d < best) {
+					best = d
+				}
+			}
+			p, d := hex(q)
+			ban, dist, err := repo.MatchBan(p, d, 6)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case best < 0 && ban != nil:
+				t.Fatalf("matched ban %s at %d, none is within 6", ban.ID, dist)
+			case best >= 0 && (ban == nil || dist != best):
+				t.Fatalf("matched %v at %d, want distance %d", ban, dist, best)
+			case ban != nil && banned[ban.ID].distance(q) != dist:
+				t.Fatalf("ban %s reported at %d", ban.ID, dist)
+			}
+		}
+	}
+	check()
+
+	// Unbanning removes entries from the index
+	n := 0
+	for id := range banned {
+		if n++; n > 60 {
+			break
+		}
+		if err := repo.Unban(id); err != nil {
+			t.Fatal(err)
+		}
+		delete(banned, id)
+	}
+	check()
+	if err := repo.Unban("missing"); err != ErrBanNotFound {
+		t.Errorf("Unban(missing) = %v", err)
+	}
+}
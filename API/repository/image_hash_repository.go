@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	"forum/models"
+	"forum/utils"
+)
+
+// ImageHashRepository stores the banned images list and finds images by
+// perceptual hash. Lookups go through in-memory BK-trees: the banned list is
+// reloaded after every change, the index of forum images is rebuilt once it
+// is older than refresh, so it may briefly miss new uploads and still list
+// deleted images.
+type ImageHashRepository struct {
+	db      *sql.DB
+	refresh time.Duration
+
+	mu       sync.Mutex
+	bans     *hashIndex // nil when banned_images changed
+	banByID  map[string]models.BannedImage
+	images   *hashIndex
+	imagesAt time.Time
+}
+
+func NewImageHashRepository(db *sql.DB) *ImageHashRepository {
+	return &ImageHashRepository{db: db, refresh: time.Minute}
+}
+
+// Ban adds the hashes of an image to the banned list
+func (r *ImageHashRepository) Ban(ban models.BannedImage) (*models.BannedImage, error) {
+	ban.ID = utils.GenerateUUID()
+	ban.CreatedAt = time.Now()
+
+	var imageID, createdBy interface{}
+	if ban.ImageID != "" {
+		imageID = ban.ImageID
+	}
+	if ban.CreatedBy != "" {
+		createdBy = ban.CreatedBy
+	}
+	_, err := r.db.Exec(`INSERT INTO banned_images (ban_id, phash, dhash, reason, image_id, created_by, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		ban.ID, ban.PHash, ban.DHash, ban.Reason, imageID, createdBy, ban.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.bans = nil
+	r.mu.Unlock()
+	return &ban, nil
+}
+
+// Unban removes an entry from the banned list
+func (r *ImageHashRepository) Unban(id string) error {
+	res, err := r.db.Exec(`DELETE FROM banned_images WHERE ban_id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrBanNotFound
+	}
+
+	r.mu.Lock()
+	r.bans = nil
+	r.mu.Unlock()
+	return nil
+}
+
+// ListBans returns the banned list, newest first
+func (r *ImageHashRepository) ListBans() ([]models.BannedImage, error) {
+	rows, err := r.db.Query(`SELECT ban_id, phash, dhash, reason, image_id, created_by, created_at FROM banned_images ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bans := []models.BannedImage{}
+	for rows.Next() {
+		var ban models.BannedImage
+		var imageID, createdBy sql.NullString
+		if err := rows.Scan(&ban.ID, &ban.PHash, &ban.DHash, &ban.Reason, &imageID, &createdBy, &ban.CreatedAt); err != nil {
+			return nil, err
+		}
+		ban.ImageID, ban.CreatedBy = imageID.String, createdBy.String
+		bans = append(bans, ban)
+	}
+	return bans, rows.Err()
+}
+
+// MatchBan returns the banned image closest to the given hashes and its
+// distance, or nil if none is within maxDist
+func (r *ImageHashRepository) MatchBan(phash, dhash string, maxDist int) (*models.BannedImage, int, error) {
+	h, ok := parseImageHash(phash, dhash)
+	if !ok {
+		return nil, 0, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.bans == nil {
+		bans, err := r.ListBans()
+		if err != nil {
+			return nil, 0, err
+		}
+		index, byID := &hashIndex{}, make(map[string]models.BannedImage, len(bans))
+		for _, ban := range bans {
+			if bh, ok := parseImageHash(ban.PHash, ban.DHash); ok {
+				index.add(bh, ban.ID)
+				byID[ban.ID] = ban
+			}
+		}
+		r.bans, r.banByID = index, byID
+	}
+
+	matches := r.bans.search(h, maxDist)
+	if len(matches) == 0 {
+		return nil, 0, nil
+	}
+	best := matches[0]
+	for _, m := range matches[1:] {
+		if m.distance < best.distance {
+			best = m
+		}
+	}
+	ban := r.banByID[best.id]
+	return &ban, best.distance, nil
+}
+
+// FindSimilar returns the processed images whose hashes are within maxDist
+// of the given ones, closest first
+func (r *ImageHashRepository) FindSimilar(phash, dhash string, maxDist int) ([]models.ImageMatch, error) {
+	h, ok := parseImageHash(phash, dhash)
+	if !ok {
+		return nil, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.images == nil || time.Since(r.imagesAt) > r.refresh {
+		index, err := r.loadImages()
+		if err != nil {
+			return nil, err
+		}
+		r.images, r.imagesAt = index, time.Now()
+	}
+
+	found := r.images.search(h, maxDist)
+	matches := make([]models.ImageMatch, len(found))
+	for i, m := range found {
+		matches[i] = models.ImageMatch{ImageID: m.id, Distance: m.distance}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].ImageID < matches[j].ImageID
+	})
+	return matches, nil
+}
+
+// loadImages builds the index of every processed, hashed image
+func (r *ImageHashRepository) loadImages() (*hashIndex, error) {
+	rows, err := r.db.Query(`SELECT image_id, phash, dhash FROM images WHERE status = ? AND phash != ''`, models.ImageReady)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	index := &hashIndex{}
+	for rows.Next() {
+		var id, phash, dhash string
+		if err := rows.Scan(&id, &phash, &dhash); err != nil {
+			return nil, err
+		}
+		if h, ok := parseImageHash(phash, dhash); ok {
+			index.add(h, id)
+		}
+	}
+	return index, rows.Err()
+}
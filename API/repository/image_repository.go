@@ -2,6 +2,7 @@ package repository
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 
 	"forum/models"
@@ -16,19 +17,219 @@ func NewImageRepository(db *sql.DB) *ImageRepository {
 	return &ImageRepository{db: db}
 }
 
+// Create inserts the image together with its renditions, after the last
+// image of the post. Images with a content hash also register their blob;
+// the reference count is updated by the images triggers.
 func (r *ImageRepository) Create(img models.Image) (*models.Image, error) {
 	img.ID = utils.GenerateUUID()
 	img.CreatedAt = time.Now()
-	_, err := r.db.Exec(`INSERT INTO images (image_id, post_id, user_id, file_path, thumbnail_path, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
-		img.ID, img.PostID, img.UserID, img.FilePath, img.ThumbnailPath, img.CreatedAt)
+
+	stripped := []byte("[]")
+	if len(img.StrippedMetadata) > 0 {
+		var err error
+		if stripped, err = json.Marshal(img.StrippedMetadata); err != nil {
+			return nil, err
+		}
+	}
+	if img.Orientation == 0 {
+		img.Orientation = 1
+	}
+	if img.Status == "" {
+		img.Status = models.ImageReady
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var contentHash interface{}
+	if img.ContentHash != "" {
+		contentHash = img.ContentHash
+		_, err = tx.Exec(`INSERT INTO image_blobs (content_hash, file_path, thumbnail_path, storage_backend, created_at) VALUES (?, ?, ?, ?, ?) ON CONFLICT(content_hash) DO NOTHING`,
+			img.ContentHash, img.FilePath, img.ThumbnailPath, img.Backend, img.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.QueryRow(`SELECT COALESCE(MAX(position) + 1, 0) FROM images WHERE post_id = ?`, img.PostID).Scan(&img.Position)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`INSERT INTO images (image_id, post_id, user_id, file_path, thumbnail_path, storage_backend, orientation, stripped_metadata, content_hash, position, alt_text, caption, status, blurhash, dominant_color, phash, dhash, source_bytes, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		img.ID, img.PostID, img.UserID, img.FilePath, img.ThumbnailPath, img.Backend, img.Orientation, string(stripped), contentHash, img.Position, img.AltText, img.Caption, img.Status, img.BlurHash, img.DominantColor, img.PHash, img.DHash, img.SourceBytes, img.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := insertRenditions(tx, &img); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &img, nil
+}
+
+// CreateProcessing inserts an upload waiting for its processing job, after
+// the last image of the post, unless its SourceBytes would take the user
+// past quota bytes, in which case it returns ErrStorageQuota. The quota
+// check and the insert are one statement, so concurrent uploads cannot all
+// fit in the same remaining space. A quota of 0 is unlimited.
+func (r *ImageRepository) CreateProcessing(img models.Image, quota int64) (*models.Image, error) {
+	img.ID = utils.GenerateUUID()
+	img.CreatedAt = time.Now()
+	img.Orientation = 1
+	img.Status = models.ImageProcessing
+
+	res, err := r.db.Exec(`
+		INSERT INTO images (image_id, post_id, user_id, file_path, thumbnail_path, storage_backend, orientation, position, alt_text, caption, status, phash, dhash, source_bytes, created_at)
+		SELECT ?, ?, ?, '', '', ?, ?, (SELECT COALESCE(MAX(position) + 1, 0) FROM images WHERE post_id = ?), ?, ?, ?, ?, ?, ?, ?
+		WHERE ? = 0 OR `+storageUsedSQL+` + ? <= ?`,
+		img.ID, img.PostID, img.UserID, img.Backend, img.Orientation, img.PostID, img.AltText, img.Caption, img.Status, img.PHash, img.DHash, img.SourceBytes, img.CreatedAt,
+		quota, img.UserID, img.UserID, img.SourceBytes, quota)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrStorageQuota
+	}
+	if err := r.db.QueryRow(`SELECT position FROM images WHERE image_id = ?`, img.ID).Scan(&img.Position); err != nil {
+		return nil, err
+	}
+	return &img, nil
+}
+
+// insertRenditions stores the renditions of img, filling in their IDs
+func insertRenditions(tx *sql.Tx, img *models.Image) error {
+	stmt, err := tx.Prepare(`INSERT INTO image_renditions (rendition_id, image_id, name, file_path, width, height, content_type, size_bytes, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for i := range img.Renditions {
+		rd := &img.Renditions[i]
+		rd.ID = utils.GenerateUUID()
+		rd.ImageID = img.ID
+		rd.CreatedAt = img.CreatedAt
+		if _, err := stmt.Exec(rd.ID, rd.ImageID, rd.Name, rd.FilePath, rd.Width, rd.Height, rd.ContentType, rd.SizeBytes, rd.CreatedAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Complete records the processed files of an image created with status
+// processing and marks it ready, or quarantined when img.Status says so. It
+// returns sql.ErrNoRows when the image was deleted, or already completed, in
+// the meantime.
+func (r *ImageRepository) Complete(img models.Image) error {
+	stripped := []byte("[]")
+	if len(img.StrippedMetadata) > 0 {
+		var err error
+		if stripped, err = json.Marshal(img.StrippedMetadata); err != nil {
+			return err
+		}
+	}
+	if img.Orientation == 0 {
+		img.Orientation = 1
+	}
+	if img.Status != models.ImageQuarantined {
+		img.Status = models.ImageReady
+	}
+
+	tx, err := r.db.Begin()
 	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var contentHash interface{}
+	if img.ContentHash != "" {
+		contentHash = img.ContentHash
+		_, err = tx.Exec(`INSERT INTO image_blobs (content_hash, file_path, thumbnail_path, storage_backend, created_at) VALUES (?, ?, ?, ?, ?) ON CONFLICT(content_hash) DO NOTHING`,
+			img.ContentHash, img.FilePath, img.ThumbnailPath, img.Backend, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	res, err := tx.Exec(`UPDATE images SET file_path = ?, thumbnail_path = ?, storage_backend = ?, orientation = ?, stripped_metadata = ?, content_hash = ?, blurhash = ?, dominant_color = ?, status = ? WHERE image_id = ? AND status = ?`,
+		img.FilePath, img.ThumbnailPath, img.Backend, img.Orientation, string(stripped), contentHash, img.BlurHash, img.DominantColor, img.Status, img.ID, models.ImageProcessing)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if err := insertRenditions(tx, &img); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetStatus changes the processing status of an image
+func (r *ImageRepository) SetStatus(id, status string) error {
+	_, err := r.db.Exec(`UPDATE images SET status = ? WHERE image_id = ?`, status, id)
+	return err
+}
+
+// imageColumns lists the images columns in the order scanImage reads them
+const imageColumns = `image_id, post_id, user_id, file_path, thumbnail_path, storage_backend, orientation, stripped_metadata, content_hash, position, alt_text, caption, status, blurhash, dominant_color, phash, dhash, created_at`
+
+// scanImage reads one row selected with imageColumns
+func scanImage(row interface{ Scan(...interface{}) error }) (models.Image, error) {
+	var img models.Image
+	var stripped string
+	var contentHash sql.NullString
+	if err := row.Scan(&img.ID, &img.PostID, &img.UserID, &img.FilePath, &img.ThumbnailPath, &img.Backend, &img.Orientation, &stripped, &contentHash, &img.Position, &img.AltText, &img.Caption, &img.Status, &img.BlurHash, &img.DominantColor, &img.PHash, &img.DHash, &img.CreatedAt); err != nil {
+		return img, err
+	}
+	img.ContentHash = contentHash.String
+	if stripped != "" {
+		json.Unmarshal([]byte(stripped), &img.StrippedMetadata)
+	}
+	return img, nil
+}
+
+// GetByID returns an image with its renditions, or nil if it does not exist
+func (r *ImageRepository) GetByID(id string) (*models.Image, error) {
+	img, err := scanImage(r.db.QueryRow(`SELECT `+imageColumns+` FROM images WHERE image_id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if img.Renditions, err = r.getRenditions(img.ID); err != nil {
 		return nil, err
 	}
 	return &img, nil
 }
 
+// GetByPostID returns the ready images of a post in gallery order with their
+// renditions attached
 func (r *ImageRepository) GetByPostID(postID string) ([]models.Image, error) {
-	rows, err := r.db.Query(`SELECT image_id, post_id, user_id, file_path, thumbnail_path, created_at FROM images WHERE post_id = ?`, postID)
+	return r.getByPostID(postID, true)
+}
+
+// GetGalleryByPostID is GetByPostID including images that are still
+// processing or failed, for the post's author
+func (r *ImageRepository) GetGalleryByPostID(postID string) ([]models.Image, error) {
+	return r.getByPostID(postID, false)
+}
+
+func (r *ImageRepository) getByPostID(postID string, readyOnly bool) ([]models.Image, error) {
+	query := `SELECT ` + imageColumns + ` FROM images WHERE post_id = ?`
+	if readyOnly {
+		query += ` AND status = '` + models.ImageReady + `'`
+	}
+	rows, err := r.db.Query(query+` ORDER BY position ASC, created_at ASC`, postID)
 	if err != nil {
 		return nil, err
 	}
@@ -36,11 +237,296 @@ func (r *ImageRepository) GetByPostID(postID string) ([]models.Image, error) {
 
 	var images []models.Image
 	for rows.Next() {
-		var img models.Image
-		if err := rows.Scan(&img.ID, &img.PostID, &img.UserID, &img.FilePath, &img.ThumbnailPath, &img.CreatedAt); err != nil {
+		img, err := scanImage(rows)
+		if err != nil {
 			return nil, err
 		}
 		images = append(images, img)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(images) == 0 {
+		return images, nil
+	}
+
+	renditions, err := r.getRenditionsByPostID(postID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range images {
+		images[i].Renditions = renditions[images[i].ID]
+	}
 	return images, nil
 }
+
+// getRenditionsByPostID returns renditions grouped by image ID, smallest first
+func (r *ImageRepository) getRenditionsByPostID(postID string) (map[string][]models.ImageRendition, error) {
+	rows, err := r.db.Query(`
+		SELECT ir.rendition_id, ir.image_id, ir.name, ir.file_path, ir.width, ir.height, ir.content_type, ir.size_bytes, ir.created_at
+		FROM image_renditions ir
+		JOIN images i ON ir.image_id = i.image_id
+		WHERE i.post_id = ?
+		ORDER BY ir.width ASC`, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	renditions := make(map[string][]models.ImageRendition)
+	for rows.Next() {
+		var rd models.ImageRendition
+		if err := rows.Scan(&rd.ID, &rd.ImageID, &rd.Name, &rd.FilePath, &rd.Width, &rd.Height, &rd.ContentType, &rd.SizeBytes, &rd.CreatedAt); err != nil {
+			return nil, err
+		}
+		renditions[rd.ImageID] = append(renditions[rd.ImageID], rd)
+	}
+	return renditions, rows.Err()
+}
+
+// FindByHash returns an existing image whose blob has the given content hash
+// and is still referenced, with its renditions, or nil if there is none.
+func (r *ImageRepository) FindByHash(hash string) (*models.Image, error) {
+	var img models.Image
+	var stripped string
+	err := r.db.QueryRow(`
+		SELECT i.image_id, i.post_id, i.user_id, i.file_path, i.thumbnail_path, i.storage_backend, i.orientation, i.stripped_metadata, i.status, i.blurhash, i.dominant_color, i.phash, i.dhash, i.created_at
+		FROM images i
+		JOIN image_blobs b ON i.content_hash = b.content_hash
+		WHERE b.content_hash = ? AND b.ref_count > 0
+		ORDER BY i.created_at DESC
+		LIMIT 1`, hash).Scan(&img.ID, &img.PostID, &img.UserID, &img.FilePath, &img.ThumbnailPath, &img.Backend, &img.Orientation, &stripped, &img.Status, &img.BlurHash, &img.DominantColor, &img.PHash, &img.DHash, &img.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	img.ContentHash = hash
+	if stripped != "" {
+		json.Unmarshal([]byte(stripped), &img.StrippedMetadata)
+	}
+
+	if img.Renditions, err = r.getRenditions(img.ID); err != nil {
+		return nil, err
+	}
+	return &img, nil
+}
+
+// getRenditions returns the renditions of one image, smallest first
+func (r *ImageRepository) getRenditions(imageID string) ([]models.ImageRendition, error) {
+	rows, err := r.db.Query(`SELECT rendition_id, image_id, name, file_path, width, height, content_type, size_bytes, created_at FROM image_renditions WHERE image_id = ? ORDER BY width ASC`, imageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var renditions []models.ImageRendition
+	for rows.Next() {
+		var rd models.ImageRendition
+		if err := rows.Scan(&rd.ID, &rd.ImageID, &rd.Name, &rd.FilePath, &rd.Width, &rd.Height, &rd.ContentType, &rd.SizeBytes, &rd.CreatedAt); err != nil {
+			return nil, err
+		}
+		renditions = append(renditions, rd)
+	}
+	return renditions, rows.Err()
+}
+
+// UpdateText sets the alt text and caption of an image
+func (r *ImageRepository) UpdateText(id, altText, caption string) error {
+	res, err := r.db.Exec(`UPDATE images SET alt_text = ?, caption = ? WHERE image_id = ?`, altText, caption, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Reorder assigns positions to the images of a post in the order of
+// imageIDs, which must list every image of the post exactly once.
+func (r *ImageRepository) Reorder(postID string, imageIDs []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM images WHERE post_id = ?`, postID).Scan(&count); err != nil {
+		return err
+	}
+	if count != len(imageIDs) {
+		return ErrImageOrder
+	}
+	seen := make(map[string]bool, len(imageIDs))
+	for i, id := range imageIDs {
+		if seen[id] {
+			return ErrImageOrder
+		}
+		seen[id] = true
+		res, err := tx.Exec(`UPDATE images SET position = ? WHERE image_id = ? AND post_id = ?`, i, id, postID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrImageOrder
+		}
+	}
+	return tx.Commit()
+}
+
+// Delete removes an image and closes the gap it leaves in the post's
+// gallery. The blob reference is released by the images trigger.
+func (r *ImageRepository) Delete(id string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var postID string
+	var position int
+	err = tx.QueryRow(`SELECT post_id, position FROM images WHERE image_id = ?`, id).Scan(&postID, &position)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM images WHERE image_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE images SET position = position - 1 WHERE post_id = ? AND position > ?`, postID, position); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReleaseBlobs removes every blob that is no longer referenced and returns
+// them so the caller can delete their files.
+func (r *ImageRepository) ReleaseBlobs() ([]models.ImageBlob, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT content_hash, file_path, thumbnail_path, storage_backend, ref_count, created_at FROM image_blobs WHERE ref_count <= 0`)
+	if err != nil {
+		return nil, err
+	}
+	var blobs []models.ImageBlob
+	for rows.Next() {
+		var b models.ImageBlob
+		if err := rows.Scan(&b.ContentHash, &b.FilePath, &b.ThumbnailPath, &b.Backend, &b.RefCount, &b.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		blobs = append(blobs, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, b := range blobs {
+		if _, err := tx.Exec(`DELETE FROM image_blobs WHERE content_hash = ? AND ref_count <= 0`, b.ContentHash); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return blobs, nil
+}
+
+// BlobExists reports whether the blob of hash is registered, whatever its
+// reference count
+func (r *ImageRepository) BlobExists(hash string) (bool, error) {
+	var n int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM image_blobs WHERE content_hash = ?`, hash).Scan(&n)
+	return n > 0, err
+}
+
+// ReferencedKeys returns every storage key the database points at: image
+// originals, thumbnails and renditions.
+func (r *ImageRepository) ReferencedKeys() (map[string]bool, error) {
+	rows, err := r.db.Query(`
+		SELECT file_path FROM images
+		UNION SELECT thumbnail_path FROM images
+		UNION SELECT file_path FROM image_renditions`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make(map[string]bool)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys[key] = true
+	}
+	return keys, rows.Err()
+}
+
+// CountByPostID returns the number of images in a post's gallery
+func (r *ImageRepository) CountByPostID(postID string) (int, error) {
+	var n int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM images WHERE post_id = ?`, postID).Scan(&n)
+	return n, err
+}
+
+// StorageUsedByUser returns the bytes attributed to a user's images: the
+// original and every rendition. Images sharing a blob each count in full.
+// An image still processing has no renditions yet and counts the size of
+// its upload instead.
+func (r *ImageRepository) StorageUsedByUser(userID string) (int64, error) {
+	var n int64
+	err := r.db.QueryRow(`SELECT `+storageUsedSQL, userID, userID).Scan(&n)
+	return n, err
+}
+
+// storageUsedSQL is the storage used by a user, whose ID is bound twice
+const storageUsedSQL = `COALESCE((
+			SELECT SUM(ir.size_bytes)
+			FROM image_renditions ir
+			JOIN images i ON ir.image_id = i.image_id
+			WHERE i.user_id = ?), 0) + COALESCE((
+			SELECT SUM(source_bytes)
+			FROM images
+			WHERE user_id = ? AND status = '` + models.ImageProcessing + `'), 0)`
+
+// ListMissingAltText returns a page of ready images without alt text, newest
+// first, along with the total number of such images
+func (r *ImageRepository) ListMissingAltText(limit, offset int) ([]models.Image, int, error) {
+	return r.listWhere(`status = '`+models.ImageReady+`' AND TRIM(alt_text) = ''`, `created_at DESC`, limit, offset)
+}
+
+// ListQuarantined returns a page of quarantined images, oldest first so the
+// longest waiting are reviewed first, along with their total number
+func (r *ImageRepository) ListQuarantined(limit, offset int) ([]models.Image, int, error) {
+	return r.listWhere(`status = '`+models.ImageQuarantined+`'`, `created_at ASC`, limit, offset)
+}
+
+// listWhere pages through the images matching a fixed condition
+func (r *ImageRepository) listWhere(cond, order string, limit, offset int) ([]models.Image, int, error) {
+	var total int
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM images WHERE ` + cond).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := r.db.Query(`SELECT `+imageColumns+` FROM images WHERE `+cond+` ORDER BY `+order+`, image_id LIMIT ? OFFSET ?`, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	images := []models.Image{}
+	for rows.Next() {
+		img, err := scanImage(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		images = append(images, img)
+	}
+	return images, total, rows.Err()
+}
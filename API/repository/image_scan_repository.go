@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"forum/models"
+	"forum/utils"
+)
+
+// ImageScanRepository records upload scanner verdicts and the moderator
+// decisions on quarantined images.
+type ImageScanRepository struct {
+	db *sql.DB
+}
+
+func NewImageScanRepository(db *sql.DB) *ImageScanRepository {
+	return &ImageScanRepository{db: db}
+}
+
+// Record stores the verdict on an upload
+func (r *ImageScanRepository) Record(v models.ScanVerdict) (*models.ScanVerdict, error) {
+	v.ID = utils.GenerateUUID()
+	v.CreatedAt = time.Now()
+	if v.Labels == nil {
+		v.Labels = []string{}
+	}
+	labels, err := json.Marshal(v.Labels)
+	if err != nil {
+		return nil, err
+	}
+	var imageID interface{}
+	if v.ImageID != "" {
+		imageID = v.ImageID
+	}
+	_, err = r.db.Exec(`INSERT INTO image_scan_verdicts (verdict_id, image_id, content_hash, scanner, flagged, score, labels, error, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		v.ID, imageID, v.ContentHash, v.Scanner, v.Flagged, v.Score, string(labels), v.Error, v.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// LatestForImage returns the most recent verdict on an image, or nil if it
+// was never scanned
+func (r *ImageScanRepository) LatestForImage(imageID string) (*models.ScanVerdict, error) {
+	var v models.ScanVerdict
+	var storedImageID, reviewedBy sql.NullString
+	var reviewedAt sql.NullTime
+	var labels string
+	err := r.db.QueryRow(`
+		SELECT verdict_id, image_id, content_hash, scanner, flagged, score, labels, error, decision, reviewed_by, reviewed_at, created_at
+		FROM image_scan_verdicts
+		WHERE image_id = ?
+		ORDER BY created_at DESC
+		LIMIT 1`, imageID).
+		Scan(&v.ID, &storedImageID, &v.ContentHash, &v.Scanner, &v.Flagged, &v.Score, &labels, &v.Error, &v.Decision, &reviewedBy, &reviewedAt, &v.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	v.ImageID, v.ReviewedBy = storedImageID.String, reviewedBy.String
+	if reviewedAt.Valid {
+		v.ReviewedAt = &reviewedAt.Time
+	}
+	json.Unmarshal([]byte(labels), &v.Labels)
+	return &v, nil
+}
+
+// IsQuarantined reports whether the blob with the given content hash belongs
+// to a quarantined image
+func (r *ImageScanRepository) IsQuarantined(contentHash string) (bool, error) {
+	var quarantined bool
+	err := r.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM images WHERE content_hash = ? AND status = ?)`,
+		contentHash, models.ImageQuarantined).Scan(&quarantined)
+	return quarantined, err
+}
+
+// Approve publishes every quarantined image with the given content hash and
+// records the decision on their pending verdicts. It returns the number of
+// images published.
+func (r *ImageScanRepository) Approve(contentHash, moderatorID string) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE images SET status = ? WHERE content_hash = ? AND status = ?`,
+		models.ImageReady, contentHash, models.ImageQuarantined)
+	if err != nil {
+		return 0, err
+	}
+	if err := decide(tx, contentHash, moderatorID, models.ScanApproved); err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), tx.Commit()
+}
+
+// Reject records the rejection of the pending verdicts on a content hash and
+// returns the IDs of the quarantined images with it, for the caller to
+// delete
+func (r *ImageScanRepository) Reject(contentHash, moderatorID string) ([]string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT image_id FROM images WHERE content_hash = ? AND status = ?`, contentHash, models.ImageQuarantined)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := decide(tx, contentHash, moderatorID, models.ScanRejected); err != nil {
+		return nil, err
+	}
+	return ids, tx.Commit()
+}
+
+// decide sets the decision of the flagged, undecided verdicts on a content
+// hash
+func decide(tx *sql.Tx, contentHash, moderatorID, decision string) error {
+	_, err := tx.Exec(`UPDATE image_scan_verdicts SET decision = ?, reviewed_by = ?, reviewed_at = ? WHERE content_hash = ? AND flagged = 1 AND decision = ''`,
+		decision, moderatorID, time.Now(), contentHash)
+	return err
+}
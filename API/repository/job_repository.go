@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"forum/models"
+	"forum/utils"
+)
+
+type JobRepository struct {
+	db *sql.DB
+}
+
+func NewJobRepository(db *sql.DB) *JobRepository {
+	return &JobRepository{db: db}
+}
+
+const jobColumns = `job_id, kind, user_id, image_id, payload, status, attempts, max_attempts, last_error, run_at, created_at, updated_at`
+
+func scanJob(row interface{ Scan(...interface{}) error }) (*models.Job, error) {
+	var j models.Job
+	var imageID sql.NullString
+	if err := row.Scan(&j.ID, &j.Kind, &j.UserID, &imageID, &j.Payload, &j.Status, &j.Attempts, &j.MaxAttempts, &j.LastError, &j.RunAt, &j.CreatedAt, &j.UpdatedAt); err != nil {
+		return nil, err
+	}
+	j.ImageID = imageID.String
+	return &j, nil
+}
+
+// Create queues a job to run as soon as a worker is free
+func (r *JobRepository) Create(job models.Job) (*models.Job, error) {
+	now := time.Now().UTC()
+	job.ID = utils.GenerateUUID()
+	job.Status = models.JobQueued
+	job.Attempts = 0
+	job.RunAt, job.CreatedAt, job.UpdatedAt = now, now, now
+	if job.Payload == "" {
+		job.Payload = "{}"
+	}
+	var imageID interface{}
+	if job.ImageID != "" {
+		imageID = job.ImageID
+	}
+	_, err := r.db.Exec(`INSERT INTO jobs (`+jobColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		job.ID, job.Kind, job.UserID, imageID, job.Payload, job.Status, job.Attempts, job.MaxAttempts, job.LastError, job.RunAt, job.CreatedAt, job.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetByID returns a job, or nil if it does not exist
+func (r *JobRepository) GetByID(id string) (*models.Job, error) {
+	job, err := scanJob(r.db.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE job_id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return job, err
+}
+
+// ClaimNext marks the oldest due job as running, counting the attempt, and
+// returns it. It returns nil when no job is due. The single UPDATE makes the
+// claim atomic across workers.
+func (r *JobRepository) ClaimNext() (*models.Job, error) {
+	now := time.Now().UTC()
+	job, err := scanJob(r.db.QueryRow(`
+		UPDATE jobs SET status = ?, attempts = attempts + 1, updated_at = ?
+		WHERE job_id = (
+			SELECT job_id FROM jobs
+			WHERE status = ? AND run_at <= ?
+			ORDER BY run_at ASC, created_at ASC
+			LIMIT 1
+		)
+		RETURNING `+jobColumns, models.JobRunning, now, models.JobQueued, now))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return job, err
+}
+
+// Complete marks a job as done
+func (r *JobRepository) Complete(id string) error {
+	_, err := r.db.Exec(`UPDATE jobs SET status = ?, last_error = '', updated_at = ? WHERE job_id = ?`,
+		models.JobDone, time.Now().UTC(), id)
+	return err
+}
+
+// Retry puts a failed job back in the queue to run again at runAt
+func (r *JobRepository) Retry(id string, runAt time.Time, lastError string) error {
+	_, err := r.db.Exec(`UPDATE jobs SET status = ?, run_at = ?, last_error = ?, updated_at = ? WHERE job_id = ?`,
+		models.JobQueued, runAt.UTC(), lastError, time.Now().UTC(), id)
+	return err
+}
+
+// Fail marks a job as permanently failed
+func (r *JobRepository) Fail(id, lastError string) error {
+	_, err := r.db.Exec(`UPDATE jobs SET status = ?, last_error = ?, updated_at = ? WHERE job_id = ?`,
+		models.JobFailed, lastError, time.Now().UTC(), id)
+	return err
+}
+
+// RequeueRunning returns jobs left running by a previous process to the
+// queue. Their attempt still counts.
+func (r *JobRepository) RequeueRunning() (int64, error) {
+	res, err := r.db.Exec(`UPDATE jobs SET status = ?, updated_at = ? WHERE status = ?`,
+		models.JobQueued, time.Now().UTC(), models.JobRunning)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// PendingSourceKeys returns the storage keys of the uploads that queued or
+// running jobs still have to read.
+func (r *JobRepository) PendingSourceKeys() (map[string]bool, error) {
+	rows, err := r.db.Query(`
+		SELECT json_extract(payload, '$.source_key') FROM jobs
+		WHERE status IN (?, ?) AND json_extract(payload, '$.source_key') IS NOT NULL`,
+		models.JobQueued, models.JobRunning)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make(map[string]bool)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys[key] = true
+	}
+	return keys, rows.Err()
+}
@@ -52,12 +52,30 @@ func NewPostRepository(db *sql.DB) *PostRepository {
 	return &PostRepository{db: db}
 }
 
-func (r *PostRepository) GetAllPosts() ([]models.Post, error) {
+// pageSQL returns the condition selecting the posts of a listing, aliased p,
+// that come after page.After, the ORDER BY and LIMIT clauses, and the
+// arguments of both. One post more than the limit is asked for, so callers
+// know whether there is a next page.
+func pageSQL(page models.PostPage) (cond, tail string, args []interface{}) {
+	cond = "1"
+	if page.After != nil {
+		// created_at is compared as stored; the driver formats the cursor's
+		// time the same way it formatted the posts' when they were created.
+		cond = "(p.created_at, p.post_id) < (?, ?)"
+		args = append(args, page.After.CreatedAt, page.After.PostID)
+	}
+	return cond, " ORDER BY p.created_at DESC, p.post_id DESC LIMIT ?", append(args, page.Limit+1)
+}
+
+// GetAllPosts returns a page of posts, newest first, and the cursor of the
+// next page, nil on the last one.
+func (r *PostRepository) GetAllPosts(page models.PostPage) ([]models.Post, *models.PostCursor, error) {
+	cond, tail, args := pageSQL(page)
 	rows, err := r.db.Query(`
-		SELECT post_id, user_id, title, content, created_at, updated_at
-                FROM posts ORDER BY created_at DESC`)
+		SELECT p.post_id, p.user_id, p.title, p.content, p.created_at, p.updated_at
+		FROM posts p WHERE `+cond+tail, args...)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer rows.Close()
 
@@ -67,12 +85,64 @@ func (r *PostRepository) GetAllPosts() ([]models.Post, error) {
 		//err := rows.Scan(&post.ID, &post.UserID, &post.CategoryID, &post.Title, &post.Content, &post.CreatedAt, &post.UpdatedAt)
 		err := rows.Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &post.CreatedAt, &post.UpdatedAt)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		posts = append(posts, post)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(posts) <= page.Limit {
+		return posts, nil, nil
+	}
+	posts = posts[:page.Limit]
+	last := posts[len(posts)-1]
+	return posts, &models.PostCursor{CreatedAt: last.CreatedAt, PostID: last.ID}, nil
+}
+
+// GetPostsWithUser returns a page of posts with their authors, newest first,
+// and the cursor of the next page.
+func (r *PostRepository) GetPostsWithUser(page models.PostPage) ([]models.PostWithUser, *models.PostCursor, error) {
+	cond, tail, args := pageSQL(page)
+	return r.listWithUser(`
+		SELECT p.post_id, p.user_id, u.username, p.title, p.content, p.created_at
+		FROM posts p
+		JOIN user u ON p.user_id = u.user_id
+		WHERE `+cond+tail, page, args...)
+}
+
+// listWithUser runs a page query over posts with their authors and splits
+// off the extra post asked for by pageSQL.
+func (r *PostRepository) listWithUser(query string, page models.PostPage, args ...interface{}) ([]models.PostWithUser, *models.PostCursor, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var posts []models.PostWithUser
+	for rows.Next() {
+		var p models.PostWithUser
+		if err := rows.Scan(&p.ID, &p.UserID, &p.Username, &p.Title, &p.Content, &p.CreatedAt); err != nil {
+			return nil, nil, err
+		}
+		posts = append(posts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	return splitPage(posts, page.Limit)
+}
 
-	return posts, nil
+// splitPage trims posts to limit and returns the cursor of the next page
+// when there were more.
+func splitPage(posts []models.PostWithUser, limit int) ([]models.PostWithUser, *models.PostCursor, error) {
+	if len(posts) <= limit {
+		return posts, nil, nil
+	}
+	posts = posts[:limit]
+	last := posts[len(posts)-1]
+	return posts, &models.PostCursor{CreatedAt: last.CreatedAt, PostID: last.ID}, nil
 }
 
 // Create inserts a new post into the database
@@ -129,27 +199,15 @@ func (r *PostRepository) Create(post models.Post, categoryIDs []int) (*models.Po
 	return &post, nil
 }
 
-func (r *PostRepository) GetPostsByUser(userID string) ([]models.PostWithUser, error) {
-	rows, err := r.db.Query(`
-        SELECT p.post_id, p.user_id, u.username, p.title, p.content, p.created_at
-        FROM posts p
-        JOIN user u ON p.user_id = u.user_id
-        WHERE p.user_id = ?
-        ORDER BY p.created_at DESC`, userID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var posts []models.PostWithUser
-	for rows.Next() {
-		var p models.PostWithUser
-		if err := rows.Scan(&p.ID, &p.UserID, &p.Username, &p.Title, &p.Content, &p.CreatedAt); err != nil {
-			return nil, err
-		}
-		posts = append(posts, p)
-	}
-	return posts, nil
+// GetPostsByUser returns a page of the posts written by a user, newest
+// first, and the cursor of the next page.
+func (r *PostRepository) GetPostsByUser(userID string, page models.PostPage) ([]models.PostWithUser, *models.PostCursor, error) {
+	cond, tail, args := pageSQL(page)
+	return r.listWithUser(`
+		SELECT p.post_id, p.user_id, u.username, p.title, p.content, p.created_at
+		FROM posts p
+		JOIN user u ON p.user_id = u.user_id
+		WHERE p.user_id = ? AND `+cond+tail, page, append([]interface{}{userID}, args...)...)
 }
 
 func (r *PostRepository) GetCategoriesByPostID(postID string) ([]models.Category, error) {
@@ -262,7 +320,8 @@ func (r *PostRepository) Delete(id, userID string) error {
 // 	return posts, nil
 // }
 
-func (r *PostRepository) GetPostsReactedByUser(userID string) ([]models.PostWithUser, error) {
+func (r *PostRepository) GetPostsReactedByUser(userID string, page models.PostPage) ([]models.PostWithUser, *models.PostCursor, error) {
+	cond, tail, args := pageSQL(page)
 	query := `
 		SELECT DISTINCT p.post_id, p.user_id, u.username, p.title, p.content, p.created_at
 		FROM posts p
@@ -271,24 +330,9 @@ func (r *PostRepository) GetPostsReactedByUser(userID string) ([]models.PostWith
 			SELECT post_id FROM reactions
 			WHERE user_id = ? AND reaction_type = 1 AND post_id IS NOT NULL
 		)
-		ORDER BY p.created_at DESC
-	`
+		AND ` + cond + tail
 
-	rows, err := r.db.Query(query, userID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var posts []models.PostWithUser
-	for rows.Next() {
-		var p models.PostWithUser
-		if err := rows.Scan(&p.ID, &p.UserID, &p.Username, &p.Title, &p.Content, &p.CreatedAt); err != nil {
-			return nil, err
-		}
-		posts = append(posts, p)
-	}
-	return posts, nil
+	return r.listWithUser(query, page, append([]interface{}{userID}, args...)...)
 }
 
 // func (r *PostRepository) GetPostsReactedByUser(userID string) ([]models.PostWithUser, error) {
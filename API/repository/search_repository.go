@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"forum/models"
+)
+
+type SearchRepository struct {
+	db *sql.DB
+}
+
+func NewSearchRepository(db *sql.DB) *SearchRepository {
+	return &SearchRepository{db: db}
+}
+
+// Weight of a match in a post title against one in its content
+const searchTitleWeight = 5.0
+
+// Search returns a page of the posts and comments matching q, best match
+// first, and how many match in all. Posts and comments are ranked together
+// by bm25.
+func (r *SearchRepository) Search(q models.SearchQuery) ([]models.SearchResult, int, error) {
+	match := ftsMatch(q.Terms)
+	if match == "" {
+		return []models.SearchResult{}, 0, nil
+	}
+
+	var arms []string
+	var args []interface{}
+	if q.Kind != models.SearchComments {
+		cond, condArgs := searchFilters(q, "p")
+		arms = append(arms, `
+		SELECT 'post' AS kind, p.post_id AS post_id, '' AS comment_id, p.user_id AS user_id, u.username AS username,
+			highlight(posts_search, 1, ?, ?) AS title,
+			snippet(posts_search, 2, ?, ?, '…', 24) AS snippet,
+			p.created_at AS created_at, bm25(posts_search, 0, ?, 1.0) AS score
+		FROM posts_search
+		JOIN posts p ON p.post_id = posts_search.post_id
+		JOIN user u ON u.user_id = p.user_id
+		WHERE posts_search MATCH ?`+cond)
+		args = append(args, models.SearchMarkStart, models.SearchMarkEnd, models.SearchMarkStart, models.SearchMarkEnd, searchTitleWeight, match)
+		args = append(args, condArgs...)
+	}
+	if q.Kind != models.SearchPosts {
+		cond, condArgs := searchFilters(q, "c")
+		arms = append(arms, `
+		SELECT 'comment' AS kind, c.post_id AS post_id, c.comment_id AS comment_id, c.user_id AS user_id, u.username AS username,
+			p.title AS title,
+			snippet(comments_search, 1, ?, ?, '…', 24) AS snippet,
+			c.created_at AS created_at, bm25(comments_search, 0, 1.0) AS score
+		FROM comments_search
+		JOIN comments c ON c.comment_id = comments_search.comment_id
+		JOIN posts p ON p.post_id = c.post_id
+		JOIN user u ON u.user_id = c.user_id
+		WHERE comments_search MATCH ?`+cond)
+		args = append(args, models.SearchMarkStart, models.SearchMarkEnd, match)
+		args = append(args, condArgs...)
+	}
+	union := strings.Join(arms, "\n\t\tUNION ALL")
+
+	var total int
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM (`+union+`)`, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	if total <= q.Offset {
+		return []models.SearchResult{}, total, nil
+	}
+
+	rows, err := r.db.Query(union+`
+		ORDER BY score, created_at DESC, post_id, comment_id
+		LIMIT ? OFFSET ?`, append(args, q.Limit, q.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	results := []models.SearchResult{}
+	for rows.Next() {
+		var res models.SearchResult
+		if err := rows.Scan(&res.Kind, &res.PostID, &res.CommentID, &res.UserID, &res.Username, &res.Title, &res.Snippet, &res.CreatedAt, &res.Rank); err != nil {
+			return nil, 0, err
+		}
+		results = append(results, res)
+	}
+	return results, total, rows.Err()
+}
+
+// searchFilters returns the conditions for the filters of q on the posts
+// (alias p) or comments (alias c) arm of a search. A comment is in the
+// categories of its post.
+func searchFilters(q models.SearchQuery, alias string) (string, []interface{}) {
+	var cond strings.Builder
+	var args []interface{}
+	if q.Author != "" {
+		cond.WriteString(" AND u.username = ?")
+		args = append(args, q.Author)
+	}
+	// created_at is stored in the offset of the server that wrote the row,
+	// so it is compared as a julian day, which SQLite computes in UTC
+	if !q.From.IsZero() {
+		cond.WriteString(" AND julianday(" + alias + ".created_at) >= julianday(?)")
+		args = append(args, sqliteTime(q.From))
+	}
+	if !q.Until.IsZero() {
+		cond.WriteString(" AND julianday(" + alias + ".created_at) < julianday(?)")
+		args = append(args, sqliteTime(q.Until))
+	}
+	if q.CategoryID != 0 {
+		cond.WriteString(" AND EXISTS (SELECT 1 FROM post_categories pc WHERE pc.post_id = " + alias + ".post_id AND pc.category_id = ?)")
+		args = append(args, q.CategoryID)
+	}
+	return cond.String(), args
+}
+
+// sqliteTime formats t as a UTC time SQLite's date functions read.
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05.000")
+}
+
+// ftsMatch turns what a user typed into an FTS5 query: every word and every
+// "quoted phrase" must match, and a word ending in * matches as a prefix.
+// Everything is quoted, so FTS5 operators in the input are searched as text
+// instead of failing as bad syntax.
+func ftsMatch(terms string) string {
+	var parts []string
+	// Chunks are split at the quotes, so none are left to escape
+	quote := func(s string) string { return `"` + s + `"` }
+	for i, chunk := range strings.Split(terms, `"`) {
+		if i%2 == 1 {
+			// Inside quotes: a phrase
+			if phrase := strings.Join(strings.Fields(chunk), " "); phrase != "" {
+				parts = append(parts, quote(phrase))
+			}
+			continue
+		}
+		for _, word := range strings.Fields(chunk) {
+			prefix := strings.HasSuffix(word, "*")
+			word = strings.TrimRight(word, "*")
+			if word == "" {
+				continue
+			}
+			if prefix {
+				parts = append(parts, quote(word)+"*")
+			} else {
+				parts = append(parts, quote(word))
+			}
+		}
+	}
+	return strings.Join(parts, " ")
+}
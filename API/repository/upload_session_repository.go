@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"forum/models"
+	"forum/utils"
+)
+
+type UploadSessionRepository struct {
+	db *sql.DB
+}
+
+func NewUploadSessionRepository(db *sql.DB) *UploadSessionRepository {
+	return &UploadSessionRepository{db: db}
+}
+
+// Create stores a new session with a fresh ID, unless the user already has
+// maxPerUser live sessions, in which case it returns ErrTooManyUploads. The
+// count and the insert are one statement, so concurrent calls cannot both
+// take the last slot.
+func (r *UploadSessionRepository) Create(s models.UploadSession, maxPerUser int) (*models.UploadSession, error) {
+	s.ID = utils.GenerateUUID()
+	s.Received = 0
+	s.CreatedAt = time.Now().UTC()
+	res, err := r.db.Exec(`INSERT INTO upload_sessions (upload_id, user_id, post_id, filename, size, received, checksum, alt_text, caption, created_at, expires_at)
+		SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+		WHERE (SELECT COUNT(*) FROM upload_sessions WHERE user_id = ? AND expires_at > ?) < ?`,
+		s.ID, s.UserID, s.PostID, s.Filename, s.Size, s.Received, s.Checksum, s.AltText, s.Caption, s.CreatedAt, s.ExpiresAt.UTC(),
+		s.UserID, s.CreatedAt, maxPerUser)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrTooManyUploads
+	}
+	return &s, nil
+}
+
+// GetByID returns a session, or nil if it does not exist or has expired
+func (r *UploadSessionRepository) GetByID(id string) (*models.UploadSession, error) {
+	var s models.UploadSession
+	err := r.db.QueryRow(`SELECT upload_id, user_id, post_id, filename, size, received, checksum, alt_text, caption, created_at, expires_at FROM upload_sessions WHERE upload_id = ? AND expires_at > ?`, id, time.Now().UTC()).
+		Scan(&s.ID, &s.UserID, &s.PostID, &s.Filename, &s.Size, &s.Received, &s.Checksum, &s.AltText, &s.Caption, &s.CreatedAt, &s.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// SetReceived records how many bytes of the upload are stored
+func (r *UploadSessionRepository) SetReceived(id string, received int64) error {
+	_, err := r.db.Exec(`UPDATE upload_sessions SET received = ? WHERE upload_id = ?`, received, id)
+	return err
+}
+
+// Claim deletes a live session of the user and reports whether this call
+// deleted it. Only one of several concurrent claims of a session succeeds.
+func (r *UploadSessionRepository) Claim(id, userID string) (bool, error) {
+	res, err := r.db.Exec(`DELETE FROM upload_sessions WHERE upload_id = ? AND user_id = ? AND expires_at > ?`, id, userID, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// Delete removes a session
+func (r *UploadSessionRepository) Delete(id string) error {
+	_, err := r.db.Exec(`DELETE FROM upload_sessions WHERE upload_id = ?`, id)
+	return err
+}
+
+// DeleteExpired removes the sessions that expired before now and returns
+// their IDs so the caller can remove their data.
+func (r *UploadSessionRepository) DeleteExpired(now time.Time) ([]string, error) {
+	rows, err := r.db.Query(`DELETE FROM upload_sessions WHERE expires_at <= ? RETURNING upload_id`, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -2,16 +2,26 @@ package routes
 
 import (
 	"database/sql"
+	"log"
 	"net/http"
+	"time"
 
+	"forum/config"
+	"forum/diskcache"
 	"forum/handlers"
+	"forum/jobs"
 	"forum/middleware"
+	"forum/models"
 	"forum/repository"
 	"forum/repository/session"
 	"forum/repository/user"
+	"forum/scanning"
+	"forum/storage"
+	"forum/uploads"
+	"forum/urlsign"
 )
 
-func SetupRoutes(db *sql.DB) http.Handler {
+func SetupRoutes(db *sql.DB, store storage.Storage) http.Handler {
 	// Create repositories
 	userRepo := user.NewUserRepository(db)
 	sessionRepo := session.NewSessionRepository(db)
@@ -21,23 +31,60 @@ func SetupRoutes(db *sql.DB) http.Handler {
 	reactionRepo := repository.NewReactionRepository(db)
 	imageRepo := repository.NewImageRepository(db)
 	notificationRepo := repository.NewNotificationRepository(db)
+	jobRepo := repository.NewJobRepository(db)
+	uploadSessionRepo := repository.NewUploadSessionRepository(db)
+	imageHashRepo := repository.NewImageHashRepository(db)
+	imageScanRepo := repository.NewImageScanRepository(db)
+	avatarRepo := repository.NewAvatarRepository(db)
+	searchRepo := repository.NewSearchRepository(db)
+
+	// Uploaded files are linked through signed, expiring URLs
+	staticURLs := urlsign.NewSigner(config.LoadStaticURLConfig())
+	handlers.SetStaticURLs(staticURLs)
 
 	// Create handlers
 	authHandler := handlers.NewAuthHandler(userRepo, sessionRepo)
 	oauthHandler := handlers.NewOAuthHandler(userRepo, sessionRepo, authHandler)
 	categoryHandler := handlers.NewCategoryHandler(categoryRepo, postRepo, imageRepo)
-	postHandler := handlers.NewPostHandler(postRepo)
+	postHandler := handlers.NewPostHandler(postRepo, imageRepo, store)
 	myPostsHandler := handlers.NewMyPostsHandler(postRepo, commentRepo, reactionRepo, imageRepo)
 	likedPostsHandler := handlers.NewLikedPostsHandler(postRepo, commentRepo, reactionRepo, imageRepo)
 	commentHandler := handlers.NewCommentHandler(commentRepo, postRepo, notificationRepo)
 	reactionHandler := handlers.NewReactionHandler(reactionRepo, postRepo, commentRepo, notificationRepo)
 	notificationHandler := handlers.NewNotificationHandler(notificationRepo)
-	imageHandler := handlers.NewImageHandler(imageRepo)
+	jobPool := jobs.NewPool(jobRepo, config.LoadJobConfig())
+	imageConfig := config.LoadImageConfig()
+	imageScanner, err := scanning.New(imageConfig.Scan)
+	if err != nil {
+		log.Fatalf("Failed to set up the image scanner: %v", err)
+	}
+	imageHandler := handlers.NewImageHandler(imageRepo, postRepo, imageHashRepo, imageScanRepo, imageScanner, store, jobPool, imageConfig)
+	uploadConfig := config.LoadUploadConfig()
+	chunkedUploadHandler := handlers.NewChunkedUploadHandler(imageHandler, uploadSessionRepo, uploads.NewChunkStore(uploadConfig.ChunkDir), uploadConfig)
+	imageImportHandler := handlers.NewImageImportHandler(imageHandler, config.LoadImportConfig())
+	transformConfig := config.LoadTransformConfig()
+	transformCache, err := diskcache.New(transformConfig.CacheDir, transformConfig.CacheBytes)
+	if err != nil {
+		log.Fatalf("Failed to open transform cache: %v", err)
+	}
+	transformHandler := handlers.NewTransformHandler(imageHandler, staticURLs, transformCache, transformConfig)
 	guestHandler := handlers.NewGuestHandler(categoryRepo, postRepo, commentRepo, reactionRepo, imageRepo)
+	postDetailHandler := handlers.NewPostDetailHandler(postRepo, commentRepo, reactionRepo, imageRepo, userRepo)
+	searchHandler := handlers.NewSearchHandler(searchRepo)
+	moderationHandler := handlers.NewModerationHandler(imageHandler)
+	avatarConfig := config.LoadAvatarConfig()
+	handlers.SetAvatarBaseURL(avatarConfig.BaseURL)
+	avatarHandler := handlers.NewAvatarHandler(imageHandler, avatarRepo, avatarConfig)
+
+	// Process uploaded images in the background
+	jobPool.Handle(models.JobImageProcess, imageHandler.ProcessJob, imageHandler.ProcessJobFailed)
+	jobPool.Start()
+	chunkedUploadHandler.StartCleanup(time.Hour)
 
 	// Create middleware
 	registerLimiter := middleware.NewRateLimiter()
 	authMiddleware := middleware.NewAuthMiddleware(sessionRepo, userRepo)
+	moderatorMiddleware := middleware.NewModeratorMiddleware(config.LoadModerationConfig())
 	// Corrected: CSRF is a method on AuthMiddleware, not a standalone function
 	// csrfMiddleware is now directly authMiddleware.CSRF
 	corsMiddleware := middleware.NewCORSMiddleware("http://localhost:8081")
@@ -45,14 +92,24 @@ func SetupRoutes(db *sql.DB) http.Handler {
 	// Create router
 	mux := http.NewServeMux()
 
-	// Serve uploaded images from the API container
-	fs := http.FileServer(http.Dir("./uploads"))
-	mux.Handle("/static/", http.StripPrefix("/static/", fs))
+	// Serve uploaded images from the configured storage backend after
+	// checking their signature. They are cacheable, so only the CORS headers
+	// are applied.
+	mux.Handle("/static/", corsMiddleware.Static(http.StripPrefix("/static/", handlers.NewStaticHandler(store, staticURLs, imageScanRepo))))
+	mux.Handle("/img/", corsMiddleware.Static(http.StripPrefix("/img/", transformHandler)))
+	mux.Handle("/avatars/", corsMiddleware.Static(http.StripPrefix("/avatars/", avatarHandler)))
 
 	// Public routes
 	mux.Handle("/forum/api/categories", corsMiddleware.Handler(http.HandlerFunc(categoryHandler.GetCategories)))
 	mux.Handle("/forum/api/category", corsMiddleware.Handler(http.HandlerFunc(categoryHandler.GetCategoryByID)))
 	mux.Handle("/forum/api/feed", corsMiddleware.Handler(http.HandlerFunc(guestHandler.GetGuestData)))
+	mux.Handle("/forum/api/posts/", corsMiddleware.Handler(http.HandlerFunc(postDetailHandler.GetPost)))
+	if models.HasFTS5(db) {
+		mux.Handle("/forum/api/search", corsMiddleware.Handler(http.HandlerFunc(searchHandler.Search)))
+	} else {
+		log.Println("Warning: search is disabled, SQLite was built without FTS5 (-tags sqlite_fts5)")
+		mux.Handle("/forum/api/search", corsMiddleware.Handler(http.HandlerFunc(searchHandler.Unavailable)))
+	}
 
 	// Authentication routes (guest only)
 	guestOnly := func(h http.Handler) http.Handler {
@@ -91,14 +148,41 @@ func SetupRoutes(db *sql.DB) http.Handler {
 	mux.Handle("/forum/api/comments/create", protected(http.HandlerFunc(commentHandler.CreateComment)))
 	mux.Handle("/forum/api/react", protected(http.HandlerFunc(reactionHandler.CreateReact)))
 	mux.Handle("/forum/api/images/upload", protected(http.HandlerFunc(imageHandler.Upload)))
+	mux.Handle("/forum/api/images/import", protected(http.HandlerFunc(imageImportHandler.Import)))
+	mux.Handle("/forum/api/images/reorder", protected(http.HandlerFunc(imageHandler.Reorder)))
+	mux.Handle("/forum/api/images/update", protected(http.HandlerFunc(imageHandler.UpdateImage)))
+	mux.Handle("/forum/api/images/delete", protected(http.HandlerFunc(imageHandler.DeleteImage)))
+	mux.Handle("/forum/api/images/jobs", protected(http.HandlerFunc(imageHandler.JobStatus)))
+	mux.Handle("/forum/api/images/similar", protected(http.HandlerFunc(imageHandler.Similar)))
+	mux.Handle("/forum/api/images/transform", protected(http.HandlerFunc(transformHandler.URL)))
+	mux.Handle("/forum/api/images/uploads/init", protected(http.HandlerFunc(chunkedUploadHandler.Init)))
+	mux.Handle("/forum/api/images/uploads/append", protected(http.HandlerFunc(chunkedUploadHandler.Append)))
+	mux.Handle("/forum/api/images/uploads/status", protected(http.HandlerFunc(chunkedUploadHandler.Status)))
+	mux.Handle("/forum/api/images/uploads/finalize", protected(http.HandlerFunc(chunkedUploadHandler.Finalize)))
+	mux.Handle("/forum/api/images/uploads/cancel", protected(http.HandlerFunc(chunkedUploadHandler.Cancel)))
 	mux.Handle("/forum/api/user/notifications", protected(http.HandlerFunc(notificationHandler.GetNotifications)))
 	mux.Handle("/forum/api/user/notifications/read", protected(http.HandlerFunc(notificationHandler.MarkRead)))
 	mux.Handle("/forum/api/user/notifications/delete", protected(http.HandlerFunc(notificationHandler.Delete)))
 
 	// Additional protected routes for user management
 	mux.Handle("/forum/api/user/profile", protected(http.HandlerFunc(authHandler.GetProfile)))
+	mux.Handle("/forum/api/user/avatar", protected(http.HandlerFunc(avatarHandler.Upload)))
+	mux.Handle("/forum/api/user/avatar/delete", protected(http.HandlerFunc(avatarHandler.Delete)))
 	mux.Handle("/forum/api/session/logout-all", protected(http.HandlerFunc(authHandler.LogoutAll)))
 
+	// Moderator routes
+	moderated := func(h http.Handler) http.Handler {
+		return protected(moderatorMiddleware.RequireModerator(h))
+	}
+	mux.Handle("/forum/api/moderation/banned-images", moderated(http.HandlerFunc(moderationHandler.BannedImages)))
+	mux.Handle("/forum/api/moderation/banned-images/add", moderated(http.HandlerFunc(moderationHandler.BanImage)))
+	mux.Handle("/forum/api/moderation/banned-images/delete", moderated(http.HandlerFunc(moderationHandler.UnbanImage)))
+	mux.Handle("/forum/api/moderation/images/missing-alt", moderated(http.HandlerFunc(moderationHandler.MissingAltText)))
+	mux.Handle("/forum/api/moderation/quarantine", moderated(http.HandlerFunc(moderationHandler.Quarantine)))
+	mux.Handle("/forum/api/moderation/quarantine/file", moderated(http.HandlerFunc(moderationHandler.QuarantineFile)))
+	mux.Handle("/forum/api/moderation/quarantine/approve", moderated(http.HandlerFunc(moderationHandler.ApproveImage)))
+	mux.Handle("/forum/api/moderation/quarantine/reject", moderated(http.HandlerFunc(moderationHandler.RejectImage)))
+
 	return authMiddleware.Authenticate(mux)
 
 }
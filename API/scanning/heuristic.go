@@ -0,0 +1,73 @@
+package scanning
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+)
+
+// maxSamples bounds the pixels looked at, whatever the image size.
+const maxSamples = 256 * 256
+
+// HeuristicScanner flags images in which skin tones cover a large share of
+// the picture. It needs no external service but is crude: close-up
+// portraits and some landscapes are flagged too, so every flag still goes
+// to a moderator.
+type HeuristicScanner struct {
+	// SkinThreshold is the share of skin-coloured pixels, from 0 to 1, at
+	// which an image is flagged.
+	SkinThreshold float64
+}
+
+func (s *HeuristicScanner) Name() string { return "heuristic" }
+
+// Scan decodes the image (the first frame of a GIF) and measures the share
+// of skin-coloured pixels on an evenly spaced grid of samples.
+func (s *HeuristicScanner) Scan(_ context.Context, data []byte, _ string) (Verdict, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Verdict{}, err
+	}
+	score := skinRatio(img)
+	v := Verdict{Score: score}
+	if score >= s.SkinThreshold {
+		v.Flagged = true
+		v.Labels = []string{"skin"}
+	}
+	return v, nil
+}
+
+// skinRatio returns the share of sampled opaque pixels that have a skin
+// tone, using the usual YCbCr chrominance box (Cb 77-127, Cr 133-173) and
+// ignoring very dark pixels.
+func skinRatio(img image.Image) float64 {
+	b := img.Bounds()
+	step := 1
+	for (b.Dx()/step)*(b.Dy()/step) > maxSamples {
+		step++
+	}
+	var skin, total int
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		for x := b.Min.X; x < b.Max.X; x += step {
+			r, g, bl, a := img.At(x, y).RGBA()
+			if a < 0x8000 {
+				continue
+			}
+			total++
+			yy, cb, cr := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(bl>>8))
+			if yy > 40 && cb >= 77 && cb <= 127 && cr >= 133 && cr <= 173 {
+				skin++
+			}
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(skin) / float64(total)
+}
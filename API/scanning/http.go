@@ -0,0 +1,58 @@
+package scanning
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// HTTPScanner delegates to an external moderation service. The image is
+// POSTed as the raw request body with its content type, and the service
+// answers 200 with a JSON Verdict:
+//
+//	{"flagged": true, "score": 0.93, "labels": ["nudity"]}
+type HTTPScanner struct {
+	URL string
+	// Token, when set, is sent as a bearer token.
+	Token  string
+	Client *http.Client
+}
+
+// NewHTTPScanner returns a scanner calling url, giving up after timeout.
+func NewHTTPScanner(url, token string, timeout time.Duration) *HTTPScanner {
+	return &HTTPScanner{URL: url, Token: token, Client: &http.Client{Timeout: timeout}}
+}
+
+func (s *HTTPScanner) Name() string { return "http" }
+
+func (s *HTTPScanner) Scan(ctx context.Context, data []byte, contentType string) (Verdict, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(data))
+	if err != nil {
+		return Verdict{}, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return Verdict{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		return Verdict{}, fmt.Errorf("scanning: service answered %d", resp.StatusCode)
+	}
+
+	var v Verdict
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&v); err != nil {
+		return Verdict{}, fmt.Errorf("scanning: invalid response: %v", err)
+	}
+	return v, nil
+}
@@ -0,0 +1,134 @@
+package scanning
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubScanner answers every request with status and body after delay, and
+// keeps the last request it received.
+type stubScanner struct {
+	status int
+	body   string
+	delay  time.Duration
+
+	last     *http.Request
+	lastBody []byte
+}
+
+func (s *stubScanner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.last = r
+	s.lastBody, _ = io.ReadAll(r.Body)
+	if s.delay > 0 {
+		select {
+		case <-time.After(s.delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(s.status)
+	io.WriteString(w, s.body)
+}
+
+func startStub(t *testing.T, stub *stubScanner) string {
+	t.Helper()
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestHTTPScannerClean(t *testing.T) {
+	stub := &stubScanner{status: http.StatusOK, body: `{"flagged": false, "score": 0.02}`}
+	s := NewHTTPScanner(startStub(t, stub), "secret", time.Second)
+
+	v, err := s.Scan(context.Background(), []byte("image bytes"), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Flagged || v.Score != 0.02 || len(v.Labels) != 0 {
+		t.Errorf("verdict = %+v", v)
+	}
+
+	// The image is posted as is, with its type and the token
+	if stub.last.Method != http.MethodPost || string(stub.lastBody) != "image bytes" {
+		t.Errorf("request %s with body %q", stub.last.Method, stub.lastBody)
+	}
+	if got := stub.last.Header.Get("Content-Type"); got != "image/png" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := stub.last.Header.Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("Authorization = %q", got)
+	}
+}
+
+func TestHTTPScannerFlagged(t *testing.T) {
+	stub := &stubScanner{status: http.StatusOK, body: `{"flagged": true, "score": 0.93, "labels": ["nudity", "violence"]}`}
+	v, err := NewHTTPScanner(startStub(t, stub), "", time.Second).Scan(context.Background(), []byte("x"), "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Verdict{Flagged: true, Score: 0.93, Labels: []string{"nudity", "violence"}}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("verdict = %+v, want %+v", v, want)
+	}
+	if got := stub.last.Header.Get("Authorization"); got != "" {
+		t.Errorf("Authorization = %q without a token", got)
+	}
+}
+
+func TestHTTPScannerErrors(t *testing.T) {
+	for name, stub := range map[string]*stubScanner{
+		"server error":  {status: http.StatusInternalServerError, body: `{"error": "overloaded"}`},
+		"rate limited":  {status: http.StatusTooManyRequests},
+		"invalid json":  {status: http.StatusOK, body: `<html>`},
+		"empty answer":  {status: http.StatusOK},
+		"wrong json":    {status: http.StatusOK, body: `{"flagged": "yes"}`},
+		"stalled reply": {status: http.StatusOK, body: `{}`, delay: time.Second},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := NewHTTPScanner(startStub(t, stub), "", 100*time.Millisecond)
+			v, err := s.Scan(context.Background(), []byte("x"), "image/png")
+			if err == nil {
+				t.Fatalf("no error, verdict %+v", v)
+			}
+			if v.Flagged {
+				t.Errorf("flagged on error: %+v", v)
+			}
+		})
+	}
+}
+
+func TestHTTPScannerContextDeadline(t *testing.T) {
+	stub := &stubScanner{status: http.StatusOK, body: `{}`, delay: time.Second}
+	s := NewHTTPScanner(startStub(t, stub), "", time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := s.Scan(ctx, []byte("x"), "image/png")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("returned after %v", elapsed)
+	}
+}
+
+func TestHTTPScannerUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	_, err := NewHTTPScanner(url, "", time.Second).Scan(context.Background(), []byte("x"), "image/png")
+	if err == nil || !strings.Contains(err.Error(), "connect") {
+		t.Fatalf("got %v, want a connection error", err)
+	}
+}
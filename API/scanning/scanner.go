@@ -0,0 +1,45 @@
+// Package scanning checks uploaded images for content that needs a
+// moderator's approval before it is shown.
+package scanning
+
+import (
+	"context"
+	"fmt"
+
+	"forum/config"
+)
+
+// Verdict is the outcome of scanning one image.
+type Verdict struct {
+	// Flagged images are quarantined until a moderator reviews them.
+	Flagged bool `json:"flagged"`
+	// Score is the scanner's confidence that the image is objectionable,
+	// from 0 to 1.
+	Score float64 `json:"score"`
+	// Labels name what was found, e.g. "skin".
+	Labels []string `json:"labels,omitempty"`
+}
+
+// ImageScanner inspects an upload before it is stored.
+type ImageScanner interface {
+	// Name identifies the scanner in recorded verdicts.
+	Name() string
+	// Scan returns the verdict for the encoded image data.
+	Scan(ctx context.Context, data []byte, contentType string) (Verdict, error)
+}
+
+// New returns the scanner selected by cfg, or nil when scanning is off.
+func New(cfg config.ScanConfig) (ImageScanner, error) {
+	switch cfg.Scanner {
+	case "", "none":
+		return nil, nil
+	case "heuristic":
+		return &HeuristicScanner{SkinThreshold: cfg.SkinThreshold}, nil
+	case "http":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("scanning: IMAGE_SCANNER_URL is required for the http scanner")
+		}
+		return NewHTTPScanner(cfg.URL, cfg.Token, cfg.Timeout), nil
+	}
+	return nil, fmt.Errorf("scanning: unknown scanner %q", cfg.Scanner)
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"time"
+
+	"forum/config"
+)
+
+// ErrNotFound is returned when the requested object does not exist.
+var ErrNotFound = errors.New("storage: object not found")
+
+// ErrInvalidKey is returned for keys that are empty or try to escape the store.
+var ErrInvalidKey = errors.New("storage: invalid key")
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+	ETag        string
+}
+
+// Storage is implemented by every backend that can hold uploaded files.
+// Keys are slash separated paths such as "images/ab/<hash>/original.jpg".
+type Storage interface {
+	// Name identifies the backend and is recorded next to each stored file.
+	Name() string
+	Put(key string, r io.Reader, contentType string) error
+	Get(key string) (io.ReadCloser, *ObjectInfo, error)
+	Delete(key string) error
+	Stat(key string) (*ObjectInfo, error)
+	// List returns every object whose key starts with prefix.
+	List(prefix string) ([]ObjectInfo, error)
+}
+
+// New creates the backend selected by cfg.Driver.
+func New(cfg config.StorageConfig) (Storage, error) {
+	switch cfg.Driver {
+	case "", LocalDriverName:
+		return NewLocalStorage(cfg.LocalRoot)
+	case S3DriverName:
+		return NewS3Storage(S3Options{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			PathStyle: cfg.S3PathStyle,
+		})
+	}
+	return nil, fmt.Errorf("storage: unknown driver %q", cfg.Driver)
+}
+
+// CleanKey normalises a key and rejects anything that would resolve outside
+// the store root.
+func CleanKey(key string) (string, error) {
+	key = strings.TrimPrefix(strings.ReplaceAll(key, "\\", "/"), "/")
+	if key == "" {
+		return "", ErrInvalidKey
+	}
+	cleaned := path.Clean(key)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", ErrInvalidKey
+	}
+	return cleaned, nil
+}
+
+// ContentTypeFor guesses a content type from the key's extension.
+func ContentTypeFor(key string) string {
+	if ct := mime.TypeByExtension(path.Ext(key)); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,168 @@
+// Package uploads keeps the bytes of resumable uploads in temporary files
+// until they are complete.
+package uploads
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrOffsetMismatch is returned when a chunk does not start where the
+// stored data ends.
+var ErrOffsetMismatch = errors.New("uploads: offset does not match received bytes")
+
+// ErrTooLarge is returned when a chunk would grow the file past its size.
+var ErrTooLarge = errors.New("uploads: chunk exceeds declared size")
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+// ChunkStore appends chunks to one file per upload below a directory.
+// Appends to the same upload are serialised.
+type ChunkStore struct {
+	dir string
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// NewChunkStore returns a store in dir; the directory is created on first use.
+func NewChunkStore(dir string) *ChunkStore {
+	return &ChunkStore{dir: dir, locks: make(map[string]*sync.Mutex)}
+}
+
+func (s *ChunkStore) path(id string) (string, error) {
+	if !validID.MatchString(id) {
+		return "", fmt.Errorf("uploads: invalid id %q", id)
+	}
+	return filepath.Join(s.dir, id+".part"), nil
+}
+
+func (s *ChunkStore) lock(id string) func() {
+	s.mu.Lock()
+	l, ok := s.locks[id]
+	if !ok {
+		l = &sync.Mutex{}
+		s.locks[id] = l
+	}
+	s.mu.Unlock()
+	l.Lock()
+	return l.Unlock
+}
+
+// Create starts an empty upload.
+func (s *ChunkStore) Create(id string) error {
+	p, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Size returns the number of bytes received so far.
+func (s *ChunkStore) Size(id string) (int64, error) {
+	p, err := s.path(id)
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Append writes chunk at offset, which must equal the bytes received so
+// far, and returns the new size. max is the declared size of the upload.
+func (s *ChunkStore) Append(id string, offset int64, chunk []byte, max int64) (int64, error) {
+	unlock := s.lock(id)
+	defer unlock()
+
+	p, err := s.path(id)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if offset != size {
+		return size, ErrOffsetMismatch
+	}
+	if size+int64(len(chunk)) > max {
+		return size, ErrTooLarge
+	}
+	if _, err := f.Write(chunk); err != nil {
+		// Drop a partial write so the next attempt can resume at offset.
+		f.Truncate(size)
+		return size, err
+	}
+	return size + int64(len(chunk)), f.Sync()
+}
+
+// Read returns the complete data of an upload.
+func (s *ChunkStore) Read(id string) ([]byte, error) {
+	p, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(p)
+}
+
+// Remove deletes the data of an upload.
+func (s *ChunkStore) Remove(id string) error {
+	p, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	delete(s.locks, id)
+	s.mu.Unlock()
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Stale returns the IDs of uploads whose data was last written before t.
+func (s *ChunkStore) Stale(t time.Time) ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || filepath.Ext(name) != ".part" {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || !info.ModTime().Before(t) {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(name, ".part"))
+	}
+	return ids, nil
+}
@@ -0,0 +1,98 @@
+// Package urlsign builds expiring, HMAC-signed URLs for stored files and
+// verifies them when the files are requested.
+package urlsign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	"forum/config"
+)
+
+var (
+	// ErrMissing is returned when a request carries no signature.
+	ErrMissing = errors.New("urlsign: missing signature")
+	// ErrInvalid is returned for malformed or forged signatures.
+	ErrInvalid = errors.New("urlsign: invalid signature")
+	// ErrExpired is returned once a URL's expiry has passed.
+	ErrExpired = errors.New("urlsign: link expired")
+)
+
+// Signer signs keys relative to the static base URL. Expiries are rounded
+// up to a multiple of the TTL, so the same key gets the same URL for a while
+// and browsers can cache it; every URL is valid for between one and two TTLs.
+type Signer struct {
+	base   string
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+func NewSigner(cfg config.StaticURLConfig) *Signer {
+	return &Signer{base: cfg.Base, secret: cfg.Secret, ttl: cfg.TTL, now: time.Now}
+}
+
+// Enabled reports whether URLs are signed and must be verified.
+func (s *Signer) Enabled() bool {
+	return s.ttl > 0
+}
+
+// URL returns the public URL of key, signed when enabled. Empty keys give an
+// empty URL.
+func (s *Signer) URL(key string) string {
+	if key == "" {
+		return ""
+	}
+	if !s.Enabled() {
+		return s.base + key
+	}
+	return s.base + key + "?" + s.Sign(key).Encode()
+}
+
+// Sign returns the expires and sig query values for key. Callers that add
+// their own parameters must include them in key.
+func (s *Signer) Sign(key string) url.Values {
+	step := int64(s.ttl / time.Second)
+	if step < 1 {
+		step = 1
+	}
+	expires := (s.now().Add(s.ttl).Unix()/step + 1) * step
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("sig", s.sign(key, expires))
+	return q
+}
+
+// Verify checks the expires and sig query values of a request for key and
+// returns the expiry.
+func (s *Signer) Verify(key string, query url.Values) (time.Time, error) {
+	rawExpires, sig := query.Get("expires"), query.Get("sig")
+	if rawExpires == "" || sig == "" {
+		return time.Time{}, ErrMissing
+	}
+	expires, err := strconv.ParseInt(rawExpires, 10, 64)
+	if err != nil {
+		return time.Time{}, ErrInvalid
+	}
+	if !hmac.Equal([]byte(sig), []byte(s.sign(key, expires))) {
+		return time.Time{}, ErrInvalid
+	}
+	at := time.Unix(expires, 0)
+	if !s.now().Before(at) {
+		return at, ErrExpired
+	}
+	return at, nil
+}
+
+func (s *Signer) sign(key string, expires int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(key))
+	mac.Write([]byte{0})
+	mac.Write([]byte(strconv.FormatInt(expires, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
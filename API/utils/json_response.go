@@ -22,4 +22,24 @@ func ErrorResponse(w http.ResponseWriter, message string, status int) {
 		Message: message,
 	}
 	JSONResponse(w, response, status)
-}
\ No newline at end of file
+}
+
+// ReasonErrorResponse is ErrorResponse with a machine-readable reason such as
+// "post_not_found" and optional details (limits, current usage) so clients
+// can react without parsing the message.
+func ReasonErrorResponse(w http.ResponseWriter, message string, status int, reason string, details map[string]interface{}) {
+	response := struct {
+		Code    int                    `json:"code"`
+		Error   string                 `json:"error"`
+		Message string                 `json:"message"`
+		Reason  string                 `json:"reason"`
+		Details map[string]interface{} `json:"details,omitempty"`
+	}{
+		Code:    status,
+		Error:   http.StatusText(status),
+		Message: message,
+		Reason:  reason,
+		Details: details,
+	}
+	JSONResponse(w, response, status)
+}
@@ -1,3 +1,5 @@
+import { withCursor, appendLoadMore } from './pagination.js';
+
 const categoriesURL = 'http://localhost:8080/forum/api/categories';
 const dropdownToggle = document.querySelector('.category-dropdown-toggle');
 const dropdownContent = document.getElementById('category-tabs');
@@ -54,10 +56,13 @@ function renderCategories(categories) {
 const urlParams = new URLSearchParams(window.location.search);
 const categoryId = urlParams.get('id');
 
+// Posts of every page of the category loaded so far.
+let loadedPosts = [];
+
 // Load and render the category's posts
-async function loadCategoryPosts(id) {
+async function loadCategoryPosts(id, cursor) {
   try {
-    const resp = await fetch(`http://localhost:8080/forum/api/category?id=${id}`, {
+    const resp = await fetch(withCursor(`http://localhost:8080/forum/api/category?id=${id}`, cursor), {
       credentials: 'include'
     });
 
@@ -70,7 +75,9 @@ async function loadCategoryPosts(id) {
     }
 
     const category = await resp.json();
-    renderCategoryPosts(category);
+    loadedPosts = cursor ? loadedPosts.concat(category.posts || []) : category.posts || [];
+    renderCategoryPosts({ ...category, posts: loadedPosts });
+    appendLoadMore(forumContainer, category.next_cursor, next => loadCategoryPosts(id, next));
   } catch (err) {
     const fallback = encodeURIComponent("Network error or backend unreachable");
     window.location.href = `/guest/error?msg=${fallback}`;
@@ -100,7 +107,8 @@ function renderCategoryPosts(category) {
     if (post.thumbnail_url) {
       const img = document.createElement('img');
       img.src = post.thumbnail_url;
-      img.alt = 'Post thumbnail';
+      if (post.dominant_color) img.style.backgroundColor = post.dominant_color;
+      img.alt = post.image_alt_text || 'Post thumbnail';
       img.className = 'post-thumb';
       postEl.insertBefore(img, postEl.firstChild);
     }
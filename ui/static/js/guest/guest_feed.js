@@ -1,13 +1,20 @@
+import { withCursor, appendLoadMore } from '../pagination.js';
+
 const feedURL = 'http://localhost:8080/forum/api/feed';
 
-async function loadFeed() {
+// Categories of every page loaded so far, each with the posts of its page.
+let loadedCategories = [];
+
+async function loadFeed(cursor) {
   try {
-    const resp = await fetch(feedURL, { credentials: 'include' });
+    const resp = await fetch(withCursor(feedURL, cursor), { credentials: 'include' });
     if (!resp.ok) throw new Error('Failed to load feed');
 
     const data = await resp.json();
 
-    renderFeed(data.categories || []);
+    loadedCategories = cursor ? loadedCategories.concat(data.categories || []) : data.categories || [];
+    renderFeed(loadedCategories);
+    appendLoadMore(document.getElementById('forumContainer'), data.next_cursor, loadFeed);
   } catch (err) {
     console.error('Error loading feed:', err);
   }
@@ -36,7 +43,8 @@ function renderFeed(categories) {
    if (post.thumbnail_url) {
       const img = document.createElement('img');
       img.src = post.thumbnail_url;
-      img.alt = 'Post thumbnail';
+      if (post.dominant_color) img.style.backgroundColor = post.dominant_color;
+      img.alt = post.image_alt_text || 'Post thumbnail';
       img.className = 'post-thumb';
       postElement.insertBefore(img, postElement.firstChild);
     }
@@ -115,7 +123,7 @@ function renderFeed(categories) {
 
 
 
-window.addEventListener('DOMContentLoaded', loadFeed);
+window.addEventListener('DOMContentLoaded', () => loadFeed());
 
 
 function mergePostsFromCategories(categories) {
@@ -8,22 +8,17 @@ async function loadPost() {
   }
 
   try {
-    const resp = await fetch('http://localhost:8080/forum/api/feed', {
+    const resp = await fetch(`http://localhost:8080/forum/api/posts/${encodeURIComponent(postId)}`, {
       credentials: 'include',
     });
 
-    if (!resp.ok) throw new Error('Failed to load post');
-
-    const data = await resp.json();
-    const posts = mergePostsFromCategories(data.categories || []);
-    const post = posts.find(p => p.id === postId);
-
-    if (!post) {
+    if (resp.status === 404) {
       document.getElementById('postContainer').textContent = 'Post not found.';
       return;
     }
+    if (!resp.ok) throw new Error('Failed to load post');
 
-    renderSinglePost(post);
+    renderSinglePost(await resp.json());
   } catch (err) {
     console.error(err);
     document.getElementById('postContainer').textContent = 'Error loading post.';
@@ -43,10 +38,29 @@ function renderSinglePost(post) {
   meta.textContent = `By ${post.username || post.user_id || 'Unknown'} on ${new Date(post.created_at).toLocaleString()}`;
 
   let imageEl = null;
-  if (post.image_url) {
-    imageEl = document.createElement('img');
-    imageEl.src = post.image_url;
-    imageEl.className = 'post-image';
+  const images = post.images?.length
+    ? post.images
+    : post.image_url ? [{ url: post.image_url, alt_text: '', caption: '' }] : [];
+  if (images.length) {
+    // Gallery in the author's order, each image with its caption
+    imageEl = document.createElement('div');
+    imageEl.className = 'post-gallery';
+    images.forEach(image => {
+      const figure = document.createElement('figure');
+      const img = document.createElement('img');
+      img.src = image.url;
+      if (image.dominant_color) img.style.backgroundColor = image.dominant_color;
+      if (image.srcset) img.srcset = image.srcset;
+      img.alt = image.alt_text || '';
+      img.className = 'post-image';
+      figure.appendChild(img);
+      if (image.caption) {
+        const caption = document.createElement('figcaption');
+        caption.textContent = image.caption;
+        figure.appendChild(caption);
+      }
+      imageEl.appendChild(figure);
+    });
   }
 
   const content = document.createElement('div');
@@ -55,11 +69,9 @@ function renderSinglePost(post) {
 
   const reactions = document.createElement('div');
   reactions.className = 'post-reactions';
-  const likes = post.reactions?.filter(r => r.reaction_type === 1).length || 0;
-  const dislikes = post.reactions?.filter(r => r.reaction_type === 2).length || 0;
   reactions.innerHTML = `
-    <button disabled>▲ ${likes}</button>
-    <button disabled>▼ ${dislikes}</button>
+    <button disabled>▲ ${post.reactions?.likes || 0}</button>
+    <button disabled>▼ ${post.reactions?.dislikes || 0}</button>
   `;
 
   const commentCount =
@@ -96,49 +108,7 @@ function renderSinglePost(post) {
   commentSection.appendChild(commentHeader);
 
   if (post.comments?.length > 0) {
-    post.comments.forEach(comment => {
-      const commentEl = document.createElement('div');
-      commentEl.className = 'comment';
-      // Removed hardcoded border-top, padding-top, margin-top to let CSS handle it
-      // commentEl.style.borderTop = '1px solid #ccc';
-      // commentEl.style.paddingTop = '0.5rem';
-      // commentEl.style.marginTop = '0.5rem';
-
-      const commentUser = document.createElement('strong');
-      commentUser.textContent = comment.username || comment.user_id || 'Anonymous';
-      // Color handled by .comment strong in post.css (var(--color-primary)) - NO JS CHANGE NEEDED
-
-      const commentTime = document.createElement('time');
-      commentTime.textContent = ` (${new Date(comment.created_at).toLocaleString()})`;
-      // Color handled by .comment time in post.css (var(--text-muted)) - NO JS CHANGE NEEDED
-      // commentTime.style.fontSize = '0.85em'; // Handled by CSS
-      // commentTime.style.color = '#666'; // Handled by CSS (var(--text-muted))
-
-      const commentContent = document.createElement('div');
-      commentContent.textContent = comment.content || '';
-      // Color handled by .comment p (if you add a p tag) or by .comment itself in post.css (var(--text-secondary))
-      // commentContent.style.margin = '0.25rem 0'; // Handled by CSS
-
-      const commentReactions = document.createElement('div');
-      commentReactions.className = 'comment-reactions';
-      // commentReactions.style.marginTop = '0.25rem'; // Handled by CSS
-
-      const likeCount = comment.reactions?.filter(r => r.reaction_type === 1).length || 0;
-      const dislikeCount = comment.reactions?.filter(r => r.reaction_type === 2).length || 0;
-
-      // Buttons for comment reactions will be styled by .post-reactions button in post.css
-      commentReactions.innerHTML = `
-        <button disabled>▲ ${likeCount}</button>
-        <button disabled>▼ ${dislikeCount}</button>
-      `;
-
-      commentEl.appendChild(commentUser);
-      commentEl.appendChild(commentTime);
-      commentEl.appendChild(commentContent);
-      commentEl.appendChild(commentReactions);
-
-      commentSection.appendChild(commentEl);
-    });
+    post.comments.forEach(comment => commentSection.appendChild(renderComment(comment)));
   } else {
     const noComments = document.createElement('p');
     noComments.textContent = 'No comments yet.';
@@ -156,30 +126,39 @@ function renderSinglePost(post) {
   container.appendChild(commentSection);
 }
 
-function mergePostsFromCategories(categories) {
-  const postsMap = new Map();
-
-  categories.forEach(category => {
-    const categoryId = category.id || category.ID;
-    const categoryName = category.name || category.Name;
-
-    category.posts.forEach(post => {
-      const postId = post.id || post.ID;
-      if (!postsMap.has(postId)) {
-        postsMap.set(postId, {
-          ...post,
-          categories: [{ id: categoryId, name: categoryName }],
-        });
-      } else {
-        const existingPost = postsMap.get(postId);
-        if (!existingPost.categories.some(c => c.id === categoryId)) {
-          existingPost.categories.push({ id: categoryId, name: categoryName });
-        }
-      }
-    });
-  });
+// Renders a comment with its replies nested below it
+function renderComment(comment) {
+  const commentEl = document.createElement('div');
+  commentEl.className = 'comment';
+
+  const commentUser = document.createElement('strong');
+  commentUser.textContent = comment.username || comment.user_id || 'Anonymous';
+
+  const commentTime = document.createElement('time');
+  commentTime.textContent = ` (${new Date(comment.created_at).toLocaleString()})`;
+
+  const commentContent = document.createElement('div');
+  commentContent.textContent = comment.content || '';
 
-  return Array.from(postsMap.values());
+  const commentReactions = document.createElement('div');
+  commentReactions.className = 'comment-reactions';
+  commentReactions.innerHTML = `
+    <button disabled>▲ ${comment.reactions?.likes || 0}</button>
+    <button disabled>▼ ${comment.reactions?.dislikes || 0}</button>
+  `;
+
+  commentEl.appendChild(commentUser);
+  commentEl.appendChild(commentTime);
+  commentEl.appendChild(commentContent);
+  commentEl.appendChild(commentReactions);
+
+  if (comment.replies?.length) {
+    const replies = document.createElement('div');
+    replies.className = 'comment-replies';
+    comment.replies.forEach(reply => replies.appendChild(renderComment(reply)));
+    commentEl.appendChild(replies);
+  }
+  return commentEl;
 }
 
 window.addEventListener('DOMContentLoaded', loadPost);
\ No newline at end of file
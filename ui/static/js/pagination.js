@@ -0,0 +1,23 @@
+// Helpers for the post listings of the API, which are returned a page at a
+// time with a next_cursor to ask for the following one.
+
+// withCursor adds the cursor of the page to load to a listing URL.
+export function withCursor(url, cursor) {
+  if (!cursor) return url;
+  return `${url}${url.includes('?') ? '&' : '?'}cursor=${encodeURIComponent(cursor)}`;
+}
+
+// appendLoadMore adds a "Load more" button at the end of container when the
+// listing has another page; clicking it calls onMore with the cursor.
+export function appendLoadMore(container, cursor, onMore) {
+  if (!cursor) return;
+  const button = document.createElement('button');
+  button.type = 'button';
+  button.className = 'load-more';
+  button.textContent = 'Load more';
+  button.addEventListener('click', () => {
+    button.disabled = true;
+    onMore(cursor);
+  });
+  container.appendChild(button);
+}
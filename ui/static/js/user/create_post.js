@@ -17,6 +17,8 @@ const cancelImageBtn = document.getElementById("cancel-image-btn");
 const imageStatus = document.getElementById("image-status");
 const imageError = document.getElementById("image-error");
 const imagePreview = document.getElementById("image-preview");
+const imageAltInput = document.getElementById("image-alt");
+const imageAltCount = document.getElementById("image-alt-count");
 
 // Store CSRF token in-memory here (initially empty)
 let csrfTokenFromResponse = null;
@@ -68,8 +70,13 @@ function updateBodyCount() {
   bodyCount.textContent = `${val.length} / 2000`;
 }
 
+function updateAltCount() {
+  imageAltCount.textContent = `${imageAltInput.value.length} / 250`;
+}
+
 titleInput.addEventListener("input", updateTitleCount);
 contentInput.addEventListener("input", updateBodyCount);
+imageAltInput.addEventListener("input", updateAltCount);
 
 addImageBtn.addEventListener("click", () => imageInput.click());
 cancelImageBtn.addEventListener("click", resetImageSelection);
@@ -84,6 +91,10 @@ function resetImageSelection() {
   addImageBtn.disabled = false;
   imagePreview.src = "";
   imagePreview.classList.add("hidden");
+  imageAltInput.value = "";
+  imageAltInput.classList.add("hidden");
+  imageAltCount.classList.add("hidden");
+  updateAltCount();
 }
 
 function validateSelectedImage() {
@@ -93,7 +104,7 @@ function validateSelectedImage() {
     // No image selected, this is allowed
     return true;
   }
-  const allowed = ["image/jpeg", "image/png", "image/gif"];
+  const allowed = ["image/jpeg", "image/png", "image/gif", "image/webp"];
   if (!allowed.includes(file.type)) {
     imageStatus.textContent = file.name;
     imageStatus.classList.remove("hidden", "status-valid");
@@ -120,6 +131,8 @@ function validateSelectedImage() {
   imageStatus.classList.add("status-valid");
   cancelImageBtn.classList.remove("hidden");
   addImageBtn.disabled = true;
+  imageAltInput.classList.remove("hidden");
+  imageAltCount.classList.remove("hidden");
   const reader = new FileReader();
   reader.onload = (e) => {
     imagePreview.src = e.target.result;
@@ -211,6 +224,22 @@ function clearModalInputs() {
 }
 
 // Submit post
+// Polls an image processing job until it is done or failed, giving up after
+// about 30 seconds; the image then appears once processing finishes.
+async function waitForImageJob(jobID) {
+  for (let i = 0; i < 30; i++) {
+    await new Promise((resolve) => setTimeout(resolve, 1000));
+    const resp = await fetch(
+      `http://localhost:8080/forum/api/images/jobs?id=${encodeURIComponent(jobID)}`,
+      { credentials: "include" },
+    );
+    if (!resp.ok) return null;
+    const job = await resp.json();
+    if (job.status === "done" || job.status === "failed") return job;
+  }
+  return null;
+}
+
 submitPostBtn.addEventListener("click", async () => {
   const title = titleInput.value.trim();
   const content = contentInput.value.trim();
@@ -275,6 +304,7 @@ submitPostBtn.addEventListener("click", async () => {
       const formData = new FormData();
       formData.append("post_id", createdPost.id || createdPost.ID);
       formData.append("image", imageInput.files[0]);
+      formData.append("alt_text", imageAltInput.value.trim());
 
       const imgResp = await fetch(
         "http://localhost:8080/forum/api/images/upload",
@@ -291,7 +321,20 @@ submitPostBtn.addEventListener("click", async () => {
       if (!imgResp.ok) {
         const errImg = await imgResp.json().catch(() => ({}));
         console.error("Image upload failed:", errImg);
-        alert("Image upload failed");
+        alert(
+          errImg.reason === "alt_text_required"
+            ? "Image upload failed: please describe the image in its alt text"
+            : "Image upload failed",
+        );
+      } else {
+        const uploaded = await imgResp.json().catch(() => ({}));
+        if (uploaded.job_id) {
+          submitPostBtn.textContent = "Processing image...";
+          const job = await waitForImageJob(uploaded.job_id);
+          if (job && job.status === "failed") {
+            alert("Image processing failed");
+          }
+        }
       }
     }
 
@@ -1,3 +1,5 @@
+import { withCursor, appendLoadMore } from '../pagination.js';
+
 const categoriesURL = 'http://localhost:8080/forum/api/categories';
 const dropdownToggle = document.querySelector('.category-dropdown-toggle');
 const dropdownContent = document.getElementById('category-tabs');
@@ -51,10 +53,13 @@ function renderCategories(categories) {
 const urlParams = new URLSearchParams(window.location.search);
 const categoryId = urlParams.get('id');
 
-async function loadCategoryPosts(id) {
+// Posts of every page of the category loaded so far.
+let loadedPosts = [];
+
+async function loadCategoryPosts(id, cursor) {
   try {
     const [categoryResp, feedResp] = await Promise.all([
-      fetch(`http://localhost:8080/forum/api/category?id=${id}`, {
+      fetch(withCursor(`http://localhost:8080/forum/api/category?id=${id}`, cursor), {
         credentials: 'include'
       }),
       fetch(`http://localhost:8080/forum/api/feed`, {
@@ -73,7 +78,9 @@ async function loadCategoryPosts(id) {
     const category = await categoryResp.json();
     const feedData = await feedResp.json();
 
-    renderCategoryPosts(category, feedData.categories || []);
+    loadedPosts = cursor ? loadedPosts.concat(category.posts || []) : category.posts || [];
+    renderCategoryPosts({ ...category, posts: loadedPosts }, feedData.categories || []);
+    appendLoadMore(forumContainer, category.next_cursor, next => loadCategoryPosts(id, next));
   } catch (err) {
     const fallback = encodeURIComponent("Network error or backend unreachable");
     window.location.href = `/user/error?msg=${fallback}`;
@@ -111,7 +118,8 @@ function renderCategoryPosts(category, feedCategories) {
     if (post.thumbnail_url) {
       const img = document.createElement('img');
       img.src = post.thumbnail_url;
-      img.alt = 'Post thumbnail';
+      if (post.dominant_color) img.style.backgroundColor = post.dominant_color;
+      img.alt = post.image_alt_text || 'Post thumbnail';
       img.className = 'post-thumb';
       postEl.insertBefore(img, postEl.firstChild);
     }
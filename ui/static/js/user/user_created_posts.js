@@ -1,3 +1,5 @@
+import { withCursor, appendLoadMore } from '../pagination.js';
+
 const forumContainer = document.getElementById('forumContainer');
 const postTemplate = document.getElementById('post-template');
 
@@ -20,15 +22,18 @@ async function loadCSRFTokenFromSession() {
   }
 }
 
+// Posts of every page loaded so far.
+let loadedPosts = [];
+
 window.addEventListener('DOMContentLoaded', async () => {
   // Load CSRF token when the page is loaded
   csrfTokenFromResponse = await loadCSRFTokenFromSession();
   fetchCreatedPosts();
 });
 
-async function fetchCreatedPosts() {
+async function fetchCreatedPosts(cursor) {
   try {
-    const resp = await fetch('http://localhost:8080/forum/api/user/posts', {
+    const resp = await fetch(withCursor('http://localhost:8080/forum/api/user/posts', cursor), {
       credentials: 'include',
     });
 
@@ -37,8 +42,10 @@ async function fetchCreatedPosts() {
       throw new Error(err.message || 'Failed to load created posts');
     }
 
-    const posts = await resp.json();
-    renderCreatedPosts(posts);
+    const data = await resp.json();
+    loadedPosts = cursor ? loadedPosts.concat(data.posts || []) : data.posts || [];
+    renderCreatedPosts(loadedPosts);
+    appendLoadMore(forumContainer, data.next_cursor, fetchCreatedPosts);
   } catch (err) {
     console.error(`Error: ${err.message}`);
     forumContainer.textContent = 'You have not created any posts yet.';
@@ -59,7 +66,8 @@ function renderCreatedPosts(posts) {
     if (post.thumbnail_url) {
       const img = document.createElement('img');
       img.src = post.thumbnail_url;
-      img.alt = 'Post thumbnail';
+      if (post.dominant_color) img.style.backgroundColor = post.dominant_color;
+      img.alt = post.image_alt_text || 'Post thumbnail';
       img.className = 'post-thumb';
       postEl.insertBefore(img, postEl.firstChild);
     }
@@ -1,15 +1,20 @@
+import { withCursor, appendLoadMore } from "../pagination.js";
+
 const forumContainer = document.getElementById("forumContainer");
 const postTemplate = document.getElementById("post-template");
 
+// Posts of every page loaded so far.
+let loadedPosts = [];
+
 window.addEventListener("DOMContentLoaded", () => {
   fetchLikedPosts();
 });
 
-async function fetchLikedPosts() {
-  forumContainer.textContent = 'Loading...';
+async function fetchLikedPosts(cursor) {
+  if (!cursor) forumContainer.textContent = 'Loading...';
 
   try {
-    const resp = await fetch("http://localhost:8080/forum/api/user/liked", {
+    const resp = await fetch(withCursor("http://localhost:8080/forum/api/user/liked", cursor), {
       credentials: "include",
     });
 
@@ -18,9 +23,11 @@ async function fetchLikedPosts() {
       throw new Error(err.message || "Failed to load liked posts");
     }
 
-    const posts = await resp.json(); // No filtering needed
+    const data = await resp.json(); // No filtering needed
+    loadedPosts = cursor ? loadedPosts.concat(data.posts || []) : data.posts || [];
 
-    renderLikedPosts(posts);
+    renderLikedPosts(loadedPosts);
+    appendLoadMore(forumContainer, data.next_cursor, fetchLikedPosts);
   } catch (err) {
     console.error(`Error: ${err.message}`);
     forumContainer.textContent = "You have not liked any posts yet.";
@@ -43,7 +50,8 @@ function renderLikedPosts(posts) {
     if (post.thumbnail_url) {
       const img = document.createElement('img');
       img.src = post.thumbnail_url;
-      img.alt = 'Post thumbnail';
+      if (post.dominant_color) img.style.backgroundColor = post.dominant_color;
+      img.alt = post.image_alt_text || 'Post thumbnail';
       img.className = 'post-thumb';
       postEl.insertBefore(img, postEl.firstChild);
     }
@@ -25,7 +25,7 @@ async function loadCSRFTokenFromSession() {
   }
 }
 
-// Fetch post feed and find the single post by id
+// Fetch the post with its threaded comments
 async function loadPost() {
   if (!postId) {
     postContainer.textContent = 'Post ID missing.';
@@ -33,22 +33,17 @@ async function loadPost() {
   }
 
   try {
-    const resp = await fetch('http://localhost:8080/forum/api/feed', {
+    const resp = await fetch(`http://localhost:8080/forum/api/posts/${encodeURIComponent(postId)}`, {
       credentials: 'include',
     });
 
-    if (!resp.ok) throw new Error('Failed to load post');
-
-    const data = await resp.json();
-    const posts = mergePostsFromCategories(data.categories || []);
-    const post = posts.find(p => p.id === postId);
-
-    if (!post) {
+    if (resp.status === 404) {
       postContainer.textContent = 'Post not found.';
       return;
     }
+    if (!resp.ok) throw new Error('Failed to load post');
 
-    renderSinglePost(post);
+    renderSinglePost(await resp.json());
   } catch (err) {
     console.error(err);
     postContainer.textContent = 'Error loading post.';
@@ -71,11 +66,30 @@ function renderSinglePost(post) {
   content.className = 'post-content';
   content.textContent = post.content || '';
 
-   let imageEl = null;
-  if (post.image_url) {
-    imageEl = document.createElement('img');
-    imageEl.src = post.image_url;
-    imageEl.className = 'post-image';
+  let imageEl = null;
+  const images = post.images?.length
+    ? post.images
+    : post.image_url ? [{ url: post.image_url, alt_text: '', caption: '' }] : [];
+  if (images.length) {
+    // Gallery in the author's order, each image with its caption
+    imageEl = document.createElement('div');
+    imageEl.className = 'post-gallery';
+    images.forEach(image => {
+      const figure = document.createElement('figure');
+      const img = document.createElement('img');
+      img.src = image.url;
+      if (image.dominant_color) img.style.backgroundColor = image.dominant_color;
+      if (image.srcset) img.srcset = image.srcset;
+      img.alt = image.alt_text || '';
+      img.className = 'post-image';
+      figure.appendChild(img);
+      if (image.caption) {
+        const caption = document.createElement('figcaption');
+        caption.textContent = image.caption;
+        figure.appendChild(caption);
+      }
+      imageEl.appendChild(figure);
+    });
   }
 
   // Wrap post content in a card
@@ -87,9 +101,9 @@ function renderSinglePost(post) {
   const reactions = document.createElement('div');
   reactions.className = 'post-reactions';
 
-  // Count likes & dislikes
-  let likes = post.reactions?.filter(r => r.reaction_type === 1).length || 0;
-  let dislikes = post.reactions?.filter(r => r.reaction_type === 2).length || 0;
+  const likes = post.reactions?.likes || 0;
+  const dislikes = post.reactions?.dislikes || 0;
+  rememberReaction('post', post.id, post.my_reaction);
 
   const likeBtn = document.createElement('button');
   likeBtn.textContent = `▲ ${likes}`;
@@ -197,41 +211,17 @@ function renderSinglePost(post) {
       return;
     }
     errorMsg.classList.remove('visible');
-    if (!csrfTokenFromResponse) {
-      csrfTokenFromResponse = await loadCSRFTokenFromSession();
-      if (!csrfTokenFromResponse) {
-        alert('Session expired or not authenticated. Please log in again.');
-        return;
-      }
-    }
     submitCommentBtn.disabled = true;
     submitCommentBtn.textContent = 'Submitting...';
     try {
-      const resp = await fetch('http://localhost:8080/forum/api/comments/create', {
-        method: 'POST',
-        credentials: 'include',
-        headers: {
-          'Content-Type': 'application/json',
-          'X-CSRF-Token': csrfTokenFromResponse,
-        },
-        body: JSON.stringify({
-          post_id: post.id,
-          content,
-        }),
-      });
-      if (!resp.ok) {
-        const errData = await resp.json().catch(() => ({}));
-        errorMsg.textContent = 'Error: ' + (errData.message || 'Could not submit comment.');
+      const error = await submitComment(post.id, null, content);
+      if (error) {
+        errorMsg.textContent = error;
         errorMsg.classList.add('visible');
         return;
       }
       commentTextarea.value = '';
-      errorMsg.classList.remove('visible');
       await loadPost();
-    } catch (err) {
-      console.error('Failed to submit comment:', err);
-      errorMsg.textContent = 'Failed to submit comment. Try again later.';
-      errorMsg.classList.add('visible');
     } finally {
       submitCommentBtn.disabled = false;
       submitCommentBtn.textContent = 'Submit Comment';
@@ -243,7 +233,7 @@ function renderSinglePost(post) {
   // Comments list
   if (post.comments?.length > 0) {
     post.comments.forEach(comment => {
-      commentSection.appendChild(createCommentElement(comment));
+      commentSection.appendChild(createCommentElement(comment, post.id));
     });
   } else {
     const noComments = document.createElement('p');
@@ -269,8 +259,99 @@ function renderSinglePost(post) {
   postContainer.appendChild(postBox);
 }
 
-// Helper: create comment element with reactions
-function createCommentElement(comment) {
+// Sends a comment, or with parentId a reply, and returns an error message
+// when it was not created
+async function submitComment(postId, parentId, content) {
+  if (!csrfTokenFromResponse) {
+    csrfTokenFromResponse = await loadCSRFTokenFromSession();
+    if (!csrfTokenFromResponse) {
+      alert('Session expired or not authenticated. Please log in again.');
+      return 'Not authenticated.';
+    }
+  }
+  try {
+    const resp = await fetch('http://localhost:8080/forum/api/comments/create', {
+      method: 'POST',
+      credentials: 'include',
+      headers: {
+        'Content-Type': 'application/json',
+        'X-CSRF-Token': csrfTokenFromResponse,
+      },
+      body: JSON.stringify({
+        post_id: postId,
+        parent_id: parentId || undefined,
+        content,
+      }),
+    });
+    if (!resp.ok) {
+      const errData = await resp.json().catch(() => ({}));
+      return 'Error: ' + (errData.message || 'Could not submit comment.');
+    }
+    return null;
+  } catch (err) {
+    console.error('Failed to submit comment:', err);
+    return 'Failed to submit comment. Try again later.';
+  }
+}
+
+// Seeds the like/dislike toggle state with the viewer's saved reaction
+function rememberReaction(targetType, targetId, reactionType) {
+  if (reactionType === 1 || reactionType === 2) {
+    lastReactions.set(`${targetType}:${targetId}`, reactionType);
+  }
+}
+
+// Inline form under a comment to reply to it
+function createReplyForm(comment, postId) {
+  const form = document.createElement('form');
+  form.className = 'comment-form reply-form';
+  form.autocomplete = 'off';
+
+  const errorMsg = document.createElement('div');
+  errorMsg.className = 'comment-error-msg';
+
+  const textarea = document.createElement('textarea');
+  textarea.className = 'comment-textarea';
+  textarea.placeholder = `Reply to ${comment.username || 'comment'}...`;
+  textarea.required = true;
+  textarea.rows = 2;
+  textarea.maxLength = 1000;
+
+  const submitBtn = document.createElement('button');
+  submitBtn.type = 'submit';
+  submitBtn.className = 'submit-comment-btn';
+  submitBtn.textContent = 'Reply';
+
+  form.appendChild(errorMsg);
+  form.appendChild(textarea);
+  form.appendChild(submitBtn);
+
+  form.addEventListener('submit', async (e) => {
+    e.preventDefault();
+    const content = textarea.value.trim();
+    if (!content) {
+      errorMsg.textContent = 'Reply cannot be empty.';
+      errorMsg.classList.add('visible');
+      return;
+    }
+    submitBtn.disabled = true;
+    try {
+      const error = await submitComment(postId, comment.id, content);
+      if (error) {
+        errorMsg.textContent = error;
+        errorMsg.classList.add('visible');
+        return;
+      }
+      await loadPost();
+    } finally {
+      submitBtn.disabled = false;
+    }
+  });
+  return form;
+}
+
+// Helper: create comment element with reactions and its replies nested below
+function createCommentElement(comment, postId) {
   // Match guest style: compact, simple, but keep interactive buttons
   const commentEl = document.createElement('div');
   commentEl.className = 'comment';
@@ -288,8 +369,9 @@ function createCommentElement(comment) {
   const commentReactions = document.createElement('div');
   commentReactions.className = 'comment-reactions';
 
-  const likeCount = comment.reactions?.filter(r => r.reaction_type === 1).length || 0;
-  const dislikeCount = comment.reactions?.filter(r => r.reaction_type === 2).length || 0;
+  const likeCount = comment.reactions?.likes || 0;
+  const dislikeCount = comment.reactions?.dislikes || 0;
+  rememberReaction('comment', comment.id, comment.my_reaction);
 
   const likeBtn = document.createElement('button');
   likeBtn.textContent = `▲ ${likeCount}`;
@@ -308,12 +390,36 @@ function createCommentElement(comment) {
   commentReactions.appendChild(likeBtn);
   commentReactions.appendChild(dislikeBtn);
 
+  const replyBtn = document.createElement('button');
+  replyBtn.type = 'button';
+  replyBtn.className = 'reply-btn';
+  replyBtn.textContent = 'Reply';
+  let replyForm = null;
+  replyBtn.addEventListener('click', () => {
+    if (replyForm) {
+      replyForm.remove();
+      replyForm = null;
+      return;
+    }
+    replyForm = createReplyForm(comment, postId);
+    commentReactions.after(replyForm);
+    replyForm.querySelector('textarea').focus();
+  });
+  commentReactions.appendChild(replyBtn);
+
   // Layout: username, time, content, reactions (all compact)
   commentEl.appendChild(commentUser);
   commentEl.appendChild(commentTime);
   commentEl.appendChild(commentContent);
   commentEl.appendChild(commentReactions);
 
+  if (comment.replies?.length) {
+    const replies = document.createElement('div');
+    replies.className = 'comment-replies';
+    comment.replies.forEach(reply => replies.appendChild(createCommentElement(reply, postId)));
+    commentEl.appendChild(replies);
+  }
+
   return commentEl;
 }
 
@@ -386,27 +492,5 @@ async function handleReaction(targetId, targetType, reactionType, likeBtn, disli
 
 
 
-// Helper: merge posts from categories (same as your original)
-function mergePostsFromCategories(categories) {
-  const postsMap = new Map();
-  categories.forEach(category => {
-    const categoryId = category.id;
-    const categoryName = category.name;
-
-    category.posts.forEach(post => {
-      if (!postsMap.has(post.id)) {
-        postsMap.set(post.id, {
-          ...post,
-          categories: [{ id: categoryId, name: categoryName }],
-        });
-      } else {
-        const existing = postsMap.get(post.id);
-        existing.categories.push({ id: categoryId, name: categoryName });
-      }
-    });
-  });
-  return Array.from(postsMap.values());
-}
-
 // Initial load
 loadPost();